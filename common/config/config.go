@@ -18,6 +18,12 @@ var Logo = ""
 var TopUpLink = ""
 var ChatLink = ""
 var QuotaPerUnit = 500 * 1000.0 // $0.002 / 1K tokens
+
+// ImageTokenRatio is the price of an image prompt token relative to a text prompt token
+var ImageTokenRatio = 1.0
+
+// AudioTokenRatio is the price of an audio prompt token relative to a text prompt token
+var AudioTokenRatio = 1.0
 var DisplayInCurrencyEnabled = true
 var DisplayTokenStatEnabled = true
 
@@ -58,6 +64,9 @@ var MemoryCacheEnabled = strings.ToLower(os.Getenv("MEMORY_CACHE_ENABLED")) == "
 
 var LogConsumeEnabled = true
 
+var LogFormat = env.String("LOG_FORMAT", "text") // text or json
+var LogLevel = env.String("LOG_LEVEL", "info")   // debug, info, warn or error
+
 var SMTPServer = ""
 var SMTPPort = 587
 var SMTPAccount = ""
@@ -88,9 +97,33 @@ var AutomaticDisableChannelEnabled = false
 var AutomaticEnableChannelEnabled = false
 var QuotaRemindThreshold int64 = 1000
 var PreConsumedQuota int64 = 500
+
+// MaxRequestQuota is the maximum estimated quota of a single request, 0 means no limit
+var MaxRequestQuota int64 = 0
+
+// ModelDowngradeQuotaThreshold is the quota below which the requests of a user are downgraded to the cheaper
+// models configured for the group, 0 means never downgrade
+var ModelDowngradeQuotaThreshold int64 = 0
+
+var FreeAllowanceResetPeriod = env.Int("FREE_ALLOWANCE_RESET_PERIOD", 30*24*60*60) // unit is second, 0 means never reset
+
+// the gateway counts prompt tokens itself and compares them with the usage reported by upstream
+var GatewayPromptTokensEnabled = env.Bool("GATEWAY_PROMPT_TOKENS_ENABLED", false)
+var GatewayPromptTokensBillingEnabled = env.Bool("GATEWAY_PROMPT_TOKENS_BILLING_ENABLED", false)
+var PromptTokensDiscrepancyThreshold = env.Float64("PROMPT_TOKENS_DISCREPANCY_THRESHOLD", 0.1)
+
+var PreConsumedCompletionMultiplier = env.Float64("PRE_CONSUMED_COMPLETION_MULTIPLIER", 1.05)
 var ApproximateTokenEnabled = false
 var RetryTimes = 0
 
+// RetryOnlySafeErrors retries only the connection errors, 429 and 5xx, instead of any error but 400 and 406,
+// the requests the upstream has started answering are never retried anyway
+var RetryOnlySafeErrors = env.Bool("RETRY_ONLY_SAFE_ERRORS", false)
+
+// DisabledEndpoints is a comma separated list of the endpoints rejected before any processing, e.g. images,audio,
+// by the names of the relay modes
+var DisabledEndpoints = env.String("DISABLED_ENDPOINTS", "")
+
 var RootUserEmail = ""
 
 var IsMasterNode = os.Getenv("NODE_TYPE") != "slave"
@@ -100,11 +133,147 @@ var RequestInterval = time.Duration(requestInterval) * time.Second
 
 var SyncFrequency = env.Int("SYNC_FREQUENCY", 10*60) // unit is second
 
+var RatioReloadInterval = env.Int("RATIO_RELOAD_INTERVAL", 0) // unit is second, 0 means disabled
+
+// PricingFile is the path of a json or yaml file with the model prices, "default" means the bundled one
+var PricingFile = env.String("PRICING_FILE", "")
+var PricingReloadInterval = env.Int("PRICING_RELOAD_INTERVAL", 60) // unit is second, 0 means disabled
+
 var BatchUpdateEnabled = false
 var BatchUpdateInterval = env.Int("BATCH_UPDATE_INTERVAL", 5)
 
+// AuditEnabled records the content of requests, AuditGroups limits it to some groups, empty means all groups
+var AuditEnabled = env.Bool("AUDIT_ENABLED", false)
+var AuditGroups = env.String("AUDIT_GROUPS", "")
+var AuditRedactEnabled = env.Bool("AUDIT_REDACT_ENABLED", true)
+var AuditRetentionDays = env.Int("AUDIT_RETENTION_DAYS", 30)
+var AuditQueueSize = env.Int("AUDIT_QUEUE_SIZE", 1000)
+var AuditMaxContentLength = env.Int("AUDIT_MAX_CONTENT_LENGTH", 64*1024)
+
+var LogBatchEnabled = env.Bool("LOG_BATCH_ENABLED", false)
+var LogBatchSize = env.Int("LOG_BATCH_SIZE", 100)
+var LogBatchInterval = env.Int("LOG_BATCH_INTERVAL", 5) // unit is second
+
 var RelayTimeout = env.Int("RELAY_TIMEOUT", 0) // unit is second
 
+// TokenExpiryWarningWindow is how long before a token expires the relay starts to report its expiry time
+var TokenExpiryWarningWindow = env.Int("TOKEN_EXPIRY_WARNING_WINDOW", 24*60*60) // unit is second
+
+// MaxPromptSize is the maximum size of the text of a prompt, larger ones are rejected before their tokens are counted
+var MaxPromptSize = env.Int("MAX_PROMPT_SIZE", 16*1024*1024) // unit is byte, 0 means no limit
+
+// ClockSkewTolerance is the clock difference allowed between the gateway and the others,
+// it backdates the generated jwts of upstreams and delays the expiry of tokens
+var ClockSkewTolerance = env.Int("CLOCK_SKEW_TOLERANCE", 60) // unit is second
+
+// StripStreamObfuscation removes the obfuscation field openai pads stream chunks with
+var StripStreamObfuscation = env.Bool("STRIP_STREAM_OBFUSCATION", false)
+
+// RelayQueueSize is the number of requests of each group allowed to wait for a channel with capacity left,
+// instead of failing with 429 when the local rate limits of the channels are reached, 0 means no queue,
+// the requests wait up to RelayQueueTimeout before being rejected
+var RelayQueueSize = env.Int("RELAY_QUEUE_SIZE", 0)
+var RelayQueueTimeout = env.Int("RELAY_QUEUE_TIMEOUT", 10) // unit is second
+
+// GroupMaxStreams limits the concurrent streaming requests of each group, 0 means no limit,
+// streams over the limit are rejected at once, see the GroupMaxStreams option for the limits of single groups
+var GroupMaxStreams = env.Int("GROUP_MAX_STREAMS", 0)
+
+// MaxConcurrentAudioTranscriptions limits the concurrent transcription & translation requests of each instance,
+// 0 means no limit, the requests over the limit are rejected at once before their uploads are read
+var MaxConcurrentAudioTranscriptions = env.Int("MAX_CONCURRENT_AUDIO_TRANSCRIPTIONS", 0)
+
+// the sources of the created timestamps of the responses
+const (
+	CreatedTimestampSourceUpstream = "upstream"
+	CreatedTimestampSourceGateway  = "gateway"
+)
+
+// CreatedTimestampSource is upstream to keep the created timestamps of the upstreams, the ones missing are filled
+// with the time of the gateway, or gateway to always use the time of the gateway, consistent across a cluster
+var CreatedTimestampSource = env.String("CREATED_TIMESTAMP_SOURCE", CreatedTimestampSourceUpstream)
+
+// StreamBufferSize is the initial size in bytes of the buffer reading the streams of the upstreams,
+// which may be set per channel by stream_buffer_size of its config
+var StreamBufferSize = env.Int("STREAM_BUFFER_SIZE", 64*1024)
+
+// StreamMaxEventSize is the size in bytes the buffer of a stream grows up to for an oversized event,
+// a stream with a larger event is cut off
+var StreamMaxEventSize = env.Int("STREAM_MAX_EVENT_SIZE", 16*1024*1024)
+
+// SlowRequestThreshold logs the relay requests whose upstream latency exceeds it at warn level, 0 means disabled
+var SlowRequestThreshold = env.Int("SLOW_REQUEST_THRESHOLD", 0) // unit is millisecond
+
+// ForceStreamHeaderEnabled honors the X-One-API-Force-Stream header of the proxies in front of the gateway,
+// which overrides the stream flag of the chat & completions requests, off by default as any client may send it
+var ForceStreamHeaderEnabled = env.Bool("FORCE_STREAM_HEADER_ENABLED", false)
+
+// AudioMinBillableSeconds is the duration of audio each transcription & translation request is billed at least,
+// so that sending the audio in tiny pieces isn't free
+var AudioMinBillableSeconds = env.Float64("AUDIO_MIN_BILLABLE_SECONDS", 1)
+
+// AudioMinFileSize is the size in bytes below which the uploaded audio files are rejected as invalid
+var AudioMinFileSize = env.Int("AUDIO_MIN_FILE_SIZE", 1024)
+
+// EmbeddingsBatchSize is the number of inputs of each sub-batch of the embeddings requests asking for partial results
+var EmbeddingsBatchSize = env.Int("EMBEDDINGS_BATCH_SIZE", 256)
+
+// RequestDecompressionEnabled decompresses the relay requests sent with Content-Encoding gzip or deflate,
+// the bodies larger than MaxDecompressedRequestSize once decompressed are rejected
+var RequestDecompressionEnabled = env.Bool("REQUEST_DECOMPRESSION_ENABLED", true)
+var MaxDecompressedRequestSize = env.Int("MAX_DECOMPRESSED_REQUEST_SIZE", 64*1024*1024) // unit is byte
+
+// StreamUsageChunkEnabled appends a chunk carrying the billed usage before the [DONE] of the streams
+// whose clients didn't ask for include_usage, some clients fail on chunks without choices so it is opt-in
+var StreamUsageChunkEnabled = env.Bool("STREAM_USAGE_CHUNK_ENABLED", false)
+
+// MaxAutoContinuations is the maximum number of continuation requests issued for a non-stream chat completion
+// truncated by max_tokens, the segments are stitched into one response, 0 means never continue
+var MaxAutoContinuations = env.Int("MAX_AUTO_CONTINUATIONS", 0)
+
+// RequestSignatureWindow is how far the timestamp of a signed request may be from now, unit is second
+var RequestSignatureWindow = env.Int("REQUEST_SIGNATURE_WINDOW", 300)
+
+// RequestCoalescingEnabled sends identical deterministic non-stream requests in flight upstream only once,
+// the response is shared with all of them, and each of them is billed for it
+var RequestCoalescingEnabled = env.Bool("REQUEST_COALESCING_ENABLED", false)
+
+// JSONRepairEnabled repairs the malformed json content of the non-stream responses of json mode requests,
+// such as trailing commas and unterminated strings, the content left invalid after the repair is returned as is
+var JSONRepairEnabled = env.Bool("JSON_REPAIR_ENABLED", false)
+
+// JSONValidationEnabled reports the choices of the json mode responses whose content isn't valid json,
+// by a header for the non-stream responses and a warning chunk before the [DONE] of the streams, the content is never modified
+var JSONValidationEnabled = env.Bool("JSON_VALIDATION_ENABLED", false)
+
+// StreamEventIdEnabled numbers the data events of the streams with the id field of sse,
+// so that the clients can tell the events they missed
+var StreamEventIdEnabled = env.Bool("STREAM_EVENT_ID_ENABLED", false)
+
+// StreamRetryInterval is sent as the retry field of sse with the first event of the streams,
+// the clients wait that long before reconnecting, 0 means it is not sent
+var StreamRetryInterval = env.Int("STREAM_RETRY_INTERVAL", 0) // unit is millisecond
+
+// DeniedScriptThreshold is the share of the letters of a prompt in a denied script over which the prompt is rejected,
+// a few words quoted in another script don't make a prompt written in it
+var DeniedScriptThreshold = env.Float64("DENIED_SCRIPT_THRESHOLD", 0.3)
+
+// ResponseHeaderAllowList lists the upstream response headers forwarded to clients, separated by commas
+var ResponseHeaderAllowList = env.String("RESPONSE_HEADER_ALLOW_LIST", "content-type,content-disposition,openai-model,openai-processing-ms,openai-version,x-request-id")
+
+// a channel is considered near its upstream rate limit when the remaining part is below this ratio
+var ChannelRateLimitReserveRatio = env.Float64("CHANNEL_RATELIMIT_RESERVE_RATIO", 0.05)
+var UpstreamRateLimitHeadersEnabled = env.Bool("UPSTREAM_RATELIMIT_HEADERS_ENABLED", false)
+
+// MaxStreamDuration caps the total time of a streaming response, 0 means no limit
+var MaxStreamDuration = env.Int("MAX_STREAM_DURATION", 60*60) // unit is second
+
+// StreamSlowClientTimeout aborts a stream whose client hasn't accepted a write for that long,
+// so that the slow clients can't hold the gateway and its upstream connections, 0 means no limit
+var StreamSlowClientTimeout = env.Int("STREAM_SLOW_CLIENT_TIMEOUT", 60) // unit is second
+
+var AssistantsChannelId = env.Int("ASSISTANTS_CHANNEL_ID", 0)
+
 var GeminiSafetySetting = env.String("GEMINI_SAFETY_SETTING", "BLOCK_NONE")
 
 var Theme = env.String("THEME", "default")
@@ -135,6 +304,30 @@ var (
 
 var RateLimitKeyExpirationDuration = 20 * time.Minute
 
+// RelayRateLimitNum limits the relay requests of each bucket, the bucket is keyed by the token by default,
+// see RelayRateLimitKeySource, 0 means no limit
+var RelayRateLimitNum = env.Int("RELAY_RATE_LIMIT", 0)
+var RelayRateLimitDuration = int64(env.Int("RELAY_RATE_LIMIT_DURATION", 60)) // unit is second
+var RelayRateLimitKeySource = env.String("RELAY_RATE_LIMIT_KEY_SOURCE", "token")
+
+// RelayPassThroughEnabled forwards requests verbatim without counting tokens or quota,
+// it can also be enabled for a single channel with the pass_through channel config
+var RelayPassThroughEnabled = env.Bool("RELAY_PASS_THROUGH_ENABLED", false)
+
+// ShadowMaxConcurrency bounds the requests mirrored to shadow channels at the same time,
+// see the GroupShadowChannel option
+var ShadowMaxConcurrency = env.Int("SHADOW_MAX_CONCURRENCY", 10)
+var ShadowTimeout = env.Int("SHADOW_TIMEOUT", 60) // unit is second
+
+// TransformTimeout bounds the evaluation of the request and response transforms of the channels
+var TransformTimeout = env.Int("TRANSFORM_TIMEOUT", 100) // unit is millisecond
+
+// ParamOverrideFields lists the request params admin tokens can override with the X-Override-* headers
+var ParamOverrideFields = env.String("PARAM_OVERRIDE_FIELDS", "temperature,top_p,top_k,max_tokens,presence_penalty,frequency_penalty,seed")
+
+// ReasoningModelPrefixes lists the prefixes of the models accepting reasoning_effort, it is stripped for the others
+var ReasoningModelPrefixes = env.String("REASONING_MODEL_PREFIXES", "o1,o3,o4")
+
 var EnableMetric = env.Bool("ENABLE_METRIC", false)
 var MetricQueueSize = env.Int("METRIC_QUEUE_SIZE", 10)
 var MetricSuccessRateThreshold = env.Float64("METRIC_SUCCESS_RATE_THRESHOLD", 0.8)
@@ -10,4 +10,25 @@ const (
 	ConfigAK         = ConfigPrefix + "ak"
 	ConfigRegion     = ConfigPrefix + "region"
 	ConfigUserID     = ConfigPrefix + "user_id"
+	ConfigProjectID  = ConfigPrefix + "project_id"
+
+	ConfigAudioResponseFormat = ConfigPrefix + "audio_response_format"
+	ConfigOpenAIBeta          = ConfigPrefix + "openai_beta"
+	ConfigSafePrompt          = ConfigPrefix + "safe_prompt"
+	ConfigPassThrough         = ConfigPrefix + "pass_through"
+	ConfigStripExtraFields    = ConfigPrefix + "strip_extra_fields"
+	ConfigRPM                 = ConfigPrefix + "rpm"
+	ConfigTPM                 = ConfigPrefix + "tpm"
+	ConfigSingleToolCall      = ConfigPrefix + "single_tool_call"
+	ConfigTLSClientCert       = ConfigPrefix + "tls_client_cert"
+	ConfigTLSClientKey        = ConfigPrefix + "tls_client_key"
+	ConfigTLSCACert           = ConfigPrefix + "tls_ca_cert"
+	ConfigSkipTLSVerify       = ConfigPrefix + "skip_tls_verify"
+	ConfigTimeout             = ConfigPrefix + "timeout"
+	ConfigRequestTransform    = ConfigPrefix + "request_transform"
+	ConfigResponseTransform   = ConfigPrefix + "response_transform"
+	ConfigStreamBufferSize    = ConfigPrefix + "stream_buffer_size"
+	ConfigMarkup              = ConfigPrefix + "markup"
+	ConfigSecondaryBaseURL    = ConfigPrefix + "secondary_base_url"
+	ConfigDefaultParams       = ConfigPrefix + "default_params"
 )
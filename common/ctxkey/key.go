@@ -16,6 +16,25 @@ const (
 	ChannelName       = "channel_name"
 	TokenId           = "token_id"
 	TokenName         = "token_name"
-	BaseURL           = "base_url"
-	AvailableModels   = "available_models"
+	// TokenQuotaPoolId is the quota pool of the token, 0 if it has none
+	TokenQuotaPoolId = "token_quota_pool_id"
+	TokenExpiredTime = "token_expired_time"
+	BaseURL          = "base_url"
+	AvailableModels  = "available_models"
+	// UpstreamStreamError holds the error of a stream that failed after it started
+	UpstreamStreamError = "upstream_stream_error"
+	// KeepFirstToolCall is set when the gateway enforces parallel_tool_calls: false for the channel
+	KeepFirstToolCall = "keep_first_tool_call"
+	// DowngradedFrom holds the model the request asked for when it was downgraded to a cheaper one
+	DowngradedFrom = "downgraded_from"
+	// ResponseModel is the model reported to the client when the channel maps the model it asked for to another
+	ResponseModel = "response_model"
+	// RequestedChoices is the n of the request, to tell when the upstream returns fewer choices
+	RequestedChoices = "requested_choices"
+	// ServiceTier is the service_tier of the response, which the request is billed by
+	ServiceTier = "service_tier"
+	// UpstreamResponded is set once the upstream has answered the request successfully, after which it must not be retried
+	UpstreamResponded = "upstream_responded"
+	// RelayQueued is set once the request has waited for a channel with capacity left, so that it waits only once
+	RelayQueued = "relay_queued"
 )
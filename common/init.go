@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -25,6 +26,10 @@ func printHelp() {
 }
 
 func init() {
+	// the flags of a test binary are parsed by the testing package, which defines them after the init functions
+	if strings.HasSuffix(os.Args[0], ".test") {
+		return
+	}
 	flag.Parse()
 
 	if *PrintVersion {
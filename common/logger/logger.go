@@ -2,11 +2,13 @@ package logger
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -20,8 +22,32 @@ const (
 	loggerINFO  = "INFO"
 	loggerWarn  = "WARN"
 	loggerError = "ERR"
+	loggerSys   = "SYS"
 )
 
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+var levelPriorities = map[string]int{
+	loggerDEBUG: 0,
+	loggerINFO:  1,
+	loggerWarn:  2,
+	loggerError: 3,
+}
+
+var levelNames = map[string]string{
+	"debug": loggerDEBUG,
+	"info":  loggerINFO,
+	"warn":  loggerWarn,
+	"error": loggerError,
+}
+
+// Fields are extra key-value pairs attached to a log entry,
+// e.g. channel_id, model, user_id, latency, status
+type Fields map[string]any
+
 var setupLogOnce sync.Once
 
 func SetupLogger() {
@@ -39,31 +65,27 @@ func SetupLogger() {
 }
 
 func SysLog(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	writeLog(gin.DefaultWriter, loggerSys, loggerINFO, nil, s, nil)
 }
 
 func SysError(s string) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[SYS] %v | %s \n", t.Format("2006/01/02 - 15:04:05"), s)
+	writeLog(gin.DefaultErrorWriter, loggerSys, loggerError, nil, s, nil)
 }
 
 func Debug(ctx context.Context, msg string) {
-	if config.DebugEnabled {
-		logHelper(ctx, loggerDEBUG, msg)
-	}
+	logHelper(ctx, loggerDEBUG, msg, nil)
 }
 
 func Info(ctx context.Context, msg string) {
-	logHelper(ctx, loggerINFO, msg)
+	logHelper(ctx, loggerINFO, msg, nil)
 }
 
 func Warn(ctx context.Context, msg string) {
-	logHelper(ctx, loggerWarn, msg)
+	logHelper(ctx, loggerWarn, msg, nil)
 }
 
 func Error(ctx context.Context, msg string) {
-	logHelper(ctx, loggerError, msg)
+	logHelper(ctx, loggerError, msg, nil)
 }
 
 func Debugf(ctx context.Context, format string, a ...any) {
@@ -82,7 +104,33 @@ func Errorf(ctx context.Context, format string, a ...any) {
 	Error(ctx, fmt.Sprintf(format, a...))
 }
 
-func logHelper(ctx context.Context, level string, msg string) {
+func InfoWithFields(ctx context.Context, msg string, fields Fields) {
+	logHelper(ctx, loggerINFO, msg, fields)
+}
+
+func WarnWithFields(ctx context.Context, msg string, fields Fields) {
+	logHelper(ctx, loggerWarn, msg, fields)
+}
+
+func ErrorWithFields(ctx context.Context, msg string, fields Fields) {
+	logHelper(ctx, loggerError, msg, fields)
+}
+
+func shouldLog(level string) bool {
+	if config.DebugEnabled {
+		return true
+	}
+	minLevel, ok := levelNames[config.LogLevel]
+	if !ok {
+		minLevel = loggerINFO
+	}
+	return levelPriorities[level] >= levelPriorities[minLevel]
+}
+
+func logHelper(ctx context.Context, level string, msg string, fields Fields) {
+	if !shouldLog(level) {
+		return
+	}
 	writer := gin.DefaultErrorWriter
 	if level == loggerINFO {
 		writer = gin.DefaultWriter
@@ -91,13 +139,81 @@ func logHelper(ctx context.Context, level string, msg string) {
 	if id == nil {
 		id = helper.GenRequestID()
 	}
-	now := time.Now()
-	_, _ = fmt.Fprintf(writer, "[%s] %v | %s | %s \n", level, now.Format("2006/01/02 - 15:04:05"), id, msg)
+	writeLog(writer, level, level, id, msg, fields)
 	SetupLogger()
 }
 
+// writeLog writes one entry, tag is the prefix used by the text format
+// while level is the severity reported by the json format
+func writeLog(writer io.Writer, tag string, level string, requestId any, msg string, fields Fields) {
+	now := time.Now()
+	if config.LogFormat == FormatJSON {
+		entry := make(map[string]any, len(fields)+4)
+		for k, v := range fields {
+			entry[k] = v
+		}
+		entry["time"] = now.Format(time.RFC3339)
+		entry["level"] = level
+		if tag == loggerSys {
+			entry["sys"] = true
+		}
+		if requestId != nil {
+			entry["request_id"] = requestId
+		}
+		entry["msg"] = msg
+		jsonBytes, err := json.Marshal(entry)
+		if err == nil {
+			_, _ = fmt.Fprintf(writer, "%s\n", jsonBytes)
+			return
+		}
+	}
+	if len(fields) != 0 {
+		msg = fmt.Sprintf("%s | %s", msg, formatFields(fields))
+	}
+	if requestId == nil {
+		_, _ = fmt.Fprintf(writer, "[%s] %v | %s \n", tag, now.Format("2006/01/02 - 15:04:05"), msg)
+		return
+	}
+	_, _ = fmt.Fprintf(writer, "[%s] %v | %s | %s \n", tag, now.Format("2006/01/02 - 15:04:05"), requestId, msg)
+}
+
+func formatFields(fields Fields) string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var s string
+	for _, k := range keys {
+		if s != "" {
+			s += " "
+		}
+		s += fmt.Sprintf("%s=%v", k, fields[k])
+	}
+	return s
+}
+
+// FormatAccessLog renders a request access log line according to the configured format
+func FormatAccessLog(t time.Time, requestId string, fields Fields, text string) string {
+	if config.LogFormat != FormatJSON {
+		return text
+	}
+	entry := make(map[string]any, len(fields)+4)
+	for k, v := range fields {
+		entry[k] = v
+	}
+	entry["time"] = t.Format(time.RFC3339)
+	entry["level"] = loggerINFO
+	entry["request_id"] = requestId
+	entry["msg"] = "access"
+	jsonBytes, err := json.Marshal(entry)
+	if err != nil {
+		return text
+	}
+	return string(jsonBytes) + "\n"
+}
+
 func FatalLog(v ...any) {
-	t := time.Now()
-	_, _ = fmt.Fprintf(gin.DefaultErrorWriter, "[FATAL] %v | %v \n", t.Format("2006/01/02 - 15:04:05"), v)
+	writeLog(gin.DefaultErrorWriter, "FATAL", "FATAL", nil, fmt.Sprint(v...), nil)
 	os.Exit(1)
 }
@@ -23,7 +23,6 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"strconv"
-	"strings"
 	"sync"
 	"time"
 
@@ -65,15 +64,11 @@ func testChannel(channel *model.Channel) (err error, openaiErr *relaymodel.Error
 		return fmt.Errorf("invalid api type: %d, adaptor is nil", apiType), nil
 	}
 	adaptor.Init(meta)
-	var modelName string
-	modelList := adaptor.GetModelList()
-	if len(modelList) != 0 {
-		modelName = modelList[0]
-	}
-	if modelName == "" || !strings.Contains(channel.Models, modelName) {
-		modelNames := strings.Split(channel.Models, ",")
-		if len(modelNames) > 0 {
-			modelName = modelNames[0]
+	modelName := channel.GetTestModel()
+	if modelName == "" {
+		modelList := adaptor.GetModelList()
+		if len(modelList) != 0 {
+			modelName = modelList[0]
 		}
 	}
 	request := buildTestRequest()
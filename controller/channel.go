@@ -1,11 +1,17 @@
 package controller
 
 import (
+	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/client"
+	"github.com/songquanpeng/one-api/relay/transform"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 )
@@ -74,6 +80,43 @@ func GetChannel(c *gin.Context) {
 	return
 }
 
+// validateChannelConfig checks the transform expressions, the markup, the default params, the tls certificates and the secondary base url
+// of the channel config, so that they don't fail the requests
+func validateChannelConfig(channel *model.Channel) error {
+	cfg, err := channel.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("invalid channel config: %w", err)
+	}
+	for _, key := range []string{"request_transform", "response_transform"} {
+		if cfg[key] == "" {
+			continue
+		}
+		if err = transform.Validate(cfg[key]); err != nil {
+			return fmt.Errorf("invalid %s: %w", key, err)
+		}
+	}
+	if _, err = billingratio.ParseChannelMarkup(cfg["markup"]); err != nil {
+		return fmt.Errorf("invalid markup: %w", err)
+	}
+	if defaultParams := cfg["default_params"]; defaultParams != "" {
+		var params map[string]any
+		if err = json.Unmarshal([]byte(defaultParams), &params); err != nil {
+			return fmt.Errorf("invalid default_params: must be a json object")
+		}
+	}
+	tlsConfig := client.TLSConfig{ClientCert: cfg["tls_client_cert"], ClientKey: cfg["tls_client_key"], CACert: cfg["tls_ca_cert"]}
+	if err = tlsConfig.Validate(); err != nil {
+		return fmt.Errorf("invalid tls configuration: %w", err)
+	}
+	if secondaryBaseURL := cfg["secondary_base_url"]; secondaryBaseURL != "" {
+		u, err := url.Parse(secondaryBaseURL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid secondary_base_url: must be an http or https url")
+		}
+	}
+	return nil
+}
+
 func AddChannel(c *gin.Context) {
 	channel := model.Channel{}
 	err := c.ShouldBindJSON(&channel)
@@ -84,6 +127,14 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	err = validateChannelConfig(&channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	channel.CreatedTime = helper.GetTimestamp()
 	keys := strings.Split(channel.Key, "\n")
 	channels := make([]model.Channel, 0, len(keys))
@@ -155,6 +206,14 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	err = validateChannelConfig(&channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
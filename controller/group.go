@@ -8,7 +8,7 @@ import (
 
 func GetGroups(c *gin.Context) {
 	groupNames := make([]string, 0)
-	for groupName := range billingratio.GroupRatio {
+	for groupName := range billingratio.GetGroupRatioMap() {
 		groupNames = append(groupNames, groupName)
 	}
 	c.JSON(http.StatusOK, gin.H{
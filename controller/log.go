@@ -143,6 +143,64 @@ func GetLogsSelfStat(c *gin.Context) {
 	return
 }
 
+func getModelUsage(c *gin.Context, userId int) {
+	startTimestamp, _ := strconv.ParseInt(c.Query("start_timestamp"), 10, 64)
+	endTimestamp, _ := strconv.ParseInt(c.Query("end_timestamp"), 10, 64)
+	groupByDay := c.Query("group_by") == "day"
+	usages, err := model.GetModelUsage(userId, startTimestamp, endTimestamp, groupByDay)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    usages,
+	})
+}
+
+// GetModelUsage returns the tokens and quota used per model, of the user given by user_id or of all users
+func GetModelUsage(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getModelUsage(c, userId)
+}
+
+// GetSelfModelUsage returns the tokens and quota used per model by the current user
+func GetSelfModelUsage(c *gin.Context) {
+	getModelUsage(c, c.GetInt(ctxkey.Id))
+}
+
+func getThreadUsage(c *gin.Context, userId int) {
+	usage, err := model.GetThreadUsage(userId, c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    usage,
+	})
+}
+
+// GetThreadUsage returns the tokens and quota used by the runs of an assistants thread,
+// of the user given by user_id or of all users
+func GetThreadUsage(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getThreadUsage(c, userId)
+}
+
+// GetSelfThreadUsage returns the tokens and quota used by the runs of an assistants thread of the current user
+func GetSelfThreadUsage(c *gin.Context) {
+	getThreadUsage(c, c.GetInt(ctxkey.Id))
+}
+
 func DeleteHistoryLogs(c *gin.Context) {
 	targetTimestamp, _ := strconv.ParseInt(c.Query("target_timestamp"), 10, 64)
 	if targetTimestamp == 0 {
@@ -40,6 +40,15 @@ type OpenAIModels struct {
 	Permission []OpenAIModelPermission `json:"permission"`
 	Root       string                  `json:"root"`
 	Parent     *string                 `json:"parent"`
+	// computed when listed, as the capabilities are configurable
+	Capabilities *model.ModelCapabilities `json:"capabilities,omitempty"`
+}
+
+// withCapabilities returns the model with its current capabilities
+func withCapabilities(openAIModel OpenAIModels) OpenAIModels {
+	capabilities := model.GetModelCapabilities(openAIModel.Id)
+	openAIModel.Capabilities = &capabilities
+	return openAIModel
 }
 
 var models []OpenAIModels
@@ -123,9 +132,13 @@ func DashboardListModels(c *gin.Context) {
 }
 
 func ListAllModels(c *gin.Context) {
+	allModels := make([]OpenAIModels, 0, len(models))
+	for _, model := range models {
+		allModels = append(allModels, withCapabilities(model))
+	}
 	c.JSON(200, gin.H{
 		"object": "list",
-		"data":   models,
+		"data":   allModels,
 	})
 }
 
@@ -136,7 +149,7 @@ func ListModels(c *gin.Context) {
 		availableModels = strings.Split(c.GetString(ctxkey.AvailableModels), ",")
 	} else {
 		userId := c.GetInt(ctxkey.Id)
-		userGroup, _ := model.CacheGetUserGroup(userId)
+		userGroup, _ := model.CacheGetUserEffectiveGroup(userId)
 		availableModels, _ = model.CacheGetGroupModels(ctx, userGroup)
 	}
 	modelSet := make(map[string]bool)
@@ -147,19 +160,19 @@ func ListModels(c *gin.Context) {
 	for _, model := range models {
 		if _, ok := modelSet[model.Id]; ok {
 			modelSet[model.Id] = false
-			availableOpenAIModels = append(availableOpenAIModels, model)
+			availableOpenAIModels = append(availableOpenAIModels, withCapabilities(model))
 		}
 	}
 	for modelName, ok := range modelSet {
 		if ok {
-			availableOpenAIModels = append(availableOpenAIModels, OpenAIModels{
+			availableOpenAIModels = append(availableOpenAIModels, withCapabilities(OpenAIModels{
 				Id:      modelName,
 				Object:  "model",
 				Created: 1626777600,
 				OwnedBy: "custom",
 				Root:    modelName,
 				Parent:  nil,
-			})
+			}))
 		}
 	}
 	c.JSON(200, gin.H{
@@ -171,7 +184,7 @@ func ListModels(c *gin.Context) {
 func RetrieveModel(c *gin.Context) {
 	modelId := c.Param("model")
 	if model, ok := modelsMap[modelId]; ok {
-		c.JSON(200, model)
+		c.JSON(200, withCapabilities(model))
 	} else {
 		Error := relaymodel.Error{
 			Message: fmt.Sprintf("The model '%s' does not exist", modelId),
@@ -188,7 +201,7 @@ func RetrieveModel(c *gin.Context) {
 func GetUserAvailableModels(c *gin.Context) {
 	ctx := c.Request.Context()
 	id := c.GetInt(ctxkey.Id)
-	userGroup, err := model.CacheGetUserGroup(id)
+	userGroup, err := model.CacheGetUserEffectiveGroup(id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
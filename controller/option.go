@@ -98,3 +98,18 @@ func UpdateOption(c *gin.Context) {
 	})
 	return
 }
+
+func ReloadRatios(c *gin.Context) {
+	err := model.ReloadRatios()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
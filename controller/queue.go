@@ -0,0 +1,87 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// channelCapacityPollInterval is how often a queued request looks for a channel with capacity left,
+// the local rate limits free up as their one minute window slides, so there is nothing to be notified of
+const channelCapacityPollInterval = 100 * time.Millisecond
+
+var queuedRequests = make(map[string]int)
+var queuedRequestsLock sync.Mutex
+
+// joinRelayQueue returns false if the queue of the group is full, a joined request must leave it
+func joinRelayQueue(group string) bool {
+	queuedRequestsLock.Lock()
+	defer queuedRequestsLock.Unlock()
+	if queuedRequests[group] >= config.RelayQueueSize {
+		return false
+	}
+	queuedRequests[group]++
+	return true
+}
+
+func leaveRelayQueue(group string) {
+	queuedRequestsLock.Lock()
+	defer queuedRequestsLock.Unlock()
+	queuedRequests[group]--
+	if queuedRequests[group] <= 0 {
+		delete(queuedRequests, group)
+	}
+}
+
+// waitForChannelCapacity queues a request whose channel is at capacity, picking a channel again until one has
+// capacity left, the request is counted against it as CheckChannelCapacity does, it returns false if the queue
+// of the group is full, or if no channel has capacity left before the timeout or the client goes away
+func waitForChannelCapacity(c *gin.Context, relayMode int) bool {
+	if config.RelayQueueSize <= 0 || c.GetBool(ctxkey.RelayQueued) {
+		return false
+	}
+	c.Set(ctxkey.RelayQueued, true)
+	group := c.GetString(ctxkey.Group)
+	if !joinRelayQueue(group) {
+		return false
+	}
+	defer leaveRelayQueue(group)
+	// the channel of a pinned request can't be picked again, it waits for the channel itself
+	_, pinned := c.Get(ctxkey.SpecificChannelId)
+	pinned = pinned || relayMode == relaymode.Assistants
+	originalModel := c.GetString(ctxkey.OriginalModel)
+	ticker := time.NewTicker(channelCapacityPollInterval)
+	defer ticker.Stop()
+	timer := time.NewTimer(time.Duration(config.RelayQueueTimeout) * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			return false
+		case <-c.Request.Context().Done():
+			return false
+		}
+		if !pinned {
+			channel, err := dbmodel.CacheGetRandomSatisfiedChannel(group, originalModel, false)
+			if err != nil {
+				logger.Errorf(c.Request.Context(), "CacheGetRandomSatisfiedChannel failed: %+v", err)
+				return false
+			}
+			if channel.Id != c.GetInt(ctxkey.ChannelId) {
+				middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+			}
+		}
+		if controller.CheckChannelCapacity(c) == nil {
+			return true
+		}
+	}
+}
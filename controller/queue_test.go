@@ -0,0 +1,68 @@
+package controller
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestWaitForChannelCapacity(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&dbmodel.Channel{}, &dbmodel.Ability{}))
+	busy := &dbmodel.Channel{Id: 9301, Type: channeltype.OpenAI, Status: dbmodel.ChannelStatusEnabled, Config: `{"rpm":"1"}`}
+	idle := &dbmodel.Channel{Id: 9302, Type: channeltype.OpenAI, Status: dbmodel.ChannelStatusEnabled}
+	for _, channel := range []*dbmodel.Channel{busy, idle} {
+		assert.NoError(t, db.Create(channel).Error)
+		assert.NoError(t, db.Create(&dbmodel.Ability{Group: "default", Model: "gpt-4o", ChannelId: channel.Id, Enabled: true}).Error)
+	}
+	originalDB, originalUsingSQLite, originalRedisEnabled := dbmodel.DB, common.UsingSQLite, common.RedisEnabled
+	dbmodel.DB, common.UsingSQLite, common.RedisEnabled = db, true, false
+	config.RelayQueueSize, config.RelayQueueTimeout = 1, 1
+	defer func() {
+		dbmodel.DB, common.UsingSQLite, common.RedisEnabled = originalDB, originalUsingSQLite, originalRedisEnabled
+		config.RelayQueueSize, config.RelayQueueTimeout = 0, 10
+	}()
+
+	newBusyContext := func() *gin.Context {
+		c := newRelayContext("/v1/chat/completions", nil)
+		c.Set(ctxkey.Group, "default")
+		c.Set(ctxkey.OriginalModel, "gpt-4o")
+		middleware.SetupContextForSelectedChannel(c, busy, "gpt-4o")
+		return c
+	}
+	c := newBusyContext()
+	assert.Nil(t, controller.CheckChannelCapacity(c))
+	assert.Equal(t, http.StatusTooManyRequests, controller.CheckChannelCapacity(c).StatusCode)
+
+	// the queued request goes on with the channel which has capacity left
+	assert.True(t, waitForChannelCapacity(c, relaymode.ChatCompletions))
+	assert.Equal(t, idle.Id, c.GetInt(ctxkey.ChannelId))
+	// and waits only once
+	c = newBusyContext()
+	c.Set(ctxkey.RelayQueued, true)
+	assert.False(t, waitForChannelCapacity(c, relaymode.ChatCompletions))
+
+	// a pinned request waits for its own channel until the timeout
+	c = newBusyContext()
+	c.Set(ctxkey.SpecificChannelId, "9301")
+	assert.False(t, waitForChannelCapacity(c, relaymode.ChatCompletions))
+	assert.Equal(t, busy.Id, c.GetInt(ctxkey.ChannelId))
+
+	// the requests over the size of the queue are rejected at once
+	assert.True(t, joinRelayQueue("default"))
+	defer leaveRelayQueue("default")
+	assert.False(t, waitForChannelCapacity(newBusyContext(), relaymode.ChatCompletions))
+}
@@ -0,0 +1,176 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+	"net/http"
+	"strconv"
+)
+
+func GetAllQuotaPools(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	quotaPools, err := model.GetAllQuotaPools(p*config.ItemsPerPage, config.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    quotaPools,
+	})
+	return
+}
+
+func GetQuotaPool(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	quotaPool, err := model.GetQuotaPoolById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    quotaPool,
+	})
+	return
+}
+
+func AddQuotaPool(c *gin.Context) {
+	quotaPool := model.QuotaPool{}
+	err := c.ShouldBindJSON(&quotaPool)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(quotaPool.Name) == 0 || len(quotaPool.Name) > 30 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "额度池名称长度必须在1-30之间",
+		})
+		return
+	}
+	cleanQuotaPool := model.QuotaPool{
+		Name:        quotaPool.Name,
+		RemainQuota: quotaPool.RemainQuota,
+	}
+	err = cleanQuotaPool.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanQuotaPool,
+	})
+	return
+}
+
+func UpdateQuotaPool(c *gin.Context) {
+	quotaPool := model.QuotaPool{}
+	err := c.ShouldBindJSON(&quotaPool)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanQuotaPool, err := model.GetQuotaPoolById(quotaPool.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	// If you add more fields, please also update quotaPool.Update()
+	cleanQuotaPool.Name = quotaPool.Name
+	cleanQuotaPool.RemainQuota = quotaPool.RemainQuota
+	err = cleanQuotaPool.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanQuotaPool,
+	})
+	return
+}
+
+func DeleteQuotaPool(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteQuotaPoolById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+// SetTokenQuotaPool adds a token of any user to a quota pool, or removes it from its pool with quota_pool_id 0,
+// only admins can do it, as the pool is billed for the requests of its tokens
+func SetTokenQuotaPool(c *gin.Context) {
+	var request struct {
+		TokenId     int `json:"token_id"`
+		QuotaPoolId int `json:"quota_pool_id"`
+	}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = model.SetTokenQuotaPool(request.TokenId, request.QuotaPoolId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
@@ -13,16 +13,30 @@ import (
 	"github.com/songquanpeng/one-api/middleware"
 	dbmodel "github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/monitor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/controller"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 	"io"
 	"net/http"
+	"strconv"
 )
 
 // https://platform.openai.com/docs/api-reference/chat
 
 func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
+	if err := controller.CheckChannelCapacity(c); err != nil {
+		if !waitForChannelCapacity(c, relayMode) {
+			return err
+		}
+	}
+	channelId := c.GetInt(ctxkey.ChannelId)
+	dbmodel.BeginChannelRequest(channelId)
+	defer dbmodel.EndChannelRequest(channelId)
+	c.Set(ctxkey.UpstreamResponded, false)
+	if controller.IsPassThrough(c) {
+		return controller.RelayPassThroughHelper(c)
+	}
 	var err *model.ErrorWithStatusCode
 	switch relayMode {
 	case relaymode.ImagesGenerations:
@@ -33,26 +47,67 @@ func relayHelper(c *gin.Context, relayMode int) *model.ErrorWithStatusCode {
 		fallthrough
 	case relaymode.AudioTranscription:
 		err = controller.RelayAudioHelper(c, relayMode)
-	default:
+	case relaymode.Assistants:
+		err = controller.RelayAssistantsHelper(c)
+	case relaymode.ChatCompletions, relaymode.Completions, relaymode.Embeddings, relaymode.Moderations, relaymode.Edits:
 		err = controller.RelayTextHelper(c)
+	default:
+		err = unsupportedEndpointError(c)
 	}
 	return err
 }
 
+// unsupportedEndpointError is returned for the paths no relay mode matches, instead of relaying them as text requests
+func unsupportedEndpointError(c *gin.Context) *model.ErrorWithStatusCode {
+	return openai.ErrorWrapper(fmt.Errorf("unsupported endpoint %s %s", c.Request.Method, c.Request.URL.Path), "unsupported_endpoint", http.StatusNotFound)
+}
+
+type relayAttempt struct {
+	ChannelId  int    `json:"channel_id"`
+	StatusCode int    `json:"status_code"`
+	Message    string `json:"message"`
+}
+
 func Relay(c *gin.Context) {
 	ctx := c.Request.Context()
 	relayMode := relaymode.GetByPath(c.Request.URL.Path)
+	if relayMode == relaymode.Unknown {
+		// not the fault of the channel, so neither retried nor counted against it
+		bizErr := unsupportedEndpointError(c)
+		bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, c.GetString(logger.RequestIdKey))
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+		return
+	}
 	if config.DebugEnabled {
 		requestBody, _ := common.GetRequestBody(c)
 		logger.Debugf(ctx, "request body: %s", string(requestBody))
 	}
-	channelId := c.GetInt(ctxkey.ChannelId)
+	if bizErr := controller.CheckTokenExpiry(c); bizErr != nil {
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+		return
+	}
+	if bizErr := controller.CheckParamOverrides(c, relayMode); bizErr != nil {
+		c.JSON(bizErr.StatusCode, gin.H{
+			"error": bizErr.Error,
+		})
+		return
+	}
 	bizErr := relayHelper(c, relayMode)
+	// the request may have waited for another channel
+	channelId := c.GetInt(ctxkey.ChannelId)
 	if bizErr == nil {
-		monitor.Emit(channelId, true)
+		if !processUpstreamStreamError(c) {
+			monitor.Emit(channelId, true)
+		}
+		mirrorToShadowChannel(c, relayMode)
 		return
 	}
 	lastFailedChannelId := channelId
+	attempts := []relayAttempt{{ChannelId: channelId, StatusCode: bizErr.StatusCode, Message: bizErr.Message}}
 	channelName := c.GetString(ctxkey.ChannelName)
 	group := c.GetString(ctxkey.Group)
 	originalModel := c.GetString(ctxkey.OriginalModel)
@@ -74,39 +129,81 @@ func Relay(c *gin.Context) {
 			continue
 		}
 		middleware.SetupContextForSelectedChannel(c, channel, originalModel)
+		controller.ClearUpstreamRateLimitHeaders(c)
 		requestBody, err := common.GetRequestBody(c)
 		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 		bizErr = relayHelper(c, relayMode)
 		if bizErr == nil {
+			processUpstreamStreamError(c)
+			mirrorToShadowChannel(c, relayMode)
 			return
 		}
 		channelId := c.GetInt(ctxkey.ChannelId)
 		lastFailedChannelId = channelId
+		attempts = append(attempts, relayAttempt{ChannelId: channelId, StatusCode: bizErr.StatusCode, Message: bizErr.Message})
 		channelName := c.GetString(ctxkey.ChannelName)
 		go processChannelRelayError(ctx, channelId, channelName, bizErr)
+		if !shouldRetry(c, bizErr.StatusCode) {
+			break
+		}
 	}
 	if bizErr != nil {
 		if bizErr.StatusCode == http.StatusTooManyRequests {
 			bizErr.Error.Message = "当前分组上游负载已饱和，请稍后再试"
 		}
 		bizErr.Error.Message = helper.MessageWithRequestId(bizErr.Error.Message, requestId)
-		c.JSON(bizErr.StatusCode, gin.H{
+		response := gin.H{
 			"error": bizErr.Error,
-		})
+		}
+		// only expose the tried channels to admins, normal users shouldn't know about them
+		if config.DebugEnabled || c.GetInt(ctxkey.Role) >= dbmodel.RoleAdminUser {
+			response["attempts"] = attempts
+		}
+		c.JSON(bizErr.StatusCode, response)
 	}
 }
 
+// NoRetryHeader lets a client disable retries for a request, e.g. when it retries by itself
+// or when the request is not idempotent, ?retry=0 does the same
+const NoRetryHeader = "X-One-API-No-Retry"
+
+func isRetryDisabled(c *gin.Context) bool {
+	if noRetry, err := strconv.ParseBool(c.GetHeader(NoRetryHeader)); err == nil && noRetry {
+		return true
+	}
+	retry := c.Query("retry")
+	return retry == "0" || retry == "false"
+}
+
 func shouldRetry(c *gin.Context, statusCode int) bool {
 	if _, ok := c.Get(ctxkey.SpecificChannelId); ok {
 		return false
 	}
+	if isRetryDisabled(c) {
+		return false
+	}
+	if relaymode.GetByPath(c.Request.URL.Path) == relaymode.Assistants {
+		// assistants objects only exist on the pinned channel
+		return false
+	}
+	if c.Request.Context().Err() != nil {
+		// the client has gone away, no one would get the response of a retry
+		return false
+	}
+	if controller.HasUpstreamResponded(c) {
+		// the upstream may have produced a completion, a retry would duplicate it and bill it twice
+		return false
+	}
 	if statusCode == http.StatusTooManyRequests {
 		return true
 	}
 	if statusCode/100 == 5 {
 		return true
 	}
-	if statusCode == http.StatusBadRequest {
+	if config.RetryOnlySafeErrors {
+		return false
+	}
+	if statusCode == http.StatusBadRequest || statusCode == http.StatusNotAcceptable {
 		return false
 	}
 	if statusCode/100 == 2 {
@@ -116,7 +213,15 @@ func shouldRetry(c *gin.Context, statusCode int) bool {
 }
 
 func processChannelRelayError(ctx context.Context, channelId int, channelName string, err *model.ErrorWithStatusCode) {
-	logger.Errorf(ctx, "relay error (channel #%d): %s", channelId, err.Message)
+	if err.Code == controller.ChannelAtCapacityErrorCode {
+		// the upstream hasn't been called, the channel is only busy
+		logger.Infof(ctx, "channel #%d is at capacity, failing over", channelId)
+		return
+	}
+	logger.ErrorWithFields(ctx, fmt.Sprintf("relay error (channel #%d): %s", channelId, err.Message), logger.Fields{
+		"channel_id": channelId,
+		"status":     err.StatusCode,
+	})
 	// https://platform.openai.com/docs/guides/error-codes/api-errors
 	if monitor.ShouldDisableChannel(&err.Error, err.StatusCode) {
 		monitor.DisableChannel(channelId, channelName, err.Message)
@@ -125,6 +230,21 @@ func processChannelRelayError(ctx context.Context, channelId int, channelName st
 	}
 }
 
+// processUpstreamStreamError reports a stream that failed halfway against its channel,
+// such a request has been answered and billed, but the channel may still need to be disabled
+func processUpstreamStreamError(c *gin.Context) bool {
+	value, ok := c.Get(ctxkey.UpstreamStreamError)
+	if !ok {
+		return false
+	}
+	streamErr, ok := value.(*model.ErrorWithStatusCode)
+	if !ok {
+		return false
+	}
+	go processChannelRelayError(c.Request.Context(), c.GetInt(ctxkey.ChannelId), c.GetString(ctxkey.ChannelName), streamErr)
+	return true
+}
+
 func RelayNotImplemented(c *gin.Context) {
 	err := model.Error{
 		Message: "API not implemented",
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRelayContext(target string, header http.Header) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, target, nil)
+	for k, v := range header {
+		c.Request.Header.Set(k, v[0])
+	}
+	return c
+}
+
+func TestShouldRetryDisabledByClient(t *testing.T) {
+	c := newRelayContext("/v1/chat/completions", nil)
+	assert.True(t, shouldRetry(c, http.StatusTooManyRequests))
+	assert.True(t, shouldRetry(c, http.StatusBadGateway))
+
+	c = newRelayContext("/v1/chat/completions", http.Header{NoRetryHeader: {"true"}})
+	assert.False(t, shouldRetry(c, http.StatusTooManyRequests))
+	assert.False(t, shouldRetry(c, http.StatusBadGateway))
+
+	c = newRelayContext("/v1/chat/completions?retry=0", nil)
+	assert.False(t, shouldRetry(c, http.StatusBadGateway))
+
+	c = newRelayContext("/v1/chat/completions", http.Header{NoRetryHeader: {"false"}})
+	assert.True(t, shouldRetry(c, http.StatusBadGateway))
+}
+
+func TestShouldRetryOnlyBeforeUpstreamResponded(t *testing.T) {
+	c := newRelayContext("/v1/chat/completions", nil)
+	assert.True(t, shouldRetry(c, http.StatusUnauthorized))
+	c.Set(ctxkey.UpstreamResponded, true)
+	assert.False(t, shouldRetry(c, http.StatusBadGateway))
+
+	// a response which has started reaching the client can't be retried either
+	c = newRelayContext("/v1/chat/completions", nil)
+	_, _ = c.Writer.WriteString("data: ")
+	assert.False(t, shouldRetry(c, http.StatusInternalServerError))
+
+	config.RetryOnlySafeErrors = true
+	defer func() { config.RetryOnlySafeErrors = false }()
+	c = newRelayContext("/v1/chat/completions", nil)
+	assert.False(t, shouldRetry(c, http.StatusUnauthorized))
+	assert.True(t, shouldRetry(c, http.StatusTooManyRequests))
+	assert.True(t, shouldRetry(c, http.StatusServiceUnavailable))
+}
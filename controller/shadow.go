@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/middleware"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// shadowSlots bounds the number of mirrored requests in flight, requests beyond it are not mirrored
+var shadowSlots = make(chan struct{}, config.ShadowMaxConcurrency)
+
+// shadowTimeout bounds each mirrored request, so that a hanging shadow channel can't hold its slot forever
+var shadowTimeout = time.Duration(config.ShadowTimeout) * time.Second
+
+// mirrorToShadowChannel fires a copy of the request to the shadow channel of the group, if any,
+// it must be called after the primary response has been written, and never affects it
+func mirrorToShadowChannel(c *gin.Context, relayMode int) {
+	switch relayMode {
+	case relaymode.ChatCompletions, relaymode.Completions, relaymode.Embeddings:
+	default:
+		return
+	}
+	group := c.GetString(ctxkey.Group)
+	shadowChannelId := dbmodel.GetShadowChannelId(group)
+	if shadowChannelId == 0 || shadowChannelId == c.GetInt(ctxkey.ChannelId) {
+		return
+	}
+	ctx := c.Request.Context()
+	select {
+	case shadowSlots <- struct{}{}:
+	default:
+		logger.Warnf(ctx, "too many mirrored requests in flight, request not mirrored to shadow channel #%d", shadowChannelId)
+		return
+	}
+	channel, err := dbmodel.CacheGetChannelById(shadowChannelId)
+	if err != nil {
+		<-shadowSlots
+		logger.Warnf(ctx, "shadow channel #%d of group %s is not available", shadowChannelId, group)
+		return
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		<-shadowSlots
+		return
+	}
+	// the primary request is done once this returns, so the copy must not depend on its context
+	requestId := c.GetString(logger.RequestIdKey)
+	shadowCtx, cancel := context.WithTimeout(context.WithValue(context.Background(), logger.RequestIdKey, requestId), shadowTimeout)
+	cp := c.Copy()
+	cp.Request = c.Request.Clone(shadowCtx)
+	cp.Request.Body = http.NoBody
+	cp.Set(common.KeyRequestBody, requestBody)
+	cp.Writer = &discardResponseWriter{header: make(http.Header)}
+	// the config of the primary channel must not leak into the shadow one
+	for k := range cp.Keys {
+		if strings.HasPrefix(k, ctxkey.ConfigPrefix) {
+			delete(cp.Keys, k)
+		}
+	}
+	originalModel := c.GetString(ctxkey.OriginalModel)
+	middleware.SetupContextForSelectedChannel(cp, channel, originalModel)
+	// c is recycled by gin once the primary request is done, only cp may be used from now on
+	fields := logger.Fields{
+		"channel_id":         shadowChannelId,
+		"primary_channel_id": c.GetInt(ctxkey.ChannelId),
+		"model":              originalModel,
+	}
+	go func() {
+		defer func() {
+			cancel()
+			<-shadowSlots
+		}()
+		startTime := time.Now()
+		usage, bizErr := controller.RelayShadowHelper(cp)
+		fields["latency"] = time.Since(startTime).Milliseconds()
+		if bizErr != nil {
+			fields["status"] = bizErr.StatusCode
+			logger.WarnWithFields(shadowCtx, "shadow request failed: "+bizErr.Message, fields)
+			return
+		}
+		fields["status"] = http.StatusOK
+		if usage != nil {
+			fields["prompt_tokens"] = usage.PromptTokens
+			fields["completion_tokens"] = usage.CompletionTokens
+		}
+		logger.InfoWithFields(shadowCtx, "shadow request finished", fields)
+	}()
+}
+
+// discardResponseWriter is the response writer of mirrored requests, whatever the shadow channel answers is dropped
+type discardResponseWriter struct {
+	header http.Header
+	status int
+	size   int
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	w.size += len(data)
+	return len(data), nil
+}
+
+func (w *discardResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *discardResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *discardResponseWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *discardResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *discardResponseWriter) Size() int {
+	return w.size
+}
+
+func (w *discardResponseWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *discardResponseWriter) Flush() {
+}
+
+func (w *discardResponseWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func (w *discardResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, errors.New("hijack is not supported by mirrored requests")
+}
+
+func (w *discardResponseWriter) Pusher() http.Pusher {
+	return nil
+}
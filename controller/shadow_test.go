@@ -0,0 +1,61 @@
+package controller
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	dbmodel "github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestMirrorToShadowChannelTimesOut(t *testing.T) {
+	cancelled := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the shadow channel hangs until the request is cancelled
+		_, _ = io.ReadAll(r.Body)
+		<-r.Context().Done()
+		close(cancelled)
+	}))
+	defer upstream.Close()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&dbmodel.Channel{}))
+	baseURL := upstream.URL
+	assert.NoError(t, db.Create(&dbmodel.Channel{Id: 2, Type: channeltype.OpenAI, Key: "sk-shadow", Status: dbmodel.ChannelStatusEnabled, BaseURL: &baseURL}).Error)
+	originalDB, originalTimeout := dbmodel.DB, shadowTimeout
+	dbmodel.DB, shadowTimeout = db, 100*time.Millisecond
+	config.ApproximateTokenEnabled = true
+	assert.NoError(t, dbmodel.UpdateGroupShadowChannelByJSONString(`{"default": 2}`))
+	defer func() {
+		dbmodel.DB, shadowTimeout = originalDB, originalTimeout
+		config.ApproximateTokenEnabled = false
+		_ = dbmodel.UpdateGroupShadowChannelByJSONString(`{}`)
+	}()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Set(ctxkey.Group, "default")
+	c.Set(ctxkey.ChannelId, 1)
+	c.Set(ctxkey.OriginalModel, "gpt-4o")
+	mirrorToShadowChannel(c, relaymode.ChatCompletions)
+
+	select {
+	case <-cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the shadow request was not cancelled")
+	}
+	assert.Eventually(t, func() bool { return len(shadowSlots) == 0 }, time.Second, 10*time.Millisecond)
+}
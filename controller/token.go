@@ -150,6 +150,7 @@ func AddToken(c *gin.Context) {
 		UnlimitedQuota: token.UnlimitedQuota,
 		Models:         token.Models,
 		Subnet:         token.Subnet,
+		SigningSecret:  token.SigningSecret,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -239,6 +240,7 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
 		cleanToken.Models = token.Models
 		cleanToken.Subnet = token.Subnet
+		cleanToken.SigningSecret = token.SigningSecret
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -6,8 +6,10 @@ import (
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"net/http"
 	"strconv"
 	"time"
@@ -407,6 +409,8 @@ func UpdateUser(c *gin.Context) {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
+	// the group override is only set by UpdateUserGroupOverride, as it must be possible to remove it
+	updatedUser.GroupOverride, updatedUser.GroupOverrideExpiresAt = "", 0
 	if err := updatedUser.Update(updatePassword); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -424,6 +428,78 @@ func UpdateUser(c *gin.Context) {
 	return
 }
 
+type GroupOverrideRequest struct {
+	Id        int    `json:"id"`
+	Group     string `json:"group"`
+	ExpiresAt int64  `json:"expires_at"`
+}
+
+// UpdateUserGroupOverride relays the requests of a user in another group until the override expires,
+// an empty group removes the override
+func UpdateUserGroupOverride(c *gin.Context) {
+	var req GroupOverrideRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Id == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if req.Group != "" {
+		if _, ok := billingratio.GetGroupRatioMap()[req.Group]; !ok {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("分组 %s 不存在", req.Group),
+			})
+			return
+		}
+		if req.ExpiresAt != 0 && req.ExpiresAt <= helper.GetTimestamp() {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "过期时间已过",
+			})
+			return
+		}
+	} else {
+		req.ExpiresAt = 0
+	}
+	user, err := model.GetUserById(req.Id, false)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	myRole := c.GetInt(ctxkey.Role)
+	if myRole <= user.Role && myRole != model.RoleRootUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权更新同权限等级或更高权限等级的用户信息",
+		})
+		return
+	}
+	if err = model.UpdateUserGroupOverride(user.Id, req.Group, req.ExpiresAt); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Group == "" {
+		model.RecordLog(user.Id, model.LogTypeManage, "管理员取消了用户的临时分组")
+	} else if req.ExpiresAt == 0 {
+		model.RecordLog(user.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户的临时分组设为 %s", req.Group))
+	} else {
+		model.RecordLog(user.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户的临时分组设为 %s，%s 过期", req.Group, time.Unix(req.ExpiresAt, 0).Format("2006-01-02 15:04:05")))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
 func UpdateSelf(c *gin.Context) {
 	var user model.User
 	err := json.NewDecoder(c.Request.Body).Decode(&user)
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"embed"
+	"errors"
 	"fmt"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-contrib/sessions/cookie"
@@ -13,9 +15,15 @@ import (
 	"github.com/songquanpeng/one-api/middleware"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/router"
+	"net/http"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
+	"time"
 )
 
 //go:embed web/build/*
@@ -78,6 +86,19 @@ func main() {
 		go model.SyncOptions(config.SyncFrequency)
 		go model.SyncChannelCache(config.SyncFrequency)
 	}
+	if config.RatioReloadInterval > 0 {
+		logger.SysLog(fmt.Sprintf("reloading ratios every %d seconds", config.RatioReloadInterval))
+		go model.SyncRatios(config.RatioReloadInterval)
+	}
+	if config.PricingFile != "" {
+		err = billingratio.LoadPricingFile(config.PricingFile)
+		if err != nil {
+			logger.FatalLog("failed to load pricing file: " + err.Error())
+		}
+		if config.PricingReloadInterval > 0 {
+			go billingratio.WatchPricingFile(config.PricingFile, config.PricingReloadInterval)
+		}
+	}
 	if os.Getenv("CHANNEL_TEST_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_TEST_FREQUENCY"))
 		if err != nil {
@@ -90,6 +111,17 @@ func main() {
 		logger.SysLog("batch update enabled with interval " + strconv.Itoa(config.BatchUpdateInterval) + "s")
 		model.InitBatchUpdater()
 	}
+	if config.AuditEnabled {
+		logger.SysLog(fmt.Sprintf("audit log enabled with retention of %d days", config.AuditRetentionDays))
+		model.InitAuditLogWriter()
+	}
+	if config.LogBatchEnabled {
+		logger.SysLog(fmt.Sprintf("consume log batch writing enabled with size %d and interval %ds", config.LogBatchSize, config.LogBatchInterval))
+		model.InitConsumeLogBatchWriter()
+	}
+	if err = middleware.ValidateDisabledEndpoints(config.DisabledEndpoints); err != nil {
+		logger.FatalLog("invalid DISABLED_ENDPOINTS: " + err.Error())
+	}
 	if config.EnableMetric {
 		logger.SysLog("metric enabled, will disable channel if too much request failed")
 	}
@@ -111,8 +143,33 @@ func main() {
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
 	}
-	err = server.Run(":" + port)
+	srv := &http.Server{
+		Addr:    ":" + port,
+		Handler: server,
+	}
+	go func() {
+		err := srv.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.FatalLog("failed to start HTTP server: " + err.Error())
+		}
+	}()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+	logger.SysLog("shutting down server...")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = srv.Shutdown(ctx)
 	if err != nil {
-		logger.FatalLog("failed to start HTTP server: " + err.Error())
+		logger.SysError("failed to shutdown HTTP server: " + err.Error())
+	}
+	// the consume logs are recorded by the billing in the background, which may outlive the requests
+	if !billing.WaitInFlight(ctx) {
+		logger.SysError("timed out waiting for the billing in flight, some requests may not be billed")
+	}
+	if config.LogBatchEnabled {
+		model.FlushConsumeLogs()
 	}
+	logger.SysLog("server exited")
 }
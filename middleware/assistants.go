@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+type assistantsObjectRef struct {
+	id         string
+	objectType string
+}
+
+// getAssistantsObjectRefs returns the assistant or the thread the path refers to, and the assistant a run is created with,
+// the messages and runs are reached through their thread, so the thread stands for them
+func getAssistantsObjectRefs(c *gin.Context) []assistantsObjectRef {
+	var refs []assistantsObjectRef
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(c.Request.URL.Path, "/v1/"), "/"), "/")
+	if len(parts) >= 2 {
+		switch parts[0] {
+		case "assistants":
+			refs = append(refs, assistantsObjectRef{id: parts[1], objectType: model.AssistantsObjectTypeAssistant})
+		case "threads":
+			// POST /v1/threads/runs creates a thread along with the run
+			if parts[1] != "runs" {
+				refs = append(refs, assistantsObjectRef{id: parts[1], objectType: model.AssistantsObjectTypeThread})
+			}
+		}
+	}
+	if isAssistantsRunCreation(c) {
+		var runRequest struct {
+			AssistantId string `json:"assistant_id"`
+		}
+		if err := common.UnmarshalBodyReusable(c, &runRequest); err == nil && runRequest.AssistantId != "" {
+			refs = append(refs, assistantsObjectRef{id: runRequest.AssistantId, objectType: model.AssistantsObjectTypeAssistant})
+		}
+	}
+	return refs
+}
+
+// canAccessAssistantsObject reports whether the object is owned by the user of the request,
+// the objects without a record were created before the owners were recorded, they are left to the admins
+func canAccessAssistantsObject(c *gin.Context, id string) (bool, error) {
+	object, err := model.GetAssistantsObject(id)
+	if err != nil {
+		return false, err
+	}
+	if object == nil {
+		return c.GetInt(ctxkey.Role) >= model.RoleAdminUser, nil
+	}
+	return object.UserId == c.GetInt(ctxkey.Id), nil
+}
+
+// checkAssistantsAccess aborts the requests to the assistants and threads of the other users with a 404,
+// as the upstream does for the objects which don't exist, so that their ids aren't confirmed either
+func checkAssistantsAccess(c *gin.Context) bool {
+	for _, ref := range getAssistantsObjectRefs(c) {
+		ok, err := canAccessAssistantsObject(c, ref.id)
+		if err != nil {
+			abortWithMessage(c, http.StatusInternalServerError, err.Error())
+			return false
+		}
+		if !ok {
+			abortWithMessage(c, http.StatusNotFound, fmt.Sprintf("No %s found with id '%s'.", ref.objectType, ref.id))
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestCheckAssistantsAccess(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.AssistantsObject{}))
+	originalDB := model.DB
+	model.DB = db
+	defer func() { model.DB = originalDB }()
+
+	assert.NoError(t, model.RecordAssistantsObject("thread_1", model.AssistantsObjectTypeThread, 1, 10, "token"))
+	assert.NoError(t, model.RecordAssistantsObject("asst_1", model.AssistantsObjectTypeAssistant, 1, 10, "token"))
+	// the first record is kept
+	assert.NoError(t, model.RecordAssistantsObject("thread_1", model.AssistantsObjectTypeThread, 2, 20, "other"))
+
+	check := func(userId int, role int, method string, path string, body string) int {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(method, path, strings.NewReader(body))
+		c.Request.Header.Set("Content-Type", "application/json")
+		c.Set(ctxkey.Id, userId)
+		c.Set(ctxkey.Role, role)
+		if !checkAssistantsAccess(c) {
+			return w.Code
+		}
+		return http.StatusOK
+	}
+	assert.Equal(t, http.StatusOK, check(1, model.RoleCommonUser, http.MethodGet, "/v1/threads/thread_1/messages", ""))
+	assert.Equal(t, http.StatusOK, check(1, model.RoleCommonUser, http.MethodPost, "/v1/threads/thread_1/runs", `{"assistant_id":"asst_1"}`))
+	assert.Equal(t, http.StatusOK, check(2, model.RoleCommonUser, http.MethodPost, "/v1/threads", ""))
+	// the objects of another user are not found, whatever the path reaching them
+	assert.Equal(t, http.StatusNotFound, check(2, model.RoleCommonUser, http.MethodGet, "/v1/threads/thread_1", ""))
+	assert.Equal(t, http.StatusNotFound, check(2, model.RoleCommonUser, http.MethodGet, "/v1/threads/thread_1/runs/run_1/steps", ""))
+	assert.Equal(t, http.StatusNotFound, check(2, model.RoleCommonUser, http.MethodDelete, "/v1/assistants/asst_1", ""))
+	assert.Equal(t, http.StatusNotFound, check(2, model.RoleCommonUser, http.MethodPost, "/v1/threads/runs", `{"assistant_id":"asst_1"}`))
+	assert.Equal(t, http.StatusNotFound, check(2, model.RoleAdminUser, http.MethodGet, "/v1/threads/thread_1", ""))
+	// the objects without a record are left to the admins
+	assert.Equal(t, http.StatusNotFound, check(2, model.RoleCommonUser, http.MethodGet, "/v1/threads/thread_old", ""))
+	assert.Equal(t, http.StatusOK, check(2, model.RoleAdminUser, http.MethodGet, "/v1/threads/thread_old", ""))
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"net/http"
+	"sync"
+)
+
+// audioTranscriptions counts the transcription & translation requests in flight of this instance
+var audioTranscriptions int
+var audioTranscriptionsLock sync.Mutex
+
+// acquireAudioTranscription returns false without counting the request if the limit is reached
+func acquireAudioTranscription(maxTranscriptions int) bool {
+	audioTranscriptionsLock.Lock()
+	defer audioTranscriptionsLock.Unlock()
+	if audioTranscriptions >= maxTranscriptions {
+		return false
+	}
+	audioTranscriptions++
+	return true
+}
+
+func releaseAudioTranscription() {
+	audioTranscriptionsLock.Lock()
+	defer audioTranscriptionsLock.Unlock()
+	audioTranscriptions--
+}
+
+func isAudioTranscriptionRequest(c *gin.Context) bool {
+	relayMode := relaymode.GetByPath(c.Request.URL.Path)
+	return relayMode == relaymode.AudioTranscription || relayMode == relaymode.AudioTranslation
+}
+
+// AudioLimit limits the concurrent transcription & translation requests, separately from the text ones,
+// it comes after TokenAuth and Distribute, so that the unauthenticated requests can't take the slots
+func AudioLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		maxTranscriptions := config.MaxConcurrentAudioTranscriptions
+		if maxTranscriptions <= 0 || !isAudioTranscriptionRequest(c) {
+			c.Next()
+			return
+		}
+		if !acquireAudioTranscription(maxTranscriptions) {
+			c.Header("Retry-After", "1")
+			abortWithMessage(c, http.StatusTooManyRequests, "当前音频转录请求过多，请稍后再试")
+			return
+		}
+		// deferred so that the request is released even if the handler panics
+		defer releaseAudioTranscription()
+		c.Next()
+	}
+}
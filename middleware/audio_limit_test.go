@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAudioLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	config.MaxConcurrentAudioTranscriptions = 1
+	defer func() { config.MaxConcurrentAudioTranscriptions = 0 }()
+
+	var inFlight []int
+	router := gin.New()
+	router.Use(gin.Recovery(), AudioLimit())
+	router.POST("/v1/audio/transcriptions", func(c *gin.Context) {
+		inFlight = append(inFlight, audioTranscriptions)
+		if c.Query("panic") == "true" {
+			panic("handler failed")
+		}
+		// a second transcription is rejected while this one is in flight, while text requests are not counted
+		textCode := sendAudioLimitRequest(router, "/v1/chat/completions")
+		audioCode := sendAudioLimitRequest(router, "/v1/audio/transcriptions?nested=true")
+		c.String(http.StatusOK, "%d %d", textCode, audioCode)
+	})
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		inFlight = append(inFlight, audioTranscriptions)
+		c.Status(http.StatusOK)
+	})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", strings.NewReader("audio")))
+	assert.Equal(t, "200 429", w.Body.String())
+	assert.Equal(t, []int{1, 1}, inFlight)
+	assert.Equal(t, 0, audioTranscriptions)
+
+	// the request is released even if the handler panics
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions?panic=true", strings.NewReader("audio")))
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Equal(t, 0, audioTranscriptions)
+}
+
+func sendAudioLimitRequest(router *gin.Engine, path string) int {
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader("audio")))
+	return w.Code
+}
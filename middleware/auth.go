@@ -116,6 +116,21 @@ func TokenAuth() func(c *gin.Context) {
 			abortWithMessage(c, http.StatusForbidden, "用户已被封禁")
 			return
 		}
+		// kept in the context, so that the relay doesn't look the role up again
+		role := model.RoleCommonUser
+		if model.CacheIsAdmin(token.UserId) {
+			role = model.RoleAdminUser
+		}
+		// the body is only read from here on, so only the authenticated requests are decompressed
+		if !decompressRequest(c) {
+			return
+		}
+		if token.SigningSecret != nil && *token.SigningSecret != "" {
+			if err := verifyRequestSignature(c, *token.SigningSecret); err != nil {
+				abortWithMessage(c, http.StatusUnauthorized, err.Error())
+				return
+			}
+		}
 		requestModel, err := getRequestModel(c)
 		if err != nil && shouldCheckModel(c) {
 			abortWithMessage(c, http.StatusBadRequest, err.Error())
@@ -130,10 +145,13 @@ func TokenAuth() func(c *gin.Context) {
 			}
 		}
 		c.Set(ctxkey.Id, token.UserId)
+		c.Set(ctxkey.Role, role)
 		c.Set(ctxkey.TokenId, token.Id)
 		c.Set(ctxkey.TokenName, token.Name)
+		c.Set(ctxkey.TokenQuotaPoolId, token.QuotaPoolId)
+		c.Set(ctxkey.TokenExpiredTime, token.ExpiredTime)
 		if len(parts) > 1 {
-			if model.IsAdmin(token.UserId) {
+			if role >= model.RoleAdminUser {
 				c.Set(ctxkey.SpecificChannelId, parts[1])
 			} else {
 				abortWithMessage(c, http.StatusForbidden, "普通用户不支持指定渠道")
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// newDeflateReader reads a deflate body, which is zlib wrapped as specified, but raw deflate from some clients
+func newDeflateReader(body io.Reader) (io.ReadCloser, error) {
+	reader := bufio.NewReader(body)
+	header, err := reader.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+	isZlib := header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0
+	if isZlib {
+		return zlib.NewReader(reader)
+	}
+	return flate.NewReader(reader), nil
+}
+
+// decompressBody returns the decompressed body, an error status is returned with the error
+func decompressBody(encoding string, body io.Reader, maxSize int) ([]byte, int, error) {
+	var reader io.ReadCloser
+	var err error
+	switch encoding {
+	case "gzip", "x-gzip":
+		reader, err = gzip.NewReader(body)
+	case "deflate":
+		reader, err = newDeflateReader(body)
+	default:
+		return nil, http.StatusUnsupportedMediaType, fmt.Errorf("content encoding %s is not supported", encoding)
+	}
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid %s request body: %w", encoding, err)
+	}
+	defer reader.Close()
+	// read one more byte than allowed to tell a body of the maximum size from a larger one
+	data, err := io.ReadAll(io.LimitReader(reader, int64(maxSize)+1))
+	if err != nil {
+		return nil, http.StatusBadRequest, fmt.Errorf("invalid %s request body: %w", encoding, err)
+	}
+	if len(data) > maxSize {
+		return nil, http.StatusRequestEntityTooLarge, fmt.Errorf("decompressed request body is larger than %d bytes", maxSize)
+	}
+	return data, http.StatusOK, nil
+}
+
+// decompressRequest replaces a gzip or deflate request body with the decompressed one, whatever its content type is,
+// bodies decompressing to more than MAX_DECOMPRESSED_REQUEST_SIZE are rejected, it returns false if the request is aborted
+func decompressRequest(c *gin.Context) bool {
+	encoding := strings.ToLower(strings.TrimSpace(c.Request.Header.Get("Content-Encoding")))
+	if !config.RequestDecompressionEnabled || encoding == "" || encoding == "identity" {
+		return true
+	}
+	data, status, err := decompressBody(encoding, c.Request.Body, config.MaxDecompressedRequestSize)
+	_ = c.Request.Body.Close()
+	if err != nil {
+		abortWithMessage(c, status, err.Error())
+		return false
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(data))
+	c.Request.ContentLength = int64(len(data))
+	c.Request.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	c.Request.Header.Del("Content-Encoding")
+	return true
+}
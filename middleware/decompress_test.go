@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func compress(t *testing.T, encoding string, data string) []byte {
+	var buf bytes.Buffer
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(&buf)
+	case "deflate":
+		writer = zlib.NewWriter(&buf)
+	default:
+		var err error
+		writer, err = flate.NewWriter(&buf, flate.DefaultCompression)
+		assert.NoError(t, err)
+	}
+	_, err := writer.Write([]byte(data))
+	assert.NoError(t, err)
+	assert.NoError(t, writer.Close())
+	return buf.Bytes()
+}
+
+func TestTokenAuthDecompressesRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.User{}, &model.Token{}))
+	originalDB, originalRedisEnabled := model.DB, common.RedisEnabled
+	model.DB, common.RedisEnabled = db, false
+	defer func() { model.DB, common.RedisEnabled = originalDB, originalRedisEnabled }()
+	assert.NoError(t, db.Create(&model.User{Id: 1, Username: "user", Status: model.UserStatusEnabled}).Error)
+	assert.NoError(t, db.Create(&model.Token{Id: 1, UserId: 1, Key: "decompress", Status: model.TokenStatusEnabled, ExpiredTime: -1, UnlimitedQuota: true}).Error)
+
+	router := gin.New()
+	router.Use(TokenAuth())
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		var request struct {
+			Model string `json:"model"`
+		}
+		if err := c.ShouldBindJSON(&request); err != nil {
+			c.String(http.StatusBadRequest, err.Error())
+			return
+		}
+		c.String(http.StatusOK, request.Model)
+	})
+	send := func(encoding string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer sk-decompress")
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", encoding)
+		router.ServeHTTP(w, req)
+		return w
+	}
+	body := `{"model": "gpt-4o"}`
+
+	for _, encoding := range []string{"gzip", "deflate"} {
+		w := send(encoding, compress(t, encoding, body))
+		assert.Equal(t, http.StatusOK, w.Code, encoding)
+		assert.Equal(t, "gpt-4o", w.Body.String())
+	}
+	// raw deflate without the zlib wrapper
+	w := send("deflate", compress(t, "raw", body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "gpt-4o", w.Body.String())
+
+	w = send("", []byte(body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("br", []byte(body))
+	assert.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	w = send("gzip", []byte(body))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	maxSize := config.MaxDecompressedRequestSize
+	config.MaxDecompressedRequestSize = len(body)
+	defer func() { config.MaxDecompressedRequestSize = maxSize }()
+	w = send("gzip", compress(t, "gzip", body))
+	assert.Equal(t, http.StatusOK, w.Code)
+	// a small body decompressing to a large one
+	w = send("gzip", compress(t, "gzip", body+strings.Repeat(" ", 1<<20)))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+}
+
+func TestTokenAuthDecompressesOnlyAuthenticatedRequests(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(TokenAuth())
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	body := &readCounter{Reader: bytes.NewReader(compress(t, "gzip", `{"model": "gpt-4o"}`))}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", body)
+	req.Header.Set("Content-Encoding", "gzip")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 0, body.read)
+}
+
+type readCounter struct {
+	io.Reader
+	read int
+}
+
+func (r *readCounter) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
@@ -3,14 +3,24 @@ package middleware
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
+// NoDowngradeHeader lets a client opt out of the downgrade to a cheaper model when its quota runs low
+const NoDowngradeHeader = "X-One-API-No-Downgrade"
+
+// DowngradedFromHeader tells the client the model its request asked for when it was downgraded
+const DowngradedFromHeader = "X-One-API-Downgraded-From"
+
 type ModelRequest struct {
 	Model string `json:"model"`
 }
@@ -18,11 +28,35 @@ type ModelRequest struct {
 func Distribute() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		userId := c.GetInt(ctxkey.Id)
-		userGroup, _ := model.CacheGetUserGroup(userId)
+		userGroup, _ := model.CacheGetUserEffectiveGroup(userId)
+		if configuredGroup, _ := model.CacheGetUserGroup(userId); configuredGroup != userGroup {
+			logger.Infof(c.Request.Context(), "user %d is relayed in group %s instead of group %s by the group override", userId, userGroup, configuredGroup)
+		}
 		c.Set(ctxkey.Group, userGroup)
+		if modelName := c.GetString(ctxkey.RequestModel); modelName != "" && !model.IsModelAllowedForGroup(userGroup, modelName) {
+			abortWithMessage(c, http.StatusForbidden, fmt.Sprintf("当前分组 %s 无权使用模型 %s", userGroup, modelName))
+			return
+		}
+		// the model of a run is the one of its assistant unless the request overrides it,
+		// so a token restricted to some models must name one of them to create a run
+		if c.GetString(ctxkey.AvailableModels) != "" && c.GetString(ctxkey.RequestModel) == "" && isAssistantsRunCreation(c) {
+			abortWithMessage(c, http.StatusForbidden, "该令牌仅可使用部分模型，创建运行时须指定模型")
+			return
+		}
+		if relaymode.GetByPath(c.Request.URL.Path) == relaymode.Assistants && !checkAssistantsAccess(c) {
+			return
+		}
 		var requestModel string
 		var channel *model.Channel
 		channelId, ok := c.Get(ctxkey.SpecificChannelId)
+		if !ok && relaymode.GetByPath(c.Request.URL.Path) == relaymode.Assistants {
+			// assistants & threads are stateful, they must always go to the same channel
+			if config.AssistantsChannelId == 0 {
+				abortWithMessage(c, http.StatusServiceUnavailable, "未配置 Assistants API 所使用的渠道")
+				return
+			}
+			channelId, ok = strconv.Itoa(config.AssistantsChannelId), true
+		}
 		if ok {
 			id, err := strconv.Atoi(channelId.(string))
 			if err != nil {
@@ -40,8 +74,16 @@ func Distribute() func(c *gin.Context) {
 			}
 		} else {
 			requestModel = c.GetString(ctxkey.RequestModel)
+			c.Set(ctxkey.DowngradedFrom, "")
+			if downgradedModel := getDowngradedModel(c, userGroup, requestModel); downgradedModel != "" {
+				logger.Infof(c.Request.Context(), "model %s of user %d is downgraded to %s as the quota is low", requestModel, userId, downgradedModel)
+				c.Set(ctxkey.DowngradedFrom, requestModel)
+				c.Header(DowngradedFromHeader, requestModel)
+				requestModel = downgradedModel
+				c.Set(ctxkey.RequestModel, requestModel)
+			}
 			var err error
-			channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, requestModel, false)
+			channel, err = model.CacheGetStickyChannel(userGroup, requestModel, getStickySessionKey(c, userGroup))
 			if err != nil {
 				message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, requestModel)
 				if channel != nil {
@@ -57,7 +99,56 @@ func Distribute() func(c *gin.Context) {
 	}
 }
 
+// getDowngradedModel returns the cheaper model the request is downgraded to, empty if it is not downgraded,
+// only chat & text completions are downgraded, and the cheaper model must be permitted for the group & token
+func getDowngradedModel(c *gin.Context, group string, requestModel string) string {
+	if config.ModelDowngradeQuotaThreshold <= 0 || requestModel == "" {
+		return ""
+	}
+	relayMode := relaymode.GetByPath(c.Request.URL.Path)
+	if relayMode != relaymode.ChatCompletions && relayMode != relaymode.Completions {
+		return ""
+	}
+	if noDowngrade, err := strconv.ParseBool(c.GetHeader(NoDowngradeHeader)); err == nil && noDowngrade {
+		return ""
+	}
+	downgradedModel := model.GetGroupDowngradeModel(group, requestModel)
+	if downgradedModel == "" || !model.IsModelAllowedForGroup(group, downgradedModel) {
+		return ""
+	}
+	if availableModels := c.GetString(ctxkey.AvailableModels); availableModels != "" && !isModelInList(downgradedModel, availableModels) {
+		return ""
+	}
+	userQuota, err := model.CacheGetUserQuota(c.Request.Context(), c.GetInt(ctxkey.Id))
+	if err != nil || userQuota >= config.ModelDowngradeQuotaThreshold {
+		return ""
+	}
+	return downgradedModel
+}
+
+// getStickySessionKey returns the key the request sticks to a channel by, empty if the group has no sticky session,
+// the user field of the request falls back to the user id when it's missing
+func getStickySessionKey(c *gin.Context, group string) string {
+	userIdKey := "user_id:" + strconv.Itoa(c.GetInt(ctxkey.Id))
+	switch model.GetStickySessionKey(group) {
+	case model.StickySessionKeyUserId:
+		return userIdKey
+	case model.StickySessionKeyUser:
+		var userRequest struct {
+			User string `json:"user"`
+		}
+		err := common.UnmarshalBodyReusable(c, &userRequest)
+		if err != nil || userRequest.User == "" {
+			return userIdKey
+		}
+		return userIdKey + ":user:" + userRequest.User
+	default:
+		return ""
+	}
+}
+
 func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, modelName string) {
+	clearChannelConfig(c)
 	c.Set(ctxkey.Channel, channel.Type)
 	c.Set(ctxkey.ChannelId, channel.Id)
 	c.Set(ctxkey.ChannelName, channel.Name)
@@ -83,3 +174,16 @@ func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel, mode
 		c.Set(ctxkey.ConfigPrefix+k, v)
 	}
 }
+
+// clearChannelConfig empties the config of the previously selected channel, so that a retry inherits none of it
+func clearChannelConfig(c *gin.Context) {
+	var keys []string
+	for key := range c.Keys {
+		if strings.HasPrefix(key, ctxkey.ConfigPrefix) {
+			keys = append(keys, key)
+		}
+	}
+	for _, key := range keys {
+		c.Set(key, "")
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/controller"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupContextForSelectedChannelOnRetry(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	passThroughChannel := &model.Channel{Id: -1, Type: channeltype.OpenAI, Config: `{"pass_through":"true","rpm":"10","single_tool_call":"true"}`}
+	SetupContextForSelectedChannel(c, passThroughChannel, "gpt-4o")
+	assert.True(t, controller.IsPassThrough(c))
+	assert.Equal(t, "10", c.GetString(ctxkey.ConfigRPM))
+
+	// the retry on a normal channel inherits none of the config of the failed one
+	normalChannel := &model.Channel{Id: -2, Type: channeltype.OpenAI, Config: `{"strip_extra_fields":"true"}`}
+	SetupContextForSelectedChannel(c, normalChannel, "gpt-4o")
+	assert.False(t, controller.IsPassThrough(c))
+	assert.Empty(t, c.GetString(ctxkey.ConfigRPM))
+	assert.Empty(t, c.GetString(ctxkey.ConfigSingleToolCall))
+	assert.Equal(t, "true", c.GetString(ctxkey.ConfigStripExtraFields))
+}
@@ -0,0 +1,67 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// ValidateDisabledEndpoints returns an error naming the unknown endpoints of the list, so that a misspelled one
+// isn't left enabled silently
+func ValidateDisabledEndpoints(disabledEndpoints string) error {
+	var unknownNames []string
+	for _, name := range strings.Split(disabledEndpoints, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" && relaymode.GetByName(name) == nil {
+			unknownNames = append(unknownNames, name)
+		}
+	}
+	if len(unknownNames) > 0 {
+		return fmt.Errorf("unknown endpoints: %s", strings.Join(unknownNames, ", "))
+	}
+	return nil
+}
+
+// isEndpointDisabled reports whether the relay mode is one of the disabled endpoints, the unknown names are rejected at startup
+func isEndpointDisabled(relayMode int, disabledEndpoints string) bool {
+	for _, name := range strings.Split(disabledEndpoints, ",") {
+		for _, mode := range relaymode.GetByName(strings.TrimSpace(name)) {
+			if mode == relayMode {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// EndpointGate rejects the requests of the endpoints disabled by DISABLED_ENDPOINTS, it must come first,
+// so that the disabled endpoints are neither authenticated nor billed
+func EndpointGate() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if config.DisabledEndpoints == "" {
+			c.Next()
+			return
+		}
+		relayMode := relaymode.GetByPath(c.Request.URL.Path)
+		if relayMode == relaymode.Unknown || !isEndpointDisabled(relayMode, config.DisabledEndpoints) {
+			c.Next()
+			return
+		}
+		message := fmt.Sprintf("endpoint %s %s is disabled", c.Request.Method, c.Request.URL.Path)
+		c.JSON(http.StatusForbidden, gin.H{
+			"error": model.Error{
+				Message: helper.MessageWithRequestId(message, c.GetString(logger.RequestIdKey)),
+				Type:    "invalid_request_error",
+				Code:    "endpoint_disabled",
+			},
+		})
+		c.Abort()
+	}
+}
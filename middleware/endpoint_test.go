@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEndpointGate(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	defer func() { config.DisabledEndpoints = "" }()
+	router := gin.New()
+	router.Use(EndpointGate())
+	router.Any("/v1/*path", func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	send := func(method string, path string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+		return w.Code
+	}
+	endpoints := map[string]string{
+		"chat_completions":     "/v1/chat/completions",
+		"completions":          "/v1/completions",
+		"embeddings":           "/v1/engines/text-embedding-3-small/embeddings",
+		"moderations":          "/v1/moderations",
+		"images_generations":   "/v1/images/generations",
+		"edits":                "/v1/edits",
+		"audio_speech":         "/v1/audio/speech",
+		"audio_transcriptions": "/v1/audio/transcriptions",
+		"audio_translations":   "/v1/audio/translations",
+		"assistants":           "/v1/threads/thread_1/runs",
+	}
+	for name, path := range endpoints {
+		config.DisabledEndpoints = name
+		for otherName, otherPath := range endpoints {
+			expected := http.StatusOK
+			if otherName == name {
+				expected = http.StatusForbidden
+			}
+			assert.Equal(t, expected, send(http.MethodPost, otherPath), name+" disabled, "+otherName+" requested")
+		}
+		assert.Equal(t, http.StatusForbidden, send(http.MethodGet, path), name)
+	}
+
+	assert.NoError(t, ValidateDisabledEndpoints(""))
+	assert.NoError(t, ValidateDisabledEndpoints("images, audio,"))
+	assert.EqualError(t, ValidateDisabledEndpoints("images,imags, audios"), "unknown endpoints: imags, audios")
+
+	config.DisabledEndpoints = "images, audio,unknown"
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/images/generations"))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/audio/speech"))
+	assert.Equal(t, http.StatusForbidden, send(http.MethodPost, "/v1/audio/transcriptions"))
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/v1/chat/completions"))
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/audio/translations", nil))
+	assert.Contains(t, w.Body.String(), `"code":"endpoint_disabled"`)
+}
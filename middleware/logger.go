@@ -3,6 +3,7 @@ package middleware
 import (
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 )
 
@@ -12,7 +13,7 @@ func SetUpLogger(server *gin.Engine) {
 		if param.Keys != nil {
 			requestID = param.Keys[logger.RequestIdKey].(string)
 		}
-		return fmt.Sprintf("[GIN] %s | %s | %3d | %13v | %15s | %7s %s\n",
+		text := fmt.Sprintf("[GIN] %s | %s | %3d | %13v | %15s | %7s %s\n",
 			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
 			requestID,
 			param.StatusCode,
@@ -21,5 +22,22 @@ func SetUpLogger(server *gin.Engine) {
 			param.Method,
 			param.Path,
 		)
+		fields := logger.Fields{
+			"status":    param.StatusCode,
+			"latency":   param.Latency.Milliseconds(),
+			"client_ip": param.ClientIP,
+			"method":    param.Method,
+			"path":      param.Path,
+		}
+		if channelId, ok := param.Keys[ctxkey.ChannelId]; ok {
+			fields["channel_id"] = channelId
+		}
+		if userId, ok := param.Keys[ctxkey.Id]; ok {
+			fields["user_id"] = userId
+		}
+		if modelName, ok := param.Keys[ctxkey.RequestModel]; ok {
+			fields["model"] = modelName
+		}
+		return logger.FormatAccessLog(param.TimeStamp, requestID, fields, text)
 	}))
 }
@@ -6,7 +6,10 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
 	"net/http"
+	"strconv"
 	"time"
 )
 
@@ -14,10 +17,10 @@ var timeFormat = "2006-01-02T15:04:05.000Z"
 
 var inMemoryRateLimiter common.InMemoryRateLimiter
 
-func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, key string) {
 	ctx := context.Background()
 	rdb := common.RDB
-	key := "rateLimit:" + mark + c.ClientIP()
+	key = "rateLimit:" + key
 	listLength, err := rdb.LLen(ctx, key).Result()
 	if err != nil {
 		fmt.Println(err.Error())
@@ -60,8 +63,7 @@ func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark st
 	}
 }
 
-func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
-	key := mark + c.ClientIP()
+func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, key string) {
 	if !inMemoryRateLimiter.Request(key, maxRequestNum, duration) {
 		c.Status(http.StatusTooManyRequests)
 		c.Abort()
@@ -72,13 +74,13 @@ func memoryRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark s
 func rateLimitFactory(maxRequestNum int, duration int64, mark string) func(c *gin.Context) {
 	if common.RedisEnabled {
 		return func(c *gin.Context) {
-			redisRateLimiter(c, maxRequestNum, duration, mark)
+			redisRateLimiter(c, maxRequestNum, duration, mark+c.ClientIP())
 		}
 	} else {
 		// It's safe to call multi times.
 		inMemoryRateLimiter.Init(config.RateLimitKeyExpirationDuration)
 		return func(c *gin.Context) {
-			memoryRateLimiter(c, maxRequestNum, duration, mark)
+			memoryRateLimiter(c, maxRequestNum, duration, mark+c.ClientIP())
 		}
 	}
 }
@@ -102,3 +104,43 @@ func DownloadRateLimit() func(c *gin.Context) {
 func UploadRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(config.UploadRateLimitNum, config.UploadRateLimitDuration, "UP")
 }
+
+// getRelayRateLimitKey returns the bucket of the request according to the key source of the user's group,
+// the user field lets operators proxying for many end users limit each of them through a shared token
+func getRelayRateLimitKey(c *gin.Context) string {
+	tokenKey := "token:" + strconv.Itoa(c.GetInt(ctxkey.TokenId))
+	switch model.GetRateLimitKeySource(c.GetString(ctxkey.Group)) {
+	case model.RateLimitKeySourceIP:
+		return "ip:" + c.ClientIP()
+	case model.RateLimitKeySourceUser:
+		var userRequest struct {
+			User string `json:"user"`
+		}
+		err := common.UnmarshalBodyReusable(c, &userRequest)
+		if err != nil || userRequest.User == "" {
+			return tokenKey
+		}
+		return tokenKey + ":user:" + userRequest.User
+	default:
+		return tokenKey
+	}
+}
+
+func RelayRateLimit() func(c *gin.Context) {
+	if config.RelayRateLimitNum <= 0 {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	if !common.RedisEnabled {
+		inMemoryRateLimiter.Init(config.RateLimitKeyExpirationDuration)
+	}
+	return func(c *gin.Context) {
+		key := "RL" + getRelayRateLimitKey(c)
+		if common.RedisEnabled {
+			redisRateLimiter(c, config.RelayRateLimitNum, config.RelayRateLimitDuration, key)
+		} else {
+			memoryRateLimiter(c, config.RelayRateLimitNum, config.RelayRateLimitDuration, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+)
+
+// SignatureHeader carries the hex encoded HMAC-SHA256 of a request signed with the signing secret of its token
+const SignatureHeader = "X-One-API-Signature"
+
+// SignatureTimestampHeader carries the unix timestamp the request was signed at
+const SignatureTimestampHeader = "X-One-API-Timestamp"
+
+// signRequest returns the signature of a request, which covers the method, path, timestamp and body
+// separated by newlines, e.g. "POST\n/v1/chat/completions\n1700000000\n{...}"
+func signRequest(secret string, method string, path string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(method + "\n" + path + "\n" + timestamp + "\n"))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifyRequestSignature checks the signature of a request against the signing secret of its token,
+// a timestamp out of the REQUEST_SIGNATURE_WINDOW is rejected, so that a captured request can't be replayed later
+func verifyRequestSignature(c *gin.Context, secret string) error {
+	signature := c.Request.Header.Get(SignatureHeader)
+	timestamp := c.Request.Header.Get(SignatureTimestampHeader)
+	if signature == "" || timestamp == "" {
+		return errors.New("该令牌要求请求签名，缺少 " + SignatureHeader + " 或 " + SignatureTimestampHeader + " 请求头")
+	}
+	signedAt, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("无效的请求签名时间戳")
+	}
+	skew := helper.GetTimestamp() - signedAt
+	if skew > int64(config.RequestSignatureWindow) || skew < -int64(config.RequestSignatureWindow) {
+		return errors.New("请求签名已过期")
+	}
+	body, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	expected := signRequest(secret, c.Request.Method, c.Request.URL.Path, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return errors.New("无效的请求签名")
+	}
+	return nil
+}
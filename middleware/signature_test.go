@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyRequestSignature(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	body := `{"model":"gpt-4o"}`
+	newContext := func(signature string, timestamp string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(body))
+		c.Request.Header.Set(SignatureHeader, signature)
+		c.Request.Header.Set(SignatureTimestampHeader, timestamp)
+		return c
+	}
+	now := strconv.FormatInt(helper.GetTimestamp(), 10)
+	signature := signRequest("secret", http.MethodPost, "/v1/chat/completions", now, []byte(body))
+
+	c := newContext(signature, now)
+	assert.NoError(t, verifyRequestSignature(c, "secret"))
+	// the body is still readable by the handlers
+	readBody, err := io.ReadAll(c.Request.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(readBody))
+
+	assert.Error(t, verifyRequestSignature(newContext(signature, now), "another secret"))
+	assert.Error(t, verifyRequestSignature(newContext("", now), "secret"))
+
+	stale := strconv.FormatInt(helper.GetTimestamp()-3600, 10)
+	staleSignature := signRequest("secret", http.MethodPost, "/v1/chat/completions", stale, []byte(body))
+	assert.Error(t, verifyRequestSignature(newContext(staleSignature, stale), "secret"))
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"net/http"
+	"sync"
+)
+
+// groupStreams counts the streaming requests in flight of each group
+var groupStreams = make(map[string]int)
+var groupStreamsLock sync.Mutex
+
+// acquireStream returns false without counting the stream if the group has reached its limit
+func acquireStream(group string, maxStreams int) bool {
+	groupStreamsLock.Lock()
+	defer groupStreamsLock.Unlock()
+	if groupStreams[group] >= maxStreams {
+		return false
+	}
+	groupStreams[group]++
+	return true
+}
+
+func releaseStream(group string) {
+	groupStreamsLock.Lock()
+	defer groupStreamsLock.Unlock()
+	groupStreams[group]--
+	if groupStreams[group] <= 0 {
+		delete(groupStreams, group)
+	}
+}
+
+func isStreamRequest(c *gin.Context) bool {
+	var streamRequest struct {
+		Stream bool `json:"stream"`
+	}
+	err := common.UnmarshalBodyReusable(c, &streamRequest)
+	return err == nil && streamRequest.Stream
+}
+
+// StreamLimit limits the concurrent streaming requests of each group, non-streaming requests are not counted,
+// a stream is counted until its handler returns, which happens when it ends or the client disconnects
+func StreamLimit() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		group := c.GetString(ctxkey.Group)
+		maxStreams := model.GetGroupMaxStreams(group)
+		if maxStreams <= 0 || !isStreamRequest(c) {
+			c.Next()
+			return
+		}
+		if !acquireStream(group, maxStreams) {
+			c.Header("Retry-After", "1")
+			abortWithMessage(c, http.StatusTooManyRequests, "当前分组的流式请求过多，请稍后再试")
+			return
+		}
+		// deferred so that the stream is released even if the handler panics
+		defer releaseStream(group)
+		c.Next()
+	}
+}
@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStreamLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	assert.NoError(t, model.UpdateGroupMaxStreamsByJSONString(`{"default": 1}`))
+	defer func() { _ = model.UpdateGroupMaxStreamsByJSONString("{}") }()
+
+	var inFlight []int
+	router := gin.New()
+	router.Use(gin.Recovery(), func(c *gin.Context) {
+		c.Set(ctxkey.Group, "default")
+	}, StreamLimit())
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		inFlight = append(inFlight, groupStreams["default"])
+		if c.Query("panic") == "true" {
+			panic("handler failed")
+		}
+		// a second stream of the group is rejected while this one is in flight
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"stream": true}`))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		c.String(http.StatusOK, "%d", w.Code)
+	})
+
+	send := func(path string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	w := send("/v1/chat/completions", `{"stream": true}`)
+	assert.Equal(t, "429", w.Body.String())
+	assert.Equal(t, []int{1}, inFlight)
+	assert.Empty(t, groupStreams)
+
+	// non-streaming requests are not counted, so the inner stream is accepted
+	inFlight = nil
+	w = send("/v1/chat/completions", `{"stream": false}`)
+	assert.Equal(t, []int{0, 1}, inFlight)
+	assert.Empty(t, groupStreams)
+
+	// the stream is released even if the handler panics
+	w = send("/v1/chat/completions?panic=true", `{"stream": true}`)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Empty(t, groupStreams)
+}
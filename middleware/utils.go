@@ -6,6 +6,7 @@ import (
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"net/http"
 	"strings"
 )
 
@@ -49,6 +50,13 @@ func getRequestModel(c *gin.Context) (string, error) {
 	return modelRequest.Model, nil
 }
 
+// isAssistantsRunCreation reports whether the request creates a run of the assistants api,
+// i.e. POST /v1/threads/{thread_id}/runs or POST /v1/threads/runs
+func isAssistantsRunCreation(c *gin.Context) bool {
+	return c.Request.Method == http.MethodPost && strings.HasPrefix(c.Request.URL.Path, "/v1/threads/") &&
+		strings.HasSuffix(strings.TrimSuffix(c.Request.URL.Path, "/"), "/runs")
+}
+
 func isModelInList(modelName string, models string) bool {
 	modelList := strings.Split(models, ",")
 	for _, model := range modelList {
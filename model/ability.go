@@ -33,6 +33,23 @@ func GetRandomSatisfiedChannel(group string, model string, ignoreFirstPriority b
 		maxPrioritySubQuery := DB.Model(&Ability{}).Select("MAX(priority)").Where(groupCol+" = ? and model = ? and enabled = "+trueVal, group, model)
 		channelQuery = DB.Where(groupCol+" = ? and model = ? and enabled = "+trueVal+" and priority = (?)", group, model, maxPrioritySubQuery)
 	}
+	if GetGroupChannelStrategy(group) == ChannelStrategyTPMHeadroom {
+		var abilities []Ability
+		if err = channelQuery.Find(&abilities).Error; err != nil {
+			return nil, err
+		}
+		channelIds := make([]int, 0, len(abilities))
+		for _, ability := range abilities {
+			channelIds = append(channelIds, ability.ChannelId)
+		}
+		var channels []*Channel
+		if err = DB.Where("id IN ?", channelIds).Find(&channels).Error; err != nil {
+			return nil, err
+		}
+		if channel := selectChannelByTPMHeadroom(channels); channel != nil {
+			return channel, nil
+		}
+	}
 	if common.UsingSQLite || common.UsingPostgreSQL {
 		err = channelQuery.Order("RANDOM()").First(&ability).Error
 	} else {
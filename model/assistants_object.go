@@ -0,0 +1,69 @@
+package model
+
+import (
+	"errors"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"gorm.io/gorm"
+)
+
+// the assistants and threads of all the users live on the single channel pinned by ASSISTANTS_CHANNEL_ID,
+// so the user who created each of them is recorded, the messages and runs belong to the owner of their thread
+const (
+	AssistantsObjectTypeAssistant = "assistant"
+	AssistantsObjectTypeThread    = "thread"
+)
+
+// AssistantsObject records the owner of an assistant or a thread, the runs of a thread are billed to its token
+type AssistantsObject struct {
+	Id        string `json:"id" gorm:"primaryKey;type:varchar(64)"`
+	Type      string `json:"type" gorm:"type:varchar(16)"`
+	UserId    int    `json:"user_id" gorm:"index"`
+	TokenId   int    `json:"token_id"`
+	TokenName string `json:"token_name" gorm:"default:''"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+}
+
+// RecordAssistantsObject records the owner of a created object, the first record is kept,
+// so that an object never changes hands
+func RecordAssistantsObject(id string, objectType string, userId int, tokenId int, tokenName string) error {
+	if id == "" {
+		return errors.New("empty assistants object id")
+	}
+	object := &AssistantsObject{}
+	return DB.Where(AssistantsObject{Id: id}).
+		Attrs(AssistantsObject{Type: objectType, UserId: userId, TokenId: tokenId, TokenName: tokenName, CreatedAt: helper.GetTimestamp()}).
+		FirstOrCreate(object).Error
+}
+
+// GetAssistantsObject returns the record of the object, nil if it has none
+func GetAssistantsObject(id string) (*AssistantsObject, error) {
+	object := &AssistantsObject{}
+	err := DB.Where("id = ?", id).First(object).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return object, nil
+}
+
+// GetAssistantsObjectOwners returns the users owning the objects, the objects without a record are left out
+func GetAssistantsObjectOwners(ids []string) (map[string]int, error) {
+	owners := make(map[string]int, len(ids))
+	if len(ids) == 0 {
+		return owners, nil
+	}
+	var objects []AssistantsObject
+	err := DB.Select("id", "user_id").Where("id IN ?", ids).Find(&objects).Error
+	for _, object := range objects {
+		owners[object.Id] = object.UserId
+	}
+	return owners, err
+}
+
+// DeleteAssistantsObject removes the record of a deleted object
+func DeleteAssistantsObject(id string) error {
+	return DB.Where("id = ?", id).Delete(&AssistantsObject{}).Error
+}
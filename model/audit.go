@@ -0,0 +1,84 @@
+package model
+
+import (
+	"fmt"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"regexp"
+	"time"
+)
+
+// AuditLog keeps the content of a request for audit, it is only recorded when AUDIT_ENABLED is set
+type AuditLog struct {
+	Id         int    `json:"id"`
+	CreatedAt  int64  `json:"created_at" gorm:"bigint;index"`
+	RequestId  string `json:"request_id" gorm:"index;default:''"`
+	UserId     int    `json:"user_id" gorm:"index"`
+	TokenName  string `json:"token_name" gorm:"default:''"`
+	ChannelId  int    `json:"channel"`
+	ModelName  string `json:"model_name" gorm:"default:''"`
+	Prompt     string `json:"prompt" gorm:"type:text"`
+	Completion string `json:"completion" gorm:"type:text"`
+}
+
+var auditLogQueue chan *AuditLog
+
+var emailPattern = regexp.MustCompile(`[a-zA-Z0-9._%+\-]+@[a-zA-Z0-9.\-]+\.[a-zA-Z]{2,}`)
+var digitsPattern = regexp.MustCompile(`\d{6,}`)
+
+// InitAuditLogWriter starts the writer and the purge job, audit logs are written asynchronously
+// through a bounded queue so that the relay is never slowed down by them
+func InitAuditLogWriter() {
+	auditLogQueue = make(chan *AuditLog, config.AuditQueueSize)
+	go func() {
+		for auditLog := range auditLogQueue {
+			err := LOG_DB.Create(auditLog).Error
+			if err != nil {
+				logger.SysError("failed to record audit log: " + err.Error())
+			}
+		}
+	}()
+	go func() {
+		for {
+			purgeAuditLogs()
+			time.Sleep(time.Hour)
+		}
+	}()
+}
+
+func redactAuditContent(content string) string {
+	content = emailPattern.ReplaceAllString(content, "[email]")
+	return digitsPattern.ReplaceAllString(content, "[number]")
+}
+
+func RecordAuditLog(auditLog *AuditLog) {
+	if auditLogQueue == nil {
+		return
+	}
+	auditLog.CreatedAt = helper.GetTimestamp()
+	if config.AuditRedactEnabled {
+		auditLog.Prompt = redactAuditContent(auditLog.Prompt)
+		auditLog.Completion = redactAuditContent(auditLog.Completion)
+	}
+	select {
+	case auditLogQueue <- auditLog:
+	default:
+		logger.SysError("audit log queue is full, audit log dropped for request " + auditLog.RequestId)
+	}
+}
+
+func purgeAuditLogs() {
+	if config.AuditRetentionDays <= 0 {
+		return
+	}
+	targetTimestamp := helper.GetTimestamp() - int64(config.AuditRetentionDays)*24*60*60
+	result := LOG_DB.Where("created_at < ?", targetTimestamp).Delete(&AuditLog{})
+	if result.Error != nil {
+		logger.SysError("failed to purge audit logs: " + result.Error.Error())
+		return
+	}
+	if result.RowsAffected > 0 {
+		logger.SysLog(fmt.Sprintf("purged %d expired audit logs", result.RowsAffected))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/random"
 	"math/rand"
@@ -55,6 +56,25 @@ func CacheGetTokenByKey(key string) (*Token, error) {
 	return &token, err
 }
 
+// CacheIsQuotaPoolExhausted is the cached IsQuotaPoolExhausted, which only rejects the requests early,
+// as the pool is checked again when it is pre-consumed
+func CacheIsQuotaPoolExhausted(id int) bool {
+	if !common.RedisEnabled {
+		return IsQuotaPoolExhausted(id)
+	}
+	key := fmt.Sprintf("quota_pool_exhausted:%d", id)
+	exhausted, err := common.RedisGet(key)
+	if err == nil {
+		return exhausted == "true"
+	}
+	isExhausted := IsQuotaPoolExhausted(id)
+	err = common.RedisSet(key, strconv.FormatBool(isExhausted), time.Duration(TokenCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set quota pool error: " + err.Error())
+	}
+	return isExhausted
+}
+
 func CacheGetUserGroup(id int) (group string, err error) {
 	if !common.RedisEnabled {
 		return GetUserGroup(id)
@@ -73,6 +93,52 @@ func CacheGetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+// CacheGetUserEffectiveGroup returns the group the requests of the user are relayed in, the group override if it is in effect
+func CacheGetUserEffectiveGroup(id int) (string, error) {
+	if groupOverride := CacheGetUserGroupOverride(id); groupOverride != "" {
+		return groupOverride, nil
+	}
+	return CacheGetUserGroup(id)
+}
+
+// CacheGetUserGroupOverride returns the group override of the user if it is in effect, empty otherwise
+func CacheGetUserGroupOverride(id int) string {
+	groupOverride, expiresAt, err := cacheGetUserGroupOverride(id)
+	if err != nil {
+		logger.SysError(fmt.Sprintf("failed to get the group override of user %d: %s", id, err.Error()))
+		return ""
+	}
+	if !isGroupOverrideInEffect(groupOverride, expiresAt, helper.GetTimestamp()) {
+		return ""
+	}
+	return groupOverride
+}
+
+func cacheGetUserGroupOverride(id int) (string, int64, error) {
+	if !common.RedisEnabled {
+		return GetUserGroupOverride(id)
+	}
+	key := fmt.Sprintf("user_group_override:%d", id)
+	// cached as expires_at:group, as the group may contain colons
+	value, err := common.RedisGet(key)
+	if err == nil {
+		expiresAt, groupOverride, _ := strings.Cut(value, ":")
+		expiresAtValue, err := strconv.ParseInt(expiresAt, 10, 64)
+		if err == nil {
+			return groupOverride, expiresAtValue, nil
+		}
+	}
+	groupOverride, expiresAt, err := GetUserGroupOverride(id)
+	if err != nil {
+		return "", 0, err
+	}
+	err = common.RedisSet(key, fmt.Sprintf("%d:%s", expiresAt, groupOverride), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user group override error: " + err.Error())
+	}
+	return groupOverride, expiresAt, nil
+}
+
 func fetchAndUpdateUserQuota(ctx context.Context, id int) (quota int64, err error) {
 	quota, err = GetUserQuota(id)
 	if err != nil {
@@ -124,6 +190,27 @@ func CacheDecreaseUserQuota(id int, quota int64) error {
 	return err
 }
 
+// CacheIsAdmin is IsAdmin cached like the status of the user
+func CacheIsAdmin(userId int) bool {
+	if userId == 0 || !common.RedisEnabled {
+		return IsAdmin(userId)
+	}
+	admin, err := common.RedisGet(fmt.Sprintf("user_admin:%d", userId))
+	if err == nil {
+		return admin == "1"
+	}
+	isAdmin := IsAdmin(userId)
+	admin = "0"
+	if isAdmin {
+		admin = "1"
+	}
+	err = common.RedisSet(fmt.Sprintf("user_admin:%d", userId), admin, time.Duration(UserId2StatusCacheSeconds)*time.Second)
+	if err != nil {
+		logger.SysError("Redis set user admin error: " + err.Error())
+	}
+	return isAdmin
+}
+
 func CacheIsUserEnabled(userId int) (bool, error) {
 	if !common.RedisEnabled {
 		return IsUserEnabled(userId)
@@ -168,6 +255,7 @@ func CacheGetGroupModels(ctx context.Context, group string) ([]string, error) {
 }
 
 var group2model2channels map[string]map[string][]*Channel
+var channelId2channel map[int]*Channel
 var channelSyncLock sync.RWMutex
 
 func InitChannelCache() {
@@ -212,6 +300,7 @@ func InitChannelCache() {
 
 	channelSyncLock.Lock()
 	group2model2channels = newGroup2model2channels
+	channelId2channel = newChannelId2channel
 	channelSyncLock.Unlock()
 	logger.SysLog("channels synced from database")
 }
@@ -234,7 +323,77 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 	if len(channels) == 0 {
 		return nil, errors.New("channel not found")
 	}
-	endIdx := len(channels)
+	startIdx, endIdx := getPriorityRange(channels, ignoreFirstPriority)
+	if GetGroupChannelStrategy(group) == ChannelStrategyTPMHeadroom {
+		if channel := selectChannelByTPMHeadroom(channels[startIdx:endIdx]); channel != nil {
+			return channel, nil
+		}
+	}
+	idx := random.RandRange(startIdx, endIdx)
+	if isChannelBusy(channels[idx]) {
+		// prefer channels which are neither close to their upstream rate limits nor at their local ones
+		var candidates []*Channel
+		for _, channel := range channels[startIdx:endIdx] {
+			if !isChannelBusy(channel) {
+				candidates = append(candidates, channel)
+			}
+		}
+		if len(candidates) > 0 {
+			return candidates[rand.Intn(len(candidates))], nil
+		}
+	}
+	return channels[idx], nil
+}
+
+// CacheGetChannelById returns the channel if it is enabled, the memory cache only holds the enabled channels
+func CacheGetChannelById(id int) (*Channel, error) {
+	if !config.MemoryCacheEnabled {
+		channel, err := GetChannelById(id, true)
+		if err != nil {
+			return nil, err
+		}
+		if channel.Status != ChannelStatusEnabled {
+			return nil, errors.New("channel is not enabled")
+		}
+		return channel, nil
+	}
+	channelSyncLock.RLock()
+	defer channelSyncLock.RUnlock()
+	channel, ok := channelId2channel[id]
+	if !ok {
+		return nil, errors.New("channel not found")
+	}
+	return channel, nil
+}
+
+// CacheGetStickyChannel returns the channel the sticky key is bound to among the channels of the highest priority,
+// channels which are disabled or busy are skipped, and it falls back to the random selection if none is left
+func CacheGetStickyChannel(group string, model string, stickyKey string) (*Channel, error) {
+	if !config.MemoryCacheEnabled || stickyKey == "" {
+		return CacheGetRandomSatisfiedChannel(group, model, false)
+	}
+	channelSyncLock.RLock()
+	channels := group2model2channels[group][model]
+	var candidates []*Channel
+	if len(channels) != 0 {
+		startIdx, endIdx := getPriorityRange(channels, false)
+		for _, channel := range channels[startIdx:endIdx] {
+			if !isChannelBusy(channel) {
+				candidates = append(candidates, channel)
+			}
+		}
+	}
+	channelSyncLock.RUnlock()
+	if channel := selectStickyChannel(candidates, stickyKey); channel != nil {
+		return channel, nil
+	}
+	return CacheGetRandomSatisfiedChannel(group, model, false)
+}
+
+// getPriorityRange returns the range of the channels of the highest priority, or of the lower ones if ignoreFirstPriority,
+// the channels must be sorted by priority
+func getPriorityRange(channels []*Channel, ignoreFirstPriority bool) (startIdx int, endIdx int) {
+	endIdx = len(channels)
 	// choose by priority
 	firstChannel := channels[0]
 	if firstChannel.GetPriority() > 0 {
@@ -245,11 +404,18 @@ func CacheGetRandomSatisfiedChannel(group string, model string, ignoreFirstPrior
 			}
 		}
 	}
-	idx := rand.Intn(endIdx)
 	if ignoreFirstPriority {
 		if endIdx < len(channels) { // which means there are more than one priority
-			idx = random.RandRange(endIdx, len(channels))
+			startIdx, endIdx = endIdx, len(channels)
 		}
 	}
-	return channels[idx], nil
+	return startIdx, endIdx
+}
+
+func isChannelBusy(channel *Channel) bool {
+	if IsChannelNearRateLimit(channel.Id) {
+		return true
+	}
+	rpm, tpm := channel.GetLocalRateLimit()
+	return IsChannelAtCapacity(channel.Id, rpm, tpm)
 }
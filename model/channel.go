@@ -7,6 +7,8 @@ import (
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"gorm.io/gorm"
+	"strings"
+	"sync"
 )
 
 const (
@@ -36,6 +38,7 @@ type Channel struct {
 	ModelMapping       *string `json:"model_mapping" gorm:"type:varchar(1024);default:''"`
 	Priority           *int64  `json:"priority" gorm:"bigint;default:0"`
 	Config             string  `json:"config"`
+	TestModel          *string `json:"test_model" gorm:"default:''"`
 }
 
 func GetAllChannels(startIdx int, num int, scope string) ([]*Channel, error) {
@@ -97,6 +100,15 @@ func (channel *Channel) GetBaseURL() string {
 	return *channel.BaseURL
 }
 
+// GetTestModel returns the model used to probe the channel,
+// falling back to the first model in the channel's model list
+func (channel *Channel) GetTestModel() string {
+	if channel.TestModel != nil && *channel.TestModel != "" {
+		return *channel.TestModel
+	}
+	return strings.Split(channel.Models, ",")[0]
+}
+
 func (channel *Channel) GetModelMapping() map[string]string {
 	if channel.ModelMapping == nil || *channel.ModelMapping == "" || *channel.ModelMapping == "{}" {
 		return nil
@@ -161,15 +173,35 @@ func (channel *Channel) Delete() error {
 	return err
 }
 
+type parsedChannelConfig struct {
+	config string
+	cfg    map[string]string
+}
+
+// the parsed config of each channel, as it is loaded for each request relayed and each channel selected,
+// an entry is parsed again when the config of the channel changes
+var parsedChannelConfigs = make(map[int]parsedChannelConfig)
+var parsedChannelConfigsLock sync.RWMutex
+
+// LoadConfig returns the parsed config of the channel, the map is shared so it must not be modified
 func (channel *Channel) LoadConfig() (map[string]string, error) {
 	if channel.Config == "" {
 		return nil, nil
 	}
+	parsedChannelConfigsLock.RLock()
+	parsed, ok := parsedChannelConfigs[channel.Id]
+	parsedChannelConfigsLock.RUnlock()
+	if ok && parsed.config == channel.Config {
+		return parsed.cfg, nil
+	}
 	cfg := make(map[string]string)
 	err := json.Unmarshal([]byte(channel.Config), &cfg)
 	if err != nil {
 		return nil, err
 	}
+	parsedChannelConfigsLock.Lock()
+	parsedChannelConfigs[channel.Id] = parsedChannelConfig{config: channel.Config, cfg: cfg}
+	parsedChannelConfigsLock.Unlock()
 	return cfg, nil
 }
 
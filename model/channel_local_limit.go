@@ -0,0 +1,303 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/common/random"
+)
+
+// the requests per minute and tokens per minute limits of a channel enforced by the gateway itself,
+// so that the channel is throttled before the upstream starts to reject requests,
+// they are set with the rpm and tpm keys of the channel config, and counted in redis when it is enabled,
+// so that the instances share them, per instance otherwise or when redis fails
+
+const channelLocalLimitWindow = time.Minute
+
+type tokenUsage struct {
+	time   time.Time
+	tokens int
+}
+
+type channelUsageWindow struct {
+	requests []time.Time
+	tokens   []tokenUsage
+}
+
+var channelUsageWindows = make(map[int]*channelUsageWindow)
+var channelUsageWindowsLock sync.Mutex
+
+// the requests being relayed by each channel, guarded by channelUsageWindowsLock as well
+var channelRequestsInFlight = make(map[int]int)
+
+// GetLocalRateLimit returns the rpm and tpm limits of the channel, 0 means unlimited
+func (channel *Channel) GetLocalRateLimit() (rpm int, tpm int) {
+	cfg, err := channel.LoadConfig()
+	if err != nil || cfg == nil {
+		return 0, 0
+	}
+	return ParseLocalRateLimit(cfg["rpm"], cfg["tpm"])
+}
+
+// ParseLocalRateLimit parses the rpm and tpm values of a channel config, invalid values mean unlimited
+func ParseLocalRateLimit(rpmStr string, tpmStr string) (rpm int, tpm int) {
+	rpm, _ = strconv.Atoi(rpmStr)
+	tpm, _ = strconv.Atoi(tpmStr)
+	if rpm < 0 {
+		rpm = 0
+	}
+	if tpm < 0 {
+		tpm = 0
+	}
+	return rpm, tpm
+}
+
+// prune drops the usage older than the window, the caller must hold channelUsageWindowsLock
+func (w *channelUsageWindow) prune(now time.Time) {
+	since := now.Add(-channelLocalLimitWindow)
+	i := 0
+	for i < len(w.requests) && !w.requests[i].After(since) {
+		i++
+	}
+	w.requests = w.requests[i:]
+	i = 0
+	for i < len(w.tokens) && !w.tokens[i].time.After(since) {
+		i++
+	}
+	w.tokens = w.tokens[i:]
+}
+
+func (w *channelUsageWindow) isFull(rpm int, tpm int) bool {
+	if rpm > 0 && len(w.requests) >= rpm {
+		return true
+	}
+	if tpm > 0 {
+		tokens := 0
+		for _, usage := range w.tokens {
+			tokens += usage.tokens
+		}
+		if tokens >= tpm {
+			return true
+		}
+	}
+	return false
+}
+
+// in redis, the requests and the tokens of a channel are kept in sorted sets scored by their time in milliseconds,
+// each member of the tokens is a unique id followed by the number of tokens
+func getChannelRequestsKey(channelId int) string {
+	return fmt.Sprintf("channel_requests:%d", channelId)
+}
+
+func getChannelTokensKey(channelId int) string {
+	return fmt.Sprintf("channel_tokens:%d", channelId)
+}
+
+// acquireChannelRequestScript counts a request unless the channel is at capacity, at once for all the instances
+var acquireChannelRequestScript = redis.NewScript(`
+local since = tonumber(ARGV[1]) - tonumber(ARGV[2])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', since)
+redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', since)
+local rpm = tonumber(ARGV[3])
+local tpm = tonumber(ARGV[4])
+if rpm > 0 and redis.call('ZCARD', KEYS[1]) >= rpm then
+	return 0
+end
+if tpm > 0 then
+	local tokens = 0
+	for _, member in ipairs(redis.call('ZRANGE', KEYS[2], 0, -1)) do
+		tokens = tokens + tonumber(string.match(member, ':(%d+)$'))
+	end
+	if tokens >= tpm then
+		return 0
+	end
+end
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[5])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1
+`)
+
+func acquireRedisChannelRequest(channelId int, rpm int, tpm int) (bool, error) {
+	keys := []string{getChannelRequestsKey(channelId), getChannelTokensKey(channelId)}
+	acquired, err := acquireChannelRequestScript.Run(context.Background(), common.RDB, keys,
+		time.Now().UnixMilli(), channelLocalLimitWindow.Milliseconds(), rpm, tpm, random.GetUUID()).Int()
+	return acquired == 1, err
+}
+
+func recordRedisChannelTokens(channelId int, tokens int) error {
+	ctx := context.Background()
+	key := getChannelTokensKey(channelId)
+	member := fmt.Sprintf("%s:%d", random.GetUUID(), tokens)
+	pipe := common.RDB.TxPipeline()
+	pipe.ZAdd(ctx, key, &redis.Z{Score: float64(time.Now().UnixMilli()), Member: member})
+	pipe.PExpire(ctx, key, channelLocalLimitWindow)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// getRedisChannelUsage returns the requests and the tokens of the channel in the last minute
+func getRedisChannelUsage(channelId int) (int, int, error) {
+	ctx := context.Background()
+	since := "(" + strconv.FormatInt(time.Now().Add(-channelLocalLimitWindow).UnixMilli(), 10)
+	pipe := common.RDB.Pipeline()
+	requests := pipe.ZCount(ctx, getChannelRequestsKey(channelId), since, "+inf")
+	members := pipe.ZRangeByScore(ctx, getChannelTokensKey(channelId), &redis.ZRangeBy{Min: since, Max: "+inf"})
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, 0, err
+	}
+	tokens := 0
+	for _, member := range members.Val() {
+		n, _ := strconv.Atoi(member[strings.LastIndex(member, ":")+1:])
+		tokens += n
+	}
+	return int(requests.Val()), tokens, nil
+}
+
+// getChannelTPM returns the tpm limit of the channel, the channels are always cached in memory along with redis
+func getChannelTPM(channelId int) int {
+	channel, err := CacheGetChannelById(channelId)
+	if err != nil {
+		return 0
+	}
+	_, tpm := channel.GetLocalRateLimit()
+	return tpm
+}
+
+// IsChannelAtCapacity returns true if the channel has used up its local rpm or tpm limit in the last minute
+func IsChannelAtCapacity(channelId int, rpm int, tpm int) bool {
+	if rpm <= 0 && tpm <= 0 {
+		return false
+	}
+	if common.RedisEnabled {
+		requests, tokens, err := getRedisChannelUsage(channelId)
+		if err == nil {
+			return (rpm > 0 && requests >= rpm) || (tpm > 0 && tokens >= tpm)
+		}
+		logger.SysError("Redis get channel usage error: " + err.Error())
+	}
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	w, ok := channelUsageWindows[channelId]
+	if !ok {
+		return false
+	}
+	w.prune(time.Now())
+	return w.isFull(rpm, tpm)
+}
+
+// AcquireChannelRequest counts a request against the local limits of the channel,
+// it returns false without counting it if the channel is at capacity
+func AcquireChannelRequest(channelId int, rpm int, tpm int) bool {
+	if rpm <= 0 && tpm <= 0 {
+		return true
+	}
+	if common.RedisEnabled {
+		acquired, err := acquireRedisChannelRequest(channelId, rpm, tpm)
+		if err == nil {
+			return acquired
+		}
+		logger.SysError("Redis acquire channel request error: " + err.Error())
+	}
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	now := time.Now()
+	w, ok := channelUsageWindows[channelId]
+	if !ok {
+		w = &channelUsageWindow{}
+		channelUsageWindows[channelId] = w
+	}
+	w.prune(now)
+	if w.isFull(rpm, tpm) {
+		return false
+	}
+	w.requests = append(w.requests, now)
+	return true
+}
+
+// RecordChannelTokens counts the tokens consumed by a request against the tpm limit of the channel
+func RecordChannelTokens(channelId int, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	if common.RedisEnabled && getChannelTPM(channelId) > 0 {
+		err := recordRedisChannelTokens(channelId, tokens)
+		if err == nil {
+			return
+		}
+		logger.SysError("Redis record channel tokens error: " + err.Error())
+	}
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	w, ok := channelUsageWindows[channelId]
+	if !ok {
+		// only channels with local limits get a window when their requests are acquired
+		return
+	}
+	w.tokens = append(w.tokens, tokenUsage{time: time.Now(), tokens: tokens})
+}
+
+// GetChannelTPMHeadroom returns the share of the tpm limit of the channel left in the last minute, between 0 and 1,
+// a channel without a tpm limit has all of it left
+func GetChannelTPMHeadroom(channelId int, tpm int) float64 {
+	if tpm <= 0 {
+		return 1
+	}
+	tokens := getChannelTokens(channelId)
+	if tokens >= tpm {
+		return 0
+	}
+	return 1 - float64(tokens)/float64(tpm)
+}
+
+// getChannelTokens returns the tokens consumed by the channel in the last minute
+func getChannelTokens(channelId int) int {
+	if common.RedisEnabled {
+		_, tokens, err := getRedisChannelUsage(channelId)
+		if err == nil {
+			return tokens
+		}
+		logger.SysError("Redis get channel usage error: " + err.Error())
+	}
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	w, ok := channelUsageWindows[channelId]
+	if !ok {
+		return 0
+	}
+	w.prune(time.Now())
+	tokens := 0
+	for _, usage := range w.tokens {
+		tokens += usage.tokens
+	}
+	return tokens
+}
+
+// BeginChannelRequest counts a request in flight on the channel until EndChannelRequest is called
+func BeginChannelRequest(channelId int) {
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	channelRequestsInFlight[channelId]++
+}
+
+func EndChannelRequest(channelId int) {
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	channelRequestsInFlight[channelId]--
+	if channelRequestsInFlight[channelId] <= 0 {
+		delete(channelRequestsInFlight, channelId)
+	}
+}
+
+// GetChannelRequestsInFlight returns the number of the requests being relayed by the channel
+func GetChannelRequestsInFlight(channelId int) int {
+	channelUsageWindowsLock.Lock()
+	defer channelUsageWindowsLock.Unlock()
+	return channelRequestsInFlight[channelId]
+}
@@ -0,0 +1,108 @@
+package model
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+// withoutRedis counts the usage of the channels in the instance for the duration of the test
+func withoutRedis(t *testing.T) {
+	redisEnabled := common.RedisEnabled
+	common.RedisEnabled = false
+	t.Cleanup(func() { common.RedisEnabled = redisEnabled })
+}
+
+func TestAcquireChannelRequestRPM(t *testing.T) {
+	withoutRedis(t)
+	channelId := -1
+	defer delete(channelUsageWindows, channelId)
+
+	assert.True(t, AcquireChannelRequest(channelId, 2, 0))
+	assert.True(t, AcquireChannelRequest(channelId, 2, 0))
+	assert.False(t, AcquireChannelRequest(channelId, 2, 0))
+	assert.True(t, IsChannelAtCapacity(channelId, 2, 0))
+	// requests older than the window no longer count
+	w := channelUsageWindows[channelId]
+	for i := range w.requests {
+		w.requests[i] = w.requests[i].Add(-channelLocalLimitWindow)
+	}
+	assert.False(t, IsChannelAtCapacity(channelId, 2, 0))
+	assert.True(t, AcquireChannelRequest(channelId, 2, 0))
+}
+
+func TestAcquireChannelRequestTPM(t *testing.T) {
+	withoutRedis(t)
+	channelId := -2
+	defer delete(channelUsageWindows, channelId)
+
+	assert.True(t, AcquireChannelRequest(channelId, 0, 100))
+	RecordChannelTokens(channelId, 60)
+	assert.True(t, AcquireChannelRequest(channelId, 0, 100))
+	RecordChannelTokens(channelId, 60)
+	assert.False(t, AcquireChannelRequest(channelId, 0, 100))
+	channelUsageWindows[channelId].tokens[0].time = time.Now().Add(-2 * channelLocalLimitWindow)
+	assert.True(t, AcquireChannelRequest(channelId, 0, 100))
+}
+
+func TestChannelWithoutLocalLimit(t *testing.T) {
+	withoutRedis(t)
+	channel := &Channel{Id: -3}
+	rpm, tpm := channel.GetLocalRateLimit()
+	assert.Equal(t, 0, rpm)
+	assert.Equal(t, 0, tpm)
+	for i := 0; i < 10; i++ {
+		assert.True(t, AcquireChannelRequest(channel.Id, rpm, tpm))
+	}
+	assert.False(t, IsChannelAtCapacity(channel.Id, rpm, tpm))
+
+	channel.Config = `{"rpm":"60","tpm":"-1"}`
+	rpm, tpm = channel.GetLocalRateLimit()
+	assert.Equal(t, 60, rpm)
+	assert.Equal(t, 0, tpm)
+}
+
+func TestGetLocalRateLimit(t *testing.T) {
+	channel := &Channel{Id: -3, Config: `{"rpm": "10", "tpm": "1000"}`}
+	defer delete(parsedChannelConfigs, channel.Id)
+
+	rpm, tpm := channel.GetLocalRateLimit()
+	assert.Equal(t, 10, rpm)
+	assert.Equal(t, 1000, tpm)
+	// the parsed config is cached until the config changes
+	channel.Config = `{"rpm": "20"}`
+	rpm, tpm = channel.GetLocalRateLimit()
+	assert.Equal(t, 20, rpm)
+	assert.Equal(t, 0, tpm)
+}
+
+func TestChannelLocalLimitWithRedisUnreachable(t *testing.T) {
+	channelId := -4
+	defer delete(channelUsageWindows, channelId)
+	originalRDB, originalRedisEnabled, memoryCacheEnabled := common.RDB, common.RedisEnabled, config.MemoryCacheEnabled
+	defer func() {
+		common.RDB, common.RedisEnabled, config.MemoryCacheEnabled = originalRDB, originalRedisEnabled, memoryCacheEnabled
+	}()
+	common.RDB = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	common.RedisEnabled, config.MemoryCacheEnabled = true, true
+	channelSyncLock.Lock()
+	channelId2channel = map[int]*Channel{channelId: {Id: channelId, Config: `{"tpm": "100"}`}}
+	channelSyncLock.Unlock()
+	defer func() {
+		channelSyncLock.Lock()
+		channelId2channel = nil
+		channelSyncLock.Unlock()
+		delete(parsedChannelConfigs, channelId)
+	}()
+
+	// the limits are still enforced by the instance, rather than not at all
+	assert.True(t, AcquireChannelRequest(channelId, 0, 100))
+	RecordChannelTokens(channelId, 120)
+	assert.False(t, AcquireChannelRequest(channelId, 0, 100))
+	assert.True(t, IsChannelAtCapacity(channelId, 0, 100))
+	assert.Equal(t, 0.0, GetChannelTPMHeadroom(channelId, 100))
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"github.com/songquanpeng/one-api/common/config"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// https://platform.openai.com/docs/guides/rate-limits/rate-limits-in-headers
+// the remaining upstream rate limits of each channel, used to route away from channels close to their limits
+
+type rateLimitState struct {
+	limit     int
+	remaining int
+	resetAt   time.Time
+}
+
+type channelRateLimit struct {
+	requests rateLimitState
+	tokens   rateLimitState
+}
+
+var channelRateLimits = make(map[int]*channelRateLimit)
+var channelRateLimitsLock sync.RWMutex
+
+func parseRateLimitState(header http.Header, kind string, now time.Time) (rateLimitState, bool) {
+	limit, err := strconv.Atoi(header.Get("x-ratelimit-limit-" + kind))
+	if err != nil {
+		return rateLimitState{}, false
+	}
+	remaining, err := strconv.Atoi(header.Get("x-ratelimit-remaining-" + kind))
+	if err != nil {
+		return rateLimitState{}, false
+	}
+	// e.g. 1s, 6m0s, 20ms
+	reset, err := time.ParseDuration(header.Get("x-ratelimit-reset-" + kind))
+	if err != nil {
+		reset = time.Minute
+	}
+	return rateLimitState{limit: limit, remaining: remaining, resetAt: now.Add(reset)}, true
+}
+
+// UpdateChannelRateLimit records the x-ratelimit-* headers returned by the upstream of the channel
+func UpdateChannelRateLimit(channelId int, header http.Header) {
+	now := time.Now()
+	requests, hasRequests := parseRateLimitState(header, "requests", now)
+	tokens, hasTokens := parseRateLimitState(header, "tokens", now)
+	if !hasRequests && !hasTokens {
+		return
+	}
+	channelRateLimitsLock.Lock()
+	defer channelRateLimitsLock.Unlock()
+	rateLimit, ok := channelRateLimits[channelId]
+	if !ok {
+		rateLimit = &channelRateLimit{}
+		channelRateLimits[channelId] = rateLimit
+	}
+	if hasRequests {
+		rateLimit.requests = requests
+	}
+	if hasTokens {
+		rateLimit.tokens = tokens
+	}
+}
+
+func (s rateLimitState) isNearLimit(now time.Time) bool {
+	if s.limit <= 0 || now.After(s.resetAt) {
+		return false
+	}
+	return float64(s.remaining) <= float64(s.limit)*config.ChannelRateLimitReserveRatio
+}
+
+// IsChannelNearRateLimit returns true if the upstream of the channel is about to reject requests
+func IsChannelNearRateLimit(channelId int) bool {
+	channelRateLimitsLock.RLock()
+	defer channelRateLimitsLock.RUnlock()
+	rateLimit, ok := channelRateLimits[channelId]
+	if !ok {
+		return false
+	}
+	now := time.Now()
+	return rateLimit.requests.isNearLimit(now) || rateLimit.tokens.isNearLimit(now)
+}
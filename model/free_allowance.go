@@ -0,0 +1,99 @@
+package model
+
+import (
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+)
+
+// FreeAllowance tracks how much quota a user has used for free for a model in the current period
+type FreeAllowance struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"uniqueIndex:idx_user_model"`
+	ModelName   string `json:"model_name" gorm:"type:varchar(128);uniqueIndex:idx_user_model"`
+	UsedQuota   int64  `json:"used_quota" gorm:"bigint;default:0"`
+	PeriodStart int64  `json:"period_start" gorm:"bigint"`
+}
+
+// freeAllowanceMaxRetries bounds the retries of a consumption losing the race against the concurrent ones
+const freeAllowanceMaxRetries = 5
+
+func getFreeAllowance(userId int, modelName string) (*FreeAllowance, error) {
+	allowance := &FreeAllowance{}
+	err := DB.Where(FreeAllowance{UserId: userId, ModelName: modelName}).
+		Attrs(FreeAllowance{PeriodStart: helper.GetTimestamp()}).
+		FirstOrCreate(allowance).Error
+	if err != nil {
+		// a concurrent request may have created the record first
+		err = DB.Where(FreeAllowance{UserId: userId, ModelName: modelName}).First(allowance).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	if config.FreeAllowanceResetPeriod > 0 && helper.GetTimestamp()-allowance.PeriodStart >= int64(config.FreeAllowanceResetPeriod) {
+		// the period is only restarted from the one which is over, so that a concurrent restart isn't done twice
+		err = DB.Model(&FreeAllowance{}).Where("id = ? AND period_start = ?", allowance.Id, allowance.PeriodStart).
+			Updates(map[string]any{"used_quota": 0, "period_start": helper.GetTimestamp()}).Error
+		if err != nil {
+			return nil, err
+		}
+		err = DB.First(allowance, allowance.Id).Error
+	}
+	return allowance, err
+}
+
+// GetFreeAllowanceRemaining returns how much quota the user can still use for free for the model
+func GetFreeAllowanceRemaining(userId int, modelName string) int64 {
+	total := billingratio.GetModelFreeAllowance(modelName)
+	if total <= 0 {
+		return 0
+	}
+	allowance, err := getFreeAllowance(userId, modelName)
+	if err != nil {
+		logger.SysError("failed to get free allowance: " + err.Error())
+		return 0
+	}
+	if allowance.UsedQuota >= total {
+		return 0
+	}
+	return total - allowance.UsedQuota
+}
+
+// ConsumeFreeAllowance deducts the quota from the user's free allowance of the model,
+// and returns the part of the quota which is covered by the allowance
+func ConsumeFreeAllowance(userId int, modelName string, quota int64) int64 {
+	total := billingratio.GetModelFreeAllowance(modelName)
+	if total <= 0 || quota <= 0 {
+		return 0
+	}
+	for i := 0; i < freeAllowanceMaxRetries; i++ {
+		allowance, err := getFreeAllowance(userId, modelName)
+		if err != nil {
+			logger.SysError("failed to get free allowance: " + err.Error())
+			return 0
+		}
+		remain := total - allowance.UsedQuota
+		if remain <= 0 {
+			return 0
+		}
+		freeQuota := quota
+		if freeQuota > remain {
+			freeQuota = remain
+		}
+		// the usage is only raised from the value the free quota is computed from, so that the concurrent requests,
+		// of this instance or of the others sharing the database, can't overdraw the allowance
+		result := DB.Model(&FreeAllowance{}).
+			Where("id = ? AND used_quota = ? AND period_start = ?", allowance.Id, allowance.UsedQuota, allowance.PeriodStart).
+			Update("used_quota", allowance.UsedQuota+freeQuota)
+		if result.Error != nil {
+			logger.SysError("failed to consume free allowance: " + result.Error.Error())
+			return 0
+		}
+		if result.RowsAffected == 1 {
+			return freeQuota
+		}
+	}
+	logger.SysError("failed to consume free allowance: too many concurrent updates")
+	return 0
+}
@@ -0,0 +1,63 @@
+package model
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestConsumeFreeAllowance(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file:free_allowance?mode=memory&cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Migrator().DropTable(&FreeAllowance{}))
+	assert.NoError(t, db.AutoMigrate(&FreeAllowance{}))
+	originalDB := DB
+	DB = db
+	defer func() { DB = originalDB }()
+	originalAllowance := billingratio.ModelFreeAllowance2JSONString()
+	defer func() { _ = billingratio.UpdateModelFreeAllowanceByJSONString(originalAllowance) }()
+	resetPeriod := config.FreeAllowanceResetPeriod
+	defer func() { config.FreeAllowanceResetPeriod = resetPeriod }()
+	config.FreeAllowanceResetPeriod = 0
+	assert.NoError(t, billingratio.UpdateModelFreeAllowanceByJSONString(`{"gpt-4o-mini":1000}`))
+
+	// the models without an allowance are billed as usual
+	assert.Equal(t, int64(0), ConsumeFreeAllowance(1, "gpt-4o", 100))
+	assert.Equal(t, int64(0), GetFreeAllowanceRemaining(1, "gpt-4o"))
+
+	// 20 requests of 100 race for an allowance of 1000, which must never be overdrawn
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	var freeQuota int64
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			quota := ConsumeFreeAllowance(1, "gpt-4o-mini", 100)
+			lock.Lock()
+			freeQuota += quota
+			lock.Unlock()
+		}()
+	}
+	wg.Wait()
+	assert.LessOrEqual(t, freeQuota, int64(1000))
+	assert.Equal(t, 1000-freeQuota, GetFreeAllowanceRemaining(1, "gpt-4o-mini"))
+
+	// the last of the allowance covers a part of the quota
+	remain := GetFreeAllowanceRemaining(2, "gpt-4o-mini")
+	assert.Equal(t, int64(1000), remain)
+	assert.Equal(t, int64(900), ConsumeFreeAllowance(2, "gpt-4o-mini", 900))
+	assert.Equal(t, int64(100), ConsumeFreeAllowance(2, "gpt-4o-mini", 300))
+	assert.Equal(t, int64(0), ConsumeFreeAllowance(2, "gpt-4o-mini", 300))
+
+	// the allowance is restored once the period is over
+	config.FreeAllowanceResetPeriod = 60
+	assert.NoError(t, db.Model(&FreeAllowance{}).Where("user_id = ?", 2).Update("period_start", 1).Error)
+	assert.Equal(t, int64(1000), GetFreeAllowanceRemaining(2, "gpt-4o-mini"))
+	assert.Equal(t, int64(300), ConsumeFreeAllowance(2, "gpt-4o-mini", 300))
+}
@@ -0,0 +1,93 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/songquanpeng/one-api/common/logger"
+	"math/rand"
+	"sync"
+)
+
+const (
+	ChannelStrategyRandom      = "random"
+	ChannelStrategyTPMHeadroom = "tpm_headroom"
+)
+
+// groupChannelStrategy maps a group to the strategy its channels are selected by among the channels
+// of the same priority, groups without a strategy select channels randomly
+var groupChannelStrategy = map[string]string{}
+var groupChannelStrategyLock sync.RWMutex
+
+func GroupChannelStrategy2JSONString() string {
+	groupChannelStrategyLock.RLock()
+	defer groupChannelStrategyLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupChannelStrategy)
+	if err != nil {
+		logger.SysError("error marshalling group channel strategy: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupChannelStrategyByJSONString(jsonStr string) error {
+	newGroupChannelStrategy := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupChannelStrategy)
+	if err != nil {
+		return err
+	}
+	for group, strategy := range newGroupChannelStrategy {
+		if strategy != ChannelStrategyRandom && strategy != ChannelStrategyTPMHeadroom {
+			return fmt.Errorf("unknown channel strategy %s of group %s", strategy, group)
+		}
+	}
+	groupChannelStrategyLock.Lock()
+	groupChannelStrategy = newGroupChannelStrategy
+	groupChannelStrategyLock.Unlock()
+	return nil
+}
+
+// GetGroupChannelStrategy returns the channel selection strategy of the group, random by default
+func GetGroupChannelStrategy(group string) string {
+	groupChannelStrategyLock.RLock()
+	defer groupChannelStrategyLock.RUnlock()
+	if strategy, ok := groupChannelStrategy[group]; ok {
+		return strategy
+	}
+	return ChannelStrategyRandom
+}
+
+// selectChannelByTPMHeadroom picks a channel randomly, weighted by the share of its tpm limit left in the last minute
+// divided among the requests in flight on it, whose tokens aren't counted until they finish,
+// so that the channels with the most headroom are preferred without all the requests going to a single one,
+// the channels without a tpm limit count as idle, the busy ones are skipped, and nil is returned if all of them are
+func selectChannelByTPMHeadroom(channels []*Channel) *Channel {
+	weights := make([]float64, len(channels))
+	total := 0.0
+	for i, channel := range channels {
+		if isChannelBusy(channel) {
+			continue
+		}
+		_, tpm := channel.GetLocalRateLimit()
+		weights[i] = GetChannelTPMHeadroom(channel.Id, tpm) / float64(1+GetChannelRequestsInFlight(channel.Id))
+		total += weights[i]
+	}
+	if total <= 0 {
+		return nil
+	}
+	r := rand.Float64() * total
+	for i, weight := range weights {
+		if weight <= 0 {
+			continue
+		}
+		if r < weight {
+			return channels[i]
+		}
+		r -= weight
+	}
+	// only reached by the rounding of the weights
+	for i := len(channels) - 1; i >= 0; i-- {
+		if weights[i] > 0 {
+			return channels[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestSelectChannelByTPMHeadroom(t *testing.T) {
+	withoutRedis(t)
+	tpm := `{"tpm": "1000"}`
+	channels := []*Channel{{Id: 9101, Config: tpm}, {Id: 9102, Config: tpm}, {Id: 9103, Config: tpm}}
+	defer func() {
+		channelUsageWindowsLock.Lock()
+		for _, channel := range channels {
+			delete(channelUsageWindows, channel.Id)
+		}
+		channelUsageWindowsLock.Unlock()
+	}()
+	for _, channel := range channels {
+		assert.True(t, AcquireChannelRequest(channel.Id, 0, 1000))
+	}
+	RecordChannelTokens(9101, 1000)
+	RecordChannelTokens(9102, 900)
+	assert.Equal(t, 0.0, GetChannelTPMHeadroom(9101, 1000))
+	assert.InDelta(t, 0.1, GetChannelTPMHeadroom(9102, 1000), 1e-9)
+	assert.Equal(t, 1.0, GetChannelTPMHeadroom(9103, 1000))
+	assert.Equal(t, 1.0, GetChannelTPMHeadroom(9101, 0))
+
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		counts[selectChannelByTPMHeadroom(channels).Id]++
+	}
+	// the channel at its limit is skipped, and the one with the most headroom is preferred
+	assert.Equal(t, 0, counts[9101])
+	assert.Greater(t, counts[9103], counts[9102]*3)
+
+	RecordChannelTokens(9102, 100)
+	RecordChannelTokens(9103, 1000)
+	assert.Nil(t, selectChannelByTPMHeadroom(channels))
+
+	assert.Equal(t, ChannelStrategyRandom, GetGroupChannelStrategy("default"))
+	assert.NoError(t, UpdateGroupChannelStrategyByJSONString(`{"vip": "tpm_headroom"}`))
+	defer func() { _ = UpdateGroupChannelStrategyByJSONString(`{}`) }()
+	assert.Equal(t, ChannelStrategyTPMHeadroom, GetGroupChannelStrategy("vip"))
+	assert.Error(t, UpdateGroupChannelStrategyByJSONString(`{"vip": "least_connections"}`))
+	assert.Equal(t, ChannelStrategyTPMHeadroom, GetGroupChannelStrategy("vip"))
+}
+
+func TestSelectChannelByTPMHeadroomInFlight(t *testing.T) {
+	withoutRedis(t)
+	channels := []*Channel{{Id: 9201}, {Id: 9202}}
+	for i := 0; i < 3; i++ {
+		BeginChannelRequest(9201)
+		defer EndChannelRequest(9201)
+	}
+	counts := make(map[int]int)
+	for i := 0; i < 1000; i++ {
+		counts[selectChannelByTPMHeadroom(channels).Id]++
+	}
+	// the idle channel gets about 4 times the requests of the one with 3 requests in flight
+	assert.Greater(t, counts[9202], counts[9201]*2)
+}
+
+func TestGetRandomSatisfiedChannelByTPMHeadroom(t *testing.T) {
+	withoutRedis(t)
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&Channel{}, &Ability{}))
+	originalDB, memoryCacheEnabled, usingSQLite := DB, config.MemoryCacheEnabled, common.UsingSQLite
+	DB, config.MemoryCacheEnabled, common.UsingSQLite = db, false, true
+	defer func() {
+		DB, config.MemoryCacheEnabled, common.UsingSQLite = originalDB, memoryCacheEnabled, usingSQLite
+	}()
+	assert.NoError(t, UpdateGroupChannelStrategyByJSONString(`{"vip": "tpm_headroom"}`))
+	defer func() { _ = UpdateGroupChannelStrategyByJSONString(`{}`) }()
+
+	highPriority, lowPriority := int64(10), int64(0)
+	assert.NoError(t, db.Create(&[]Channel{{Id: 9301, Config: `{"tpm": "1000"}`}, {Id: 9302}, {Id: 9303}}).Error)
+	assert.NoError(t, db.Create(&[]Ability{
+		{Group: "vip", Model: "gpt-4", ChannelId: 9301, Enabled: true, Priority: &highPriority},
+		{Group: "vip", Model: "gpt-4", ChannelId: 9302, Enabled: true, Priority: &highPriority},
+		{Group: "vip", Model: "gpt-4", ChannelId: 9303, Enabled: true, Priority: &lowPriority},
+	}).Error)
+	assert.True(t, AcquireChannelRequest(9301, 0, 1000))
+	RecordChannelTokens(9301, 1000)
+	defer func() {
+		channelUsageWindowsLock.Lock()
+		delete(channelUsageWindows, 9301)
+		channelUsageWindowsLock.Unlock()
+	}()
+
+	// the channel which has used up its tpm limit is never selected from the database either,
+	// nor are the channels of a lower priority
+	for i := 0; i < 20; i++ {
+		channel, err := CacheGetRandomSatisfiedChannel("vip", "gpt-4", false)
+		assert.NoError(t, err)
+		assert.Equal(t, 9302, channel.Id)
+	}
+	counts := make(map[int]int)
+	for i := 0; i < 100; i++ {
+		channel, err := CacheGetRandomSatisfiedChannel("vip", "gpt-4", true)
+		assert.NoError(t, err)
+		counts[channel.Id]++
+	}
+	assert.Equal(t, 0, counts[9301])
+	assert.Greater(t, counts[9303], 0)
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// groupDedupWindow maps a group to the window in milliseconds in which a request identical to another of the same token
+// is answered with the response of the other instead of being sent upstream, groups without a window are not deduplicated
+var groupDedupWindow = map[string]int{}
+var groupDedupWindowLock sync.RWMutex
+
+func GroupDedupWindow2JSONString() string {
+	groupDedupWindowLock.RLock()
+	defer groupDedupWindowLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupDedupWindow)
+	if err != nil {
+		logger.SysError("error marshalling group dedup window: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupDedupWindowByJSONString(jsonStr string) error {
+	newGroupDedupWindow := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupDedupWindow)
+	if err != nil {
+		return err
+	}
+	groupDedupWindowLock.Lock()
+	groupDedupWindow = newGroupDedupWindow
+	groupDedupWindowLock.Unlock()
+	return nil
+}
+
+// GetGroupDedupWindow returns the dedup window in milliseconds of the group, 0 means no deduplication
+func GetGroupDedupWindow(group string) int {
+	groupDedupWindowLock.RLock()
+	defer groupDedupWindowLock.RUnlock()
+	return groupDedupWindow[group]
+}
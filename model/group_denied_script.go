@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+	"unicode"
+)
+
+// groupDeniedScripts maps a group to the unicode scripts its prompts must not be written in,
+// named like the scripts of the unicode package, e.g. Cyrillic or Han, groups without scripts listed are not checked
+var groupDeniedScripts = map[string][]string{}
+var groupDeniedScriptsLock sync.RWMutex
+
+func GroupDeniedScripts2JSONString() string {
+	groupDeniedScriptsLock.RLock()
+	defer groupDeniedScriptsLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupDeniedScripts)
+	if err != nil {
+		logger.SysError("error marshalling group denied scripts: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupDeniedScriptsByJSONString(jsonStr string) error {
+	newGroupDeniedScripts := make(map[string][]string)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupDeniedScripts)
+	if err != nil {
+		return err
+	}
+	for group, scripts := range newGroupDeniedScripts {
+		for _, script := range scripts {
+			if _, ok := unicode.Scripts[script]; !ok {
+				return fmt.Errorf("unknown script %s of group %s", script, group)
+			}
+		}
+	}
+	groupDeniedScriptsLock.Lock()
+	groupDeniedScripts = newGroupDeniedScripts
+	groupDeniedScriptsLock.Unlock()
+	return nil
+}
+
+func GetGroupDeniedScripts(group string) []string {
+	groupDeniedScriptsLock.RLock()
+	defer groupDeniedScriptsLock.RUnlock()
+	return groupDeniedScripts[group]
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// groupMaxHistoryTokens maps a group to the token budget of the messages of its chat requests,
+// the oldest messages of longer conversations are trimmed, groups without a budget are not trimmed
+var groupMaxHistoryTokens = map[string]int{}
+var groupMaxHistoryTokensLock sync.RWMutex
+
+func GroupMaxHistoryTokens2JSONString() string {
+	groupMaxHistoryTokensLock.RLock()
+	defer groupMaxHistoryTokensLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupMaxHistoryTokens)
+	if err != nil {
+		logger.SysError("error marshalling group max history tokens: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupMaxHistoryTokensByJSONString(jsonStr string) error {
+	newGroupMaxHistoryTokens := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupMaxHistoryTokens)
+	if err != nil {
+		return err
+	}
+	groupMaxHistoryTokensLock.Lock()
+	groupMaxHistoryTokens = newGroupMaxHistoryTokens
+	groupMaxHistoryTokensLock.Unlock()
+	return nil
+}
+
+// GetGroupMaxHistoryTokens returns the token budget of the messages of the group, 0 means no trimming
+func GetGroupMaxHistoryTokens(group string) int {
+	groupMaxHistoryTokensLock.RLock()
+	defer groupMaxHistoryTokensLock.RUnlock()
+	return groupMaxHistoryTokens[group]
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// groupAllowedModels limits the models each group can use regardless of channels,
+// a group without models listed can use all models
+var groupAllowedModels = map[string][]string{}
+var groupAllowedModelsLock sync.RWMutex
+
+func GroupAllowedModels2JSONString() string {
+	groupAllowedModelsLock.RLock()
+	defer groupAllowedModelsLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupAllowedModels)
+	if err != nil {
+		logger.SysError("error marshalling group allowed models: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupAllowedModelsByJSONString(jsonStr string) error {
+	newGroupAllowedModels := make(map[string][]string)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupAllowedModels)
+	if err != nil {
+		return err
+	}
+	groupAllowedModelsLock.Lock()
+	groupAllowedModels = newGroupAllowedModels
+	groupAllowedModelsLock.Unlock()
+	return nil
+}
+
+func IsModelAllowedForGroup(group string, modelName string) bool {
+	groupAllowedModelsLock.RLock()
+	defer groupAllowedModelsLock.RUnlock()
+	models := groupAllowedModels[group]
+	if len(models) == 0 {
+		return true
+	}
+	for _, m := range models {
+		if m == modelName {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,42 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// groupModelDowngrade maps a group to the cheaper models its requests are downgraded to,
+// e.g. {"default": {"gpt-4o": "gpt-4o-mini"}}, a request is only downgraded when the quota
+// of the user is below ModelDowngradeQuotaThreshold
+var groupModelDowngrade = map[string]map[string]string{}
+var groupModelDowngradeLock sync.RWMutex
+
+func GroupModelDowngrade2JSONString() string {
+	groupModelDowngradeLock.RLock()
+	defer groupModelDowngradeLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupModelDowngrade)
+	if err != nil {
+		logger.SysError("error marshalling group model downgrade: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupModelDowngradeByJSONString(jsonStr string) error {
+	newGroupModelDowngrade := make(map[string]map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupModelDowngrade)
+	if err != nil {
+		return err
+	}
+	groupModelDowngradeLock.Lock()
+	groupModelDowngrade = newGroupModelDowngrade
+	groupModelDowngradeLock.Unlock()
+	return nil
+}
+
+// GetGroupDowngradeModel returns the cheaper model the model is downgraded to in the group, empty if there is none
+func GetGroupDowngradeModel(group string, modelName string) string {
+	groupModelDowngradeLock.RLock()
+	defer groupModelDowngradeLock.RUnlock()
+	return groupModelDowngrade[group][modelName]
+}
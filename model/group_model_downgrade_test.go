@@ -0,0 +1,19 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetGroupDowngradeModel(t *testing.T) {
+	err := UpdateGroupModelDowngradeByJSONString(`{"default":{"gpt-4o":"gpt-4o-mini"}}`)
+	assert.NoError(t, err)
+	defer func() {
+		_ = UpdateGroupModelDowngradeByJSONString("{}")
+	}()
+
+	assert.Equal(t, "gpt-4o-mini", GetGroupDowngradeModel("default", "gpt-4o"))
+	assert.Equal(t, "", GetGroupDowngradeModel("default", "gpt-4o-mini"))
+	assert.Equal(t, "", GetGroupDowngradeModel("vip", "gpt-4o"))
+}
@@ -0,0 +1,34 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsModelAllowedForGroup(t *testing.T) {
+	err := UpdateGroupAllowedModelsByJSONString(`{"default":["gpt-3.5-turbo"],"vip":["gpt-3.5-turbo","gpt-4"],"svip":[]}`)
+	assert.NoError(t, err)
+	defer func() {
+		_ = UpdateGroupAllowedModelsByJSONString("{}")
+	}()
+
+	assert.True(t, IsModelAllowedForGroup("default", "gpt-3.5-turbo"))
+	assert.False(t, IsModelAllowedForGroup("default", "gpt-4"))
+	assert.True(t, IsModelAllowedForGroup("vip", "gpt-4"))
+	// an empty list or a group without an entry permits all models
+	assert.True(t, IsModelAllowedForGroup("svip", "gpt-4"))
+	assert.True(t, IsModelAllowedForGroup("unknown", "gpt-4"))
+}
+
+func TestUpdateGroupAllowedModelsByInvalidJSONString(t *testing.T) {
+	err := UpdateGroupAllowedModelsByJSONString(`{"default":["gpt-3.5-turbo"]}`)
+	assert.NoError(t, err)
+	defer func() {
+		_ = UpdateGroupAllowedModelsByJSONString("{}")
+	}()
+
+	// the previous list is kept on invalid input
+	assert.Error(t, UpdateGroupAllowedModelsByJSONString(`{"default":`))
+	assert.False(t, IsModelAllowedForGroup("default", "gpt-4"))
+}
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+const (
+	RateLimitKeySourceToken = "token"
+	RateLimitKeySourceUser  = "user"
+	RateLimitKeySourceIP    = "ip"
+)
+
+// groupRateLimitKeySource overrides RELAY_RATE_LIMIT_KEY_SOURCE for some groups
+var groupRateLimitKeySource = map[string]string{}
+var groupRateLimitKeySourceLock sync.RWMutex
+
+func GroupRateLimitKeySource2JSONString() string {
+	groupRateLimitKeySourceLock.RLock()
+	defer groupRateLimitKeySourceLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupRateLimitKeySource)
+	if err != nil {
+		logger.SysError("error marshalling group rate limit key source: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupRateLimitKeySourceByJSONString(jsonStr string) error {
+	newGroupRateLimitKeySource := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupRateLimitKeySource)
+	if err != nil {
+		return err
+	}
+	groupRateLimitKeySourceLock.Lock()
+	groupRateLimitKeySource = newGroupRateLimitKeySource
+	groupRateLimitKeySourceLock.Unlock()
+	return nil
+}
+
+func GetRateLimitKeySource(group string) string {
+	groupRateLimitKeySourceLock.RLock()
+	defer groupRateLimitKeySourceLock.RUnlock()
+	if keySource, ok := groupRateLimitKeySource[group]; ok {
+		return keySource
+	}
+	return config.RelayRateLimitKeySource
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// groupShadowChannel maps a group to the channel its traffic is mirrored to,
+// the responses of a shadow channel are only logged, they are neither returned nor billed
+var groupShadowChannel = map[string]int{}
+var groupShadowChannelLock sync.RWMutex
+
+func GroupShadowChannel2JSONString() string {
+	groupShadowChannelLock.RLock()
+	defer groupShadowChannelLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupShadowChannel)
+	if err != nil {
+		logger.SysError("error marshalling group shadow channel: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupShadowChannelByJSONString(jsonStr string) error {
+	newGroupShadowChannel := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupShadowChannel)
+	if err != nil {
+		return err
+	}
+	groupShadowChannelLock.Lock()
+	groupShadowChannel = newGroupShadowChannel
+	groupShadowChannelLock.Unlock()
+	return nil
+}
+
+// GetShadowChannelId returns the shadow channel of the group, 0 means no mirroring
+func GetShadowChannelId(group string) int {
+	groupShadowChannelLock.RLock()
+	defer groupShadowChannelLock.RUnlock()
+	return groupShadowChannel[group]
+}
@@ -0,0 +1,64 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"hash/fnv"
+	"strconv"
+	"sync"
+)
+
+const (
+	StickySessionKeyUserId = "user_id"
+	StickySessionKeyUser   = "user"
+)
+
+// groupStickySession maps a group to the key its requests stick to a channel by,
+// either the user id or the user field of the request, groups without a key select channels randomly
+var groupStickySession = map[string]string{}
+var groupStickySessionLock sync.RWMutex
+
+func GroupStickySession2JSONString() string {
+	groupStickySessionLock.RLock()
+	defer groupStickySessionLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupStickySession)
+	if err != nil {
+		logger.SysError("error marshalling group sticky session: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupStickySessionByJSONString(jsonStr string) error {
+	newGroupStickySession := make(map[string]string)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupStickySession)
+	if err != nil {
+		return err
+	}
+	groupStickySessionLock.Lock()
+	groupStickySession = newGroupStickySession
+	groupStickySessionLock.Unlock()
+	return nil
+}
+
+// GetStickySessionKey returns the sticky session key of the group, empty means no sticky session
+func GetStickySessionKey(group string) string {
+	groupStickySessionLock.RLock()
+	defer groupStickySessionLock.RUnlock()
+	return groupStickySession[group]
+}
+
+// selectStickyChannel picks the channel of the sticky key by rendezvous hashing, so that a key keeps its channel
+// as long as the channel is among the candidates, and only the keys of a removed channel move elsewhere
+func selectStickyChannel(channels []*Channel, stickyKey string) *Channel {
+	var selected *Channel
+	var maxScore uint64
+	for _, channel := range channels {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(stickyKey + ":" + strconv.Itoa(channel.Id)))
+		score := h.Sum64()
+		if selected == nil || score > maxScore {
+			selected, maxScore = channel, score
+		}
+	}
+	return selected
+}
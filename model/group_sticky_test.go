@@ -0,0 +1,74 @@
+package model
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectStickyChannel(t *testing.T) {
+	channels := []*Channel{{Id: 1}, {Id: 2}, {Id: 3}, {Id: 4}}
+	assert.Nil(t, selectStickyChannel(nil, "user_id:1"))
+
+	used := make(map[int]bool)
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("user_id:%d", i)
+		selected := selectStickyChannel(channels, key)
+		assert.Equal(t, selected, selectStickyChannel(channels, key))
+		used[selected.Id] = true
+		var others []*Channel
+		for _, channel := range channels {
+			if channel.Id != selected.Id {
+				others = append(others, channel)
+			}
+		}
+		// removing another channel keeps the key on its channel
+		assert.Equal(t, selected, selectStickyChannel(append([]*Channel{selected}, others[1:]...), key))
+		// removing its channel moves the key elsewhere
+		assert.NotEqual(t, selected.Id, selectStickyChannel(others, key).Id)
+	}
+	assert.Len(t, used, len(channels))
+}
+
+func TestCacheGetStickyChannel(t *testing.T) {
+	withoutRedis(t)
+	memoryCacheEnabled := config.MemoryCacheEnabled
+	config.MemoryCacheEnabled = true
+	priority := int64(1)
+	channels := []*Channel{{Id: -11, Priority: &priority}, {Id: -12, Priority: &priority}, {Id: -13}}
+	channelSyncLock.Lock()
+	group2model2channels = map[string]map[string][]*Channel{"default": {"gpt-4": channels}}
+	channelSyncLock.Unlock()
+	defer func() {
+		config.MemoryCacheEnabled = memoryCacheEnabled
+		channelSyncLock.Lock()
+		group2model2channels = nil
+		channelSyncLock.Unlock()
+		delete(channelUsageWindows, -11)
+		delete(channelUsageWindows, -12)
+	}()
+
+	selected, err := CacheGetStickyChannel("default", "gpt-4", "user_id:1")
+	assert.NoError(t, err)
+	// only the channels of the highest priority are candidates
+	assert.NotEqual(t, -13, selected.Id)
+	for i := 0; i < 10; i++ {
+		channel, err := CacheGetStickyChannel("default", "gpt-4", "user_id:1")
+		assert.NoError(t, err)
+		assert.Equal(t, selected.Id, channel.Id)
+	}
+
+	// a busy channel is skipped
+	selected.Config = `{"rpm": "1"}`
+	defer func() { selected.Config = "" }()
+	assert.True(t, AcquireChannelRequest(selected.Id, 1, 0))
+	channel, err := CacheGetStickyChannel("default", "gpt-4", "user_id:1")
+	assert.NoError(t, err)
+	assert.NotEqual(t, selected.Id, channel.Id)
+	assert.NotEqual(t, -13, channel.Id)
+
+	_, err = CacheGetStickyChannel("default", "gpt-3.5-turbo", "user_id:1")
+	assert.Error(t, err)
+}
@@ -0,0 +1,44 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// groupMaxStreams overrides GROUP_MAX_STREAMS for some groups
+var groupMaxStreams = map[string]int{}
+var groupMaxStreamsLock sync.RWMutex
+
+func GroupMaxStreams2JSONString() string {
+	groupMaxStreamsLock.RLock()
+	defer groupMaxStreamsLock.RUnlock()
+	jsonBytes, err := json.Marshal(groupMaxStreams)
+	if err != nil {
+		logger.SysError("error marshalling group max streams: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupMaxStreamsByJSONString(jsonStr string) error {
+	newGroupMaxStreams := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupMaxStreams)
+	if err != nil {
+		return err
+	}
+	groupMaxStreamsLock.Lock()
+	groupMaxStreams = newGroupMaxStreams
+	groupMaxStreamsLock.Unlock()
+	return nil
+}
+
+// GetGroupMaxStreams returns the limit of concurrent streams of the group, 0 means no limit
+func GetGroupMaxStreams(group string) int {
+	groupMaxStreamsLock.RLock()
+	defer groupMaxStreamsLock.RUnlock()
+	if maxStreams, ok := groupMaxStreams[group]; ok {
+		return maxStreams
+	}
+	return config.GroupMaxStreams
+}
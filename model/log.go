@@ -2,27 +2,29 @@ package model
 
 import (
 	"context"
-	"fmt"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"gorm.io/gorm"
+	"strings"
 )
 
 type Log struct {
-	Id               int    `json:"id"`
-	UserId           int    `json:"user_id" gorm:"index"`
-	CreatedAt        int64  `json:"created_at" gorm:"bigint;index:idx_created_at_type"`
-	Type             int    `json:"type" gorm:"index:idx_created_at_type"`
-	Content          string `json:"content"`
-	Username         string `json:"username" gorm:"index:index_username_model_name,priority:2;default:''"`
-	TokenName        string `json:"token_name" gorm:"index;default:''"`
-	ModelName        string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
-	Quota            int    `json:"quota" gorm:"default:0"`
-	PromptTokens     int    `json:"prompt_tokens" gorm:"default:0"`
-	CompletionTokens int    `json:"completion_tokens" gorm:"default:0"`
-	ChannelId        int    `json:"channel" gorm:"index"`
+	Id                int    `json:"id"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	CreatedAt         int64  `json:"created_at" gorm:"bigint;index:idx_created_at_type"`
+	Type              int    `json:"type" gorm:"index:idx_created_at_type"`
+	Content           string `json:"content"`
+	Username          string `json:"username" gorm:"index:index_username_model_name,priority:2;default:''"`
+	TokenName         string `json:"token_name" gorm:"index;default:''"`
+	ModelName         string `json:"model_name" gorm:"index;index:index_username_model_name,priority:1;default:''"`
+	Quota             int    `json:"quota" gorm:"default:0"`
+	PromptTokens      int    `json:"prompt_tokens" gorm:"default:0"`
+	CompletionTokens  int    `json:"completion_tokens" gorm:"default:0"`
+	ChannelId         int    `json:"channel" gorm:"index"`
+	ImagePromptTokens int    `json:"image_prompt_tokens" gorm:"default:0"` // the part of PromptTokens spent on images
+	ThreadId          string `json:"thread_id" gorm:"index;default:''"`    // the assistants thread the request ran in
 }
 
 const (
@@ -66,11 +68,62 @@ func RecordTopupLog(userId int, content string, quota int) {
 }
 
 func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptTokens int, completionTokens int, modelName string, tokenName string, quota int64, content string) {
-	logger.Info(ctx, fmt.Sprintf("record consume log: userId=%d, channelId=%d, promptTokens=%d, completionTokens=%d, modelName=%s, tokenName=%s, quota=%d, content=%s", userId, channelId, promptTokens, completionTokens, modelName, tokenName, quota, content))
+	RecordConsumeLogWithImageTokens(ctx, userId, channelId, promptTokens, 0, completionTokens, modelName, tokenName, quota, content)
+}
+
+// RecordConsumeLogWithImageTokens records a consume log along with the part of its prompt tokens spent on images
+func RecordConsumeLogWithImageTokens(ctx context.Context, userId int, channelId int, promptTokens int, imagePromptTokens int, completionTokens int, modelName string, tokenName string, quota int64, content string) {
+	if quota == 0 && promptTokens+completionTokens == 0 {
+		logger.Warnf(ctx, "consume log of model %s has neither tokens nor quota", modelName)
+	}
+	logger.InfoWithFields(ctx, "record consume log", logger.Fields{
+		"user_id":             userId,
+		"channel_id":          channelId,
+		"prompt_tokens":       promptTokens,
+		"image_prompt_tokens": imagePromptTokens,
+		"completion_tokens":   completionTokens,
+		"model":               modelName,
+		"token_name":          tokenName,
+		"quota":               quota,
+		"content":             content,
+	})
 	if !config.LogConsumeEnabled {
 		return
 	}
-	log := &Log{
+	recordConsumeLog(ctx, &Log{
+		UserId:            userId,
+		Username:          GetUsernameById(userId),
+		CreatedAt:         helper.GetTimestamp(),
+		Type:              LogTypeConsume,
+		Content:           content,
+		PromptTokens:      promptTokens,
+		ImagePromptTokens: imagePromptTokens,
+		CompletionTokens:  completionTokens,
+		TokenName:         tokenName,
+		ModelName:         modelName,
+		Quota:             int(quota),
+		ChannelId:         channelId,
+	})
+}
+
+// RecordThreadConsumeLog records the consume log of an assistants run, keyed by its thread,
+// so that the usage of the thread can be summed up afterwards
+func RecordThreadConsumeLog(ctx context.Context, threadId string, userId int, channelId int, promptTokens int, completionTokens int, modelName string, tokenName string, quota int64, content string) {
+	logger.InfoWithFields(ctx, "record consume log", logger.Fields{
+		"user_id":           userId,
+		"channel_id":        channelId,
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": completionTokens,
+		"model":             modelName,
+		"token_name":        tokenName,
+		"quota":             quota,
+		"thread_id":         threadId,
+		"content":           content,
+	})
+	if !config.LogConsumeEnabled {
+		return
+	}
+	recordConsumeLog(ctx, &Log{
 		UserId:           userId,
 		Username:         GetUsernameById(userId),
 		CreatedAt:        helper.GetTimestamp(),
@@ -82,6 +135,14 @@ func RecordConsumeLog(ctx context.Context, userId int, channelId int, promptToke
 		ModelName:        modelName,
 		Quota:            int(quota),
 		ChannelId:        channelId,
+		ThreadId:         threadId,
+	})
+}
+
+func recordConsumeLog(ctx context.Context, log *Log) {
+	if config.LogBatchEnabled {
+		addConsumeLog(log)
+		return
 	}
 	err := LOG_DB.Create(log).Error
 	if err != nil {
@@ -210,16 +271,19 @@ type LogStatistic struct {
 	CompletionTokens int    `gorm:"column:completion_tokens"`
 }
 
-func SearchLogsByDayAndModel(userId, start, end int) (LogStatistics []*LogStatistic, err error) {
-	groupSelect := "DATE_FORMAT(FROM_UNIXTIME(created_at), '%Y-%m-%d') as day"
-
+// dayGroupSelect selects the day of created_at as YYYY-MM-DD in the syntax of the log database
+func dayGroupSelect() string {
 	if common.UsingPostgreSQL {
-		groupSelect = "TO_CHAR(date_trunc('day', to_timestamp(created_at)), 'YYYY-MM-DD') as day"
+		return "TO_CHAR(date_trunc('day', to_timestamp(created_at)), 'YYYY-MM-DD') as day"
 	}
-
 	if common.UsingSQLite {
-		groupSelect = "strftime('%Y-%m-%d', datetime(created_at, 'unixepoch')) as day"
+		return "strftime('%Y-%m-%d', datetime(created_at, 'unixepoch')) as day"
 	}
+	return "DATE_FORMAT(FROM_UNIXTIME(created_at), '%Y-%m-%d') as day"
+}
+
+func SearchLogsByDayAndModel(userId, start, end int) (LogStatistics []*LogStatistic, err error) {
+	groupSelect := dayGroupSelect()
 
 	err = LOG_DB.Raw(`
 		SELECT `+groupSelect+`,
@@ -237,3 +301,78 @@ func SearchLogsByDayAndModel(userId, start, end int) (LogStatistics []*LogStatis
 
 	return LogStatistics, err
 }
+
+type ModelUsage struct {
+	Day              string `json:"day,omitempty" gorm:"column:day"`
+	ModelName        string `json:"model_name" gorm:"column:model_name"`
+	RequestCount     int    `json:"request_count" gorm:"column:request_count"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"column:prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"column:completion_tokens"`
+	Quota            int64  `json:"quota" gorm:"column:quota"`
+}
+
+// GetModelUsage aggregates the consume logs of the user per model, userId 0 means all users,
+// the usage is further split per day if groupByDay is set, timestamps of 0 leave the range open
+func GetModelUsage(userId int, startTimestamp int64, endTimestamp int64, groupByDay bool) (usages []*ModelUsage, err error) {
+	selects := []string{
+		"model_name",
+		"count(1) as request_count",
+		"coalesce(sum(prompt_tokens),0) as prompt_tokens",
+		"coalesce(sum(completion_tokens),0) as completion_tokens",
+		"coalesce(sum(quota),0) as quota",
+	}
+	groups := "model_name"
+	if groupByDay {
+		selects = append([]string{dayGroupSelect()}, selects...)
+		groups = "day, model_name"
+	}
+	tx := LOG_DB.Table("logs").Select(strings.Join(selects, ", ")).Where("type = ?", LogTypeConsume)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if startTimestamp != 0 {
+		tx = tx.Where("created_at >= ?", startTimestamp)
+	}
+	if endTimestamp != 0 {
+		tx = tx.Where("created_at <= ?", endTimestamp)
+	}
+	err = tx.Group(groups).Order(groups).Scan(&usages).Error
+	return usages, err
+}
+
+// ThreadUsage is the usage summed up over the consume logs of an assistants thread
+type ThreadUsage struct {
+	ThreadId         string        `json:"thread_id"`
+	RequestCount     int           `json:"request_count"`
+	PromptTokens     int64         `json:"prompt_tokens"`
+	CompletionTokens int64         `json:"completion_tokens"`
+	TotalTokens      int64         `json:"total_tokens"`
+	Quota            int64         `json:"quota"`
+	Models           []*ModelUsage `json:"models"`
+}
+
+// GetThreadUsage sums up the consume logs of the thread per model, userId 0 means all users
+func GetThreadUsage(userId int, threadId string) (*ThreadUsage, error) {
+	tx := LOG_DB.Table("logs").Select(strings.Join([]string{
+		"model_name",
+		"count(1) as request_count",
+		"coalesce(sum(prompt_tokens),0) as prompt_tokens",
+		"coalesce(sum(completion_tokens),0) as completion_tokens",
+		"coalesce(sum(quota),0) as quota",
+	}, ", ")).Where("type = ? and thread_id = ?", LogTypeConsume, threadId)
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	usage := &ThreadUsage{ThreadId: threadId, Models: make([]*ModelUsage, 0)}
+	if err := tx.Group("model_name").Order("model_name").Scan(&usage.Models).Error; err != nil {
+		return nil, err
+	}
+	for _, modelUsage := range usage.Models {
+		usage.RequestCount += modelUsage.RequestCount
+		usage.PromptTokens += modelUsage.PromptTokens
+		usage.CompletionTokens += modelUsage.CompletionTokens
+		usage.Quota += modelUsage.Quota
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage, nil
+}
@@ -0,0 +1,74 @@
+package model
+
+import (
+	"fmt"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+	"time"
+)
+
+// consume logs are buffered and written in batches when LOG_BATCH_ENABLED is set,
+// quota updates are not affected and stay immediate
+
+var consumeLogBuffer []*Log
+var consumeLogBufferLock sync.Mutex
+var consumeLogFlushLock sync.Mutex
+var consumeLogFlushSignal = make(chan struct{}, 1)
+
+func InitConsumeLogBatchWriter() {
+	go func() {
+		ticker := time.NewTicker(time.Duration(config.LogBatchInterval) * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+			case <-consumeLogFlushSignal:
+			}
+			FlushConsumeLogs()
+		}
+	}()
+}
+
+func addConsumeLog(log *Log) {
+	consumeLogBufferLock.Lock()
+	consumeLogBuffer = append(consumeLogBuffer, log)
+	full := len(consumeLogBuffer) >= config.LogBatchSize
+	consumeLogBufferLock.Unlock()
+	if full {
+		select {
+		case consumeLogFlushSignal <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// maxRequeuedConsumeLogBatches bounds the consume logs kept for retrying while the log database fails,
+// in batches of LOG_BATCH_SIZE, the older logs are dropped beyond it
+const maxRequeuedConsumeLogBatches = 10
+
+// FlushConsumeLogs writes all buffered consume logs, it must be called before shutting down
+func FlushConsumeLogs() {
+	consumeLogFlushLock.Lock()
+	defer consumeLogFlushLock.Unlock()
+	consumeLogBufferLock.Lock()
+	logs := consumeLogBuffer
+	consumeLogBuffer = nil
+	consumeLogBufferLock.Unlock()
+	if len(logs) == 0 {
+		return
+	}
+	err := LOG_DB.CreateInBatches(logs, config.LogBatchSize).Error
+	if err != nil {
+		logger.SysError("failed to batch record consume logs: " + err.Error())
+		// put them back, they will be retried on the next flush
+		consumeLogBufferLock.Lock()
+		consumeLogBuffer = append(logs, consumeLogBuffer...)
+		maxLogs := maxRequeuedConsumeLogBatches * config.LogBatchSize
+		if dropped := len(consumeLogBuffer) - maxLogs; dropped > 0 {
+			consumeLogBuffer = consumeLogBuffer[dropped:]
+			logger.SysError(fmt.Sprintf("dropped %d consume logs which failed to be recorded", dropped))
+		}
+		consumeLogBufferLock.Unlock()
+	}
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestGetThreadUsage(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&Log{}))
+	originalDB := LOG_DB
+	LOG_DB = db
+	defer func() { LOG_DB = originalDB }()
+
+	logs := []*Log{
+		{UserId: 1, Type: LogTypeConsume, ModelName: "gpt-4o", PromptTokens: 10, CompletionTokens: 5, Quota: 100, ThreadId: "thread_1"},
+		{UserId: 1, Type: LogTypeConsume, ModelName: "gpt-4o", PromptTokens: 20, ImagePromptTokens: 8, CompletionTokens: 5, Quota: 200, ThreadId: "thread_1"},
+		{UserId: 1, Type: LogTypeConsume, ModelName: "gpt-4o-mini", PromptTokens: 1, CompletionTokens: 1, Quota: 1, ThreadId: "thread_1"},
+		{UserId: 2, Type: LogTypeConsume, ModelName: "gpt-4o", PromptTokens: 7, CompletionTokens: 7, Quota: 70, ThreadId: "thread_1"},
+		{UserId: 1, Type: LogTypeConsume, ModelName: "gpt-4o", PromptTokens: 9, CompletionTokens: 9, Quota: 90, ThreadId: "thread_2"},
+	}
+	assert.NoError(t, db.Create(logs).Error)
+
+	usage, err := GetThreadUsage(1, "thread_1")
+	assert.NoError(t, err)
+	assert.Equal(t, 3, usage.RequestCount)
+	assert.Equal(t, int64(31), usage.PromptTokens)
+	assert.Equal(t, int64(11), usage.CompletionTokens)
+	assert.Equal(t, int64(42), usage.TotalTokens)
+	assert.Equal(t, int64(301), usage.Quota)
+	assert.Len(t, usage.Models, 2)
+	assert.Equal(t, "gpt-4o", usage.Models[0].ModelName)
+	assert.Equal(t, 2, usage.Models[0].RequestCount)
+
+	assert.Equal(t, 74, SumUsedToken(LogTypeConsume, 0, 0, "", "", ""))
+	modelUsages, err := GetModelUsage(1, 0, 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(39), modelUsages[0].PromptTokens)
+
+	usage, err = GetThreadUsage(0, "thread_1")
+	assert.NoError(t, err)
+	assert.Equal(t, 4, usage.RequestCount)
+
+	usage, err = GetThreadUsage(1, "thread_unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, usage.RequestCount)
+	assert.Empty(t, usage.Models)
+}
+
+func TestGetModelUsage(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&Log{}, &User{}))
+	originalDB, originalLogDB, usingSQLite := DB, LOG_DB, common.UsingSQLite
+	DB, LOG_DB, common.UsingSQLite = db, db, true
+	defer func() { DB, LOG_DB, common.UsingSQLite = originalDB, originalLogDB, usingSQLite }()
+	logConsumeEnabled, logBatchEnabled := config.LogConsumeEnabled, config.LogBatchEnabled
+	config.LogConsumeEnabled, config.LogBatchEnabled = true, false
+	defer func() { config.LogConsumeEnabled, config.LogBatchEnabled = logConsumeEnabled, logBatchEnabled }()
+
+	// the image tokens are recorded as a part of the prompt tokens, so they are counted once in the usage
+	ctx := context.Background()
+	RecordConsumeLogWithImageTokens(ctx, 1, 1, 20, 8, 5, "gpt-4o", "token", 200, "")
+	RecordConsumeLogWithImageTokens(ctx, 1, 1, 10, 0, 5, "gpt-4o", "token", 100, "")
+	RecordConsumeLog(ctx, 1, 1, 3, 1, "gpt-4o-mini", "token", 4, "")
+	RecordConsumeLogWithImageTokens(ctx, 2, 1, 30, 30, 0, "gpt-4o", "token", 300, "")
+	assert.NoError(t, db.Model(&Log{}).Where("model_name = ?", "gpt-4o-mini").Update("created_at", 86400).Error)
+
+	usages, err := GetModelUsage(1, 0, 0, false)
+	assert.NoError(t, err)
+	assert.Len(t, usages, 2)
+	assert.Equal(t, "gpt-4o", usages[0].ModelName)
+	assert.Equal(t, 2, usages[0].RequestCount)
+	assert.Equal(t, int64(30), usages[0].PromptTokens)
+	assert.Equal(t, int64(10), usages[0].CompletionTokens)
+	assert.Equal(t, int64(300), usages[0].Quota)
+
+	usages, err = GetModelUsage(0, 0, 0, false)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(60), usages[0].PromptTokens)
+
+	// the range and the days are told by the creation time
+	usages, err = GetModelUsage(1, 0, 86400, true)
+	assert.NoError(t, err)
+	assert.Len(t, usages, 1)
+	assert.Equal(t, "1970-01-02", usages[0].Day)
+	assert.Equal(t, "gpt-4o-mini", usages[0].ModelName)
+	assert.Equal(t, int64(3), usages[0].PromptTokens)
+}
+
+func TestFlushConsumeLogsBoundsRequeued(t *testing.T) {
+	// no logs table, so that the logs fail to be recorded
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	originalDB := LOG_DB
+	LOG_DB = db
+	defer func() {
+		LOG_DB = originalDB
+		consumeLogBuffer = nil
+	}()
+
+	maxLogs := maxRequeuedConsumeLogBatches * config.LogBatchSize
+	for i := 0; i < maxLogs+5; i++ {
+		consumeLogBuffer = append(consumeLogBuffer, &Log{Id: i + 1})
+	}
+	FlushConsumeLogs()
+	assert.Len(t, consumeLogBuffer, maxLogs)
+	// the oldest logs are dropped
+	assert.Equal(t, 6, consumeLogBuffer[0].Id)
+}
@@ -138,6 +138,22 @@ func InitDB(envName string) (db *gorm.DB, err error) {
 		if err != nil {
 			return nil, err
 		}
+		err = db.AutoMigrate(&FreeAllowance{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&AuditLog{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&QuotaPool{})
+		if err != nil {
+			return nil, err
+		}
+		err = db.AutoMigrate(&AssistantsObject{})
+		if err != nil {
+			return nil, err
+		}
 		logger.SysLog("database migrated")
 		return db, err
 	} else {
@@ -0,0 +1,147 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// ModelCapabilities tells the clients what a model supports, listed with the model in /v1/models
+type ModelCapabilities struct {
+	Streaming  bool `json:"streaming"`
+	Tools      bool `json:"tools"`
+	Vision     bool `json:"vision"`
+	JSONMode   bool `json:"json_mode"`
+	MaxContext int  `json:"max_context,omitempty"`
+}
+
+// modelCapabilities maps a model to the capabilities overriding its default ones, only the fields
+// set are overridden, e.g. {"my-model": {"vision": true, "max_context": 32768}}
+var modelCapabilities = map[string]json.RawMessage{}
+var modelCapabilitiesLock sync.RWMutex
+
+func ModelCapabilities2JSONString() string {
+	modelCapabilitiesLock.RLock()
+	defer modelCapabilitiesLock.RUnlock()
+	jsonBytes, err := json.Marshal(modelCapabilities)
+	if err != nil {
+		logger.SysError("error marshalling model capabilities: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelCapabilitiesByJSONString(jsonStr string) error {
+	newModelCapabilities := make(map[string]json.RawMessage)
+	err := json.Unmarshal([]byte(jsonStr), &newModelCapabilities)
+	if err != nil {
+		return err
+	}
+	for name, capabilities := range newModelCapabilities {
+		var parsed ModelCapabilities
+		if err = json.Unmarshal(capabilities, &parsed); err != nil {
+			return fmt.Errorf("invalid capabilities of model %s: %w", name, err)
+		}
+		if parsed.MaxContext < 0 {
+			return fmt.Errorf("invalid max_context of model %s: must not be negative", name)
+		}
+	}
+	modelCapabilitiesLock.Lock()
+	modelCapabilities = newModelCapabilities
+	modelCapabilitiesLock.Unlock()
+	return nil
+}
+
+// the models which are not chat models, and neither stream nor take tools, images or json mode
+var nonChatModelKeywords = []string{"embedding", "embed", "tts", "whisper", "dall-e", "moderation", "rerank", "cogview", "stable-diffusion", "flux"}
+
+var toolsModelPrefixes = []string{"gpt-4", "gpt-3.5-turbo", "o1", "o3", "o4", "claude-3", "claude-sonnet", "claude-opus", "gemini", "mistral-large", "mistral-small", "deepseek-chat", "qwen", "glm-4", "moonshot"}
+
+var visionModelPrefixes = []string{"gpt-4o", "gpt-4-turbo", "gpt-4-vision", "gpt-4.1", "o1", "o3", "o4", "claude-3", "claude-sonnet", "claude-opus", "gemini-1.5", "gemini-2", "gemini-pro-vision", "glm-4v", "qwen-vl"}
+
+var jsonModeModelPrefixes = []string{"gpt-4o", "gpt-4-turbo", "gpt-4-1106", "gpt-4-0125", "gpt-4.1", "gpt-3.5-turbo", "o1", "o3", "o4", "gemini", "mistral", "deepseek-chat", "qwen", "glm-4", "moonshot"}
+
+// the models lacking a capability their family has, checked before the prefixes
+var toolsModelExceptions = []string{"o1-mini", "o1-preview", "gpt-4-vision", "gpt-3.5-turbo-instruct"}
+var visionModelExceptions = []string{"o1-mini", "o1-preview", "o3-mini"}
+var jsonModeModelExceptions = []string{"o1-mini", "o1-preview", "gpt-4-vision", "gpt-3.5-turbo-instruct", "gpt-3.5-turbo-0613", "gpt-3.5-turbo-0301"}
+
+// the context windows of the known models, by the longest matching prefix
+var modelContextWindows = map[string]int{
+	"gpt-3.5-turbo":     16385,
+	"gpt-4":             8192,
+	"gpt-4-32k":         32768,
+	"gpt-4-turbo":       128000,
+	"gpt-4-1106":        128000,
+	"gpt-4-0125":        128000,
+	"gpt-4-vision":      128000,
+	"gpt-4o":            128000,
+	"gpt-4.1":           1047576,
+	"o1":                200000,
+	"o1-mini":           128000,
+	"o1-preview":        128000,
+	"o3":                200000,
+	"o4-mini":           200000,
+	"claude-3":          200000,
+	"claude-sonnet":     200000,
+	"claude-opus":       200000,
+	"gemini-1.5-pro":    2097152,
+	"gemini-1.5-flash":  1048576,
+	"gemini-2":          1048576,
+	"deepseek-chat":     65536,
+	"deepseek-reasoner": 65536,
+}
+
+func hasAnyPrefix(name string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func getModelContextWindow(name string) int {
+	window, longest := 0, 0
+	for prefix, size := range modelContextWindows {
+		if strings.HasPrefix(name, prefix) && len(prefix) > longest {
+			window, longest = size, len(prefix)
+		}
+	}
+	return window
+}
+
+// getDefaultModelCapabilities guesses the capabilities of a model by its name, the unknown models are assumed
+// to be chat models which stream and nothing more, the context window is the one of the model rather than
+// the max input tokens, which is a limit set by the admin
+func getDefaultModelCapabilities(name string) ModelCapabilities {
+	lowerName := strings.ToLower(name)
+	for _, keyword := range nonChatModelKeywords {
+		if strings.Contains(lowerName, keyword) {
+			return ModelCapabilities{}
+		}
+	}
+	capabilities := ModelCapabilities{
+		Streaming:  true,
+		Tools:      hasAnyPrefix(lowerName, toolsModelPrefixes) && !hasAnyPrefix(lowerName, toolsModelExceptions),
+		Vision:     hasAnyPrefix(lowerName, visionModelPrefixes) && !hasAnyPrefix(lowerName, visionModelExceptions),
+		JSONMode:   hasAnyPrefix(lowerName, jsonModeModelPrefixes) && !hasAnyPrefix(lowerName, jsonModeModelExceptions),
+		MaxContext: getModelContextWindow(lowerName),
+	}
+	return capabilities
+}
+
+// GetModelCapabilities returns the default capabilities of the model with the configured ones applied
+func GetModelCapabilities(name string) ModelCapabilities {
+	capabilities := getDefaultModelCapabilities(name)
+	modelCapabilitiesLock.RLock()
+	configured, ok := modelCapabilities[name]
+	modelCapabilitiesLock.RUnlock()
+	if ok {
+		// the fields not configured keep their default values
+		_ = json.Unmarshal(configured, &capabilities)
+	}
+	return capabilities
+}
@@ -0,0 +1,32 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetModelCapabilities(t *testing.T) {
+	assert.Equal(t, ModelCapabilities{Streaming: true, Tools: true, Vision: true, JSONMode: true, MaxContext: 128000}, GetModelCapabilities("gpt-4o-mini"))
+	assert.Equal(t, ModelCapabilities{Streaming: true, MaxContext: 128000}, GetModelCapabilities("o1-mini"))
+	assert.Equal(t, ModelCapabilities{}, GetModelCapabilities("text-embedding-3-small"))
+	assert.Equal(t, ModelCapabilities{Streaming: true}, GetModelCapabilities("my-model"))
+
+	// the max input tokens set by the admin isn't the context window of the model
+	assert.NoError(t, UpdateModelMaxInputTokensByJSONString(`{"gpt-4o-mini": 4096}`))
+	assert.Equal(t, 128000, GetModelCapabilities("gpt-4o-mini").MaxContext)
+	assert.NoError(t, UpdateModelMaxInputTokensByJSONString("{}"))
+
+	err := UpdateModelCapabilitiesByJSONString(`{"my-model": {"vision": true, "max_context": 32768}, "gpt-4o-mini": {"streaming": false}}`)
+	assert.NoError(t, err)
+	defer func() {
+		_ = UpdateModelCapabilitiesByJSONString("{}")
+	}()
+	// only the configured fields are overridden
+	assert.Equal(t, ModelCapabilities{Streaming: true, Vision: true, MaxContext: 32768}, GetModelCapabilities("my-model"))
+	assert.Equal(t, ModelCapabilities{Tools: true, Vision: true, JSONMode: true, MaxContext: 128000}, GetModelCapabilities("gpt-4o-mini"))
+
+	assert.Error(t, UpdateModelCapabilitiesByJSONString(`{"my-model": {"vision": "yes"}}`))
+	assert.Error(t, UpdateModelCapabilitiesByJSONString(`{"my-model": {"max_context": -1}}`))
+	assert.Equal(t, ModelCapabilities{Streaming: true, Vision: true, MaxContext: 32768}, GetModelCapabilities("my-model"))
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// modelMaxInputTokens maps a model to the most prompt tokens it accepts, the longer prompts are rejected
+// before they are sent upstream, models without a limit are not checked
+var modelMaxInputTokens = map[string]int{}
+var modelMaxInputTokensLock sync.RWMutex
+
+func ModelMaxInputTokens2JSONString() string {
+	modelMaxInputTokensLock.RLock()
+	defer modelMaxInputTokensLock.RUnlock()
+	jsonBytes, err := json.Marshal(modelMaxInputTokens)
+	if err != nil {
+		logger.SysError("error marshalling model max input tokens: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelMaxInputTokensByJSONString(jsonStr string) error {
+	newModelMaxInputTokens := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &newModelMaxInputTokens)
+	if err != nil {
+		return err
+	}
+	modelMaxInputTokensLock.Lock()
+	modelMaxInputTokens = newModelMaxInputTokens
+	modelMaxInputTokensLock.Unlock()
+	return nil
+}
+
+// GetModelMaxInputTokens returns the most prompt tokens the model accepts, 0 means no limit
+func GetModelMaxInputTokens(name string) int {
+	modelMaxInputTokensLock.RLock()
+	defer modelMaxInputTokensLock.RUnlock()
+	return modelMaxInputTokens[name]
+}
@@ -0,0 +1,41 @@
+package model
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// modelTimeout maps a model to the timeout of its upstream requests in seconds, which takes precedence
+// over the timeout of the channel, e.g. for reasoning models taking much longer than the others
+var modelTimeout = map[string]int{}
+var modelTimeoutLock sync.RWMutex
+
+func ModelTimeout2JSONString() string {
+	modelTimeoutLock.RLock()
+	defer modelTimeoutLock.RUnlock()
+	jsonBytes, err := json.Marshal(modelTimeout)
+	if err != nil {
+		logger.SysError("error marshalling model timeout: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelTimeoutByJSONString(jsonStr string) error {
+	newModelTimeout := make(map[string]int)
+	err := json.Unmarshal([]byte(jsonStr), &newModelTimeout)
+	if err != nil {
+		return err
+	}
+	modelTimeoutLock.Lock()
+	modelTimeout = newModelTimeout
+	modelTimeoutLock.Unlock()
+	return nil
+}
+
+// GetModelTimeout returns the timeout of the model in seconds, 0 means the model has no timeout of its own
+func GetModelTimeout(name string) int {
+	modelTimeoutLock.RLock()
+	defer modelTimeoutLock.RUnlock()
+	return modelTimeout[name]
+}
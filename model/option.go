@@ -1,6 +1,7 @@
 package model
 
 import (
+	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/logger"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
@@ -66,12 +67,33 @@ func InitOptionMap() {
 	config.OptionMap["QuotaForInvitee"] = strconv.FormatInt(config.QuotaForInvitee, 10)
 	config.OptionMap["QuotaRemindThreshold"] = strconv.FormatInt(config.QuotaRemindThreshold, 10)
 	config.OptionMap["PreConsumedQuota"] = strconv.FormatInt(config.PreConsumedQuota, 10)
+	config.OptionMap["MaxRequestQuota"] = strconv.FormatInt(config.MaxRequestQuota, 10)
+	config.OptionMap["GroupMaxRequestQuota"] = billingratio.GroupMaxRequestQuota2JSONString()
 	config.OptionMap["ModelRatio"] = billingratio.ModelRatio2JSONString()
 	config.OptionMap["GroupRatio"] = billingratio.GroupRatio2JSONString()
 	config.OptionMap["CompletionRatio"] = billingratio.CompletionRatio2JSONString()
+	config.OptionMap["ModelPrice"] = billingratio.ModelPrice2JSONString()
+	config.OptionMap["ModelFreeAllowance"] = billingratio.ModelFreeAllowance2JSONString()
+	config.OptionMap["ServiceTierRatio"] = billingratio.ServiceTierRatio2JSONString()
+	config.OptionMap["GroupAllowedModels"] = GroupAllowedModels2JSONString()
+	config.OptionMap["GroupRateLimitKeySource"] = GroupRateLimitKeySource2JSONString()
+	config.OptionMap["GroupStickySession"] = GroupStickySession2JSONString()
+	config.OptionMap["GroupMaxStreams"] = GroupMaxStreams2JSONString()
+	config.OptionMap["GroupMaxHistoryTokens"] = GroupMaxHistoryTokens2JSONString()
+	config.OptionMap["GroupDeniedScripts"] = GroupDeniedScripts2JSONString()
+	config.OptionMap["GroupDedupWindow"] = GroupDedupWindow2JSONString()
+	config.OptionMap["GroupChannelStrategy"] = GroupChannelStrategy2JSONString()
+	config.OptionMap["ModelTimeout"] = ModelTimeout2JSONString()
+	config.OptionMap["ModelMaxInputTokens"] = ModelMaxInputTokens2JSONString()
+	config.OptionMap["ModelCapabilities"] = ModelCapabilities2JSONString()
+	config.OptionMap["ModelDowngradeQuotaThreshold"] = strconv.FormatInt(config.ModelDowngradeQuotaThreshold, 10)
+	config.OptionMap["GroupModelDowngrade"] = GroupModelDowngrade2JSONString()
+	config.OptionMap["GroupShadowChannel"] = GroupShadowChannel2JSONString()
 	config.OptionMap["TopUpLink"] = config.TopUpLink
 	config.OptionMap["ChatLink"] = config.ChatLink
 	config.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(config.QuotaPerUnit, 'f', -1, 64)
+	config.OptionMap["ImageTokenRatio"] = strconv.FormatFloat(config.ImageTokenRatio, 'f', -1, 64)
+	config.OptionMap["AudioTokenRatio"] = strconv.FormatFloat(config.AudioTokenRatio, 'f', -1, 64)
 	config.OptionMap["RetryTimes"] = strconv.Itoa(config.RetryTimes)
 	config.OptionMap["Theme"] = config.Theme
 	config.OptionMapRWMutex.Unlock()
@@ -99,6 +121,42 @@ func SyncOptions(frequency int) {
 	}
 }
 
+var ratioOptionKeys = []string{"ModelRatio", "GroupRatio", "CompletionRatio", "ModelPrice"}
+
+// ReloadRatios reloads the ratio options from the database,
+// the ratio maps are swapped atomically so it is safe to call while serving requests
+func ReloadRatios() error {
+	keyCol := "`key`"
+	if common.UsingPostgreSQL {
+		keyCol = `"key"`
+	}
+	var options []*Option
+	err := DB.Where(keyCol+" IN ?", ratioOptionKeys).Find(&options).Error
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if option.Key == "ModelRatio" {
+			option.Value = billingratio.AddNewMissingRatio(option.Value)
+		}
+		err = updateOptionMap(option.Key, option.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func SyncRatios(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		err := ReloadRatios()
+		if err != nil {
+			logger.SysError("failed to reload ratios: " + err.Error())
+		}
+	}
+}
+
 func UpdateOption(key string, value string) error {
 	// Save to database first
 	option := Option{
@@ -206,6 +264,10 @@ func updateOptionMap(key string, value string) (err error) {
 		config.QuotaRemindThreshold, _ = strconv.ParseInt(value, 10, 64)
 	case "PreConsumedQuota":
 		config.PreConsumedQuota, _ = strconv.ParseInt(value, 10, 64)
+	case "MaxRequestQuota":
+		config.MaxRequestQuota, _ = strconv.ParseInt(value, 10, 64)
+	case "GroupMaxRequestQuota":
+		err = billingratio.UpdateGroupMaxRequestQuotaByJSONString(value)
 	case "RetryTimes":
 		config.RetryTimes, _ = strconv.Atoi(value)
 	case "ModelRatio":
@@ -214,6 +276,40 @@ func updateOptionMap(key string, value string) (err error) {
 		err = billingratio.UpdateGroupRatioByJSONString(value)
 	case "CompletionRatio":
 		err = billingratio.UpdateCompletionRatioByJSONString(value)
+	case "ModelPrice":
+		err = billingratio.UpdateModelPriceByJSONString(value)
+	case "GroupAllowedModels":
+		err = UpdateGroupAllowedModelsByJSONString(value)
+	case "GroupRateLimitKeySource":
+		err = UpdateGroupRateLimitKeySourceByJSONString(value)
+	case "GroupStickySession":
+		err = UpdateGroupStickySessionByJSONString(value)
+	case "GroupMaxStreams":
+		err = UpdateGroupMaxStreamsByJSONString(value)
+	case "GroupMaxHistoryTokens":
+		err = UpdateGroupMaxHistoryTokensByJSONString(value)
+	case "GroupDeniedScripts":
+		err = UpdateGroupDeniedScriptsByJSONString(value)
+	case "GroupDedupWindow":
+		err = UpdateGroupDedupWindowByJSONString(value)
+	case "GroupChannelStrategy":
+		err = UpdateGroupChannelStrategyByJSONString(value)
+	case "ModelTimeout":
+		err = UpdateModelTimeoutByJSONString(value)
+	case "ModelMaxInputTokens":
+		err = UpdateModelMaxInputTokensByJSONString(value)
+	case "ModelCapabilities":
+		err = UpdateModelCapabilitiesByJSONString(value)
+	case "ModelDowngradeQuotaThreshold":
+		config.ModelDowngradeQuotaThreshold, _ = strconv.ParseInt(value, 10, 64)
+	case "GroupModelDowngrade":
+		err = UpdateGroupModelDowngradeByJSONString(value)
+	case "GroupShadowChannel":
+		err = UpdateGroupShadowChannelByJSONString(value)
+	case "ModelFreeAllowance":
+		err = billingratio.UpdateModelFreeAllowanceByJSONString(value)
+	case "ServiceTierRatio":
+		err = billingratio.UpdateServiceTierRatioByJSONString(value)
 	case "TopUpLink":
 		config.TopUpLink = value
 	case "ChatLink":
@@ -222,6 +318,10 @@ func updateOptionMap(key string, value string) (err error) {
 		config.ChannelDisableThreshold, _ = strconv.ParseFloat(value, 64)
 	case "QuotaPerUnit":
 		config.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
+	case "ImageTokenRatio":
+		config.ImageTokenRatio, _ = strconv.ParseFloat(value, 64)
+	case "AudioTokenRatio":
+		config.AudioTokenRatio, _ = strconv.ParseFloat(value, 64)
 	case "Theme":
 		config.Theme = value
 	}
@@ -0,0 +1,129 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"gorm.io/gorm"
+)
+
+// QuotaPool is a quota shared by its member tokens, e.g. the budget of a team, the requests of the tokens
+// are billed to the pool in addition to the tokens and their users, and all of them are rejected once it is exhausted
+type QuotaPool struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"index"`
+	RemainQuota int64  `json:"remain_quota" gorm:"bigint;default:0"`
+	UsedQuota   int64  `json:"used_quota" gorm:"bigint;default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+var ErrQuotaPoolExhausted = errors.New("额度池额度不足")
+
+func GetAllQuotaPools(startIdx int, num int) ([]*QuotaPool, error) {
+	var quotaPools []*QuotaPool
+	err := DB.Order("id desc").Limit(num).Offset(startIdx).Find(&quotaPools).Error
+	return quotaPools, err
+}
+
+func GetQuotaPoolById(id int) (*QuotaPool, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	quotaPool := QuotaPool{Id: id}
+	err := DB.First(&quotaPool, "id = ?", id).Error
+	return &quotaPool, err
+}
+
+func (quotaPool *QuotaPool) Insert() error {
+	quotaPool.CreatedTime = helper.GetTimestamp()
+	return DB.Create(quotaPool).Error
+}
+
+// Update Make sure your quota pool's fields is completed, the used quota is only changed by the relay
+func (quotaPool *QuotaPool) Update() error {
+	err := DB.Model(quotaPool).Select("name", "remain_quota").Updates(quotaPool).Error
+	if err == nil && common.RedisEnabled {
+		// a pool which is topped up is usable at once
+		_ = common.RedisDel(fmt.Sprintf("quota_pool_exhausted:%d", quotaPool.Id))
+	}
+	return err
+}
+
+// DeleteQuotaPoolById deletes the pool and detaches its member tokens, which are no longer limited by it
+func DeleteQuotaPoolById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&Token{}).Where("quota_pool_id = ?", id).Update("quota_pool_id", 0).Error
+		if err != nil {
+			return err
+		}
+		return tx.Delete(&QuotaPool{Id: id}).Error
+	})
+}
+
+// SetTokenQuotaPool adds the token to the pool, or removes it from its pool if quotaPoolId is 0
+func SetTokenQuotaPool(tokenId int, quotaPoolId int) error {
+	if quotaPoolId != 0 {
+		if _, err := GetQuotaPoolById(quotaPoolId); err != nil {
+			return err
+		}
+	}
+	result := DB.Model(&Token{}).Where("id = ?", tokenId).Update("quota_pool_id", quotaPoolId)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("令牌不存在")
+	}
+	return nil
+}
+
+// IsQuotaPoolExhausted reports whether the pool has no quota left, a pool which can't be read is treated as exhausted
+func IsQuotaPoolExhausted(id int) bool {
+	var remainQuota int64
+	err := DB.Model(&QuotaPool{}).Where("id = ?", id).Select("remain_quota").Scan(&remainQuota).Error
+	return err != nil || remainQuota <= 0
+}
+
+// preConsumeQuotaPool takes the quota from the pool only if it has enough left, the check and the update
+// are a single statement, so that concurrent requests of the member tokens can't overdraw the pool
+func preConsumeQuotaPool(id int, quota int64) error {
+	result := DB.Model(&QuotaPool{}).Where("id = ? and remain_quota >= ?", id, quota).Updates(
+		map[string]interface{}{
+			"remain_quota": gorm.Expr("remain_quota - ?", quota),
+			"used_quota":   gorm.Expr("used_quota + ?", quota),
+		},
+	)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrQuotaPoolExhausted
+	}
+	return nil
+}
+
+// refundQuotaPool gives the quota taken by preConsumeQuotaPool back, when the rest of the pre-consumption fails
+func refundQuotaPool(id int, quota int64) {
+	if id == 0 {
+		return
+	}
+	if err := adjustQuotaPool(id, -quota); err != nil {
+		logger.SysError(fmt.Sprintf("failed to refund quota pool #%d: %s", id, err.Error()))
+	}
+}
+
+// adjustQuotaPool bills the pool for the quota consumed beyond the pre-consumed one, or refunds it if quota is negative,
+// the pool may go below zero here, as the request is already done
+func adjustQuotaPool(id int, quota int64) error {
+	return DB.Model(&QuotaPool{}).Where("id = ?", id).Updates(
+		map[string]interface{}{
+			"remain_quota": gorm.Expr("remain_quota - ?", quota),
+			"used_quota":   gorm.Expr("used_quota + ?", quota),
+		},
+	).Error
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPreConsumeQuotaPoolConcurrently(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:?cache=shared"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&QuotaPool{}))
+	originalDB := DB
+	DB = db
+	defer func() { DB = originalDB }()
+
+	quotaPool := &QuotaPool{Name: "team", RemainQuota: 1000}
+	assert.NoError(t, quotaPool.Insert())
+
+	// 20 requests of 100 race for a pool of 1000, exactly 10 of them must succeed
+	var wg sync.WaitGroup
+	var lock sync.Mutex
+	succeeded := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if preConsumeQuotaPool(quotaPool.Id, 100) == nil {
+				lock.Lock()
+				succeeded++
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 10, succeeded)
+	assert.True(t, IsQuotaPoolExhausted(quotaPool.Id))
+
+	// a refund makes the pool usable again
+	assert.NoError(t, adjustQuotaPool(quotaPool.Id, -100))
+	assert.False(t, IsQuotaPoolExhausted(quotaPool.Id))
+	quotaPool, err = GetQuotaPoolById(quotaPool.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), quotaPool.RemainQuota)
+	assert.Equal(t, int64(900), quotaPool.UsedQuota)
+}
+
+func TestPreConsumeTokenQuotaRefundsPool(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&QuotaPool{}, &Token{}, &User{}))
+	originalDB := DB
+	DB = db
+	defer func() { DB = originalDB }()
+	// no quota reminder is sent in the background, as it would outlive the database of the test
+	quotaRemindThreshold := config.QuotaRemindThreshold
+	config.QuotaRemindThreshold = 0
+	defer func() { config.QuotaRemindThreshold = quotaRemindThreshold }()
+
+	quotaPool := &QuotaPool{Name: "team", RemainQuota: 1000}
+	assert.NoError(t, quotaPool.Insert())
+	assert.NoError(t, db.Create(&User{Id: 1, Username: "user", Quota: 1000, AccessToken: "access"}).Error)
+	assert.NoError(t, db.Create(&Token{Id: 1, UserId: 1, Key: "key", RemainQuota: 1000, QuotaPoolId: quotaPool.Id}).Error)
+
+	// the user quota fails to be decreased after the pool and the token are taken
+	assert.NoError(t, db.Callback().Update().Before("gorm:update").Register("fail_user_update", func(tx *gorm.DB) {
+		if tx.Statement.Table == "users" {
+			_ = tx.AddError(errors.New("user update failed"))
+		}
+	}))
+	assert.Error(t, PreConsumeTokenQuota(1, 100))
+
+	quotaPool, err = GetQuotaPoolById(quotaPool.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), quotaPool.RemainQuota)
+	assert.Equal(t, int64(0), quotaPool.UsedQuota)
+	token, err := GetTokenById(1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1000), token.RemainQuota)
+}
@@ -32,6 +32,8 @@ type Token struct {
 	UsedQuota      int64   `json:"used_quota" gorm:"bigint;default:0"` // used quota
 	Models         *string `json:"models" gorm:"default:''"`           // allowed models
 	Subnet         *string `json:"subnet" gorm:"default:''"`           // allowed subnet
+	SigningSecret  *string `json:"signing_secret" gorm:"default:''"`   // requests must be signed with it if set
+	QuotaPoolId    int     `json:"quota_pool_id" gorm:"default:0"`     // the shared quota pool, 0 means none
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int, order string) ([]*Token, error) {
@@ -77,7 +79,7 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	if token.Status != TokenStatusEnabled {
 		return nil, errors.New("该令牌状态不可用")
 	}
-	if token.ExpiredTime != -1 && token.ExpiredTime < helper.GetTimestamp() {
+	if IsTokenExpired(token.ExpiredTime) {
 		if !common.RedisEnabled {
 			token.Status = TokenStatusExpired
 			err := token.SelectUpdate()
@@ -98,6 +100,9 @@ func ValidateUserToken(key string) (token *Token, err error) {
 		}
 		return nil, errors.New("该令牌额度已用尽")
 	}
+	if token.QuotaPoolId != 0 && CacheIsQuotaPoolExhausted(token.QuotaPoolId) {
+		return nil, errors.New("该令牌所属的额度池已用尽")
+	}
 	return token, nil
 }
 
@@ -130,7 +135,7 @@ func (token *Token) Insert() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() error {
 	var err error
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet").Updates(token).Error
+	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "models", "subnet", "signing_secret").Updates(token).Error
 	return err
 }
 
@@ -220,6 +225,13 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if userQuota < quota {
 		return errors.New("用户额度不足")
 	}
+	if token.QuotaPoolId != 0 {
+		// taken first, as it is the only one checked and updated at once
+		err = preConsumeQuotaPool(token.QuotaPoolId, quota)
+		if err != nil {
+			return err
+		}
+	}
 	quotaTooLow := userQuota >= config.QuotaRemindThreshold && userQuota-quota < config.QuotaRemindThreshold
 	noMoreQuota := userQuota-quota <= 0
 	if quotaTooLow || noMoreQuota {
@@ -245,15 +257,34 @@ func PreConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	if !token.UnlimitedQuota {
 		err = DecreaseTokenQuota(tokenId, quota)
 		if err != nil {
+			refundQuotaPool(token.QuotaPoolId, quota)
 			return err
 		}
 	}
 	err = DecreaseUserQuota(token.UserId, quota)
-	return err
+	if err != nil {
+		refundQuotaPool(token.QuotaPoolId, quota)
+		if !token.UnlimitedQuota {
+			if refundErr := IncreaseTokenQuota(tokenId, quota); refundErr != nil {
+				logger.SysError("failed to refund the token quota: " + refundErr.Error())
+			}
+		}
+		return err
+	}
+	return nil
 }
 
 func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return err
+	}
+	if token.QuotaPoolId != 0 && quota != 0 {
+		err = adjustQuotaPool(token.QuotaPoolId, quota)
+		if err != nil {
+			return err
+		}
+	}
 	if quota > 0 {
 		err = DecreaseUserQuota(token.UserId, quota)
 	} else {
@@ -274,3 +305,12 @@ func PostConsumeTokenQuota(tokenId int, quota int64) (err error) {
 	}
 	return nil
 }
+
+// IsTokenExpired reports whether a token with the given expiry time has expired,
+// the clock skew tolerance is granted so that instances with slightly different clocks agree
+func IsTokenExpired(expiredTime int64) bool {
+	if expiredTime == -1 {
+		return false
+	}
+	return expiredTime+int64(config.ClockSkewTolerance) < helper.GetTimestamp()
+}
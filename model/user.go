@@ -46,6 +46,9 @@ type User struct {
 	Group            string `json:"group" gorm:"type:varchar(32);default:'default'"`
 	AffCode          string `json:"aff_code" gorm:"type:varchar(32);column:aff_code;uniqueIndex"`
 	InviterId        int    `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	// the group the requests of the user are relayed in instead of Group, e.g. for a promo or an incident
+	GroupOverride          string `json:"group_override" gorm:"type:varchar(32);default:''"`
+	GroupOverrideExpiresAt int64  `json:"group_override_expires_at" gorm:"bigint;default:0"` // 0 means it never expires
 }
 
 func GetMaxUserId() int {
@@ -338,6 +341,35 @@ func GetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+// isGroupOverrideInEffect reports whether the group override has been set and hasn't expired at now
+func isGroupOverrideInEffect(groupOverride string, expiresAt int64, now int64) bool {
+	return groupOverride != "" && (expiresAt == 0 || now < expiresAt)
+}
+
+func GetUserGroupOverride(id int) (groupOverride string, expiresAt int64, err error) {
+	user := User{}
+	err = DB.Model(&User{}).Where("id = ?", id).Select("group_override", "group_override_expires_at").Find(&user).Error
+	return user.GroupOverride, user.GroupOverrideExpiresAt, err
+}
+
+// UpdateUserGroupOverride sets the group override of the user, an empty group removes it
+func UpdateUserGroupOverride(id int, groupOverride string, expiresAt int64) error {
+	err := DB.Model(&User{}).Where("id = ?", id).Updates(map[string]any{
+		"group_override":            groupOverride,
+		"group_override_expires_at": expiresAt,
+	}).Error
+	if err != nil {
+		return err
+	}
+	if common.RedisEnabled {
+		// the override takes effect at once, instead of after the cache expires
+		if err = common.RedisDel(fmt.Sprintf("user_group_override:%d", id)); err != nil {
+			logger.SysError("Redis delete user group override error: " + err.Error())
+		}
+	}
+	return nil
+}
+
 func IncreaseUserQuota(id int, quota int64) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
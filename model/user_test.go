@@ -0,0 +1,45 @@
+package model
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestUserGroupOverride(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&User{}))
+	originalDB, originalRedisEnabled := DB, common.RedisEnabled
+	DB, common.RedisEnabled = db, false
+	defer func() { DB, common.RedisEnabled = originalDB, originalRedisEnabled }()
+
+	user := &User{Username: "user", Password: "12345678", Group: "default", AccessToken: "token", AffCode: "code"}
+	assert.NoError(t, db.Create(user).Error)
+	group, err := CacheGetUserEffectiveGroup(user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "default", group)
+
+	assert.NoError(t, UpdateUserGroupOverride(user.Id, "vip", 0))
+	group, err = CacheGetUserEffectiveGroup(user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "vip", group)
+
+	// an expired override is ignored
+	assert.NoError(t, UpdateUserGroupOverride(user.Id, "vip", 1))
+	assert.Equal(t, "", CacheGetUserGroupOverride(user.Id))
+
+	assert.NoError(t, UpdateUserGroupOverride(user.Id, "", 0))
+	groupOverride, expiresAt, err := GetUserGroupOverride(user.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "", groupOverride)
+	assert.Equal(t, int64(0), expiresAt)
+
+	assert.True(t, isGroupOverrideInEffect("vip", 0, 100))
+	assert.True(t, isGroupOverrideInEffect("vip", 101, 100))
+	assert.False(t, isGroupOverrideInEffect("vip", 100, 100))
+	assert.False(t, isGroupOverrideInEffect("", 0, 100))
+}
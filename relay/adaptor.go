@@ -14,6 +14,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/adaptor/palm"
 	"github.com/songquanpeng/one-api/relay/adaptor/tencent"
+	"github.com/songquanpeng/one-api/relay/adaptor/vertexai"
 	"github.com/songquanpeng/one-api/relay/adaptor/xunfei"
 	"github.com/songquanpeng/one-api/relay/adaptor/zhipu"
 	"github.com/songquanpeng/one-api/relay/apitype"
@@ -49,6 +50,8 @@ func GetAdaptor(apiType int) adaptor.Adaptor {
 		return &coze.Adaptor{}
 	case apitype.Cohere:
 		return &cohere.Adaptor{}
+	case apitype.VertexAI:
+		return &vertexai.Adaptor{}
 	}
 	return nil
 }
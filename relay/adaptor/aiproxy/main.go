@@ -1,14 +1,13 @@
 package aiproxy
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/constant"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -54,8 +53,8 @@ func responseAIProxyLibrary2OpenAI(response *LibraryResponse) *openai.TextRespon
 		FinishReason: "stop",
 	}
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 	}
@@ -67,8 +66,8 @@ func documentsAIProxyLibrary(documents []LibraryDocument) *openai.ChatCompletion
 	choice.Delta.Content = aiProxyDocuments2Markdown(documents)
 	choice.FinishReason = &constant.StopFinishReason
 	return &openai.ChatCompletionsStreamResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion.chunk",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   "",
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -79,8 +78,8 @@ func streamResponseAIProxyLibrary2OpenAI(response *LibraryStreamResponse) *opena
 	var choice openai.ChatCompletionsStreamResponseChoice
 	choice.Delta.Content = response.Content
 	return &openai.ChatCompletionsStreamResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion.chunk",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   response.Model,
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -89,7 +88,7 @@ func streamResponseAIProxyLibrary2OpenAI(response *LibraryStreamResponse) *opena
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	var usage model.Usage
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -118,8 +117,12 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 		stopChan <- true
 	}()
+	// all the chunks of a stream share the same id
+	responseId := openai.GenerateResponseId()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
 	var documents []LibraryDocument
+	isFirstChunk := true
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -133,6 +136,12 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				documents = AIProxyLibraryResponse.Documents
 			}
 			response := streamResponseAIProxyLibrary2OpenAI(&AIProxyLibraryResponse)
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -142,6 +151,11 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			return true
 		case <-stopChan:
 			response := documentsAIProxyLibrary(documents)
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -86,7 +86,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 	} else {
 		switch meta.Mode {
 		case relaymode.Embeddings:
-			err, usage = EmbeddingHandler(c, resp)
+			err, usage = EmbeddingHandler(c, resp, meta.PromptTokens)
 		case relaymode.ImagesGenerations:
 			err, usage = ImageHandler(c, resp)
 		default:
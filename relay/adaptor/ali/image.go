@@ -165,7 +165,7 @@ func responseAli2OpenAIImage(response *TaskResponse, responseFormat string) *ope
 		imageResponse.Data = append(imageResponse.Data, openai.ImageData{
 			Url:           data.Url,
 			B64Json:       b64Json,
-			RevisedPrompt: "",
+			RevisedPrompt: data.ActualPrompt,
 		})
 	}
 	return &imageResponse
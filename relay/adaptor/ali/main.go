@@ -1,12 +1,12 @@
 package ali
 
 import (
-	"bufio"
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/model"
 	"io"
@@ -77,7 +77,7 @@ func ConvertImageRequest(request model.ImageRequest) *ImageRequest {
 	return &imageRequest
 }
 
-func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+func EmbeddingHandler(c *gin.Context, resp *http.Response, promptTokens int) (*model.ErrorWithStatusCode, *model.Usage) {
 	var aliResponse EmbeddingResponse
 	err := json.NewDecoder(resp.Body).Decode(&aliResponse)
 	if err != nil {
@@ -102,6 +102,7 @@ func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStat
 	}
 
 	fullTextResponse := embeddingResponseAli2OpenAI(&aliResponse)
+	openai.FillEmbeddingUsage(&fullTextResponse.Usage, promptTokens)
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -114,7 +115,7 @@ func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStat
 
 func embeddingResponseAli2OpenAI(response *EmbeddingResponse) *openai.EmbeddingResponse {
 	openAIEmbeddingResponse := openai.EmbeddingResponse{
-		Object: "list",
+		Object: openai.ObjectList,
 		Data:   make([]openai.EmbeddingResponseItem, 0, len(response.Output.Embeddings)),
 		Model:  "text-embedding-v1",
 		Usage:  model.Usage{TotalTokens: response.Usage.TotalTokens},
@@ -122,7 +123,7 @@ func embeddingResponseAli2OpenAI(response *EmbeddingResponse) *openai.EmbeddingR
 
 	for _, item := range response.Output.Embeddings {
 		openAIEmbeddingResponse.Data = append(openAIEmbeddingResponse.Data, openai.EmbeddingResponseItem{
-			Object:    `embedding`,
+			Object:    openai.ObjectEmbedding,
 			Index:     item.TextIndex,
 			Embedding: item.Embedding,
 		})
@@ -132,8 +133,8 @@ func embeddingResponseAli2OpenAI(response *EmbeddingResponse) *openai.EmbeddingR
 
 func responseAli2OpenAI(response *ChatResponse) *openai.TextResponse {
 	fullTextResponse := openai.TextResponse{
-		Id:      response.RequestId,
-		Object:  "chat.completion",
+		Id:      openai.NormalizeResponseId(response.RequestId),
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: response.Output.Choices,
 		Usage: model.Usage{
@@ -157,8 +158,8 @@ func streamResponseAli2OpenAI(aliResponse *ChatResponse) *openai.ChatCompletions
 		choice.FinishReason = &finishReason
 	}
 	response := openai.ChatCompletionsStreamResponse{
-		Id:      aliResponse.RequestId,
-		Object:  "chat.completion.chunk",
+		Id:      openai.NormalizeResponseId(aliResponse.RequestId),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   "qwen",
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -168,7 +169,7 @@ func streamResponseAli2OpenAI(aliResponse *ChatResponse) *openai.ChatCompletions
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	var usage model.Usage
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -197,8 +198,11 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 		stopChan <- true
 	}()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
 	//lastResponseText := ""
+	isFirstChunk := true
+	var responseId string
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -217,6 +221,16 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			if response == nil {
 				return true
 			}
+			// a generated id must be the same for all the chunks of the stream
+			if responseId == "" {
+				responseId = response.Id
+			}
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			//response.Choices[0].Delta.Content = strings.TrimPrefix(response.Choices[0].Delta.Content, lastResponseText)
 			//lastResponseText = aliResponse.Output.Text
 			jsonResponse, err := json.Marshal(response)
@@ -63,6 +63,8 @@ type TaskResponse struct {
 			Url      string `json:"url,omitempty"`
 			Code     string `json:"code,omitempty"`
 			Message  string `json:"message,omitempty"`
+			// the prompt rewritten by the model when prompt extension is on
+			ActualPrompt string `json:"actual_prompt,omitempty"`
 		} `json:"results,omitempty"`
 		TaskMetrics struct {
 			Total     int `json:"TOTAL,omitempty"`
@@ -1,14 +1,13 @@
 package anthropic
 
 import (
-	"bufio"
 	"encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/model"
 	"io"
@@ -124,7 +123,7 @@ func StreamResponseClaude2OpenAI(claudeResponse *StreamResponse) (*openai.ChatCo
 		choice.FinishReason = &finishReason
 	}
 	var openaiResponse openai.ChatCompletionsStreamResponse
-	openaiResponse.Object = "chat.completion.chunk"
+	openaiResponse.Object = openai.ObjectChatCompletionChunk
 	openaiResponse.Choices = []openai.ChatCompletionsStreamResponseChoice{choice}
 	return &openaiResponse, response
 }
@@ -144,9 +143,9 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
 	}
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", claudeResponse.Id),
+		Id:      openai.NormalizeResponseId(claudeResponse.Id),
 		Model:   claudeResponse.Model,
-		Object:  "chat.completion",
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 	}
@@ -155,7 +154,7 @@ func ResponseClaude2OpenAI(claudeResponse *Response) *openai.TextResponse {
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	createdTime := helper.GetTimestamp()
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -204,14 +203,14 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				usage.PromptTokens += meta.Usage.InputTokens
 				usage.CompletionTokens += meta.Usage.OutputTokens
 				modelName = meta.Model
-				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
+				id = openai.NormalizeResponseId(meta.Id)
 				return true
 			}
 			if response == nil {
 				return true
 			}
 			response.Id = id
-			response.Model = modelName
+			response.Model = openai.ResponseModelName(c, modelName)
 			response.Created = createdTime
 			jsonStr, err := json.Marshal(response)
 			if err != nil {
@@ -255,7 +254,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 		}, nil
 	}
 	fullTextResponse := ResponseClaude2OpenAI(&claudeResponse)
-	fullTextResponse.Model = modelName
+	fullTextResponse.Model = openai.ResponseModelName(c, modelName)
 	usage := model.Usage{
 		PromptTokens:     claudeResponse.Usage.InputTokens,
 		CompletionTokens: claudeResponse.Usage.OutputTokens,
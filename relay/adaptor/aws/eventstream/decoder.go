@@ -0,0 +1,193 @@
+// Package eventstream decodes the binary framing of the aws event stream protocol, which is used by the
+// streaming apis of aws such as bedrock instead of SSE
+//
+// https://docs.aws.amazon.com/transcribe/latest/dg/streaming-setting-up.html#streaming-event-stream
+package eventstream
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+const (
+	// the total length, the headers length and the crc of the prelude
+	preludeLength = 12
+	crcLength     = 4
+	minLength     = preludeLength + crcLength
+	// the limit of a message of aws, a larger length means the stream is corrupted
+	maxLength = 16 << 20
+)
+
+// the types of the header values
+const (
+	headerTrue = iota
+	headerFalse
+	headerByte
+	headerShort
+	headerInt
+	headerLong
+	headerBytes
+	headerString
+	headerTimestamp
+	headerUUID
+)
+
+// the headers of the messages
+const (
+	HeaderMessageType   = ":message-type"
+	HeaderEventType     = ":event-type"
+	HeaderExceptionType = ":exception-type"
+	HeaderErrorCode     = ":error-code"
+	HeaderErrorMessage  = ":error-message"
+)
+
+// the message types
+const (
+	MessageTypeEvent     = "event"
+	MessageTypeException = "exception"
+	MessageTypeError     = "error"
+)
+
+type Message struct {
+	// the values are bool, int8, int16, int32, int64, []byte, string, the unix milliseconds of a timestamp
+	// as int64, or the 16 bytes of an uuid
+	Headers map[string]any
+	Payload []byte
+}
+
+// StringHeader returns the header if it is a string, "" otherwise
+func (m *Message) StringHeader(name string) string {
+	value, _ := m.Headers[name].(string)
+	return value
+}
+
+// Err returns the error an exception or error message carries, nil for the events
+func (m *Message) Err() error {
+	switch m.StringHeader(HeaderMessageType) {
+	case MessageTypeException:
+		return fmt.Errorf("%s: %s", m.StringHeader(HeaderExceptionType), m.Payload)
+	case MessageTypeError:
+		return fmt.Errorf("%s: %s", m.StringHeader(HeaderErrorCode), m.StringHeader(HeaderErrorMessage))
+	}
+	return nil
+}
+
+// Decoder reads the messages one after another, a message split across several reads of the
+// underlying reader is reassembled
+type Decoder struct {
+	reader io.Reader
+	buffer []byte
+}
+
+func NewDecoder(reader io.Reader) *Decoder {
+	return &Decoder{reader: reader}
+}
+
+// Decode returns the next message, io.EOF if the stream ends between two messages,
+// and io.ErrUnexpectedEOF if it ends in the middle of one
+func (d *Decoder) Decode() (*Message, error) {
+	var prelude [preludeLength]byte
+	if _, err := io.ReadFull(d.reader, prelude[:]); err != nil {
+		return nil, err
+	}
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc := crc32.ChecksumIEEE(prelude[:8]); crc != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, fmt.Errorf("prelude crc mismatch: %08x", crc)
+	}
+	if totalLength < minLength || totalLength > maxLength {
+		return nil, fmt.Errorf("invalid message length %d", totalLength)
+	}
+	if headersLength > totalLength-minLength {
+		return nil, fmt.Errorf("invalid headers length %d", headersLength)
+	}
+
+	if cap(d.buffer) < int(totalLength) {
+		d.buffer = make([]byte, totalLength)
+	}
+	message := d.buffer[:totalLength]
+	copy(message, prelude[:])
+	if _, err := io.ReadFull(d.reader, message[preludeLength:]); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	crcOffset := totalLength - crcLength
+	if crc := crc32.ChecksumIEEE(message[:crcOffset]); crc != binary.BigEndian.Uint32(message[crcOffset:]) {
+		return nil, fmt.Errorf("message crc mismatch: %08x", crc)
+	}
+
+	headersEnd := preludeLength + headersLength
+	headers, err := decodeHeaders(message[preludeLength:headersEnd])
+	if err != nil {
+		return nil, err
+	}
+	// the buffer is reused by the next message
+	payload := make([]byte, crcOffset-headersEnd)
+	copy(payload, message[headersEnd:crcOffset])
+	return &Message{Headers: headers, Payload: payload}, nil
+}
+
+func decodeHeaders(data []byte) (map[string]any, error) {
+	headers := make(map[string]any)
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+1 {
+			return nil, fmt.Errorf("truncated header")
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		var value any
+		length := 0
+		switch valueType {
+		case headerTrue:
+			value = true
+		case headerFalse:
+			value = false
+		case headerByte:
+			length = 1
+		case headerShort:
+			length = 2
+		case headerInt:
+			length = 4
+		case headerLong, headerTimestamp:
+			length = 8
+		case headerUUID:
+			length = 16
+		case headerBytes, headerString:
+			if len(data) < 2 {
+				return nil, fmt.Errorf("truncated header %s", name)
+			}
+			length = int(binary.BigEndian.Uint16(data))
+			data = data[2:]
+		default:
+			return nil, fmt.Errorf("unknown type %d of header %s", valueType, name)
+		}
+		if len(data) < length {
+			return nil, fmt.Errorf("truncated header %s", name)
+		}
+		raw := data[:length]
+		switch valueType {
+		case headerByte:
+			value = int8(raw[0])
+		case headerShort:
+			value = int16(binary.BigEndian.Uint16(raw))
+		case headerInt:
+			value = int32(binary.BigEndian.Uint32(raw))
+		case headerLong, headerTimestamp:
+			value = int64(binary.BigEndian.Uint64(raw))
+		case headerBytes, headerUUID:
+			value = append([]byte(nil), raw...)
+		case headerString:
+			value = string(raw)
+		}
+		headers[name] = value
+		data = data[length:]
+	}
+	return headers, nil
+}
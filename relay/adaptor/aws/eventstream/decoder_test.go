@@ -0,0 +1,79 @@
+package eventstream
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeStringHeader(name, value string) []byte {
+	header := []byte{byte(len(name))}
+	header = append(header, name...)
+	header = append(header, headerString)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(value)))
+	return append(header, value...)
+}
+
+// encodeMessage crafts a message with string headers
+func encodeMessage(headers [][2]string, payload []byte) []byte {
+	var headersData []byte
+	for _, header := range headers {
+		headersData = append(headersData, encodeStringHeader(header[0], header[1])...)
+	}
+	totalLength := preludeLength + len(headersData) + len(payload) + crcLength
+	message := binary.BigEndian.AppendUint32(nil, uint32(totalLength))
+	message = binary.BigEndian.AppendUint32(message, uint32(len(headersData)))
+	message = binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+	message = append(message, headersData...)
+	message = append(message, payload...)
+	return binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+}
+
+func TestDecoder(t *testing.T) {
+	var stream []byte
+	stream = append(stream, encodeMessage([][2]string{{HeaderMessageType, MessageTypeEvent}, {HeaderEventType, "chunk"}}, []byte(`{"a":1}`))...)
+	stream = append(stream, encodeMessage(nil, nil)...)
+	stream = append(stream, encodeMessage([][2]string{{HeaderMessageType, MessageTypeException}, {HeaderExceptionType, "throttlingException"}}, []byte(`{"message":"slow down"}`))...)
+
+	// a byte per read, so that every message is reassembled from partial reads
+	decoder := NewDecoder(iotest.OneByteReader(bytes.NewReader(stream)))
+	message, err := decoder.Decode()
+	assert.NoError(t, err)
+	assert.Equal(t, "chunk", message.StringHeader(HeaderEventType))
+	assert.Equal(t, `{"a":1}`, string(message.Payload))
+	assert.NoError(t, message.Err())
+
+	message, err = decoder.Decode()
+	assert.NoError(t, err)
+	assert.Empty(t, message.Headers)
+	assert.Empty(t, message.Payload)
+
+	message, err = decoder.Decode()
+	assert.NoError(t, err)
+	assert.EqualError(t, message.Err(), `throttlingException: {"message":"slow down"}`)
+
+	_, err = decoder.Decode()
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestDecoderInvalidMessages(t *testing.T) {
+	message := encodeMessage([][2]string{{HeaderEventType, "chunk"}}, []byte("payload"))
+
+	_, err := NewDecoder(bytes.NewReader(message[:len(message)-3])).Decode()
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+
+	corrupted := append([]byte(nil), message...)
+	corrupted[len(corrupted)-6] ^= 0xff
+	_, err = NewDecoder(bytes.NewReader(corrupted)).Decode()
+	assert.ErrorContains(t, err, "message crc mismatch")
+
+	corrupted = append([]byte(nil), message...)
+	corrupted[2] ^= 0xff
+	_, err = NewDecoder(bytes.NewReader(corrupted)).Decode()
+	assert.ErrorContains(t, err, "prelude crc mismatch")
+}
@@ -0,0 +1,92 @@
+package aws
+
+import (
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// renderLlamaPrompt renders the messages with the chat template of llama 3
+//
+// https://www.llama.com/docs/model-cards-and-prompt-formats/meta-llama-3/
+func renderLlamaPrompt(messages []relaymodel.Message) string {
+	var builder strings.Builder
+	builder.WriteString("<|begin_of_text|>")
+	for _, message := range messages {
+		role := message.Role
+		if role == "developer" {
+			role = "system"
+		}
+		builder.WriteString("<|start_header_id|>" + role + "<|end_header_id|>\n\n")
+		builder.WriteString(message.StringContent())
+		builder.WriteString("<|eot_id|>")
+	}
+	builder.WriteString("<|start_header_id|>assistant<|end_header_id|>\n\n")
+	return builder.String()
+}
+
+func convertLlamaRequest(request relaymodel.GeneralOpenAIRequest) *LlamaRequest {
+	return &LlamaRequest{
+		Prompt:      renderLlamaPrompt(request.Messages),
+		MaxGenLen:   request.MaxTokens,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+	}
+}
+
+func stopReasonLlama2OpenAI(reason *string) string {
+	if reason == nil {
+		return ""
+	}
+	switch *reason {
+	case "stop":
+		return "stop"
+	case "length":
+		return "length"
+	}
+	return *reason
+}
+
+func responseLlama2OpenAI(response *LlamaResponse) (*openai.TextResponse, relaymodel.Usage) {
+	usage := relaymodel.Usage{
+		PromptTokens:     response.PromptTokenCount,
+		CompletionTokens: response.GenerationTokenCount,
+		TotalTokens:      response.PromptTokenCount + response.GenerationTokenCount,
+	}
+	return &openai.TextResponse{
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletion,
+		Created: helper.GetTimestamp(),
+		Choices: []openai.TextResponseChoice{{
+			Index:        0,
+			Message:      relaymodel.Message{Role: "assistant", Content: response.Generation},
+			FinishReason: stopReasonLlama2OpenAI(response.StopReason),
+		}},
+		Usage: usage,
+	}, usage
+}
+
+// streamResponseLlama2OpenAI converts a chunk of a llama stream, and updates the usage by the counts it carries
+func streamResponseLlama2OpenAI(response *LlamaResponse, usage *relaymodel.Usage) *openai.ChatCompletionsStreamResponse {
+	if response.PromptTokenCount > 0 {
+		usage.PromptTokens = response.PromptTokenCount
+	}
+	if response.GenerationTokenCount > 0 {
+		usage.CompletionTokens = response.GenerationTokenCount
+	}
+	if response.InvocationMetrics != nil {
+		usage.PromptTokens = response.InvocationMetrics.InputTokenCount
+		usage.CompletionTokens = response.InvocationMetrics.OutputTokenCount
+	}
+	var choice openai.ChatCompletionsStreamResponseChoice
+	choice.Delta.Content = response.Generation
+	if finishReason := stopReasonLlama2OpenAI(response.StopReason); finishReason != "" {
+		choice.FinishReason = &finishReason
+	}
+	return &openai.ChatCompletionsStreamResponse{
+		Object:  openai.ObjectChatCompletionChunk,
+		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
+	}
+}
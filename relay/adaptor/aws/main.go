@@ -3,23 +3,31 @@ package aws
 
 import (
 	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"io"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime"
-	"github.com/aws/aws-sdk-go-v2/service/bedrockruntime/types"
 	"github.com/gin-gonic/gin"
 	"github.com/jinzhu/copier"
 	"github.com/pkg/errors"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/aws/eventstream"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 )
 
@@ -46,22 +54,156 @@ func wrapErr(err error) *relaymodel.ErrorWithStatusCode {
 
 // https://docs.aws.amazon.com/bedrock/latest/userguide/model-ids.html
 var awsModelIDMap = map[string]string{
-	"claude-instant-1.2":       "anthropic.claude-instant-v1",
-	"claude-2.0":               "anthropic.claude-v2",
-	"claude-2.1":               "anthropic.claude-v2:1",
-	"claude-3-sonnet-20240229": "anthropic.claude-3-sonnet-20240229-v1:0",
-	"claude-3-opus-20240229":   "anthropic.claude-3-opus-20240229-v1:0",
-	"claude-3-haiku-20240307":  "anthropic.claude-3-haiku-20240307-v1:0",
+	"claude-instant-1.2":        "anthropic.claude-instant-v1",
+	"claude-2.0":                "anthropic.claude-v2",
+	"claude-2.1":                "anthropic.claude-v2:1",
+	"claude-3-sonnet-20240229":  "anthropic.claude-3-sonnet-20240229-v1:0",
+	"claude-3-opus-20240229":    "anthropic.claude-3-opus-20240229-v1:0",
+	"claude-3-haiku-20240307":   "anthropic.claude-3-haiku-20240307-v1:0",
+	"llama3-8b-8192":            "meta.llama3-8b-instruct-v1:0",
+	"llama3-70b-8192":           "meta.llama3-70b-instruct-v1:0",
+	"amazon-titan-text-lite":    "amazon.titan-text-lite-v1",
+	"amazon-titan-text-express": "amazon.titan-text-express-v1",
+	"amazon-titan-text-premier": "amazon.titan-text-premier-v1:0",
 }
 
+// the model families of bedrock, each of them has a request and response body of its own
+const (
+	modelFamilyClaude = iota
+	modelFamilyLlama
+	modelFamilyTitan
+)
+
+var modelFamilyPrefixes = map[string]int{
+	"anthropic.":    modelFamilyClaude,
+	"meta.llama3":   modelFamilyLlama,
+	"amazon.titan-": modelFamilyTitan,
+}
+
+func modelFamily(awsModelId string) (int, bool) {
+	for prefix, family := range modelFamilyPrefixes {
+		if strings.HasPrefix(awsModelId, prefix) {
+			return family, true
+		}
+	}
+	return 0, false
+}
+
+// awsModelID returns the bedrock id of the model, a bedrock id of a supported family, e.g. mapped to by the channel,
+// is used as is
 func awsModelID(requestModel string) (string, error) {
 	if awsModelID, ok := awsModelIDMap[requestModel]; ok {
 		return awsModelID, nil
 	}
+	if _, ok := modelFamily(requestModel); ok {
+		return requestModel, nil
+	}
 
 	return "", errors.Errorf("model %s not found", requestModel)
 }
 
+// convertRequest converts the request into the body of the model family
+func convertRequest(request relaymodel.GeneralOpenAIRequest) (any, error) {
+	awsModelId, err := awsModelID(request.Model)
+	if err != nil {
+		return nil, err
+	}
+	family, _ := modelFamily(awsModelId)
+	switch family {
+	case modelFamilyLlama:
+		return convertLlamaRequest(request), nil
+	case modelFamilyTitan:
+		return convertTitanRequest(request), nil
+	}
+	claudeReq := anthropic.ConvertRequest(request)
+	awsClaudeReq := &Request{
+		AnthropicVersion: "bedrock-2023-05-31",
+	}
+	if err = copier.Copy(awsClaudeReq, claudeReq); err != nil {
+		return nil, errors.Wrap(err, "copy request")
+	}
+	return awsClaudeReq, nil
+}
+
+// responseAWS2OpenAI converts the response body of the model family
+func responseAWS2OpenAI(family int, body []byte) (*openai.TextResponse, *relaymodel.Usage, error) {
+	switch family {
+	case modelFamilyLlama:
+		llamaResponse := new(LlamaResponse)
+		if err := json.Unmarshal(body, llamaResponse); err != nil {
+			return nil, nil, err
+		}
+		openaiResp, usage := responseLlama2OpenAI(llamaResponse)
+		return openaiResp, &usage, nil
+	case modelFamilyTitan:
+		titanResponse := new(TitanResponse)
+		if err := json.Unmarshal(body, titanResponse); err != nil {
+			return nil, nil, err
+		}
+		openaiResp, usage := responseTitan2OpenAI(titanResponse)
+		return openaiResp, &usage, nil
+	}
+	claudeResponse := new(anthropic.Response)
+	if err := json.Unmarshal(body, claudeResponse); err != nil {
+		return nil, nil, err
+	}
+	openaiResp := anthropic.ResponseClaude2OpenAI(claudeResponse)
+	usage := relaymodel.Usage{
+		PromptTokens:     claudeResponse.Usage.InputTokens,
+		CompletionTokens: claudeResponse.Usage.OutputTokens,
+		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
+	}
+	openaiResp.Usage = usage
+	return openaiResp, &usage, nil
+}
+
+// newStreamConverter returns the converter of the chunks of a stream of the model family, which updates the usage
+// by the counts the chunks carry, a nil response means the chunk has nothing for the client
+func newStreamConverter(family int, usage *relaymodel.Usage) func(chunk []byte) (*openai.ChatCompletionsStreamResponse, error) {
+	switch family {
+	case modelFamilyLlama:
+		id := openai.GenerateResponseId()
+		return func(chunk []byte) (*openai.ChatCompletionsStreamResponse, error) {
+			llamaResponse := new(LlamaResponse)
+			if err := json.Unmarshal(chunk, llamaResponse); err != nil {
+				return nil, err
+			}
+			response := streamResponseLlama2OpenAI(llamaResponse, usage)
+			response.Id = id
+			return response, nil
+		}
+	case modelFamilyTitan:
+		id := openai.GenerateResponseId()
+		return func(chunk []byte) (*openai.ChatCompletionsStreamResponse, error) {
+			titanResponse := new(TitanStreamResponse)
+			if err := json.Unmarshal(chunk, titanResponse); err != nil {
+				return nil, err
+			}
+			response := streamResponseTitan2OpenAI(titanResponse, usage)
+			response.Id = id
+			return response, nil
+		}
+	}
+	var id string
+	return func(chunk []byte) (*openai.ChatCompletionsStreamResponse, error) {
+		claudeResp := new(anthropic.StreamResponse)
+		if err := json.Unmarshal(chunk, claudeResp); err != nil {
+			return nil, err
+		}
+		response, meta := anthropic.StreamResponseClaude2OpenAI(claudeResp)
+		if meta != nil {
+			usage.PromptTokens += meta.Usage.InputTokens
+			usage.CompletionTokens += meta.Usage.OutputTokens
+			id = openai.NormalizeResponseId(meta.Id)
+			return nil, nil
+		}
+		if response != nil {
+			response.Id = id
+		}
+		return response, nil
+	}
+}
+
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
 	awsCli, err := newAwsClient(c)
 	if err != nil {
@@ -72,6 +214,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	if err != nil {
 		return wrapErr(errors.Wrap(err, "awsModelID")), nil
 	}
+	family, _ := modelFamily(awsModelId)
 
 	awsReq := &bedrockruntime.InvokeModelInput{
 		ModelId:     aws.String(awsModelId),
@@ -79,19 +222,11 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 		ContentType: aws.String("application/json"),
 	}
 
-	claudeReq_, ok := c.Get(ctxkey.ConvertedRequest)
+	convertedReq, ok := c.Get(ctxkey.ConvertedRequest)
 	if !ok {
 		return wrapErr(errors.New("request not found")), nil
 	}
-	claudeReq := claudeReq_.(*anthropic.Request)
-	awsClaudeReq := &Request{
-		AnthropicVersion: "bedrock-2023-05-31",
-	}
-	if err = copier.Copy(awsClaudeReq, claudeReq); err != nil {
-		return wrapErr(errors.Wrap(err, "copy request")), nil
-	}
-
-	awsReq.Body, err = json.Marshal(awsClaudeReq)
+	awsReq.Body, err = json.Marshal(convertedReq)
 	if err != nil {
 		return wrapErr(errors.Wrap(err, "marshal request")), nil
 	}
@@ -101,114 +236,130 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 		return wrapErr(errors.Wrap(err, "InvokeModel")), nil
 	}
 
-	claudeResponse := new(anthropic.Response)
-	err = json.Unmarshal(awsResp.Body, claudeResponse)
+	openaiResp, usage, err := responseAWS2OpenAI(family, awsResp.Body)
 	if err != nil {
 		return wrapErr(errors.Wrap(err, "unmarshal response")), nil
 	}
-
-	openaiResp := anthropic.ResponseClaude2OpenAI(claudeResponse)
-	openaiResp.Model = modelName
-	usage := relaymodel.Usage{
-		PromptTokens:     claudeResponse.Usage.InputTokens,
-		CompletionTokens: claudeResponse.Usage.OutputTokens,
-		TotalTokens:      claudeResponse.Usage.InputTokens + claudeResponse.Usage.OutputTokens,
-	}
-	openaiResp.Usage = usage
+	openaiResp.Model = openai.ResponseModelName(c, modelName)
 
 	c.JSON(http.StatusOK, openaiResp)
-	return nil, &usage
+	return nil, usage
 }
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*relaymodel.ErrorWithStatusCode, *relaymodel.Usage) {
 	createdTime := helper.GetTimestamp()
-	awsCli, err := newAwsClient(c)
-	if err != nil {
-		return wrapErr(errors.Wrap(err, "newAwsClient")), nil
-	}
-
 	awsModelId, err := awsModelID(c.GetString(ctxkey.RequestModel))
 	if err != nil {
 		return wrapErr(errors.Wrap(err, "awsModelID")), nil
 	}
+	family, _ := modelFamily(awsModelId)
 
-	awsReq := &bedrockruntime.InvokeModelWithResponseStreamInput{
-		ModelId:     aws.String(awsModelId),
-		Accept:      aws.String("application/json"),
-		ContentType: aws.String("application/json"),
-	}
-
-	claudeReq_, ok := c.Get(ctxkey.ConvertedRequest)
+	convertedReq, ok := c.Get(ctxkey.ConvertedRequest)
 	if !ok {
 		return wrapErr(errors.New("request not found")), nil
 	}
-	claudeReq := claudeReq_.(*anthropic.Request)
-
-	awsClaudeReq := &Request{
-		AnthropicVersion: "bedrock-2023-05-31",
-	}
-	if err = copier.Copy(awsClaudeReq, claudeReq); err != nil {
-		return wrapErr(errors.Wrap(err, "copy request")), nil
-	}
-	awsReq.Body, err = json.Marshal(awsClaudeReq)
+	body, err := json.Marshal(convertedReq)
 	if err != nil {
 		return wrapErr(errors.Wrap(err, "marshal request")), nil
 	}
 
-	awsResp, err := awsCli.InvokeModelWithResponseStream(c.Request.Context(), awsReq)
+	streamResp, err := invokeModelWithResponseStream(c, awsModelId, body)
 	if err != nil {
 		return wrapErr(errors.Wrap(err, "InvokeModelWithResponseStream")), nil
 	}
-	stream := awsResp.GetStream()
-	defer stream.Close()
+	defer streamResp.Body.Close()
+	if streamResp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(streamResp.Body)
+		bizErr := wrapErr(errors.Errorf("InvokeModelWithResponseStream: %s", responseBody))
+		bizErr.StatusCode = streamResp.StatusCode
+		return bizErr, nil
+	}
 
 	c.Writer.Header().Set("Content-Type", "text/event-stream")
 	var usage relaymodel.Usage
-	var id string
+	render := newChunkRenderer(c, family, &usage, createdTime)
+	decoder := eventstream.NewDecoder(streamResp.Body)
 	c.Stream(func(w io.Writer) bool {
-		event, ok := <-stream.Events()
-		if !ok {
+		message, err := decoder.Decode()
+		if err == io.EOF {
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
-
-		switch v := event.(type) {
-		case *types.ResponseStreamMemberChunk:
-			claudeResp := new(anthropic.StreamResponse)
-			err := json.NewDecoder(bytes.NewReader(v.Value.Bytes)).Decode(claudeResp)
-			if err != nil {
-				logger.SysError("error unmarshalling stream response: " + err.Error())
-				return false
-			}
-
-			response, meta := anthropic.StreamResponseClaude2OpenAI(claudeResp)
-			if meta != nil {
-				usage.PromptTokens += meta.Usage.InputTokens
-				usage.CompletionTokens += meta.Usage.OutputTokens
-				id = fmt.Sprintf("chatcmpl-%s", meta.Id)
-				return true
-			}
-			if response == nil {
-				return true
-			}
-			response.Id = id
-			response.Model = c.GetString(ctxkey.OriginalModel)
-			response.Created = createdTime
-			jsonStr, err := json.Marshal(response)
-			if err != nil {
-				logger.SysError("error marshalling stream response: " + err.Error())
-				return true
-			}
-			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
-			return true
-		case *types.UnknownUnionMember:
-			fmt.Println("unknown tag:", v.Tag)
+		if err != nil {
+			logger.SysError("error decoding stream response: " + err.Error())
+			return false
+		}
+		if err = message.Err(); err != nil {
+			logger.SysError("error in stream response: " + err.Error())
 			return false
-		default:
-			fmt.Println("union is nil or unknown type")
+		}
+		if message.StringHeader(eventstream.HeaderEventType) != "chunk" {
+			logger.SysError("unknown event type: " + message.StringHeader(eventstream.HeaderEventType))
+			return false
+		}
+		// the chunk of the model is base64 encoded in the bytes field
+		var payload struct {
+			Bytes []byte `json:"bytes"`
+		}
+		if err = json.Unmarshal(message.Payload, &payload); err != nil {
+			logger.SysError("error unmarshalling stream payload: " + err.Error())
 			return false
 		}
+		return render(payload.Bytes)
 	})
 
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
 	return nil, &usage
 }
+
+// invokeModelWithResponseStream sends the streaming request to bedrock, signed as the sdk does, so that
+// the event stream of the response is decoded by the eventstream package, the base url of the channel,
+// e.g. a vpc endpoint, replaces the regional endpoint if set
+func invokeModelWithResponseStream(c *gin.Context, awsModelId string, body []byte) (*http.Response, error) {
+	region := c.GetString(ctxkey.ConfigRegion)
+	baseURL := strings.TrimSuffix(c.GetString(ctxkey.BaseURL), "/")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+	// the colon of the versioned model ids must be escaped, which url.PathEscape leaves as is
+	escapedModelId := strings.ReplaceAll(url.PathEscape(awsModelId), ":", "%3A")
+	req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, baseURL+"/model/"+escapedModelId+"/invoke-with-response-stream", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	payloadHash := sha256.Sum256(body)
+	creds := aws.Credentials{AccessKeyID: c.GetString(ctxkey.ConfigAK), SecretAccessKey: c.GetString(ctxkey.ConfigSK)}
+	err = v4.NewSigner().SignHTTP(c.Request.Context(), creds, req, hex.EncodeToString(payloadHash[:]), "bedrock", region, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return adaptor.DoRequest(c, req)
+}
+
+// newChunkRenderer returns the function writing a chunk of a stream of the model family as an openai SSE chunk,
+// it returns false if the stream should be stopped
+func newChunkRenderer(c *gin.Context, family int, usage *relaymodel.Usage, createdTime int64) func(chunk []byte) bool {
+	convert := newStreamConverter(family, usage)
+	responseModel := openai.ResponseModelName(c, c.GetString(ctxkey.OriginalModel))
+	return func(chunk []byte) bool {
+		response, err := convert(chunk)
+		if err != nil {
+			logger.SysError("error unmarshalling stream response: " + err.Error())
+			return false
+		}
+		if response == nil {
+			return true
+		}
+		response.Model = responseModel
+		response.Created = createdTime
+		jsonStr, err := json.Marshal(response)
+		if err != nil {
+			logger.SysError("error marshalling stream response: " + err.Error())
+			return true
+		}
+		c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
+		return true
+	}
+}
@@ -0,0 +1,127 @@
+package aws
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/client"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAwsModelID(t *testing.T) {
+	id, err := awsModelID("llama3-8b-8192")
+	assert.NoError(t, err)
+	assert.Equal(t, "meta.llama3-8b-instruct-v1:0", id)
+	family, _ := modelFamily(id)
+	assert.Equal(t, modelFamilyLlama, family)
+
+	id, err = awsModelID("amazon.titan-text-express-v1")
+	assert.NoError(t, err)
+	family, _ = modelFamily(id)
+	assert.Equal(t, modelFamilyTitan, family)
+
+	_, err = awsModelID("gpt-4o")
+	assert.Error(t, err)
+}
+
+func TestRenderLlamaPrompt(t *testing.T) {
+	prompt := renderLlamaPrompt([]relaymodel.Message{
+		{Role: "system", Content: "be brief"},
+		{Role: "user", Content: "hi"},
+	})
+	assert.Equal(t, "<|begin_of_text|><|start_header_id|>system<|end_header_id|>\n\nbe brief<|eot_id|>"+
+		"<|start_header_id|>user<|end_header_id|>\n\nhi<|eot_id|>"+
+		"<|start_header_id|>assistant<|end_header_id|>\n\n", prompt)
+}
+
+func TestResponseAWS2OpenAI(t *testing.T) {
+	body := []byte(`{"inputTextTokenCount":5,"results":[{"tokenCount":3,"outputText":" hello","completionReason":"FINISH"}]}`)
+	response, usage, err := responseAWS2OpenAI(modelFamilyTitan, body)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", response.Choices[0].Message.StringContent())
+	assert.Equal(t, "stop", response.Choices[0].FinishReason)
+	assert.Equal(t, relaymodel.Usage{PromptTokens: 5, CompletionTokens: 3, TotalTokens: 8}, *usage)
+
+	var streamUsage relaymodel.Usage
+	convert := newStreamConverter(modelFamilyLlama, &streamUsage)
+	chunk, err := convert([]byte(`{"generation":"hi","stop_reason":"stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":4,"outputTokenCount":2}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "hi", chunk.Choices[0].Delta.Content)
+	assert.Equal(t, "stop", *chunk.Choices[0].FinishReason)
+	assert.Equal(t, 4, streamUsage.PromptTokens)
+	assert.Equal(t, 2, streamUsage.CompletionTokens)
+}
+
+// encodeEventMessage crafts an event message of the aws event stream with string headers
+func encodeEventMessage(eventType string, payload []byte) []byte {
+	var headers []byte
+	for _, header := range [][2]string{{":message-type", "event"}, {":event-type", eventType}} {
+		headers = append(headers, byte(len(header[0])))
+		headers = append(headers, header[0]...)
+		// the type of the string values
+		headers = append(headers, 7)
+		headers = binary.BigEndian.AppendUint16(headers, uint16(len(header[1])))
+		headers = append(headers, header[1]...)
+	}
+	message := binary.BigEndian.AppendUint32(nil, uint32(12+len(headers)+len(payload)+4))
+	message = binary.BigEndian.AppendUint32(message, uint32(len(headers)))
+	message = binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+	message = append(message, headers...)
+	message = append(message, payload...)
+	return binary.BigEndian.AppendUint32(message, crc32.ChecksumIEEE(message))
+}
+
+// closeNotifyRecorder is a response recorder usable by gin.Context.Stream
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestStreamHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/model/anthropic.claude-3-haiku-20240307-v1%3A0/invoke-with-response-stream", r.URL.EscapedPath())
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=ak/"))
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		for _, event := range []string{
+			`{"type":"message_start","message":{"id":"msg_1","model":"claude-3-haiku-20240307","usage":{"input_tokens":9}}}`,
+			`{"type":"content_block_delta","delta":{"type":"text_delta","text":"Hello"}}`,
+			`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":2}}`,
+		} {
+			payload, _ := json.Marshal(map[string][]byte{"bytes": []byte(event)})
+			_, _ = w.Write(encodeEventMessage("chunk", payload))
+		}
+	}))
+	defer upstream.Close()
+	originalHTTPClient := client.HTTPClient
+	client.HTTPClient = &http.Client{}
+	defer func() { client.HTTPClient = originalHTTPClient }()
+
+	w := &closeNotifyRecorder{httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set(ctxkey.BaseURL, upstream.URL)
+	c.Set(ctxkey.ConfigAK, "ak")
+	c.Set(ctxkey.ConfigSK, "sk")
+	c.Set(ctxkey.ConfigRegion, "us-east-1")
+	c.Set(ctxkey.RequestModel, "claude-3-haiku-20240307")
+	c.Set(ctxkey.ConvertedRequest, map[string]any{"max_tokens": 10})
+
+	bizErr, usage := StreamHandler(c, nil)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, relaymodel.Usage{PromptTokens: 9, CompletionTokens: 2, TotalTokens: 11}, *usage)
+	body := w.Body.String()
+	assert.Contains(t, body, `"content":"Hello"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
@@ -15,3 +15,63 @@ type Request struct {
 	TopK             int                 `json:"top_k,omitempty"`
 	StopSequences    []string            `json:"stop_sequences,omitempty"`
 }
+
+// LlamaRequest is the request to AWS Llama
+//
+// https://docs.aws.amazon.com/bedrock/latest/userguide/model-parameters-meta.html
+type LlamaRequest struct {
+	Prompt      string  `json:"prompt"`
+	MaxGenLen   int     `json:"max_gen_len,omitempty"`
+	Temperature float64 `json:"temperature,omitempty"`
+	TopP        float64 `json:"top_p,omitempty"`
+}
+
+// LlamaResponse is the response of AWS Llama, a chunk of a stream has the same fields,
+// with the generation of the chunk only
+type LlamaResponse struct {
+	Generation           string             `json:"generation"`
+	PromptTokenCount     int                `json:"prompt_token_count"`
+	GenerationTokenCount int                `json:"generation_token_count"`
+	StopReason           *string            `json:"stop_reason"`
+	InvocationMetrics    *InvocationMetrics `json:"amazon-bedrock-invocationMetrics,omitempty"`
+}
+
+// TitanRequest is the request to AWS Titan Text
+//
+// https://docs.aws.amazon.com/bedrock/latest/userguide/model-parameters-titan-text.html
+type TitanRequest struct {
+	InputText            string                    `json:"inputText"`
+	TextGenerationConfig TitanTextGenerationConfig `json:"textGenerationConfig"`
+}
+
+type TitanTextGenerationConfig struct {
+	MaxTokenCount int     `json:"maxTokenCount,omitempty"`
+	Temperature   float64 `json:"temperature,omitempty"`
+	TopP          float64 `json:"topP,omitempty"`
+}
+
+type TitanResult struct {
+	TokenCount       int    `json:"tokenCount"`
+	OutputText       string `json:"outputText"`
+	CompletionReason string `json:"completionReason"`
+}
+
+type TitanResponse struct {
+	InputTextTokenCount int           `json:"inputTextTokenCount"`
+	Results             []TitanResult `json:"results"`
+}
+
+type TitanStreamResponse struct {
+	OutputText                string             `json:"outputText"`
+	Index                     int                `json:"index"`
+	TotalOutputTextTokenCount int                `json:"totalOutputTextTokenCount"`
+	CompletionReason          *string            `json:"completionReason"`
+	InputTextTokenCount       int                `json:"inputTextTokenCount"`
+	InvocationMetrics         *InvocationMetrics `json:"amazon-bedrock-invocationMetrics,omitempty"`
+}
+
+// InvocationMetrics is added by bedrock to the last chunk of a stream
+type InvocationMetrics struct {
+	InputTokenCount  int `json:"inputTokenCount"`
+	OutputTokenCount int `json:"outputTokenCount"`
+}
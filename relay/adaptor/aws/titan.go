@@ -0,0 +1,101 @@
+package aws
+
+import (
+	"strings"
+
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// renderTitanPrompt renders the messages as the conversation titan text expects, the system messages
+// are put first as they are
+//
+// https://docs.aws.amazon.com/bedrock/latest/userguide/prompt-templates-and-examples.html
+func renderTitanPrompt(messages []relaymodel.Message) string {
+	var builder strings.Builder
+	for _, message := range messages {
+		switch message.Role {
+		case "system", "developer":
+			builder.WriteString(message.StringContent() + "\n\n")
+		case "assistant":
+			builder.WriteString("Bot: " + message.StringContent() + "\n")
+		default:
+			builder.WriteString("User: " + message.StringContent() + "\n")
+		}
+	}
+	builder.WriteString("Bot:")
+	return builder.String()
+}
+
+func convertTitanRequest(request relaymodel.GeneralOpenAIRequest) *TitanRequest {
+	return &TitanRequest{
+		InputText: renderTitanPrompt(request.Messages),
+		TextGenerationConfig: TitanTextGenerationConfig{
+			MaxTokenCount: request.MaxTokens,
+			Temperature:   request.Temperature,
+			TopP:          request.TopP,
+		},
+	}
+}
+
+func completionReasonTitan2OpenAI(reason string) string {
+	switch reason {
+	case "":
+		return ""
+	case "FINISH", "STOP_CRITERIA_MET":
+		return "stop"
+	case "LENGTH", "MAX_TOKENS":
+		return "length"
+	case "CONTENT_FILTERED":
+		return "content_filter"
+	}
+	return strings.ToLower(reason)
+}
+
+func responseTitan2OpenAI(response *TitanResponse) (*openai.TextResponse, relaymodel.Usage) {
+	usage := relaymodel.Usage{PromptTokens: response.InputTextTokenCount}
+	fullTextResponse := openai.TextResponse{
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletion,
+		Created: helper.GetTimestamp(),
+		Choices: make([]openai.TextResponseChoice, 0, len(response.Results)),
+	}
+	for i, result := range response.Results {
+		usage.CompletionTokens += result.TokenCount
+		fullTextResponse.Choices = append(fullTextResponse.Choices, openai.TextResponseChoice{
+			Index:        i,
+			Message:      relaymodel.Message{Role: "assistant", Content: strings.TrimSpace(result.OutputText)},
+			FinishReason: completionReasonTitan2OpenAI(result.CompletionReason),
+		})
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	fullTextResponse.Usage = usage
+	return &fullTextResponse, usage
+}
+
+// streamResponseTitan2OpenAI converts a chunk of a titan stream, and updates the usage by the counts it carries
+func streamResponseTitan2OpenAI(response *TitanStreamResponse, usage *relaymodel.Usage) *openai.ChatCompletionsStreamResponse {
+	if response.InputTextTokenCount > 0 {
+		usage.PromptTokens = response.InputTextTokenCount
+	}
+	if response.TotalOutputTextTokenCount > 0 {
+		usage.CompletionTokens = response.TotalOutputTextTokenCount
+	}
+	if response.InvocationMetrics != nil {
+		usage.PromptTokens = response.InvocationMetrics.InputTokenCount
+		usage.CompletionTokens = response.InvocationMetrics.OutputTokenCount
+	}
+	var choice openai.ChatCompletionsStreamResponseChoice
+	choice.Index = response.Index
+	choice.Delta.Content = response.OutputText
+	if response.CompletionReason != nil {
+		if finishReason := completionReasonTitan2OpenAI(*response.CompletionReason); finishReason != "" {
+			choice.FinishReason = &finishReason
+		}
+	}
+	return &openai.ChatCompletionsStreamResponse{
+		Object:  openai.ObjectChatCompletionChunk,
+		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
+	}
+}
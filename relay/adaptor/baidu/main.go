@@ -1,13 +1,13 @@
 package baidu
 
 import (
-	"bufio"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/client"
 	"github.com/songquanpeng/one-api/relay/constant"
@@ -86,9 +86,9 @@ func responseBaidu2OpenAI(response *ChatResponse) *openai.TextResponse {
 		FinishReason: "stop",
 	}
 	fullTextResponse := openai.TextResponse{
-		Id:      response.Id,
-		Object:  "chat.completion",
-		Created: response.Created,
+		Id:      openai.NormalizeResponseId(response.Id),
+		Object:  openai.ObjectChatCompletion,
+		Created: openai.NormalizeCreated(response.Created),
 		Choices: []openai.TextResponseChoice{choice},
 		Usage:   response.Usage,
 	}
@@ -102,9 +102,9 @@ func streamResponseBaidu2OpenAI(baiduResponse *ChatStreamResponse) *openai.ChatC
 		choice.FinishReason = &constant.StopFinishReason
 	}
 	response := openai.ChatCompletionsStreamResponse{
-		Id:      baiduResponse.Id,
-		Object:  "chat.completion.chunk",
-		Created: baiduResponse.Created,
+		Id:      openai.NormalizeResponseId(baiduResponse.Id),
+		Object:  openai.ObjectChatCompletionChunk,
+		Created: openai.NormalizeCreated(baiduResponse.Created),
 		Model:   "ernie-bot",
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
 	}
@@ -119,7 +119,7 @@ func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *EmbeddingReque
 
 func embeddingResponseBaidu2OpenAI(response *EmbeddingResponse) *openai.EmbeddingResponse {
 	openAIEmbeddingResponse := openai.EmbeddingResponse{
-		Object: "list",
+		Object: openai.ObjectList,
 		Data:   make([]openai.EmbeddingResponseItem, 0, len(response.Data)),
 		Model:  "baidu-embedding",
 		Usage:  response.Usage,
@@ -136,7 +136,7 @@ func embeddingResponseBaidu2OpenAI(response *EmbeddingResponse) *openai.Embeddin
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	var usage model.Usage
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -163,6 +163,8 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		stopChan <- true
 	}()
 	common.SetEventStreamHeaders(c)
+	isFirstChunk := true
+	var responseId string
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -178,6 +180,15 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				usage.CompletionTokens = baiduResponse.Usage.TotalTokens - baiduResponse.Usage.PromptTokens
 			}
 			response := streamResponseBaidu2OpenAI(&baiduResponse)
+			// a generated id must be the same for all the chunks of the stream
+			if responseId == "" {
+				responseId = response.Id
+			}
+			response.Id = responseId
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -234,7 +245,7 @@ func Handler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *
 	return nil, &fullTextResponse.Usage
 }
 
-func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+func EmbeddingHandler(c *gin.Context, resp *http.Response, promptTokens int) (*model.ErrorWithStatusCode, *model.Usage) {
 	var baiduResponse EmbeddingResponse
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -260,6 +271,7 @@ func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStat
 		}, nil
 	}
 	fullTextResponse := embeddingResponseBaidu2OpenAI(&baiduResponse)
+	openai.FillEmbeddingUsage(&fullTextResponse.Usage, promptTokens)
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
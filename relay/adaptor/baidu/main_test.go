@@ -0,0 +1,93 @@
+package baidu
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseBaidu2OpenAIIdAndCreated(t *testing.T) {
+	response := responseBaidu2OpenAI(&ChatResponse{Id: "as-123", Result: "hi"})
+	assert.Equal(t, "chatcmpl-as-123", response.Id)
+	assert.Greater(t, response.Created, int64(0))
+
+	response = responseBaidu2OpenAI(&ChatResponse{Result: "hi", Created: 1700000000})
+	assert.True(t, strings.HasPrefix(response.Id, openai.ResponseIdPrefix))
+	assert.Equal(t, int64(1700000000), response.Created)
+
+	streamResponse := streamResponseBaidu2OpenAI(&ChatStreamResponse{ChatResponse: ChatResponse{Result: "hi"}})
+	assert.True(t, strings.HasPrefix(streamResponse.Id, openai.ResponseIdPrefix))
+	assert.Greater(t, streamResponse.Created, int64(0))
+}
+
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestStreamHandlerFirstChunkRole(t *testing.T) {
+	w := &closeNotifyRecorder{httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`data: {"id":"as-123","result":"Hello","is_end":false}` + "\n\n" +
+			`data: {"id":"as-123","result":" world","is_end":true,"usage":{"prompt_tokens":1,"completion_tokens":2,"total_tokens":3}}` + "\n\n")),
+	}
+
+	bizErr, _ := StreamHandler(c, resp)
+	assert.Nil(t, bizErr)
+	var chunks []openai.ChatCompletionsStreamResponse
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionsStreamResponse
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		chunks = append(chunks, chunk)
+	}
+	assert.Len(t, chunks, 2)
+	// the role is only sent with the first chunk, as openai does
+	assert.Equal(t, "assistant", chunks[0].Choices[0].Delta.Role)
+	assert.Equal(t, "Hello", chunks[0].Choices[0].Delta.Content)
+	assert.Empty(t, chunks[1].Choices[0].Delta.Role)
+}
+
+func TestStreamHandlerGeneratedId(t *testing.T) {
+	w := &closeNotifyRecorder{httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body: io.NopCloser(strings.NewReader(`data: {"result":"Hello","is_end":false}` + "\n\n" +
+			`data: {"result":" world","is_end":true}` + "\n\n")),
+	}
+
+	bizErr, _ := StreamHandler(c, resp)
+	assert.Nil(t, bizErr)
+	var ids []string
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok || data == "[DONE]" {
+			continue
+		}
+		var chunk openai.ChatCompletionsStreamResponse
+		assert.NoError(t, json.Unmarshal([]byte(data), &chunk))
+		ids = append(ids, chunk.Id)
+	}
+	assert.Len(t, ids, 2)
+	// the id generated for an upstream which doesn't give one is the same for all the chunks
+	assert.True(t, strings.HasPrefix(ids[0], openai.ResponseIdPrefix))
+	assert.Equal(t, ids[0], ids[1])
+}
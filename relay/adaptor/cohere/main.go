@@ -1,10 +1,8 @@
 package cohere
 
 import (
-	"bufio"
 	"bytes"
 	"encoding/json"
-	"fmt"
 	"io"
 	"net/http"
 	"strings"
@@ -13,6 +11,7 @@ import (
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/model"
 )
@@ -98,7 +97,7 @@ func StreamResponseCohere2OpenAI(cohereResponse *StreamResponse) (*openai.ChatCo
 		choice.FinishReason = &finishReason
 	}
 	var openaiResponse openai.ChatCompletionsStreamResponse
-	openaiResponse.Object = "chat.completion.chunk"
+	openaiResponse.Object = openai.ObjectChatCompletionChunk
 	openaiResponse.Choices = []openai.ChatCompletionsStreamResponseChoice{choice}
 	return &openaiResponse, response
 }
@@ -114,9 +113,9 @@ func ResponseCohere2OpenAI(cohereResponse *Response) *openai.TextResponse {
 		FinishReason: stopReasonCohere2OpenAI(cohereResponse.FinishReason),
 	}
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", cohereResponse.ResponseID),
+		Id:      openai.NormalizeResponseId(cohereResponse.ResponseID),
 		Model:   "model",
-		Object:  "chat.completion",
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 	}
@@ -125,7 +124,8 @@ func ResponseCohere2OpenAI(cohereResponse *Response) *openai.TextResponse {
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	createdTime := helper.GetTimestamp()
-	scanner := bufio.NewScanner(resp.Body)
+	responseId := openai.GenerateResponseId()
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -170,7 +170,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			if response == nil {
 				return true
 			}
-			response.Id = fmt.Sprintf("chatcmpl-%d", createdTime)
+			response.Id = responseId
 			response.Model = c.GetString("original_model")
 			response.Created = createdTime
 			jsonStr, err := json.Marshal(response)
@@ -1,13 +1,21 @@
 package adaptor
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/relay/client"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
+	"syscall"
 )
 
 func SetupCommonRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) {
@@ -18,7 +26,50 @@ func SetupCommonRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta
 	}
 }
 
+// DoRequestHelper sends the request to the base url of the channel, and to the secondary_base_url
+// of its config if the connection to the base url fails, before the relay fails over to another channel
 func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
+	secondaryBaseURL := strings.TrimSuffix(c.GetString(ctxkey.ConfigSecondaryBaseURL), "/")
+	if secondaryBaseURL == "" || secondaryBaseURL == meta.BaseURL {
+		return doRequestHelper(a, c, meta, requestBody)
+	}
+	ctx := c.Request.Context()
+	// kept, so that the request can be sent again to the secondary base url
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("read request body failed: %w", err)
+	}
+	primaryBaseURL := meta.BaseURL
+	resp, err := doRequestHelper(a, c, meta, bytes.NewReader(body))
+	if err == nil {
+		logger.Infof(ctx, "channel #%d served by the primary base url %s", meta.ChannelId, primaryBaseURL)
+		return resp, nil
+	}
+	if !isConnectionError(err) {
+		return nil, err
+	}
+	logger.Warnf(ctx, "failed to connect to the primary base url %s of channel #%d, trying the secondary base url %s: %s", primaryBaseURL, meta.ChannelId, secondaryBaseURL, err.Error())
+	meta.BaseURL = secondaryBaseURL
+	resp, err = doRequestHelper(a, c, meta, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	logger.Infof(ctx, "channel #%d served by the secondary base url %s", meta.ChannelId, secondaryBaseURL)
+	return resp, nil
+}
+
+// isConnectionError reports whether the request failed to connect to the upstream, e.g. the connection
+// is refused or times out, in which case the upstream hasn't got the request
+func isConnectionError(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+func doRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
 	fullRequestURL, err := a.GetRequestURL(meta)
 	if err != nil {
 		return nil, fmt.Errorf("get request url failed: %w", err)
@@ -31,15 +82,60 @@ func DoRequestHelper(a Adaptor, c *gin.Context, meta *meta.Meta, requestBody io.
 	if err != nil {
 		return nil, fmt.Errorf("setup request header failed: %w", err)
 	}
+	ctx, cancel, hasTimeout := upstreamTimeoutContext(c, meta)
+	if hasTimeout {
+		req = req.WithContext(ctx)
+	}
 	resp, err := DoRequest(c, req)
 	if err != nil {
+		if hasTimeout {
+			cancel()
+		}
 		return nil, fmt.Errorf("do request failed: %w", err)
 	}
+	if hasTimeout {
+		resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	}
 	return resp, nil
 }
 
+// the channels which have been warned of skipping the tls verification, so that it is logged once per channel
+var skipTLSVerifyWarned sync.Map
+
+// GetHTTPClient returns the client of the channel selected in the context,
+// which follows the tls configuration of the channel if any
+func GetHTTPClient(c *gin.Context) (*http.Client, error) {
+	tlsConfig := client.TLSConfig{
+		ClientCert: c.GetString(ctxkey.ConfigTLSClientCert),
+		ClientKey:  c.GetString(ctxkey.ConfigTLSClientKey),
+		CACert:     c.GetString(ctxkey.ConfigTLSCACert),
+		SkipVerify: c.GetString(ctxkey.ConfigSkipTLSVerify) == "true",
+	}
+	if tlsConfig.SkipVerify {
+		channelId := c.GetInt(ctxkey.ChannelId)
+		if _, warned := skipTLSVerifyWarned.LoadOrStore(channelId, struct{}{}); !warned {
+			logger.SysError(fmt.Sprintf("tls verification is disabled for channel #%d, its traffic can be intercepted, consider tls_ca_cert instead", channelId))
+		}
+	}
+	httpClient, err := client.GetHTTPClient(tlsConfig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tls configuration: %w", err)
+	}
+	return httpClient, nil
+}
+
 func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
-	resp, err := client.HTTPClient.Do(req)
+	httpClient, err := GetHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := req.Context().Deadline(); ok && httpClient.Timeout != 0 {
+		// the deadline of the request replaces the timeout of the client, which may be shorter
+		clientWithoutTimeout := *httpClient
+		clientWithoutTimeout.Timeout = 0
+		httpClient = &clientWithoutTimeout
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -50,3 +146,34 @@ func DoRequest(c *gin.Context, req *http.Request) (*http.Response, error) {
 	_ = c.Request.Body.Close()
 	return resp, nil
 }
+
+// CopyResponseHeaders forwards the upstream response headers in RESPONSE_HEADER_ALLOW_LIST to the client,
+// an entry ending with * matches all headers with that prefix, other headers are dropped
+func CopyResponseHeaders(c *gin.Context, resp *http.Response) {
+	for k, v := range resp.Header {
+		if len(v) == 0 || !isResponseHeaderAllowed(k) {
+			continue
+		}
+		c.Writer.Header().Set(k, v[0])
+	}
+}
+
+func isResponseHeaderAllowed(key string) bool {
+	key = strings.ToLower(key)
+	for _, allowed := range strings.Split(config.ResponseHeaderAllowList, ",") {
+		allowed = strings.ToLower(strings.TrimSpace(allowed))
+		if allowed == "" {
+			continue
+		}
+		if strings.HasSuffix(allowed, "*") {
+			if strings.HasPrefix(key, strings.TrimSuffix(allowed, "*")) {
+				return true
+			}
+			continue
+		}
+		if key == allowed {
+			return true
+		}
+	}
+	return false
+}
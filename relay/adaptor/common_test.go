@@ -0,0 +1,72 @@
+package adaptor
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+// urlAdaptor sends the requests to the base url as is
+type urlAdaptor struct {
+	Adaptor
+}
+
+func (a *urlAdaptor) GetRequestURL(meta *meta.Meta) (string, error) {
+	return meta.BaseURL + "/v1/chat/completions", nil
+}
+
+func (a *urlAdaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) error {
+	return nil
+}
+
+func TestDoRequestHelperSecondaryBaseURL(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	secondary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(body)
+	}))
+	defer secondary.Close()
+	// a server which is closed refuses the connections
+	unreachable := httptest.NewServer(http.NotFoundHandler())
+	unreachable.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hijacker, _ := w.(http.Hijacker)
+		conn, _, _ := hijacker.Hijack()
+		_ = conn.Close()
+	}))
+	defer failing.Close()
+
+	send := func(baseURL string, secondaryBaseURL string) (*meta.Meta, string, error) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		c.Set(ctxkey.ConfigSecondaryBaseURL, secondaryBaseURL)
+		meta := &meta.Meta{ChannelId: 1, BaseURL: baseURL}
+		resp, err := DoRequestHelper(&urlAdaptor{}, c, meta, strings.NewReader(`{"model":"gpt-4"}`))
+		if err != nil {
+			return meta, "", err
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return meta, string(body), nil
+	}
+
+	meta, body, err := send(unreachable.URL, secondary.URL+"/")
+	assert.NoError(t, err)
+	assert.Equal(t, `{"model":"gpt-4"}`, body)
+	assert.Equal(t, secondary.URL, meta.BaseURL)
+
+	_, _, err = send(unreachable.URL, "")
+	assert.Error(t, err)
+
+	// the upstream may have got the request once connected, so it is not sent again
+	meta, _, err = send(failing.URL, secondary.URL)
+	assert.Error(t, err)
+	assert.Equal(t, failing.URL, meta.BaseURL)
+}
@@ -1,14 +1,13 @@
 package coze
 
 import (
-	"bufio"
 	"encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/conv"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/coze/constant/messagetype"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -72,9 +71,9 @@ func StreamResponseCoze2OpenAI(cozeResponse *StreamResponse) (*openai.ChatComple
 		choice.FinishReason = &finishReason
 	}
 	var openaiResponse openai.ChatCompletionsStreamResponse
-	openaiResponse.Object = "chat.completion.chunk"
+	openaiResponse.Object = openai.ObjectChatCompletionChunk
 	openaiResponse.Choices = []openai.ChatCompletionsStreamResponseChoice{choice}
-	openaiResponse.Id = cozeResponse.ConversationId
+	openaiResponse.Id = openai.NormalizeResponseId(cozeResponse.ConversationId)
 	return &openaiResponse, response
 }
 
@@ -96,9 +95,9 @@ func ResponseCoze2OpenAI(cozeResponse *Response) *openai.TextResponse {
 		FinishReason: "stop",
 	}
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", cozeResponse.ConversationId),
+		Id:      openai.NormalizeResponseId(cozeResponse.ConversationId),
 		Model:   "coze-bot",
-		Object:  "chat.completion",
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 	}
@@ -108,7 +107,7 @@ func ResponseCoze2OpenAI(cozeResponse *Response) *openai.TextResponse {
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *string) {
 	var responseText string
 	createdTime := helper.GetTimestamp()
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -139,6 +138,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	}()
 	common.SetEventStreamHeaders(c)
 	var modelName string
+	var responseId string
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -157,6 +157,11 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			for _, choice := range response.Choices {
 				responseText += conv.AsString(choice.Delta.Content)
 			}
+			// a generated id must be the same for all the chunks of the stream
+			if responseId == "" {
+				responseId = response.Id
+			}
+			response.Id = responseId
 			response.Model = modelName
 			response.Created = createdTime
 			jsonStr, err := json.Marshal(response)
@@ -1,7 +1,6 @@
 package gemini
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -14,6 +13,7 @@ import (
 	"github.com/songquanpeng/one-api/common/image"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/constant"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -52,6 +52,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 		GenerationConfig: ChatGenerationConfig{
 			Temperature:     textRequest.Temperature,
 			TopP:            textRequest.TopP,
+			TopK:            float64(textRequest.TopK),
 			MaxOutputTokens: textRequest.MaxTokens,
 		},
 	}
@@ -102,6 +103,13 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 						Data:     data,
 					},
 				})
+			} else if part.Type == model.ContentTypeInputAudio {
+				parts = append(parts, Part{
+					InlineData: &InlineData{
+						MimeType: "audio/" + part.InputAudio.Format,
+						Data:     part.InputAudio.Data,
+					},
+				})
 			}
 		}
 		content.Parts = parts
@@ -191,8 +199,8 @@ func getToolCalls(candidate *ChatCandidate) []model.Tool {
 
 func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: make([]openai.TextResponseChoice, 0, len(response.Candidates)),
 	}
@@ -207,6 +215,7 @@ func responseGeminiChat2OpenAI(response *ChatResponse) *openai.TextResponse {
 		if len(candidate.Content.Parts) > 0 {
 			if candidate.Content.Parts[0].FunctionCall != nil {
 				choice.Message.ToolCalls = getToolCalls(&candidate)
+				choice.FinishReason = constant.ToolCallsFinishReason
 			} else {
 				choice.Message.Content = candidate.Content.Parts[0].Text
 			}
@@ -224,7 +233,7 @@ func streamResponseGeminiChat2OpenAI(geminiResponse *ChatResponse) *openai.ChatC
 	choice.Delta.Content = geminiResponse.GetResponseText()
 	choice.FinishReason = &constant.StopFinishReason
 	var response openai.ChatCompletionsStreamResponse
-	response.Object = "chat.completion.chunk"
+	response.Object = openai.ObjectChatCompletionChunk
 	response.Model = "gemini"
 	response.Choices = []openai.ChatCompletionsStreamResponseChoice{choice}
 	return &response
@@ -234,7 +243,7 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 	responseText := ""
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -260,7 +269,10 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 		stopChan <- true
 	}()
+	responseId := openai.GenerateResponseId()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
+	isFirstChunk := true
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -274,13 +286,17 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 			responseText += dummy.Content
 			var choice openai.ChatCompletionsStreamResponseChoice
 			choice.Delta.Content = dummy.Content
-			response := openai.ChatCompletionsStreamResponse{
-				Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-				Object:  "chat.completion.chunk",
-				Created: helper.GetTimestamp(),
+			response := &openai.ChatCompletionsStreamResponse{
+				Id:      responseId,
+				Object:  openai.ObjectChatCompletionChunk,
+				Created: createdTime,
 				Model:   "gemini-pro",
 				Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
 			}
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
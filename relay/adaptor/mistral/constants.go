@@ -3,8 +3,11 @@ package mistral
 var ModelList = []string{
 	"open-mistral-7b",
 	"open-mixtral-8x7b",
+	"open-mixtral-8x22b",
+	"open-mistral-nemo",
 	"mistral-small-latest",
 	"mistral-medium-latest",
 	"mistral-large-latest",
+	"codestral-latest",
 	"mistral-embed",
 }
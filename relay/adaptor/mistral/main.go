@@ -0,0 +1,38 @@
+package mistral
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+func ConvertRequest(c *gin.Context, request model.GeneralOpenAIRequest) *Request {
+	mistralRequest := Request{
+		Model:            request.Model,
+		Messages:         request.Messages,
+		Temperature:      request.Temperature,
+		TopP:             request.TopP,
+		MaxTokens:        request.MaxTokens,
+		Stop:             request.Stop,
+		N:                request.N,
+		PresencePenalty:  request.PresencePenalty,
+		FrequencyPenalty: request.FrequencyPenalty,
+		Stream:           request.Stream,
+		StreamOptions:    request.StreamOptions,
+		SafePrompt:       c.GetString(ctxkey.ConfigSafePrompt) == "true",
+		RandomSeed:       int(request.Seed),
+		ResponseFormat:   request.ResponseFormat,
+		Tools:            request.Tools,
+		ToolChoice:       request.ToolChoice,
+	}
+	// safe_prompt is not an openai field, so it is read from the original request
+	var safePromptRequest struct {
+		SafePrompt *bool `json:"safe_prompt"`
+	}
+	err := common.UnmarshalBodyReusable(c, &safePromptRequest)
+	if err == nil && safePromptRequest.SafePrompt != nil {
+		mistralRequest.SafePrompt = *safePromptRequest.SafePrompt
+	}
+	return &mistralRequest
+}
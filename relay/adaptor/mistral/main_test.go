@@ -0,0 +1,32 @@
+package mistral
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"mistral-large-latest"}`))
+	request := model.GeneralOpenAIRequest{
+		Model:            "mistral-large-latest",
+		Stop:             []any{"\n\n"},
+		N:                2,
+		PresencePenalty:  0.5,
+		FrequencyPenalty: 0.3,
+		Stream:           true,
+		StreamOptions:    &model.StreamOptions{IncludeUsage: true},
+		LogitBias:        map[string]float64{"1": 1},
+	}
+	jsonData, err := json.Marshal(ConvertRequest(c, request))
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"model":"mistral-large-latest","messages":null,"stop":["\n\n"],"n":2,"presence_penalty":0.5,"frequency_penalty":0.3,"stream":true,"stream_options":{"include_usage":true}}`, string(jsonData))
+}
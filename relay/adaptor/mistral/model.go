@@ -0,0 +1,26 @@
+package mistral
+
+import (
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Request is mostly compatible with openai, but rejects some of its fields
+// https://docs.mistral.ai/api/#operation/createChatCompletion
+type Request struct {
+	Model            string                `json:"model"`
+	Messages         []model.Message       `json:"messages"`
+	Temperature      float64               `json:"temperature,omitempty"`
+	TopP             float64               `json:"top_p,omitempty"`
+	MaxTokens        int                   `json:"max_tokens,omitempty"`
+	Stop             any                   `json:"stop,omitempty"`
+	N                int                   `json:"n,omitempty"`
+	PresencePenalty  float64               `json:"presence_penalty,omitempty"`
+	FrequencyPenalty float64               `json:"frequency_penalty,omitempty"`
+	Stream           bool                  `json:"stream,omitempty"`
+	StreamOptions    *model.StreamOptions  `json:"stream_options,omitempty"`
+	SafePrompt       bool                  `json:"safe_prompt,omitempty"`
+	RandomSeed       int                   `json:"random_seed,omitempty"`
+	ResponseFormat   *model.ResponseFormat `json:"response_format,omitempty"`
+	Tools            []model.Tool          `json:"tools,omitempty"`
+	ToolChoice       any                   `json:"tool_choice,omitempty"`
+}
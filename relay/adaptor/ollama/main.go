@@ -1,12 +1,9 @@
 package ollama
 
 import (
-	"bufio"
 	"context"
 	"encoding/json"
-	"fmt"
 	"github.com/songquanpeng/one-api/common/helper"
-	"github.com/songquanpeng/one-api/common/random"
 	"io"
 	"net/http"
 	"strings"
@@ -14,6 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/constant"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -26,6 +24,7 @@ func ConvertRequest(request model.GeneralOpenAIRequest) *ChatRequest {
 			Seed:             int(request.Seed),
 			Temperature:      request.Temperature,
 			TopP:             request.TopP,
+			TopK:             request.TopK,
 			FrequencyPenalty: request.FrequencyPenalty,
 			PresencePenalty:  request.PresencePenalty,
 		},
@@ -52,9 +51,9 @@ func responseOllama2OpenAI(response *ChatResponse) *openai.TextResponse {
 		choice.FinishReason = "stop"
 	}
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
+		Id:      openai.GenerateResponseId(),
 		Model:   response.Model,
-		Object:  "chat.completion",
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 		Usage: model.Usage{
@@ -74,8 +73,8 @@ func streamResponseOllama2OpenAI(ollamaResponse *ChatResponse) *openai.ChatCompl
 		choice.FinishReason = &constant.StopFinishReason
 	}
 	response := openai.ChatCompletionsStreamResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion.chunk",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   ollamaResponse.Model,
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -85,7 +84,7 @@ func streamResponseOllama2OpenAI(ollamaResponse *ChatResponse) *openai.ChatCompl
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	var usage model.Usage
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -107,6 +106,9 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 		stopChan <- true
 	}()
+	// all the chunks of a stream share the same id
+	responseId := openai.GenerateResponseId()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
 	c.Stream(func(w io.Writer) bool {
 		select {
@@ -123,6 +125,8 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				usage.TotalTokens = ollamaResponse.PromptEvalCount + ollamaResponse.EvalCount
 			}
 			response := streamResponseOllama2OpenAI(&ollamaResponse)
+			response.Id = responseId
+			response.Created = createdTime
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -149,7 +153,7 @@ func ConvertEmbeddingRequest(request model.GeneralOpenAIRequest) *EmbeddingReque
 	}
 }
 
-func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+func EmbeddingHandler(c *gin.Context, resp *http.Response, promptTokens int) (*model.ErrorWithStatusCode, *model.Usage) {
 	var ollamaResponse EmbeddingResponse
 	err := json.NewDecoder(resp.Body).Decode(&ollamaResponse)
 	if err != nil {
@@ -174,6 +178,7 @@ func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStat
 	}
 
 	fullTextResponse := embeddingResponseOllama2OpenAI(&ollamaResponse)
+	openai.FillEmbeddingUsage(&fullTextResponse.Usage, promptTokens)
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -186,14 +191,14 @@ func EmbeddingHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStat
 
 func embeddingResponseOllama2OpenAI(response *EmbeddingResponse) *openai.EmbeddingResponse {
 	openAIEmbeddingResponse := openai.EmbeddingResponse{
-		Object: "list",
+		Object: openai.ObjectList,
 		Data:   make([]openai.EmbeddingResponseItem, 0, 1),
 		Model:  "text-embedding-v1",
 		Usage:  model.Usage{TotalTokens: 0},
 	}
 
 	openAIEmbeddingResponse.Data = append(openAIEmbeddingResponse.Data, openai.EmbeddingResponseItem{
-		Object:    `embedding`,
+		Object:    openai.ObjectEmbedding,
 		Index:     0,
 		Embedding: response.Embedding,
 	})
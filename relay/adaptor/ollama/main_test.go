@@ -0,0 +1,19 @@
+package ollama
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseOllama2OpenAIIdAndCreated(t *testing.T) {
+	response := responseOllama2OpenAI(&ChatResponse{Model: "llama3", Message: Message{Role: "assistant", Content: "hi"}, Done: true})
+	assert.True(t, strings.HasPrefix(response.Id, openai.ResponseIdPrefix))
+	assert.Greater(t, response.Created, int64(0))
+
+	streamResponse := streamResponseOllama2OpenAI(&ChatResponse{Model: "llama3", Message: Message{Content: "hi"}})
+	assert.True(t, strings.HasPrefix(streamResponse.Id, openai.ResponseIdPrefix))
+	assert.Greater(t, streamResponse.Created, int64(0))
+}
@@ -4,8 +4,11 @@ import (
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/minimax"
+	"github.com/songquanpeng/one-api/relay/adaptor/mistral"
+	"github.com/songquanpeng/one-api/relay/adaptor/perplexity"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -45,6 +48,8 @@ func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 		return GetFullRequestURL(meta.BaseURL, requestURL, meta.ChannelType), nil
 	case channeltype.Minimax:
 		return minimax.GetRequestURL(meta)
+	case channeltype.Perplexity:
+		return perplexity.GetRequestURL(meta)
 	default:
 		return GetFullRequestURL(meta.BaseURL, meta.RequestURLPath, meta.ChannelType), nil
 	}
@@ -52,6 +57,14 @@ func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
 
 func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) error {
 	adaptor.SetupCommonRequestHeader(c, req, meta)
+	// beta features like assistants require this header, the channel config takes precedence
+	openAIBeta := c.GetString(ctxkey.ConfigOpenAIBeta)
+	if openAIBeta == "" {
+		openAIBeta = c.Request.Header.Get("OpenAI-Beta")
+	}
+	if openAIBeta != "" {
+		req.Header.Set("OpenAI-Beta", openAIBeta)
+	}
 	if meta.ChannelType == channeltype.Azure {
 		req.Header.Set("api-key", meta.APIKey)
 		return nil
@@ -68,6 +81,9 @@ func (a *Adaptor) ConvertRequest(c *gin.Context, relayMode int, request *model.G
 	if request == nil {
 		return nil, errors.New("request is nil")
 	}
+	if a.ChannelType == channeltype.Mistral && relayMode == relaymode.ChatCompletions {
+		return mistral.ConvertRequest(c, *request), nil
+	}
 	return request, nil
 }
 
@@ -83,7 +99,10 @@ func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Read
 }
 
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
-	if meta.IsStream {
+	if meta.IsStream && IsJSON(resp.Header.Get("Content-Type")) {
+		// the upstream failed to establish a stream and answered with a completion or an error object
+		err, usage = NonStreamFallbackHandler(c, resp, meta.Mode, meta.PromptTokens, meta.ActualModelName)
+	} else if meta.IsStream {
 		var responseText string
 		err, responseText, usage = StreamHandler(c, resp, meta.Mode)
 		if usage == nil {
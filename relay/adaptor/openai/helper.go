@@ -2,11 +2,100 @@ package openai
 
 import (
 	"fmt"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/model"
+	"mime"
 	"strings"
 )
 
+// ResponseIdPrefix is the prefix of chat completion ids, some sdks rely on it
+const ResponseIdPrefix = "chatcmpl-"
+
+// GenerateResponseId returns a new chat completion id, for upstreams which don't provide one,
+// a stream must use the same id for all its chunks
+func GenerateResponseId() string {
+	return ResponseIdPrefix + random.GetUUID()
+}
+
+// NormalizeResponseId prefixes the id given by the upstream like openai does, an empty id is replaced by a new one
+func NormalizeResponseId(id string) string {
+	if id == "" {
+		return GenerateResponseId()
+	}
+	if strings.HasPrefix(id, ResponseIdPrefix) {
+		return id
+	}
+	return ResponseIdPrefix + id
+}
+
+// IsReasoningModel reports whether the model is one of the reasoning models, which accept reasoning_effort,
+// the models are told by the prefixes of REASONING_MODEL_PREFIXES
+func IsReasoningModel(modelName string) bool {
+	for _, prefix := range strings.Split(config.ReasoningModelPrefixes, ",") {
+		prefix = strings.TrimSpace(prefix)
+		if prefix != "" && strings.HasPrefix(modelName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// NormalizeCreated returns the creation time given by the upstream, or now if there is none
+// or CREATED_TIMESTAMP_SOURCE is gateway
+func NormalizeCreated(created int64) int64 {
+	if created <= 0 || config.CreatedTimestampSource == config.CreatedTimestampSourceGateway {
+		return helper.GetTimestamp()
+	}
+	return created
+}
+
+// IsEventStream tells whether the content type is the one of a server-sent event stream,
+// the media type is case-insensitive and may have parameters like the charset
+func IsEventStream(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "text/event-stream"
+}
+
+// IsJSON tells whether the content type is the one of a JSON document, like IsEventStream
+func IsJSON(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	return err == nil && mediaType == "application/json"
+}
+
+// FillEmbeddingUsage falls back to the input tokens counted by the gateway when the upstream doesn't report the usage of embeddings
+func FillEmbeddingUsage(usage *model.Usage, promptTokens int) {
+	if usage.PromptTokens != 0 || usage.TotalTokens != 0 {
+		return
+	}
+	usage.PromptTokens = promptTokens
+	usage.TotalTokens = promptTokens
+}
+
+// SetAssistantRole sets the role on the deltas of the chunk which have none, translated streams call it on their first chunk,
+// as openai sends the role with the first chunk and some strict clients rely on it
+func SetAssistantRole(response *ChatCompletionsStreamResponse) {
+	for i := range response.Choices {
+		if response.Choices[i].Delta.Role == "" {
+			response.Choices[i].Delta.Role = "assistant"
+		}
+	}
+}
+
+// ToolCallsText returns the names and arguments of the tool calls, which are billed as completion tokens
+func ToolCallsText(toolCalls []model.Tool) string {
+	var text string
+	for _, toolCall := range toolCalls {
+		text += toolCall.Function.Name
+		if arguments, ok := toolCall.Function.Arguments.(string); ok {
+			text += arguments
+		}
+	}
+	return text
+}
+
 func ResponseText2Usage(responseText string, modeName string, promptTokens int) *model.Usage {
 	usage := &model.Usage{}
 	usage.PromptTokens = promptTokens
@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeResponseId(t *testing.T) {
+	assert.Equal(t, "chatcmpl-123", NormalizeResponseId("chatcmpl-123"))
+	assert.Equal(t, "chatcmpl-abc", NormalizeResponseId("abc"))
+	id := NormalizeResponseId("")
+	assert.True(t, strings.HasPrefix(id, ResponseIdPrefix))
+	assert.Greater(t, len(id), len(ResponseIdPrefix))
+	assert.NotEqual(t, id, GenerateResponseId())
+}
+
+func TestNormalizeCreated(t *testing.T) {
+	assert.Equal(t, int64(1700000000), NormalizeCreated(1700000000))
+	assert.Greater(t, NormalizeCreated(0), int64(0))
+}
+
+func TestIsReasoningModel(t *testing.T) {
+	assert.True(t, IsReasoningModel("o3-mini"))
+	assert.False(t, IsReasoningModel("gpt-4o"))
+	defer func(prefixes string) { config.ReasoningModelPrefixes = prefixes }(config.ReasoningModelPrefixes)
+	config.ReasoningModelPrefixes = "o1, gpt-5"
+	assert.True(t, IsReasoningModel("gpt-5-mini"))
+	assert.False(t, IsReasoningModel("o3-mini"))
+}
+
+func TestIsEventStream(t *testing.T) {
+	assert.True(t, IsEventStream("text/event-stream"))
+	assert.True(t, IsEventStream("Text/Event-Stream; charset=utf-8"))
+	assert.False(t, IsEventStream("application/json"))
+	assert.False(t, IsEventStream("text/event-streaming"))
+	assert.False(t, IsEventStream(""))
+	assert.True(t, IsJSON("application/json; charset=utf-8"))
+	assert.False(t, IsJSON("text/plain"))
+}
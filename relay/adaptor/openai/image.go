@@ -4,7 +4,10 @@ import (
 	"bytes"
 	"encoding/json"
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 	"io"
 	"net/http"
 )
@@ -24,12 +27,13 @@ func ImageHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCo
 	if err != nil {
 		return ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
 	}
+	if c.GetString(ctxkey.ConfigStripExtraFields) == "true" {
+		responseBody = NormalizeResponseBody(responseBody, c.GetInt(ctxkey.Channel), relaymode.ImagesGenerations, false)
+	}
 
 	resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
 
-	for k, v := range resp.Header {
-		c.Writer.Header().Set(k, v[0])
-	}
+	adaptor.CopyResponseHeaders(c, resp)
 	c.Writer.WriteHeader(resp.StatusCode)
 
 	_, err = io.Copy(c.Writer, resp.Body)
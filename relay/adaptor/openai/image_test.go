@@ -0,0 +1,30 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestImageHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := `{"created":1700000000,"data":[` +
+		`{"url":"https://example.com/1.png","revised_prompt":"A red fox in the snow, digital art"},` +
+		`{"b64_json":"aGVsbG8=","revised_prompt":"A red fox in the snow, watercolor"}]}`
+	resp := newJSONResponse(body)
+
+	bizErr, _ := ImageHandler(c, resp)
+	assert.Nil(t, bizErr)
+	assert.JSONEq(t, body, w.Body.String())
+
+	var imageResponse ImageResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &imageResponse))
+	assert.Equal(t, []ImageData{
+		{Url: "https://example.com/1.png", RevisedPrompt: "A red fox in the snow, digital art"},
+		{B64Json: "aGVsbG8=", RevisedPrompt: "A red fox in the snow, watercolor"},
+	}, imageResponse.Data)
+}
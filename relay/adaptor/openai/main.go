@@ -1,13 +1,19 @@
 package openai
 
 import (
-	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/conv"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 	"io"
@@ -23,7 +29,7 @@ const (
 
 func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.ErrorWithStatusCode, string, *model.Usage) {
 	responseText := ""
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -39,6 +45,19 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
 	var usage *model.Usage
+	// set when the upstream fails after the stream has started, what was streamed so far is still billed
+	var streamErr *model.Error
+	var schema responseSchema
+	if c.GetString(ctxkey.ConfigStripExtraFields) == "true" {
+		schema = getChannelResponseSchema(c.GetInt(ctxkey.Channel), relayMode, true)
+	}
+	responseModel := c.GetString(ctxkey.ResponseModel)
+	createdTime := helper.GetTimestamp()
+	choiceIndices := make(map[int]bool)
+	var toolCallFilter *ToolCallStreamFilter
+	if c.GetBool(ctxkey.KeepFirstToolCall) {
+		toolCallFilter = NewToolCallStreamFilter()
+	}
 	go func() {
 		for scanner.Scan() {
 			data := scanner.Text()
@@ -52,8 +71,15 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 				dataChan <- data
 				continue
 			}
+			if upstreamErr := parseStreamError(data[dataPrefixLength:]); upstreamErr != nil {
+				streamErr = upstreamErr
+				break
+			}
 			switch relayMode {
 			case relaymode.ChatCompletions:
+				if toolCallFilter != nil {
+					data = toolCallFilter.Filter(data)
+				}
 				var streamResponse ChatCompletionsStreamResponse
 				err := json.Unmarshal([]byte(data[dataPrefixLength:]), &streamResponse)
 				if err != nil {
@@ -65,15 +91,20 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 					// but for empty choice, we should not pass it to client, this is for azure
 					continue // just ignore empty choice
 				}
-				dataChan <- data
+				dataChan <- NormalizeStreamFields(normalizeStreamData(data, schema), relayMode, responseModel, createdTime)
 				for _, choice := range streamResponse.Choices {
-					responseText += conv.AsString(choice.Delta.Content)
+					choiceIndices[choice.Index] = true
+					// a model calling tools may stream no content at all
+					responseText += conv.AsString(choice.Delta.Content) + ToolCallsText(choice.Delta.ToolCalls)
 				}
 				if streamResponse.Usage != nil {
 					usage = streamResponse.Usage
 				}
+				if streamResponse.ServiceTier != "" {
+					c.Set(ctxkey.ServiceTier, streamResponse.ServiceTier)
+				}
 			case relaymode.Completions:
-				dataChan <- data
+				dataChan <- NormalizeStreamFields(normalizeStreamData(data, schema), relayMode, responseModel, createdTime)
 				var streamResponse CompletionsStreamResponse
 				err := json.Unmarshal([]byte(data[dataPrefixLength:]), &streamResponse)
 				if err != nil {
@@ -85,8 +116,17 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 				}
 			}
 		}
+		// a stream cut at MAX_STREAM_DURATION is reported by the relay controller
+		if err := scanner.Err(); err != nil && streamErr == nil && !errors.Is(c.Request.Context().Err(), context.DeadlineExceeded) {
+			streamErr = &model.Error{
+				Message: "upstream stream interrupted: " + err.Error(),
+				Type:    "upstream_error",
+				Code:    "stream_interrupted",
+			}
+		}
 		stopChan <- true
 	}()
+	adaptor.CopyResponseHeaders(c, resp)
 	common.SetEventStreamHeaders(c)
 	c.Stream(func(w io.Writer) bool {
 		select {
@@ -99,16 +139,204 @@ func StreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*model.E
 			c.Render(-1, common.CustomEvent{Data: data})
 			return true
 		case <-stopChan:
+			if streamErr != nil {
+				c.Render(-1, common.CustomEvent{Data: "data: " + streamErrorChunk(*streamErr)})
+				c.Render(-1, common.CustomEvent{Data: "data: " + done})
+			}
 			return false
 		}
 	})
 	err := resp.Body.Close()
+	if streamErr != nil {
+		// the client already got part of the answer, so bill it instead of failing the request,
+		// the relay controller reports the error against the channel afterwards
+		logger.WarnWithFields(c.Request.Context(), "upstream stream failed after partial output: "+streamErr.Message, logger.Fields{
+			"channel_id": c.GetInt(ctxkey.ChannelId),
+		})
+		c.Set(ctxkey.UpstreamStreamError, &model.ErrorWithStatusCode{
+			Error:      *streamErr,
+			StatusCode: http.StatusBadGateway,
+		})
+		return nil, responseText, usage
+	}
 	if err != nil {
 		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
+	if relayMode == relaymode.ChatCompletions {
+		checkChoiceCount(c, len(choiceIndices))
+	}
 	return nil, responseText, usage
 }
 
+// checkChoiceCount logs when the upstream returns fewer choices than the n of the request, as some of them cap n,
+// the available choices are returned and billed as usual
+func checkChoiceCount(c *gin.Context, returned int) {
+	requested := c.GetInt(ctxkey.RequestedChoices)
+	if requested > 1 && returned < requested {
+		logger.Warnf(c.Request.Context(), "%d choices are requested but channel #%d returned %d", requested, c.GetInt(ctxkey.ChannelId), returned)
+	}
+}
+
+// parseStreamError returns the error carried by a stream chunk, if any
+func parseStreamError(data string) *model.Error {
+	if !strings.Contains(data, `"error"`) {
+		return nil
+	}
+	var errorResponse struct {
+		Error *model.Error `json:"error"`
+	}
+	err := json.Unmarshal([]byte(strings.TrimSuffix(data, "\r")), &errorResponse)
+	if err != nil || errorResponse.Error == nil {
+		return nil
+	}
+	if errorResponse.Error.Message == "" && errorResponse.Error.Type == "" {
+		return nil
+	}
+	return errorResponse.Error
+}
+
+// normalizeStreamData removes the obfuscation padding added by openai to each chunk when configured,
+// or all the fields which are not in the schema of the chunks if any,
+// lines other than data ones, e.g. comments, are already dropped by the stream handler
+func normalizeStreamData(data string, schema responseSchema) string {
+	if schema != nil {
+		chunk := []byte(strings.TrimSuffix(data[dataPrefixLength:], "\r"))
+		return dataPrefix + string(stripResponseBody(chunk, schema))
+	}
+	if !config.StripStreamObfuscation || !strings.Contains(data, `"obfuscation"`) {
+		return data
+	}
+	var chunk map[string]json.RawMessage
+	err := json.Unmarshal([]byte(strings.TrimSuffix(data[dataPrefixLength:], "\r")), &chunk)
+	if err != nil {
+		return data
+	}
+	if _, ok := chunk["obfuscation"]; !ok {
+		return data
+	}
+	delete(chunk, "obfuscation")
+	jsonData, err := json.Marshal(chunk)
+	if err != nil {
+		return data
+	}
+	return dataPrefix + string(jsonData)
+}
+
+// NonStreamFallbackHandler handles a stream request answered with a JSON document instead of an event stream,
+// an error object is returned as an error and a chat completion or a completion is replayed to the client as a single chunk
+func NonStreamFallbackHandler(c *gin.Context, resp *http.Response, relayMode int, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var textResponse struct {
+		TextResponse
+		Error model.Error `json:"error"`
+	}
+	err = json.Unmarshal(responseBody, &textResponse)
+	if err != nil {
+		return ErrorWrapper(fmt.Errorf("upstream failed to establish a stream: %s", string(responseBody)), "bad_stream_response", http.StatusBadGateway), nil
+	}
+	if textResponse.Error.Message != "" || textResponse.Error.Type != "" {
+		return &model.ErrorWithStatusCode{
+			Error:      textResponse.Error,
+			StatusCode: http.StatusBadGateway,
+		}, nil
+	}
+	if (relayMode != relaymode.ChatCompletions && relayMode != relaymode.Completions) || len(textResponse.Choices) == 0 {
+		return ErrorWrapper(errors.New("upstream failed to establish a stream"), "bad_stream_response", http.StatusBadGateway), nil
+	}
+	if relayMode == relaymode.Completions {
+		return completionsFallback(c, responseBody, textResponse.TextResponse, promptTokens, modelName)
+	}
+	streamResponse := ChatCompletionsStreamResponse{
+		Id:          textResponse.Id,
+		Object:      ObjectChatCompletionChunk,
+		Created:     NormalizeCreated(textResponse.Created),
+		Model:       ResponseModelName(c, textResponse.Model),
+		ServiceTier: textResponse.ServiceTier,
+	}
+	responseText := ""
+	for _, choice := range textResponse.Choices {
+		if c.GetBool(ctxkey.KeepFirstToolCall) {
+			choice.Message.ToolCalls = keepFirstTool(choice.Message.ToolCalls)
+		}
+		finishReason := choice.FinishReason
+		streamResponse.Choices = append(streamResponse.Choices, ChatCompletionsStreamResponseChoice{
+			Index:        choice.Index,
+			Delta:        choice.Message,
+			FinishReason: &finishReason,
+		})
+		responseText += choice.Message.StringContent() + ToolCallsText(choice.Message.ToolCalls)
+	}
+	SetAssistantRole(&streamResponse)
+	usage := &textResponse.Usage
+	if usage.TotalTokens == 0 {
+		usage = ResponseText2Usage(responseText, modelName, promptTokens)
+	}
+	streamResponse.Usage = usage
+	jsonResponse, err := json.Marshal(streamResponse)
+	if err != nil {
+		return ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	renderFallbackChunk(c, jsonResponse)
+	return nil, usage
+}
+
+// completionsFallback replays a completion as a single chunk, the chunks of the completions
+// have the shape of the completion itself
+func completionsFallback(c *gin.Context, responseBody []byte, textResponse TextResponse, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
+	var completionResponse struct {
+		Choices []struct {
+			Index        int    `json:"index"`
+			Text         string `json:"text"`
+			FinishReason string `json:"finish_reason"`
+		} `json:"choices"`
+	}
+	err := json.Unmarshal(responseBody, &completionResponse)
+	if err != nil {
+		return ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	responseText := ""
+	for _, choice := range completionResponse.Choices {
+		responseText += choice.Text
+	}
+	usage := &textResponse.Usage
+	if usage.TotalTokens == 0 {
+		usage = ResponseText2Usage(responseText, modelName, promptTokens)
+	}
+	jsonResponse, err := json.Marshal(gin.H{
+		"id":      textResponse.Id,
+		"object":  "text_completion",
+		"created": NormalizeCreated(textResponse.Created),
+		"model":   ResponseModelName(c, textResponse.Model),
+		"choices": completionResponse.Choices,
+		"usage":   usage,
+	})
+	if err != nil {
+		return ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	renderFallbackChunk(c, jsonResponse)
+	return nil, usage
+}
+
+func renderFallbackChunk(c *gin.Context, jsonResponse []byte) {
+	common.SetEventStreamHeaders(c)
+	c.Render(-1, common.CustomEvent{Data: dataPrefix + string(jsonResponse)})
+	c.Render(-1, common.CustomEvent{Data: dataPrefix + done})
+}
+
+func streamErrorChunk(err model.Error) string {
+	errorChunk, _ := json.Marshal(gin.H{
+		"error": err,
+	})
+	return string(errorChunk)
+}
+
 func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName string) (*model.ErrorWithStatusCode, *model.Usage) {
 	var textResponse SlimTextResponse
 	responseBody, err := io.ReadAll(resp.Body)
@@ -129,6 +357,28 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 			StatusCode: resp.StatusCode,
 		}, nil
 	}
+	relayMode := relaymode.GetByPath(c.Request.URL.Path)
+	if relayMode == relaymode.ChatCompletions || relayMode == relaymode.Completions {
+		checkChoiceCount(c, len(textResponse.Choices))
+	}
+	if textResponse.ServiceTier != "" {
+		c.Set(ctxkey.ServiceTier, textResponse.ServiceTier)
+	}
+	if c.GetString(ctxkey.ConfigStripExtraFields) == "true" {
+		responseBody = NormalizeResponseBody(responseBody, c.GetInt(ctxkey.Channel), relayMode, false)
+	}
+	responseBody = NormalizeResponseFields(responseBody, relayMode, false, c.GetString(ctxkey.ResponseModel), helper.GetTimestamp())
+	if relayMode == relaymode.ChatCompletions && c.GetBool(ctxkey.KeepFirstToolCall) {
+		responseBody = KeepFirstToolCall(responseBody)
+		for i := range textResponse.Choices {
+			textResponse.Choices[i].Message.ToolCalls = keepFirstTool(textResponse.Choices[i].Message.ToolCalls)
+		}
+	}
+	if relayMode == relaymode.Embeddings && textResponse.Usage.TotalTokens == 0 {
+		// the client gets the usage it is billed for
+		FillEmbeddingUsage(&textResponse.Usage, promptTokens)
+		responseBody = setResponseUsage(responseBody, &textResponse.Usage)
+	}
 	// Reset response body
 	resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
 
@@ -136,9 +386,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	// And then we will have to send an error response, but in this case, the header has already been set.
 	// So the HTTPClient will be confused by the response.
 	// For example, Postman will report error, and we cannot check the response at all.
-	for k, v := range resp.Header {
-		c.Writer.Header().Set(k, v[0])
-	}
+	adaptor.CopyResponseHeaders(c, resp)
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = io.Copy(c.Writer, resp.Body)
 	if err != nil {
@@ -152,7 +400,7 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	if textResponse.Usage.TotalTokens == 0 {
 		completionTokens := 0
 		for _, choice := range textResponse.Choices {
-			completionTokens += CountTokenText(choice.Message.StringContent(), modelName)
+			completionTokens += CountTokenText(choice.Message.StringContent()+ToolCallsText(choice.Message.ToolCalls), modelName)
 		}
 		textResponse.Usage = model.Usage{
 			PromptTokens:     promptTokens,
@@ -162,3 +410,21 @@ func Handler(c *gin.Context, resp *http.Response, promptTokens int, modelName st
 	}
 	return nil, &textResponse.Usage
 }
+
+// setResponseUsage replaces the usage of the response body, the body is returned as is if it isn't a json object
+func setResponseUsage(responseBody []byte, usage *model.Usage) []byte {
+	var response map[string]json.RawMessage
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return responseBody
+	}
+	usageJSON, err := json.Marshal(usage)
+	if err != nil {
+		return responseBody
+	}
+	response["usage"] = usageJSON
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		return responseBody
+	}
+	return jsonResponse
+}
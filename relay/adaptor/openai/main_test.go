@@ -0,0 +1,279 @@
+package openai
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+)
+
+func newJSONResponse(body string) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+}
+
+func TestNonStreamFallbackHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	resp := newJSONResponse(`{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"gpt-3.5-turbo",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`)
+
+	bizErr, usage := NonStreamFallbackHandler(c, resp, relaymode.ChatCompletions, 9, "gpt-3.5-turbo")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 11, usage.TotalTokens)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, `data: {"id":"chatcmpl-123","object":"chat.completion.chunk"`)
+	assert.Contains(t, body, `"delta":{"role":"assistant","content":"Hello!"}`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestNonStreamFallbackHandlerWithCompletions(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	resp := newJSONResponse(`{"id":"cmpl-123","object":"text_completion","created":1700000000,"model":"gpt-3.5-turbo-instruct",` +
+		`"choices":[{"index":0,"text":"Hello!","finish_reason":"stop"}],"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`)
+
+	bizErr, usage := NonStreamFallbackHandler(c, resp, relaymode.Completions, 9, "gpt-3.5-turbo-instruct")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 11, usage.TotalTokens)
+	assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+	body := w.Body.String()
+	assert.Contains(t, body, `data: {"choices":[{"index":0,"text":"Hello!","finish_reason":"stop"}],"created":1700000000,"id":"cmpl-123"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestNonStreamFallbackHandlerWithError(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	resp := newJSONResponse(`{"error":{"message":"The server is overloaded","type":"server_error","code":null}}`)
+
+	bizErr, usage := NonStreamFallbackHandler(c, resp, relaymode.ChatCompletions, 9, "gpt-3.5-turbo")
+	assert.NotNil(t, bizErr)
+	assert.Nil(t, usage)
+	assert.Equal(t, http.StatusBadGateway, bizErr.StatusCode)
+	assert.Equal(t, "The server is overloaded", bizErr.Error.Message)
+	// nothing should have been streamed to the client
+	assert.Empty(t, w.Body.String())
+}
+
+// closeNotifyRecorder is a response recorder usable by gin.Context.Stream
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+// brokenReader returns its data, then fails like a connection dropped by the upstream
+type brokenReader struct {
+	data io.Reader
+}
+
+func (r *brokenReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		return n, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func newStreamContext() (*gin.Context, *closeNotifyRecorder) {
+	w := &closeNotifyRecorder{httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set(ctxkey.ChannelId, 7)
+	return c, w
+}
+
+func newStreamResponse(body io.Reader) *http.Response {
+	header := make(http.Header)
+	header.Set("Content-Type", "text/event-stream")
+	return &http.Response{
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       io.NopCloser(body),
+	}
+}
+
+const partialStream = `data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{"content":"Hello"}}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"gpt-3.5-turbo","choices":[{"index":0,"delta":{"content":" world"}}]}
+
+`
+
+func TestStreamHandlerWithUpstreamErrorEvent(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newStreamResponse(strings.NewReader(partialStream +
+		`data: {"error":{"message":"The server had an error while processing your request","type":"server_error","code":null}}` + "\n\n"))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "Hello world", responseText)
+	body := w.Body.String()
+	assert.Contains(t, body, `"content":" world"`)
+	assert.Contains(t, body, `data: {"error":{"message":"The server had an error while processing your request","type":"server_error"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+
+	value, ok := c.Get(ctxkey.UpstreamStreamError)
+	assert.True(t, ok)
+	streamErr := value.(*model.ErrorWithStatusCode)
+	assert.Equal(t, http.StatusBadGateway, streamErr.StatusCode)
+	assert.Equal(t, "server_error", streamErr.Type)
+}
+
+func TestStreamHandlerWithUpstreamDisconnect(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newStreamResponse(&brokenReader{data: strings.NewReader(partialStream)})
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	// what was streamed before the failure is returned so that it can be billed
+	assert.Equal(t, "Hello world", responseText)
+	body := w.Body.String()
+	assert.Contains(t, body, `"code":"stream_interrupted"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+
+	value, ok := c.Get(ctxkey.UpstreamStreamError)
+	assert.True(t, ok)
+	assert.Equal(t, "upstream_error", value.(*model.ErrorWithStatusCode).Type)
+}
+
+func TestStreamHandlerCompleted(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newStreamResponse(strings.NewReader(partialStream + "data: [DONE]\n\n"))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "Hello world", responseText)
+	assert.NotContains(t, w.Body.String(), `"error"`)
+	_, ok := c.Get(ctxkey.UpstreamStreamError)
+	assert.False(t, ok)
+}
+
+func TestHandlerEmbeddingsWithoutUsage(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/embeddings", nil)
+	resp := newJSONResponse(`{"object":"list","data":[{"object":"embedding","embedding":[0.1,0.2],"index":0}],"model":"bge-m3"}`)
+
+	bizErr, usage := Handler(c, resp, 5, "bge-m3")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 5, usage.PromptTokens)
+	assert.Equal(t, 5, usage.TotalTokens)
+	assert.Contains(t, w.Body.String(), `"usage":{"prompt_tokens":5,"completion_tokens":0,"total_tokens":5}`)
+	assert.Contains(t, w.Body.String(), `"embedding":[0.1,0.2]`)
+
+	// the usage reported by the upstream is kept
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/embeddings", nil)
+	resp = newJSONResponse(`{"object":"list","data":[],"model":"bge-m3","usage":{"prompt_tokens":3,"total_tokens":3}}`)
+	bizErr, usage = Handler(c, resp, 5, "bge-m3")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 3, usage.TotalTokens)
+	assert.Contains(t, w.Body.String(), `"usage":{"prompt_tokens":3,"total_tokens":3}`)
+}
+
+const toolCallStream = `data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":null,"tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":"}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"\"Paris\"}"}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`
+
+func TestStreamHandlerWithToolCallsOnly(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newStreamResponse(strings.NewReader(toolCallStream))
+
+	bizErr, responseText, usage := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	assert.Nil(t, usage)
+	// the tool calls are billed as completion tokens although there is no content
+	assert.Equal(t, `get_weather{"city":"Paris"}`, responseText)
+	body := w.Body.String()
+	assert.Contains(t, body, `"finish_reason":"tool_calls"`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestHandlerRestoresRequestedModel(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set(ctxkey.ResponseModel, "gpt-4")
+	resp := newJSONResponse(`{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"my-gpt4-deployment",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`)
+
+	bizErr, usage := Handler(c, resp, 9, "my-gpt4-deployment")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 11, usage.TotalTokens)
+	assert.Contains(t, w.Body.String(), `"model":"gpt-4"`)
+	assert.NotContains(t, w.Body.String(), "my-gpt4-deployment")
+}
+
+func TestStreamHandlerRestoresRequestedModel(t *testing.T) {
+	c, w := newStreamContext()
+	c.Set(ctxkey.ResponseModel, "gpt-4")
+	resp := newStreamResponse(strings.NewReader(strings.ReplaceAll(partialStream, "gpt-3.5-turbo", "my-gpt4-deployment") + "data: [DONE]\n\n"))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "Hello world", responseText)
+	body := w.Body.String()
+	assert.Equal(t, 2, strings.Count(body, `"model":"gpt-4"`))
+	assert.NotContains(t, body, "my-gpt4-deployment")
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+}
+
+func TestHandlerWithFewerChoicesThanRequested(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set(ctxkey.RequestedChoices, 3)
+	body := `{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"gpt-3.5-turbo",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`
+	resp := newJSONResponse(body)
+
+	// only the returned choice is billed, and it is returned as is
+	bizErr, usage := Handler(c, resp, 9, "gpt-3.5-turbo")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 2, usage.CompletionTokens)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, body, w.Body.String())
+}
+
+func TestHandlerCapturesServiceTier(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	body := `{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"o3","service_tier":"flex",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"Hello!"},"finish_reason":"stop"}],` +
+		`"usage":{"prompt_tokens":9,"completion_tokens":2,"total_tokens":11}}`
+	resp := newJSONResponse(body)
+
+	bizErr, _ := Handler(c, resp, 9, "o3")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "flex", c.GetString(ctxkey.ServiceTier))
+	assert.JSONEq(t, body, w.Body.String())
+}
@@ -78,6 +78,7 @@ type UsageOrResponseText struct {
 
 type SlimTextResponse struct {
 	Choices     []TextResponseChoice `json:"choices"`
+	ServiceTier string               `json:"service_tier,omitempty"`
 	model.Usage `json:"usage"`
 	Error       model.Error `json:"error"`
 }
@@ -94,6 +95,7 @@ type TextResponse struct {
 	Object      string               `json:"object"`
 	Created     int64                `json:"created"`
 	Choices     []TextResponseChoice `json:"choices"`
+	ServiceTier string               `json:"service_tier,omitempty"`
 	model.Usage `json:"usage"`
 }
 
@@ -129,12 +131,13 @@ type ChatCompletionsStreamResponseChoice struct {
 }
 
 type ChatCompletionsStreamResponse struct {
-	Id      string                                `json:"id"`
-	Object  string                                `json:"object"`
-	Created int64                                 `json:"created"`
-	Model   string                                `json:"model"`
-	Choices []ChatCompletionsStreamResponseChoice `json:"choices"`
-	Usage   *model.Usage                          `json:"usage"`
+	Id          string                                `json:"id"`
+	Object      string                                `json:"object"`
+	Created     int64                                 `json:"created"`
+	Model       string                                `json:"model"`
+	Choices     []ChatCompletionsStreamResponseChoice `json:"choices"`
+	ServiceTier string                                `json:"service_tier,omitempty"`
+	Usage       *model.Usage                          `json:"usage"`
 }
 
 type CompletionsStreamResponse struct {
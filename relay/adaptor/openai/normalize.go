@@ -0,0 +1,361 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/adaptor/perplexity"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// responseSchema lists the fields of an openai response object kept by the normalizer,
+// the schema of a field applies to each item of an array, and a nil schema keeps the value as is
+type responseSchema map[string]responseSchema
+
+var toolCallSchema = responseSchema{
+	"id":    nil,
+	"type":  nil,
+	"index": nil,
+	"function": {
+		"name":      nil,
+		"arguments": nil,
+	},
+}
+
+var messageSchema = responseSchema{
+	"role":          nil,
+	"content":       nil,
+	"name":          nil,
+	"refusal":       nil,
+	"tool_calls":    toolCallSchema,
+	"function_call": toolCallSchema["function"],
+}
+
+var usageSchema = responseSchema{
+	"prompt_tokens":             nil,
+	"completion_tokens":         nil,
+	"total_tokens":              nil,
+	"prompt_tokens_details":     nil,
+	"completion_tokens_details": nil,
+}
+
+// https://platform.openai.com/docs/api-reference/chat/object
+var chatCompletionSchema = responseSchema{
+	"id":                 nil,
+	"object":             nil,
+	"created":            nil,
+	"model":              nil,
+	"system_fingerprint": nil,
+	"service_tier":       nil,
+	"usage":              usageSchema,
+	"choices": {
+		"index":         nil,
+		"message":       messageSchema,
+		"logprobs":      nil,
+		"finish_reason": nil,
+	},
+}
+
+// https://platform.openai.com/docs/api-reference/chat/streaming
+var chatCompletionChunkSchema = responseSchema{
+	"id":                 nil,
+	"object":             nil,
+	"created":            nil,
+	"model":              nil,
+	"system_fingerprint": nil,
+	"service_tier":       nil,
+	"usage":              usageSchema,
+	"choices": {
+		"index":         nil,
+		"delta":         messageSchema,
+		"logprobs":      nil,
+		"finish_reason": nil,
+	},
+}
+
+// https://platform.openai.com/docs/api-reference/completions/object
+var completionSchema = responseSchema{
+	"id":                 nil,
+	"object":             nil,
+	"created":            nil,
+	"model":              nil,
+	"system_fingerprint": nil,
+	"usage":              usageSchema,
+	"choices": {
+		"text":          nil,
+		"index":         nil,
+		"logprobs":      nil,
+		"finish_reason": nil,
+	},
+}
+
+// https://platform.openai.com/docs/api-reference/embeddings/object
+var embeddingSchema = responseSchema{
+	"object": nil,
+	"model":  nil,
+	"usage":  usageSchema,
+	"data": {
+		"object":    nil,
+		"embedding": nil,
+		"index":     nil,
+	},
+}
+
+// https://platform.openai.com/docs/api-reference/moderations/object
+var moderationSchema = responseSchema{
+	"id":    nil,
+	"model": nil,
+	"results": {
+		"flagged":                      nil,
+		"categories":                   nil,
+		"category_scores":              nil,
+		"category_applied_input_types": nil,
+	},
+}
+
+// the deprecated edits api, which returned a completion without id nor model
+var editSchema = responseSchema{
+	"object":  nil,
+	"created": nil,
+	"usage":   usageSchema,
+	"choices": {
+		"text":  nil,
+		"index": nil,
+	},
+}
+
+// https://platform.openai.com/docs/api-reference/images/object
+var imageSchema = responseSchema{
+	"created":       nil,
+	"background":    nil,
+	"output_format": nil,
+	"quality":       nil,
+	"size":          nil,
+	"usage":         nil,
+	"data": {
+		"url":            nil,
+		"b64_json":       nil,
+		"revised_prompt": nil,
+	},
+}
+
+// getResponseSchema returns the schema of the json responses of the relay mode, the audio responses may be text
+// and the assistants are relayed as is, so they have none
+func getResponseSchema(relayMode int, isStream bool) responseSchema {
+	switch relayMode {
+	case relaymode.ChatCompletions:
+		if isStream {
+			return chatCompletionChunkSchema
+		}
+		return chatCompletionSchema
+	case relaymode.Completions:
+		// the chunks of completions have the same shape as the completion
+		return completionSchema
+	case relaymode.Embeddings:
+		return embeddingSchema
+	case relaymode.Moderations:
+		return moderationSchema
+	case relaymode.Edits:
+		return editSchema
+	case relaymode.ImagesGenerations:
+		return imageSchema
+	}
+	return nil
+}
+
+// the extra top-level fields of some channels which are worth keeping, as clients use them
+var channelExtraResponseFields = map[int][]string{
+	channeltype.Perplexity: perplexity.ExtraResponseFields,
+}
+
+// getChannelResponseSchema extends the response schema of the relay mode with the extra fields of the channel
+func getChannelResponseSchema(channelType int, relayMode int, isStream bool) responseSchema {
+	schema := getResponseSchema(relayMode, isStream)
+	extraFields := channelExtraResponseFields[channelType]
+	if schema == nil || len(extraFields) == 0 {
+		return schema
+	}
+	extendedSchema := make(responseSchema, len(schema)+len(extraFields))
+	for k, v := range schema {
+		extendedSchema[k] = v
+	}
+	for _, field := range extraFields {
+		extendedSchema[field] = nil
+	}
+	return extendedSchema
+}
+
+func stripFields(value any, schema responseSchema) any {
+	if schema == nil {
+		return value
+	}
+	switch v := value.(type) {
+	case map[string]any:
+		for k, item := range v {
+			fieldSchema, ok := schema[k]
+			if !ok {
+				delete(v, k)
+				continue
+			}
+			v[k] = stripFields(item, fieldSchema)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = stripFields(item, schema)
+		}
+	}
+	return value
+}
+
+// NormalizeResponseBody strips the fields which are not part of the openai response of the relay mode,
+// for strict sdks which fail on the extra fields of some upstreams, the body is returned as is if it can't be handled
+func NormalizeResponseBody(body []byte, channelType int, relayMode int, isStream bool) []byte {
+	schema := getChannelResponseSchema(channelType, relayMode, isStream)
+	if schema == nil {
+		return body
+	}
+	return stripResponseBody(body, schema)
+}
+
+func stripResponseBody(body []byte, schema responseSchema) []byte {
+	response, ok := decodeObject(body)
+	if !ok {
+		return body
+	}
+	// an error object is not a response
+	if _, ok := response["error"]; ok {
+		return body
+	}
+	stripFields(response, schema)
+	return encodeObject(response, body)
+}
+
+// the objects of the responses, which the clients tell the responses apart by
+const (
+	ObjectChatCompletion      = "chat.completion"
+	ObjectChatCompletionChunk = "chat.completion.chunk"
+	ObjectTextCompletion      = "text_completion"
+	ObjectList                = "list"
+	ObjectEmbedding           = "embedding"
+)
+
+// ResponseObject returns the object of the responses of the relay mode, the chunks of a completions stream are
+// text_completion as well, it is empty for the modes whose responses have no object
+func ResponseObject(relayMode int, isStream bool) string {
+	switch relayMode {
+	case relaymode.ChatCompletions:
+		if isStream {
+			return ObjectChatCompletionChunk
+		}
+		return ObjectChatCompletion
+	case relaymode.Completions:
+		return ObjectTextCompletion
+	case relaymode.Embeddings:
+		return ObjectList
+	}
+	return ""
+}
+
+// ResponseModelName returns the model reported to the client, which is the model it asked for if the channel mapped it,
+// so that the deployment names of the channels don't leak to the clients
+func ResponseModelName(c *gin.Context, modelName string) string {
+	if responseModel := c.GetString(ctxkey.ResponseModel); responseModel != "" {
+		return responseModel
+	}
+	return modelName
+}
+
+// NormalizeResponseFields sets the fields of a response the clients rely on in a single pass: the object is set to
+// the one of the relay mode, as some upstreams leave it out or get it wrong, a missing or zero created is set to now,
+// as is any created if CREATED_TIMESTAMP_SOURCE is gateway, and the model is set to responseModel if it isn't empty,
+// the body is returned as is if it is already right or isn't a response
+func NormalizeResponseFields(body []byte, relayMode int, isStream bool, responseModel string, now int64) []byte {
+	object := ResponseObject(relayMode, isStream)
+	hasCreated := relayMode == relaymode.ChatCompletions || relayMode == relaymode.Completions
+	if object == "" && !hasCreated && responseModel == "" {
+		return body
+	}
+	// decoded rather than searched for, as the nested objects may have the same fields
+	response, ok := decodeObject(body)
+	if !ok {
+		return body
+	}
+	if _, ok := response["error"]; ok {
+		return body
+	}
+	changed := false
+	if object != "" && response["object"] != object {
+		response["object"] = object
+		changed = true
+	}
+	if relayMode == relaymode.Embeddings {
+		items, _ := response["data"].([]any)
+		for _, item := range items {
+			if item, ok := item.(map[string]any); ok && item["object"] != ObjectEmbedding {
+				item["object"] = ObjectEmbedding
+				changed = true
+			}
+		}
+	}
+	if hasCreated {
+		var created int64
+		if number, ok := response["created"].(json.Number); ok {
+			created, _ = number.Int64()
+		}
+		if (created <= 0 || config.CreatedTimestampSource == config.CreatedTimestampSourceGateway) && created != now {
+			response["created"] = now
+			changed = true
+		}
+	}
+	if responseModel != "" {
+		if modelName, ok := response["model"].(string); ok && modelName != responseModel {
+			response["model"] = responseModel
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	return encodeObject(response, body)
+}
+
+// NormalizeStreamFields normalizes the fields of a chunk like NormalizeResponseFields,
+// now is the time the stream started, so that all its chunks agree
+func NormalizeStreamFields(data string, relayMode int, responseModel string, now int64) string {
+	if !strings.HasPrefix(data, dataPrefix) {
+		return data
+	}
+	chunk := []byte(strings.TrimSuffix(data[dataPrefixLength:], "\r"))
+	normalized := NormalizeResponseFields(chunk, relayMode, true, responseModel, now)
+	if bytes.Equal(normalized, chunk) {
+		return data
+	}
+	return dataPrefix + string(normalized)
+}
+
+func decodeObject(body []byte) (map[string]any, bool) {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// keep the numbers, e.g. the embeddings, exactly as the upstream wrote them
+	decoder.UseNumber()
+	var object map[string]any
+	if err := decoder.Decode(&object); err != nil {
+		return nil, false
+	}
+	return object, true
+}
+
+// encodeObject encodes the object the way it was decoded, fallback is returned if it fails
+func encodeObject(object map[string]any, fallback []byte) []byte {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(object); err != nil {
+		return fallback
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
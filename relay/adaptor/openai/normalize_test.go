@@ -0,0 +1,150 @@
+package openai
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeResponseBody(t *testing.T) {
+	body := `{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"deepseek-chat","search_results":[],` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"<b>hi</b>","reasoning_content":"..."},"finish_reason":"stop","matched_stop":1}],` +
+		`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2,"prompt_cache_hit_tokens":0}}`
+	assert.JSONEq(t, `{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"deepseek-chat",`+
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"<b>hi</b>"},"finish_reason":"stop"}],`+
+		`"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`,
+		string(NormalizeResponseBody([]byte(body), channeltype.OpenAI, relaymode.ChatCompletions, false)))
+
+	chunk := `{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"m","choices":[{"index":0,"delta":{"content":"hi","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{}","extra":1}}]},"extra":1}],"extra":1}`
+	assert.JSONEq(t, `{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"m","choices":[{"index":0,"delta":{"content":"hi","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"f","arguments":"{}"}}]}}]}`,
+		string(NormalizeResponseBody([]byte(chunk), channeltype.OpenAI, relaymode.ChatCompletions, true)))
+
+	// numbers are kept as written
+	embedding := `{"object":"list","data":[{"object":"embedding","embedding":[0.10000000000000001,-2e-7],"index":0,"norm":1}],"model":"m","usage":{"prompt_tokens":1,"total_tokens":1},"id":"x"}`
+	assert.Equal(t, `{"data":[{"embedding":[0.10000000000000001,-2e-7],"index":0,"object":"embedding"}],"model":"m","object":"list","usage":{"prompt_tokens":1,"total_tokens":1}}`,
+		string(NormalizeResponseBody([]byte(embedding), channeltype.OpenAI, relaymode.Embeddings, false)))
+
+	// the citations of perplexity are kept
+	citations := `{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"sonar","citations":["https://example.com"],"choices":[],"extra":1}`
+	assert.JSONEq(t, `{"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"sonar","citations":["https://example.com"],"choices":[]}`,
+		string(NormalizeResponseBody([]byte(citations), channeltype.Perplexity, relaymode.ChatCompletions, true)))
+	assert.NotContains(t, string(NormalizeResponseBody([]byte(citations), channeltype.OpenAI, relaymode.ChatCompletions, true)), "citations")
+
+	moderation := `{"id":"modr-123","model":"omni-moderation-latest","results":[{"flagged":false,"categories":{"hate":false},"category_scores":{"hate":0.01},"extra":1}],"extra":1}`
+	assert.JSONEq(t, `{"id":"modr-123","model":"omni-moderation-latest","results":[{"flagged":false,"categories":{"hate":false},"category_scores":{"hate":0.01}}]}`,
+		string(NormalizeResponseBody([]byte(moderation), channeltype.OpenAI, relaymode.Moderations, false)))
+
+	image := `{"created":1700000000,"data":[{"url":"https://example.com/a.png","revised_prompt":"a cat","seed":1}],"task_id":"x"}`
+	assert.JSONEq(t, `{"created":1700000000,"data":[{"url":"https://example.com/a.png","revised_prompt":"a cat"}]}`,
+		string(NormalizeResponseBody([]byte(image), channeltype.OpenAI, relaymode.ImagesGenerations, false)))
+
+	// errors and the modes without a json schema are left alone
+	errorBody := `{"error":{"message":"bad","type":"invalid_request_error","extra":1}}`
+	assert.Equal(t, errorBody, string(NormalizeResponseBody([]byte(errorBody), channeltype.OpenAI, relaymode.ChatCompletions, false)))
+	assert.Equal(t, body, string(NormalizeResponseBody([]byte(body), channeltype.OpenAI, relaymode.AudioTranscription, false)))
+}
+
+func TestHandlerStripExtraFields(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest("POST", "/v1/chat/completions", nil)
+	c.Set(ctxkey.ConfigStripExtraFields, "true")
+	resp := newJSONResponse(`{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"model":"m","provider":"x",` +
+		`"choices":[{"index":0,"message":{"role":"assistant","content":"hi"},"finish_reason":"stop"}],"usage":{"prompt_tokens":1,"completion_tokens":1,"total_tokens":2}}`)
+	bizErr, usage := Handler(c, resp, 1, "m")
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 2, usage.TotalTokens)
+	assert.NotContains(t, w.Body.String(), "provider")
+	assert.Contains(t, w.Body.String(), `"content":"hi"`)
+}
+
+func TestStreamHandlerStripExtraFields(t *testing.T) {
+	c, w := newStreamContext()
+	c.Set(ctxkey.ConfigStripExtraFields, "true")
+	resp := newStreamResponse(strings.NewReader(`data: {"id":"chatcmpl-123","object":"chat.completion.chunk","created":1700000000,"model":"m","choices":[{"index":0,"delta":{"content":"Hello","reasoning_content":"..."}}],"provider":"x"}` + "\n\n" +
+		"data: [DONE]\n\n"))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "Hello", responseText)
+	body := w.Body.String()
+	assert.Contains(t, body, `"content":"Hello"`)
+	assert.NotContains(t, body, "reasoning_content")
+	assert.NotContains(t, body, "provider")
+}
+
+func TestResponseObject(t *testing.T) {
+	assert.Equal(t, "chat.completion", ResponseObject(relaymode.ChatCompletions, false))
+	assert.Equal(t, "chat.completion.chunk", ResponseObject(relaymode.ChatCompletions, true))
+	assert.Equal(t, "text_completion", ResponseObject(relaymode.Completions, false))
+	assert.Equal(t, "text_completion", ResponseObject(relaymode.Completions, true))
+	assert.Equal(t, "list", ResponseObject(relaymode.Embeddings, false))
+	assert.Equal(t, "", ResponseObject(relaymode.ImagesGenerations, false))
+
+}
+
+func TestNormalizeResponseFields(t *testing.T) {
+	source := config.CreatedTimestampSource
+	defer func() { config.CreatedTimestampSource = source }()
+	config.CreatedTimestampSource = config.CreatedTimestampSourceUpstream
+	normalize := func(body string, relayMode int, isStream bool, responseModel string) string {
+		return string(NormalizeResponseFields([]byte(body), relayMode, isStream, responseModel, 1800000000))
+	}
+
+	// a missing or wrong object is set, a right one is left as is
+	assert.JSONEq(t, `{"id":"chatcmpl-123","object":"chat.completion","created":1700000000,"choices":[]}`,
+		normalize(`{"id":"chatcmpl-123","created":1700000000,"choices":[]}`, relaymode.ChatCompletions, false, ""))
+	assert.JSONEq(t, `{"id":"cmpl-123","object":"text_completion","created":1700000000,"choices":[]}`,
+		normalize(`{"id":"cmpl-123","object":"chat.completion","created":1700000000,"choices":[]}`, relaymode.Completions, false, ""))
+	assert.JSONEq(t, `{"object":"list","data":[{"object":"embedding","embedding":[0.1],"index":0}]}`,
+		normalize(`{"data":[{"embedding":[0.1],"index":0}]}`, relaymode.Embeddings, false, ""))
+	// only the fields of the response count
+	assert.JSONEq(t, `{"object":"chat.completion","created":1700000000,"choices":[{"object":"chat.completion","created":0}]}`,
+		normalize(`{"created":1700000000,"choices":[{"object":"chat.completion","created":0}]}`, relaymode.ChatCompletions, false, ""))
+	assert.JSONEq(t, `{"object":"chat.completion.chunk","created":1700000000,"choices":[]}`,
+		normalize(`{"object":"chat.completion","created":1700000000,"choices":[]}`, relaymode.ChatCompletions, true, ""))
+	body := `{"object":"chat.completion",  "created":1700000000, "model":"gpt-4o", "choices":[]}`
+	assert.Equal(t, body, normalize(body, relaymode.ChatCompletions, false, ""))
+	assert.Equal(t, body, normalize(body, relaymode.ChatCompletions, false, "gpt-4o"))
+
+	// a missing or zero created is set, however it is spaced
+	assert.JSONEq(t, `{"object":"chat.completion","created":1800000000}`,
+		normalize(`{"object":"chat.completion","created": 0}`, relaymode.ChatCompletions, false, ""))
+	assert.JSONEq(t, `{"object":"text_completion","created":1800000000}`,
+		normalize(`{"object":"text_completion"}`, relaymode.Completions, false, ""))
+	config.CreatedTimestampSource = config.CreatedTimestampSourceGateway
+	assert.JSONEq(t, `{"object":"chat.completion","created":1800000000}`,
+		normalize(`{"object":"chat.completion","created":1700000000}`, relaymode.ChatCompletions, false, ""))
+	config.CreatedTimestampSource = config.CreatedTimestampSourceUpstream
+
+	// the model is set only if the response has one
+	assert.JSONEq(t, `{"object":"chat.completion","created":1700000000,"model":"gpt-4o"}`,
+		normalize(`{"object":"chat.completion","created":1700000000,"model":"my-deployment"}`, relaymode.ChatCompletions, false, "gpt-4o"))
+	assert.JSONEq(t, `{"object":"list","data":[]}`, normalize(`{"object":"list","data":[]}`, relaymode.Embeddings, false, "gpt-4o"))
+
+	// the errors are left as is
+	body = `{"error":{"message":"bad request"}}`
+	assert.Equal(t, body, normalize(body, relaymode.ChatCompletions, false, "gpt-4o"))
+
+	assert.Equal(t, `data: {"choices":[],"created":1800000000,"model":"gpt-4o","object":"chat.completion.chunk"}`,
+		NormalizeStreamFields(`data: {"choices":[],"object":"","model":"my-deployment"}`, relaymode.ChatCompletions, "gpt-4o", 1800000000))
+	assert.Equal(t, "data: [DONE]", NormalizeStreamFields("data: [DONE]", relaymode.ChatCompletions, "gpt-4o", 1800000000))
+}
+
+func TestStreamHandlerSetsCompletionsObject(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newStreamResponse(strings.NewReader(`data: {"id":"cmpl-123","created":1700000000,"model":"m","choices":[{"index":0,"text":"Hello"}]}` + "\n\n" +
+		"data: [DONE]\n\n"))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.Completions)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, "Hello", responseText)
+	assert.Contains(t, w.Body.String(), `"object":"text_completion"`)
+}
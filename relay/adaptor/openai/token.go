@@ -1,6 +1,8 @@
 package openai
 
 import (
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
 	"fmt"
 	"github.com/pkoukk/tiktoken-go"
@@ -11,6 +13,7 @@ import (
 	"github.com/songquanpeng/one-api/relay/model"
 	"math"
 	"strings"
+	"unicode/utf8"
 )
 
 // tokenEncoderMap won't grow after initialization
@@ -28,7 +31,7 @@ func InitTokenEncoders() {
 	if err != nil {
 		logger.FatalLog(fmt.Sprintf("failed to get gpt-4 token encoder: %s", err.Error()))
 	}
-	for model := range billingratio.ModelRatio {
+	for model := range billingratio.GetModelRatioMap() {
 		if strings.HasPrefix(model, "gpt-3.5") {
 			tokenEncoderMap[model] = gpt35TokenEncoder
 		} else if strings.HasPrefix(model, "gpt-4") {
@@ -57,14 +60,52 @@ func getTokenEncoder(model string) *tiktoken.Tiktoken {
 	return defaultTokenEncoder
 }
 
+// tokenCountChunkSize is the size of the pieces a long text is encoded by,
+// so that the tokens of a huge prompt are never held in memory all at once
+const tokenCountChunkSize = 64 * 1024
+
 func getTokenNum(tokenEncoder *tiktoken.Tiktoken, text string) int {
 	if config.ApproximateTokenEnabled {
 		return int(float64(len(text)) * 0.38)
 	}
-	return len(tokenEncoder.Encode(text, nil, nil))
+	tokenNum := 0
+	for text != "" {
+		var chunk string
+		chunk, text = nextTokenChunk(text)
+		tokenNum += len(tokenEncoder.Encode(chunk, nil, nil))
+	}
+	return tokenNum
+}
+
+// nextTokenChunk cuts the text before the last whitespace within the chunk size,
+// a word is then never split across two chunks and the count stays the same as encoding the whole text
+func nextTokenChunk(text string) (chunk string, rest string) {
+	if len(text) <= tokenCountChunkSize {
+		return text, ""
+	}
+	cut := strings.LastIndexAny(text[:tokenCountChunkSize], " \t\n")
+	if cut <= 0 {
+		// no whitespace at all, at least don't split a character
+		cut = tokenCountChunkSize
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+		if cut == 0 {
+			// not valid utf-8 anyway
+			cut = tokenCountChunkSize
+		}
+	}
+	return text[:cut], text[cut:]
 }
 
 func CountTokenMessages(messages []model.Message, model string) int {
+	textTokens, imageTokens, audioTokens := CountTokenMessagesByType(messages, model)
+	return textTokens + imageTokens + audioTokens
+}
+
+// CountTokenMessagesByType counts the tokens of the images and of the audio in the messages apart from the other tokens,
+// so that they can be billed at different ratios
+func CountTokenMessagesByType(messages []model.Message, model string) (textTokens int, imageTokens int, audioTokens int) {
 	tokenEncoder := getTokenEncoder(model)
 	// Reference:
 	// https://github.com/openai/openai-cookbook/blob/main/examples/How_to_count_tokens_with_tiktoken.ipynb
@@ -100,11 +141,23 @@ func CountTokenMessages(messages []model.Message, model string) int {
 						if imageUrl["detail"] != nil {
 							detail = imageUrl["detail"].(string)
 						}
-						imageTokens, err := countImageTokens(url, detail)
+						imageTokenNum, err := countImageTokens(url, detail)
 						if err != nil {
 							logger.SysError("error counting image tokens: " + err.Error())
 						} else {
-							tokenNum += imageTokens
+							imageTokens += imageTokenNum
+						}
+					}
+				case "input_audio":
+					inputAudio, ok := m["input_audio"].(map[string]any)
+					if ok {
+						data, _ := inputAudio["data"].(string)
+						format, _ := inputAudio["format"].(string)
+						audioTokenNum, err := countAudioTokens(data, format)
+						if err != nil {
+							logger.SysError("error counting audio tokens: " + err.Error())
+						} else {
+							audioTokens += audioTokenNum
 						}
 					}
 				}
@@ -117,7 +170,64 @@ func CountTokenMessages(messages []model.Message, model string) int {
 		}
 	}
 	tokenNum += 3 // Every reply is primed with <|start|>assistant<|message|>
-	return tokenNum
+	return tokenNum, imageTokens, audioTokens
+}
+
+// openai bills about 10 tokens for each second of audio input
+const audioTokensPerSecond = 10
+
+// mp3 audio is assumed to be 128 kbps, as its duration can't be told without decoding the frames
+const mp3BytesPerSecond = 128 * 1000 / 8
+
+// countAudioTokens estimates the tokens of base64 encoded audio by its duration
+func countAudioTokens(data string, format string) (int, error) {
+	audio, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := GetAudioDuration(audio, format)
+	if err != nil {
+		return 0, err
+	}
+	return CountAudioSecondsTokens(seconds), nil
+}
+
+// GetAudioDuration returns the seconds of wav, mp3 or flac audio, read from the headers of wav and flac,
+// and estimated from the size of mp3
+func GetAudioDuration(audio []byte, format string) (float64, error) {
+	switch format {
+	case "wav":
+		// the canonical 44 bytes header, whose byte rate is at offset 28
+		if len(audio) < 44 || string(audio[0:4]) != "RIFF" || string(audio[8:12]) != "WAVE" {
+			return 0, errors.New("invalid wav audio")
+		}
+		byteRate := binary.LittleEndian.Uint32(audio[28:32])
+		if byteRate == 0 {
+			return 0, errors.New("invalid wav audio")
+		}
+		return float64(len(audio)-44) / float64(byteRate), nil
+	case "mp3":
+		return float64(len(audio)) / mp3BytesPerSecond, nil
+	case "flac":
+		// the marker, then the header of the streaminfo block, whose bytes 10 to 17 hold
+		// the sample rate in 20 bits, the channels and the bits per sample in 8 bits, and the total samples in 36 bits
+		if len(audio) < 26 || string(audio[0:4]) != "fLaC" || audio[4]&0x7f != 0 {
+			return 0, errors.New("invalid flac audio")
+		}
+		info := binary.BigEndian.Uint64(audio[18:26])
+		sampleRate := info >> 44
+		totalSamples := info & (1<<36 - 1)
+		if sampleRate == 0 {
+			return 0, errors.New("invalid flac audio")
+		}
+		return float64(totalSamples) / float64(sampleRate), nil
+	}
+	return 0, fmt.Errorf("unsupported audio format %s", format)
+}
+
+// CountAudioSecondsTokens returns the tokens billed for the seconds of audio
+func CountAudioSecondsTokens(seconds float64) int {
+	return int(math.Ceil(seconds * audioTokensPerSecond))
 }
 
 const (
@@ -193,11 +303,25 @@ func CountTokenInput(input any, model string) int {
 	case string:
 		return CountTokenText(v, model)
 	case []string:
-		text := ""
+		tokenNum := 0
 		for _, s := range v {
-			text += s
+			tokenNum += CountTokenText(s, model)
+		}
+		return tokenNum
+	case []any:
+		// a decoded json array, e.g. the input of embeddings, which may be texts, a token array or token arrays
+		tokenNum := 0
+		for _, item := range v {
+			switch item := item.(type) {
+			case string:
+				tokenNum += CountTokenText(item, model)
+			case float64:
+				tokenNum++
+			case []any:
+				tokenNum += CountTokenInput(item, model)
+			}
 		}
-		return CountTokenText(text, model)
+		return tokenNum
 	}
 	return 0
 }
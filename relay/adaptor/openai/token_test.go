@@ -0,0 +1,82 @@
+package openai
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNextTokenChunk(t *testing.T) {
+	chunk, rest := nextTokenChunk("hello world")
+	assert.Equal(t, "hello world", chunk)
+	assert.Empty(t, rest)
+
+	text := strings.Repeat("hello world ", tokenCountChunkSize/4)
+	var chunks []string
+	for remaining := text; remaining != ""; {
+		chunk, remaining = nextTokenChunk(remaining)
+		assert.LessOrEqual(t, len(chunk), tokenCountChunkSize)
+		// words are never split, the next chunk starts with the whitespace
+		if remaining != "" {
+			assert.True(t, strings.HasPrefix(remaining, " "))
+		}
+		chunks = append(chunks, chunk)
+	}
+	assert.Greater(t, len(chunks), 1)
+	assert.Equal(t, text, strings.Join(chunks, ""))
+
+	// without whitespace, characters are not split
+	text = strings.Repeat("你好", tokenCountChunkSize)
+	chunk, rest = nextTokenChunk(text)
+	assert.LessOrEqual(t, len(chunk), tokenCountChunkSize)
+	assert.True(t, utf8.ValidString(chunk))
+	assert.True(t, utf8.ValidString(rest))
+	assert.Equal(t, text, chunk+rest)
+}
+
+func TestCountAudioTokens(t *testing.T) {
+	// 2 seconds of 16 kHz 16-bit mono wav
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	copy(header[8:12], "WAVE")
+	binary.LittleEndian.PutUint32(header[28:32], 32000)
+	wav := append(header, make([]byte, 64000)...)
+	tokens, err := countAudioTokens(base64.StdEncoding.EncodeToString(wav), "wav")
+	assert.NoError(t, err)
+	assert.Equal(t, 20, tokens)
+
+	// 1 second of 128 kbps mp3
+	tokens, err = countAudioTokens(base64.StdEncoding.EncodeToString(make([]byte, 16000)), "mp3")
+	assert.NoError(t, err)
+	assert.Equal(t, 10, tokens)
+
+	_, err = countAudioTokens(base64.StdEncoding.EncodeToString([]byte("not a wav")), "wav")
+	assert.Error(t, err)
+	_, err = countAudioTokens("", "flac")
+	assert.Error(t, err)
+	_, err = countAudioTokens("", "ogg")
+	assert.Error(t, err)
+}
+
+func TestGetAudioDuration(t *testing.T) {
+	// 3 seconds of 44.1 kHz flac, the streaminfo block being the last metadata block
+	flac := make([]byte, 42)
+	copy(flac[0:4], "fLaC")
+	flac[4] = 0x80
+	binary.BigEndian.PutUint64(flac[18:26], 44100<<44|1<<41|15<<36|3*44100)
+	seconds, err := GetAudioDuration(flac, "flac")
+	assert.NoError(t, err)
+	assert.Equal(t, 3.0, seconds)
+
+	flac[4] = 0x84
+	_, err = GetAudioDuration(flac, "flac")
+	assert.Error(t, err)
+
+	seconds, err = GetAudioDuration(make([]byte, 32000), "mp3")
+	assert.NoError(t, err)
+	assert.Equal(t, 2.0, seconds)
+}
@@ -0,0 +1,133 @@
+package openai
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// some upstreams ignore parallel_tool_calls: false and still call several tools at once,
+// for such channels the gateway keeps the first tool call of each choice and drops the others
+
+// KeepFirstToolCall keeps the first tool call of the choices of a chat completion,
+// the body is returned as is if it has no more than one tool call per choice or can't be handled
+func KeepFirstToolCall(body []byte) []byte {
+	response, ok := decodeObject(body)
+	if !ok {
+		return body
+	}
+	changed := false
+	for _, message := range choiceObjects(response, "message") {
+		toolCalls, ok := message["tool_calls"].([]any)
+		if ok && len(toolCalls) > 1 {
+			message["tool_calls"] = toolCalls[:1]
+			changed = true
+		}
+	}
+	if !changed {
+		return body
+	}
+	return encodeObject(response, body)
+}
+
+// keepFirstTool keeps the first tool call of a parsed choice, for counting the tokens of what was returned
+func keepFirstTool(toolCalls []model.Tool) []model.Tool {
+	if len(toolCalls) > 1 {
+		return toolCalls[:1]
+	}
+	return toolCalls
+}
+
+// ToolCallStreamFilter drops the deltas of all the tool calls but the first one of each choice from a stream,
+// the tool calls of a stream are told apart by their index
+type ToolCallStreamFilter struct {
+	// the index of the first tool call of each choice
+	firstIndex map[string]string
+}
+
+func NewToolCallStreamFilter() *ToolCallStreamFilter {
+	return &ToolCallStreamFilter{firstIndex: make(map[string]string)}
+}
+
+// Filter returns the data line without the deltas of the dropped tool calls
+func (f *ToolCallStreamFilter) Filter(data string) string {
+	if !strings.Contains(data, `"tool_calls"`) {
+		return data
+	}
+	chunk, ok := decodeObject([]byte(strings.TrimSuffix(data[dataPrefixLength:], "\r")))
+	if !ok {
+		return data
+	}
+	changed := false
+	choices, _ := chunk["choices"].([]any)
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		delta, ok := choice["delta"].(map[string]any)
+		if !ok {
+			continue
+		}
+		toolCalls, ok := delta["tool_calls"].([]any)
+		if !ok {
+			continue
+		}
+		choiceIndex := jsonString(choice["index"])
+		kept := make([]any, 0, len(toolCalls))
+		for _, toolCall := range toolCalls {
+			toolCallObject, ok := toolCall.(map[string]any)
+			if !ok {
+				continue
+			}
+			index := jsonString(toolCallObject["index"])
+			firstIndex, seen := f.firstIndex[choiceIndex]
+			if !seen {
+				f.firstIndex[choiceIndex] = index
+				firstIndex = index
+			}
+			if index == firstIndex {
+				kept = append(kept, toolCall)
+			}
+		}
+		if len(kept) == len(toolCalls) {
+			continue
+		}
+		changed = true
+		if len(kept) == 0 {
+			delete(delta, "tool_calls")
+		} else {
+			delta["tool_calls"] = kept
+		}
+	}
+	if !changed {
+		return data
+	}
+	return dataPrefix + string(encodeObject(chunk, []byte(data[dataPrefixLength:])))
+}
+
+func choiceObjects(response map[string]any, field string) []map[string]any {
+	var objects []map[string]any
+	choices, _ := response["choices"].([]any)
+	for _, item := range choices {
+		choice, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		if object, ok := choice[field].(map[string]any); ok {
+			objects = append(objects, object)
+		}
+	}
+	return objects
+}
+
+func jsonString(value any) string {
+	if number, ok := value.(json.Number); ok {
+		return number.String()
+	}
+	if s, ok := value.(string); ok {
+		return s
+	}
+	return ""
+}
@@ -0,0 +1,65 @@
+package openai
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeepFirstToolCall(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-123","choices":[{"index":0,"message":{"role":"assistant","content":null,"tool_calls":[` +
+		`{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"Paris\"}"}},` +
+		`{"id":"call_2","type":"function","function":{"name":"get_time","arguments":"{}"}}]},"finish_reason":"tool_calls"}]}`)
+	kept := string(KeepFirstToolCall(body))
+	assert.Contains(t, kept, `"id":"call_1"`)
+	assert.Contains(t, kept, `"arguments":"{\"city\":\"Paris\"}"`)
+	assert.NotContains(t, kept, `"call_2"`)
+	assert.Contains(t, kept, `"finish_reason":"tool_calls"`)
+
+	single := []byte(`{"choices":[{"index":0,"message":{"tool_calls":[{"id":"call_1"}]}}]}`)
+	assert.Equal(t, single, KeepFirstToolCall(single))
+	assert.Equal(t, []byte("not json"), KeepFirstToolCall([]byte("not json")))
+}
+
+const parallelToolCallStream = `data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"role":"assistant","tool_calls":[{"index":0,"id":"call_1","type":"function","function":{"name":"get_weather","arguments":""}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"function":{"arguments":"{\"city\":\"Paris\"}"}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"id":"call_2","type":"function","function":{"name":"get_time","arguments":""}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{"tool_calls":[{"index":1,"function":{"arguments":"{}"}}]},"finish_reason":null}]}
+
+data: {"id":"chatcmpl-123","object":"chat.completion.chunk","choices":[{"index":0,"delta":{},"finish_reason":"tool_calls"}]}
+
+data: [DONE]
+
+`
+
+func TestStreamHandlerKeepFirstToolCall(t *testing.T) {
+	c, w := newStreamContext()
+	c.Set(ctxkey.KeepFirstToolCall, true)
+	resp := newStreamResponse(strings.NewReader(parallelToolCallStream))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	// only the kept tool call is billed
+	assert.Equal(t, `get_weather{"city":"Paris"}`, responseText)
+	body := w.Body.String()
+	assert.Contains(t, body, `"id":"call_1"`)
+	assert.NotContains(t, body, `"call_2"`)
+	assert.NotContains(t, body, `"index":1`)
+	assert.Contains(t, body, `"finish_reason":"tool_calls"`)
+}
+
+func TestStreamHandlerParallelToolCalls(t *testing.T) {
+	c, w := newStreamContext()
+	resp := newStreamResponse(strings.NewReader(parallelToolCallStream))
+
+	bizErr, responseText, _ := StreamHandler(c, resp, relaymode.ChatCompletions)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, `get_weather{"city":"Paris"}get_time{}`, responseText)
+	assert.Contains(t, w.Body.String(), `"call_2"`)
+}
@@ -2,12 +2,10 @@ package palm
 
 import (
 	"encoding/json"
-	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
-	"github.com/songquanpeng/one-api/common/random"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/constant"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -26,7 +24,7 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 		Temperature:    textRequest.Temperature,
 		CandidateCount: textRequest.N,
 		TopP:           textRequest.TopP,
-		TopK:           textRequest.MaxTokens,
+		TopK:           textRequest.TopK,
 	}
 	for _, message := range textRequest.Messages {
 		palmMessage := ChatMessage{
@@ -44,6 +42,9 @@ func ConvertRequest(textRequest model.GeneralOpenAIRequest) *ChatRequest {
 
 func responsePaLM2OpenAI(response *ChatResponse) *openai.TextResponse {
 	fullTextResponse := openai.TextResponse{
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletion,
+		Created: helper.GetTimestamp(),
 		Choices: make([]openai.TextResponseChoice, 0, len(response.Candidates)),
 	}
 	for i, candidate := range response.Candidates {
@@ -62,12 +63,14 @@ func responsePaLM2OpenAI(response *ChatResponse) *openai.TextResponse {
 
 func streamResponsePaLM2OpenAI(palmResponse *ChatResponse) *openai.ChatCompletionsStreamResponse {
 	var choice openai.ChatCompletionsStreamResponseChoice
+	// the whole answer is sent as a single chunk
+	choice.Delta.Role = "assistant"
 	if len(palmResponse.Candidates) > 0 {
 		choice.Delta.Content = palmResponse.Candidates[0].Content
 	}
 	choice.FinishReason = &constant.StopFinishReason
 	var response openai.ChatCompletionsStreamResponse
-	response.Object = "chat.completion.chunk"
+	response.Object = openai.ObjectChatCompletionChunk
 	response.Model = "palm2"
 	response.Choices = []openai.ChatCompletionsStreamResponseChoice{choice}
 	return &response
@@ -75,7 +78,7 @@ func streamResponsePaLM2OpenAI(palmResponse *ChatResponse) *openai.ChatCompletio
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string) {
 	responseText := ""
-	responseId := fmt.Sprintf("chatcmpl-%s", random.GetUUID())
+	responseId := openai.GenerateResponseId()
 	createdTime := helper.GetTimestamp()
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
@@ -0,0 +1,14 @@
+package perplexity
+
+// https://docs.perplexity.ai/guides/model-cards
+var ModelList = []string{
+	"sonar",
+	"sonar-pro",
+}
+
+// ExtraResponseFields are the fields perplexity adds to the openai responses and chunks,
+// which are kept even if the channel strips the extra fields, as clients want the sources of the answers
+var ExtraResponseFields = []string{
+	"citations",
+	"search_results",
+}
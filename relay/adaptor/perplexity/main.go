@@ -0,0 +1,18 @@
+package perplexity
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// GetRequestURL returns the url of the chat completions, perplexity serves them without the /v1 prefix
+// https://docs.perplexity.ai/api-reference/chat-completions
+func GetRequestURL(meta *meta.Meta) (string, error) {
+	if meta.Mode == relaymode.ChatCompletions {
+		return fmt.Sprintf("%s%s", meta.BaseURL, strings.TrimPrefix(meta.RequestURLPath, "/v1")), nil
+	}
+	return "", fmt.Errorf("unsupported relay mode %d for perplexity", meta.Mode)
+}
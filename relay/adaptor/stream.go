@@ -0,0 +1,40 @@
+package adaptor
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+)
+
+// the smallest buffer a channel may set, smaller ones only cost more reads
+const minStreamBufferSize = 4 * 1024
+
+// getStreamBufferSize returns the initial size of the buffer of a stream, which is the stream_buffer_size
+// of the channel config if it is valid, or STREAM_BUFFER_SIZE, and the size the buffer may grow up to
+func getStreamBufferSize(channelBufferSize string) (int, int) {
+	size := config.StreamBufferSize
+	if channelSize, err := strconv.Atoi(channelBufferSize); err == nil && channelSize > 0 {
+		size = channelSize
+	}
+	if size < minStreamBufferSize {
+		size = minStreamBufferSize
+	}
+	maxSize := config.StreamMaxEventSize
+	if size > maxSize {
+		size = maxSize
+	}
+	return size, maxSize
+}
+
+// NewStreamScanner returns a scanner of the stream of an upstream, its buffer starts at the size set for
+// the channel and grows for oversized events up to STREAM_MAX_EVENT_SIZE, instead of failing at 64KB
+func NewStreamScanner(c *gin.Context, body io.Reader) *bufio.Scanner {
+	size, maxSize := getStreamBufferSize(c.GetString(ctxkey.ConfigStreamBufferSize))
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 0, size), maxSize)
+	return scanner
+}
@@ -0,0 +1,40 @@
+package adaptor
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetStreamBufferSize(t *testing.T) {
+	size, maxSize := getStreamBufferSize("")
+	assert.Equal(t, config.StreamBufferSize, size)
+	assert.Equal(t, config.StreamMaxEventSize, maxSize)
+
+	size, _ = getStreamBufferSize("1048576")
+	assert.Equal(t, 1048576, size)
+	size, _ = getStreamBufferSize("16")
+	assert.Equal(t, minStreamBufferSize, size)
+	size, _ = getStreamBufferSize("invalid")
+	assert.Equal(t, config.StreamBufferSize, size)
+}
+
+func TestNewStreamScannerLargeEvent(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(ctxkey.ConfigStreamBufferSize, "4096")
+	// an event far larger than both the buffer of the channel and the default limit of bufio
+	event := "data: {\"content\":\"" + strings.Repeat("a", 1024*1024) + "\"}"
+	scanner := NewStreamScanner(c, strings.NewReader(event+"\n\ndata: [DONE]\n"))
+
+	var lines []string
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	assert.NoError(t, scanner.Err())
+	assert.Equal(t, []string{event, "", "data: [DONE]"}, lines)
+}
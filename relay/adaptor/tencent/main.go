@@ -1,7 +1,6 @@
 package tencent
 
 import (
-	"bufio"
 	"crypto/hmac"
 	"crypto/sha1"
 	"encoding/base64"
@@ -14,6 +13,7 @@ import (
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/common/random"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/constant"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -52,7 +52,8 @@ func ConvertRequest(request model.GeneralOpenAIRequest) *ChatRequest {
 
 func responseTencent2OpenAI(response *ChatResponse) *openai.TextResponse {
 	fullTextResponse := openai.TextResponse{
-		Object:  "chat.completion",
+		Id:      openai.NormalizeResponseId(response.Id),
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Usage:   response.Usage,
 	}
@@ -72,8 +73,8 @@ func responseTencent2OpenAI(response *ChatResponse) *openai.TextResponse {
 
 func streamResponseTencent2OpenAI(TencentResponse *ChatResponse) *openai.ChatCompletionsStreamResponse {
 	response := openai.ChatCompletionsStreamResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion.chunk",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   "tencent-hunyuan",
 	}
@@ -90,7 +91,7 @@ func streamResponseTencent2OpenAI(TencentResponse *ChatResponse) *openai.ChatCom
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, string) {
 	var responseText string
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -119,7 +120,10 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 		stopChan <- true
 	}()
+	responseId := openai.GenerateResponseId()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
+	isFirstChunk := true
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
@@ -130,6 +134,12 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				return true
 			}
 			response := streamResponseTencent2OpenAI(&TencentResponse)
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			if len(response.Choices) != 0 {
 				responseText += conv.AsString(response.Choices[0].Delta.Content)
 			}
@@ -0,0 +1,72 @@
+package adaptor
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+// getEffectiveTimeout returns the timeout in seconds of an upstream request and what it is set by,
+// the timeout of the model takes precedence over the one of the channel, which takes precedence over RELAY_TIMEOUT
+func getEffectiveTimeout(modelTimeout int, channelTimeout int, defaultTimeout int) (int, string) {
+	if modelTimeout > 0 {
+		return modelTimeout, "model"
+	}
+	if channelTimeout > 0 {
+		return channelTimeout, "channel"
+	}
+	return defaultTimeout, "default"
+}
+
+// upstreamTimeoutContext returns a context bounded by the timeout of the model or the channel of the request,
+// ok is false if neither has one, in which case the timeout of the client applies.
+// The upstream request outlives the client, unless the request has a deadline of its own, e.g. a mirrored one.
+// The effective timeout is logged whatever sets it
+func upstreamTimeoutContext(c *gin.Context, meta *meta.Meta) (ctx context.Context, cancel context.CancelFunc, ok bool) {
+	parent := context.Background()
+	_, hasDeadline := c.Request.Context().Deadline()
+	if hasDeadline {
+		parent = c.Request.Context()
+	}
+	modelTimeout := model.GetModelTimeout(meta.ActualModelName)
+	if modelTimeout == 0 {
+		modelTimeout = model.GetModelTimeout(meta.OriginModelName)
+	}
+	channelTimeout, _ := strconv.Atoi(c.GetString(ctxkey.ConfigTimeout))
+	timeout, source := getEffectiveTimeout(modelTimeout, channelTimeout, config.RelayTimeout)
+	if timeout > 0 {
+		logger.Infof(c.Request.Context(), "upstream timeout of model %s on channel #%d is %ds, set by the %s", meta.ActualModelName, meta.ChannelId, timeout, source)
+	} else {
+		logger.Infof(c.Request.Context(), "upstream request of model %s on channel #%d has no timeout", meta.ActualModelName, meta.ChannelId)
+	}
+	if source == "default" {
+		if !hasDeadline {
+			return nil, nil, false
+		}
+		ctx, cancel = context.WithCancel(parent)
+		return ctx, cancel, true
+	}
+	ctx, cancel = context.WithTimeout(parent, time.Duration(timeout)*time.Second)
+	return ctx, cancel, true
+}
+
+// cancelOnCloseBody releases the context of a request once its response body is closed,
+// as the deadline covers reading the body as well
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
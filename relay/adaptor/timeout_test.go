@@ -0,0 +1,68 @@
+package adaptor
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEffectiveTimeout(t *testing.T) {
+	timeout, source := getEffectiveTimeout(600, 120, 300)
+	assert.Equal(t, 600, timeout)
+	assert.Equal(t, "model", source)
+	// the model timeout wins even when it is shorter
+	timeout, source = getEffectiveTimeout(30, 120, 300)
+	assert.Equal(t, 30, timeout)
+	assert.Equal(t, "model", source)
+	timeout, source = getEffectiveTimeout(0, 120, 300)
+	assert.Equal(t, 120, timeout)
+	assert.Equal(t, "channel", source)
+	timeout, source = getEffectiveTimeout(0, 0, 300)
+	assert.Equal(t, 300, timeout)
+	assert.Equal(t, "default", source)
+}
+
+func TestUpstreamTimeoutContext(t *testing.T) {
+	assert.NoError(t, model.UpdateModelTimeoutByJSONString(`{"o1": 600}`))
+	defer func() { _ = model.UpdateModelTimeoutByJSONString("{}") }()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	deadlineIn := func(meta *meta.Meta) time.Duration {
+		ctx, cancel, ok := upstreamTimeoutContext(c, meta)
+		if !ok {
+			return 0
+		}
+		defer cancel()
+		deadline, _ := ctx.Deadline()
+		return time.Until(deadline).Round(time.Minute)
+	}
+	originalWriter := gin.DefaultWriter
+	defer func() { gin.DefaultWriter = originalWriter }()
+	var logs bytes.Buffer
+	gin.DefaultWriter = &logs
+	originalRelayTimeout := config.RelayTimeout
+	defer func() { config.RelayTimeout = originalRelayTimeout }()
+
+	config.RelayTimeout = 0
+	assert.Zero(t, deadlineIn(&meta.Meta{ActualModelName: "gpt-4o"}))
+	assert.Contains(t, logs.String(), "upstream request of model gpt-4o on channel #0 has no timeout")
+	// the default timeout is applied by the client, but it is logged as well
+	config.RelayTimeout = 300
+	assert.Zero(t, deadlineIn(&meta.Meta{ActualModelName: "gpt-4o"}))
+	assert.Contains(t, logs.String(), "upstream timeout of model gpt-4o on channel #0 is 300s, set by the default")
+	c.Set(ctxkey.ConfigTimeout, "120")
+	assert.Equal(t, 2*time.Minute, deadlineIn(&meta.Meta{ActualModelName: "gpt-4o"}))
+	assert.Equal(t, 10*time.Minute, deadlineIn(&meta.Meta{ActualModelName: "o1"}))
+	// the timeout of the requested model applies to the model it is mapped to as well
+	assert.Equal(t, 10*time.Minute, deadlineIn(&meta.Meta{OriginModelName: "o1", ActualModelName: "o1-2024-12-17"}))
+}
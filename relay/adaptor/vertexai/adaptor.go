@@ -0,0 +1,103 @@
+package vertexai
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	channelhelper "github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/gemini"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// Adaptor relays to gemini models hosted on google vertex ai, the request and response bodies are the same as gemini's,
+// while the channel key is a service account json key and the endpoint depends on the project and region
+type Adaptor struct {
+	account   *ServiceAccount
+	projectId string
+	region    string
+}
+
+func (a *Adaptor) Init(meta *meta.Meta) {
+
+}
+
+func (a *Adaptor) GetRequestURL(meta *meta.Meta) (string, error) {
+	if a.account == nil {
+		return "", errors.New("service account is not loaded")
+	}
+	action := "generateContent"
+	if meta.IsStream {
+		action = "streamGenerateContent"
+	}
+	baseURL := meta.BaseURL
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", a.region)
+	}
+	version := helper.AssignOrDefault(meta.APIVersion, "v1")
+	return fmt.Sprintf("%s/%s/projects/%s/locations/%s/publishers/google/models/%s:%s",
+		baseURL, version, a.projectId, a.region, meta.ActualModelName, action), nil
+}
+
+func (a *Adaptor) SetupRequestHeader(c *gin.Context, req *http.Request, meta *meta.Meta) error {
+	channelhelper.SetupCommonRequestHeader(c, req, meta)
+	token, err := GetAccessToken(a.account)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *Adaptor) ConvertRequest(c *gin.Context, relayMode int, request *model.GeneralOpenAIRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	return gemini.ConvertRequest(*request), nil
+}
+
+func (a *Adaptor) ConvertImageRequest(request *model.ImageRequest) (any, error) {
+	if request == nil {
+		return nil, errors.New("request is nil")
+	}
+	return request, nil
+}
+
+func (a *Adaptor) DoRequest(c *gin.Context, meta *meta.Meta, requestBody io.Reader) (*http.Response, error) {
+	account, err := ParseServiceAccount(meta.APIKey)
+	if err != nil {
+		return nil, err
+	}
+	a.account = account
+	a.projectId = helper.AssignOrDefault(c.GetString(ctxkey.ConfigProjectID), account.ProjectId)
+	a.region = helper.AssignOrDefault(c.GetString(ctxkey.ConfigRegion), defaultRegion)
+	if a.projectId == "" {
+		return nil, errors.New("vertex ai project id is not set")
+	}
+	return channelhelper.DoRequestHelper(a, c, meta, requestBody)
+}
+
+func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
+	if meta.IsStream {
+		var responseText string
+		err, responseText = gemini.StreamHandler(c, resp)
+		usage = openai.ResponseText2Usage(responseText, meta.ActualModelName, meta.PromptTokens)
+	} else {
+		err, usage = gemini.Handler(c, resp, meta.PromptTokens, meta.ActualModelName)
+	}
+	return
+}
+
+func (a *Adaptor) GetModelList() []string {
+	return ModelList
+}
+
+func (a *Adaptor) GetChannelName() string {
+	return "google vertex ai"
+}
@@ -0,0 +1,11 @@
+package vertexai
+
+// https://cloud.google.com/vertex-ai/generative-ai/docs/learn/models
+
+var ModelList = []string{
+	"gemini-1.0-pro", "gemini-1.0-pro-002",
+	"gemini-1.0-pro-vision-001",
+	"gemini-1.5-pro-001", "gemini-1.5-flash-001",
+}
+
+const defaultRegion = "us-central1"
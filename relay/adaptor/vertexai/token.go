@@ -0,0 +1,162 @@
+package vertexai
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/client"
+)
+
+// https://developers.google.com/identity/protocols/oauth2/service-account#httprest
+
+const (
+	defaultTokenURI = "https://oauth2.googleapis.com/token"
+	tokenScope      = "https://www.googleapis.com/auth/cloud-platform"
+	// the assertion is valid for at most one hour
+	assertionLifetime = time.Hour
+	// refresh the access token a bit earlier so that in-flight requests don't use an expired one
+	tokenRefreshMargin = 5 * time.Minute
+)
+
+// ServiceAccount is the json key of a google cloud service account, it is used as the channel key
+type ServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectId    string `json:"project_id"`
+	PrivateKeyId string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	TokenType        string `json:"token_type"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+type tokenData struct {
+	Token      string
+	ExpiryTime time.Time
+}
+
+var accessTokens sync.Map
+var accessTokensLock sync.Mutex
+
+func ParseServiceAccount(key string) (*ServiceAccount, error) {
+	var account ServiceAccount
+	err := json.Unmarshal([]byte(key), &account)
+	if err != nil {
+		return nil, fmt.Errorf("invalid service account key: %w", err)
+	}
+	if account.ClientEmail == "" || account.PrivateKey == "" {
+		return nil, fmt.Errorf("invalid service account key: client_email and private_key are required")
+	}
+	if account.TokenURI == "" {
+		account.TokenURI = defaultTokenURI
+	}
+	return &account, nil
+}
+
+// GetAccessToken returns a cached oauth access token of the service account,
+// a new one is exchanged with a signed jwt when the cached one is about to expire
+func GetAccessToken(account *ServiceAccount) (string, error) {
+	cacheKey := account.ClientEmail + ":" + account.PrivateKeyId
+	if token, ok := loadAccessToken(cacheKey); ok {
+		return token, nil
+	}
+	// only one exchange at a time, the others wait and then use the cached token
+	accessTokensLock.Lock()
+	defer accessTokensLock.Unlock()
+	if token, ok := loadAccessToken(cacheKey); ok {
+		return token, nil
+	}
+	token, expiresIn, err := exchangeAccessToken(account)
+	if err != nil {
+		return "", err
+	}
+	accessTokens.Store(cacheKey, tokenData{
+		Token:      token,
+		ExpiryTime: time.Now().Add(expiresIn - tokenRefreshMargin - clockSkew()),
+	})
+	return token, nil
+}
+
+func loadAccessToken(cacheKey string) (string, bool) {
+	data, ok := accessTokens.Load(cacheKey)
+	if !ok {
+		return "", false
+	}
+	tokenData := data.(tokenData)
+	if time.Now().After(tokenData.ExpiryTime) {
+		return "", false
+	}
+	return tokenData.Token, true
+}
+
+func signAssertion(account *ServiceAccount) (string, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return "", fmt.Errorf("invalid service account private key: %w", err)
+	}
+	// issued a bit in the past, google rejects assertions issued in the future
+	now := time.Now().Add(-clockSkew())
+	claims := jwt.MapClaims{
+		"iss":   account.ClientEmail,
+		"scope": tokenScope,
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(assertionLifetime).Unix(),
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	if account.PrivateKeyId != "" {
+		token.Header["kid"] = account.PrivateKeyId
+	}
+	return token.SignedString(privateKey)
+}
+
+func clockSkew() time.Duration {
+	return time.Duration(config.ClockSkewTolerance) * time.Second
+}
+
+func exchangeAccessToken(account *ServiceAccount) (string, time.Duration, error) {
+	assertion, err := signAssertion(account)
+	if err != nil {
+		return "", 0, err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequest(http.MethodPost, account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.HTTPClient.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("exchange access token failed: %w", err)
+	}
+	defer resp.Body.Close()
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("exchange access token failed: %w", err)
+	}
+	var tokenResponse tokenResponse
+	err = json.Unmarshal(responseBody, &tokenResponse)
+	if err != nil {
+		return "", 0, fmt.Errorf("exchange access token failed: %s", string(responseBody))
+	}
+	if resp.StatusCode != http.StatusOK || tokenResponse.AccessToken == "" {
+		return "", 0, fmt.Errorf("exchange access token failed: %s %s", tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	return tokenResponse.AccessToken, time.Duration(tokenResponse.ExpiresIn) * time.Second, nil
+}
@@ -43,6 +43,9 @@ func requestOpenAI2Xunfei(request model.GeneralOpenAIRequest, xunfeiAppId string
 	xunfeiRequest.Parameter.Chat.Domain = domain
 	xunfeiRequest.Parameter.Chat.Temperature = request.Temperature
 	xunfeiRequest.Parameter.Chat.TopK = request.N
+	if request.TopK != 0 {
+		xunfeiRequest.Parameter.Chat.TopK = request.TopK
+	}
 	xunfeiRequest.Parameter.Chat.MaxTokens = request.MaxTokens
 	xunfeiRequest.Payload.Message.Text = messages
 	if len(lastToolCalls) != 0 {
@@ -89,9 +92,12 @@ func responseXunfei2OpenAI(response *ChatResponse) *openai.TextResponse {
 		},
 		FinishReason: constant.StopFinishReason,
 	}
+	if len(choice.Message.ToolCalls) != 0 {
+		choice.FinishReason = constant.ToolCallsFinishReason
+	}
 	fullTextResponse := openai.TextResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: []openai.TextResponseChoice{choice},
 		Usage:   response.Payload.Usage.Text,
@@ -112,10 +118,13 @@ func streamResponseXunfei2OpenAI(xunfeiResponse *ChatResponse) *openai.ChatCompl
 	choice.Delta.ToolCalls = getToolCalls(xunfeiResponse)
 	if xunfeiResponse.Payload.Choices.Status == 2 {
 		choice.FinishReason = &constant.StopFinishReason
+		if len(choice.Delta.ToolCalls) != 0 {
+			choice.FinishReason = &constant.ToolCallsFinishReason
+		}
 	}
 	response := openai.ChatCompletionsStreamResponse{
-		Id:      fmt.Sprintf("chatcmpl-%s", random.GetUUID()),
-		Object:  "chat.completion.chunk",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   "SparkDesk",
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -155,8 +164,11 @@ func StreamHandler(c *gin.Context, textRequest model.GeneralOpenAIRequest, appId
 	if err != nil {
 		return openai.ErrorWrapper(err, "xunfei_request_failed", http.StatusInternalServerError), nil
 	}
+	responseId := openai.GenerateResponseId()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
 	var usage model.Usage
+	isFirstChunk := true
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case xunfeiResponse := <-dataChan:
@@ -164,6 +176,12 @@ func StreamHandler(c *gin.Context, textRequest model.GeneralOpenAIRequest, appId
 			usage.CompletionTokens += xunfeiResponse.Payload.Usage.Text.CompletionTokens
 			usage.TotalTokens += xunfeiResponse.Payload.Usage.Text.TotalTokens
 			response := streamResponseXunfei2OpenAI(&xunfeiResponse)
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -108,7 +108,7 @@ func (a *Adaptor) DoResponseV4(c *gin.Context, resp *http.Response, meta *meta.M
 func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Meta) (usage *model.Usage, err *model.ErrorWithStatusCode) {
 	switch meta.Mode {
 	case relaymode.Embeddings:
-		err, usage = EmbeddingsHandler(c, resp)
+		err, usage = EmbeddingsHandler(c, resp, meta.PromptTokens)
 		return
 	case relaymode.ImagesGenerations:
 		err, usage = openai.ImageHandler(c, resp)
@@ -121,7 +121,7 @@ func (a *Adaptor) DoResponse(c *gin.Context, resp *http.Response, meta *meta.Met
 		err, usage = StreamHandler(c, resp)
 	} else {
 		if meta.Mode == relaymode.Embeddings {
-			err, usage = EmbeddingsHandler(c, resp)
+			err, usage = EmbeddingsHandler(c, resp, meta.PromptTokens)
 		} else {
 			err, usage = Handler(c, resp)
 		}
@@ -1,13 +1,14 @@
 package zhipu
 
 import (
-	"bufio"
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt"
 	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/constant"
 	"github.com/songquanpeng/one-api/relay/model"
@@ -44,10 +45,12 @@ func GetToken(apikey string) string {
 	id := split[0]
 	secret := split[1]
 
+	// the token is issued a bit in the past and renewed a bit early, so that the clock of zhipu may differ from ours
+	skew := time.Duration(config.ClockSkewTolerance) * time.Second
 	expMillis := time.Now().Add(time.Duration(expSeconds)*time.Second).UnixNano() / 1e6
-	expiryTime := time.Now().Add(time.Duration(expSeconds) * time.Second)
+	expiryTime := time.Now().Add(time.Duration(expSeconds)*time.Second - skew)
 
-	timestamp := time.Now().UnixNano() / 1e6
+	timestamp := time.Now().Add(-skew).UnixNano() / 1e6
 
 	payload := jwt.MapClaims{
 		"api_key":   id,
@@ -91,8 +94,8 @@ func ConvertRequest(request model.GeneralOpenAIRequest) *Request {
 
 func responseZhipu2OpenAI(response *Response) *openai.TextResponse {
 	fullTextResponse := openai.TextResponse{
-		Id:      response.Data.TaskId,
-		Object:  "chat.completion",
+		Id:      openai.NormalizeResponseId(response.Data.TaskId),
+		Object:  openai.ObjectChatCompletion,
 		Created: helper.GetTimestamp(),
 		Choices: make([]openai.TextResponseChoice, 0, len(response.Data.Choices)),
 		Usage:   response.Data.Usage,
@@ -118,7 +121,8 @@ func streamResponseZhipu2OpenAI(zhipuResponse string) *openai.ChatCompletionsStr
 	var choice openai.ChatCompletionsStreamResponseChoice
 	choice.Delta.Content = zhipuResponse
 	response := openai.ChatCompletionsStreamResponse{
-		Object:  "chat.completion.chunk",
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   "chatglm",
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -131,8 +135,8 @@ func streamMetaResponseZhipu2OpenAI(zhipuResponse *StreamMetaResponse) (*openai.
 	choice.Delta.Content = ""
 	choice.FinishReason = &constant.StopFinishReason
 	response := openai.ChatCompletionsStreamResponse{
-		Id:      zhipuResponse.RequestId,
-		Object:  "chat.completion.chunk",
+		Id:      openai.NormalizeResponseId(zhipuResponse.RequestId),
+		Object:  openai.ObjectChatCompletionChunk,
 		Created: helper.GetTimestamp(),
 		Model:   "chatglm",
 		Choices: []openai.ChatCompletionsStreamResponseChoice{choice},
@@ -142,7 +146,7 @@ func streamMetaResponseZhipu2OpenAI(zhipuResponse *StreamMetaResponse) (*openai.
 
 func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
 	var usage *model.Usage
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
 			return 0, nil, nil
@@ -178,11 +182,20 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 		}
 		stopChan <- true
 	}()
+	responseId := openai.GenerateResponseId()
+	createdTime := helper.GetTimestamp()
 	common.SetEventStreamHeaders(c)
+	isFirstChunk := true
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
 			response := streamResponseZhipu2OpenAI(data)
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -198,6 +211,12 @@ func StreamHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusC
 				return true
 			}
 			response, zhipuUsage := streamMetaResponseZhipu2OpenAI(&zhipuResponse)
+			response.Id = responseId
+			response.Created = createdTime
+			if isFirstChunk {
+				openai.SetAssistantRole(response)
+				isFirstChunk = false
+			}
 			jsonResponse, err := json.Marshal(response)
 			if err != nil {
 				logger.SysError("error marshalling stream response: " + err.Error())
@@ -255,7 +274,7 @@ func Handler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *
 	return nil, &fullTextResponse.Usage
 }
 
-func EmbeddingsHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithStatusCode, *model.Usage) {
+func EmbeddingsHandler(c *gin.Context, resp *http.Response, promptTokens int) (*model.ErrorWithStatusCode, *model.Usage) {
 	var zhipuResponse EmbeddingResponse
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -270,6 +289,7 @@ func EmbeddingsHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithSta
 		return openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
 	}
 	fullTextResponse := embeddingResponseZhipu2OpenAI(&zhipuResponse)
+	openai.FillEmbeddingUsage(&fullTextResponse.Usage, promptTokens)
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
 		return openai.ErrorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
@@ -282,7 +302,7 @@ func EmbeddingsHandler(c *gin.Context, resp *http.Response) (*model.ErrorWithSta
 
 func embeddingResponseZhipu2OpenAI(response *EmbeddingResponse) *openai.EmbeddingResponse {
 	openAIEmbeddingResponse := openai.EmbeddingResponse{
-		Object: "list",
+		Object: openai.ObjectList,
 		Data:   make([]openai.EmbeddingResponseItem, 0, len(response.Embeddings)),
 		Model:  response.Model,
 		Usage: model.Usage{
@@ -294,7 +314,7 @@ func embeddingResponseZhipu2OpenAI(response *EmbeddingResponse) *openai.Embeddin
 
 	for _, item := range response.Embeddings {
 		openAIEmbeddingResponse.Data = append(openAIEmbeddingResponse.Data, openai.EmbeddingResponseItem{
-			Object:    `embedding`,
+			Object:    openai.ObjectEmbedding,
 			Index:     item.Index,
 			Embedding: item.Embedding,
 		})
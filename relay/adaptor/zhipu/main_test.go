@@ -0,0 +1,25 @@
+package zhipu
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponseZhipu2OpenAIIdAndCreated(t *testing.T) {
+	var response Response
+	response.Data.TaskId = "task-1"
+	fullTextResponse := responseZhipu2OpenAI(&response)
+	assert.Equal(t, "chatcmpl-task-1", fullTextResponse.Id)
+	assert.Greater(t, fullTextResponse.Created, int64(0))
+
+	streamResponse := streamResponseZhipu2OpenAI("hi")
+	assert.True(t, strings.HasPrefix(streamResponse.Id, openai.ResponseIdPrefix))
+	assert.Greater(t, streamResponse.Created, int64(0))
+
+	metaResponse, _ := streamMetaResponseZhipu2OpenAI(&StreamMetaResponse{})
+	assert.True(t, strings.HasPrefix(metaResponse.Id, openai.ResponseIdPrefix))
+	assert.Greater(t, metaResponse.Created, int64(0))
+}
@@ -15,6 +15,7 @@ const (
 	AwsClaude
 	Coze
 	Cohere
+	VertexAI
 
 	Dummy // this one is only for count, do not add any channel after this
 )
@@ -5,21 +5,58 @@ import (
 	"fmt"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	"sync"
 )
 
+// inFlight counts the billing done in the background after the responses, so that the shutdown can wait for it
+var inFlight sync.WaitGroup
+
+// Go runs the billing of a request in the background
+func Go(f func()) {
+	inFlight.Add(1)
+	go func() {
+		defer inFlight.Done()
+		f()
+	}()
+}
+
+// WaitInFlight waits for the billing in the background to finish, it returns false if the context is done first
+func WaitInFlight(ctx context.Context) bool {
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func ReturnPreConsumedQuota(ctx context.Context, preConsumedQuota int64, tokenId int) {
 	if preConsumedQuota != 0 {
-		go func(ctx context.Context) {
+		Go(func() {
 			// return pre-consumed quota
 			err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
 			if err != nil {
 				logger.Error(ctx, "error return pre-consumed quota: "+err.Error())
 			}
-		}(ctx)
+		})
 	}
 }
 
-func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, modelRatio float64, groupRatio float64, modelName string, tokenName string) {
+// PostConsumeQuota settles the quota of a request billed otherwise than by the text relay,
+// and records its consume log with the tokens it is billed for
+func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQuota int64, userId int, channelId int, promptTokens int, completionTokens int, modelRatio float64, groupRatio float64, channelMarkup float64, modelName string, tokenName string, extraLogContent string) {
+	// counted against the tpm limit of the channel, whatever the relay mode is
+	model.RecordChannelTokens(channelId, promptTokens+completionTokens)
+	// the free allowance covers the quota first, the pre-consumed quota is refunded for the part it covers
+	billedQuota, freeLogContent := ConsumeFreeAllowance(userId, modelName, totalQuota)
+	freeQuota := totalQuota - billedQuota
+	quotaDelta -= freeQuota
+	totalQuota = billedQuota
 	// quotaDelta is remaining quota to be consumed
 	err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
 	if err != nil {
@@ -30,13 +67,31 @@ func PostConsumeQuota(ctx context.Context, tokenId int, quotaDelta int64, totalQ
 		logger.SysError("error update user quota cache: " + err.Error())
 	}
 	// totalQuota is total quota consumed
-	if totalQuota != 0 {
-		logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
-		model.RecordConsumeLog(ctx, userId, channelId, int(totalQuota), 0, modelName, tokenName, totalQuota, logContent)
+	if totalQuota != 0 || freeQuota != 0 {
+		logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio) + ChannelMarkupLogContent(channelMarkup) + extraLogContent + freeLogContent
+		model.RecordConsumeLog(ctx, userId, channelId, promptTokens, completionTokens, modelName, tokenName, totalQuota, logContent)
 		model.UpdateUserUsedQuotaAndRequestCount(userId, totalQuota)
 		model.UpdateChannelUsedQuota(channelId, totalQuota)
 	}
-	if totalQuota <= 0 {
+	if totalQuota <= 0 && freeQuota == 0 {
 		logger.Error(ctx, fmt.Sprintf("totalQuota consumed is %d, something is wrong", totalQuota))
 	}
 }
+
+// ConsumeFreeAllowance covers the quota with the user's free allowance of the model first, it returns the quota
+// left to bill, and the part of the log content telling the free quota, so that the free usage is told apart
+func ConsumeFreeAllowance(userId int, modelName string, quota int64) (int64, string) {
+	freeQuota := model.ConsumeFreeAllowance(userId, modelName, quota)
+	if freeQuota <= 0 {
+		return quota, ""
+	}
+	return quota - freeQuota, fmt.Sprintf("，免费额度抵扣 %d", freeQuota)
+}
+
+// ChannelMarkupLogContent returns the part of the log content telling the markup of the channel, empty without markup
+func ChannelMarkupLogContent(channelMarkup float64) string {
+	if channelMarkup == 1 {
+		return ""
+	}
+	return fmt.Sprintf("，渠道加价倍率 %.2f", channelMarkup)
+}
@@ -0,0 +1,70 @@
+package billing
+
+import (
+	"context"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestPostConsumeQuotaRecordsTokens(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.Log{}, &model.User{}, &model.Token{}, &model.Channel{}))
+	originalDB, originalLogDB, originalRedisEnabled := model.DB, model.LOG_DB, common.RedisEnabled
+	model.DB, model.LOG_DB, common.RedisEnabled = db, db, false
+	defer func() { model.DB, model.LOG_DB, common.RedisEnabled = originalDB, originalLogDB, originalRedisEnabled }()
+	logConsumeEnabled, logBatchEnabled := config.LogConsumeEnabled, config.LogBatchEnabled
+	config.LogConsumeEnabled, config.LogBatchEnabled = true, false
+	defer func() { config.LogConsumeEnabled, config.LogBatchEnabled = logConsumeEnabled, logBatchEnabled }()
+
+	// the speech of 20 characters and the transcription of 7 tokens
+	PostConsumeQuota(context.Background(), 1, 0, 300, 1, 1, 20, 0, 15, 1, 1, "tts-1", "token", "")
+	PostConsumeQuota(context.Background(), 1, 0, 105, 1, 1, 0, 7, 15, 1, 1, "whisper-1", "token", "，音频时长 2.0 秒")
+	var logs []model.Log
+	assert.NoError(t, db.Order("id").Find(&logs).Error)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, 20, logs[0].PromptTokens)
+	assert.Equal(t, 0, logs[0].CompletionTokens)
+	assert.Equal(t, 300, logs[0].Quota)
+	assert.Equal(t, 0, logs[1].PromptTokens)
+	assert.Equal(t, 7, logs[1].CompletionTokens)
+	assert.Equal(t, "whisper-1", logs[1].ModelName)
+	assert.Contains(t, logs[1].Content, "音频时长 2.0 秒")
+}
+
+func TestPostConsumeQuotaWithFreeAllowance(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.Log{}, &model.User{}, &model.Token{}, &model.Channel{}, &model.FreeAllowance{}))
+	originalDB, originalLogDB, originalRedisEnabled := model.DB, model.LOG_DB, common.RedisEnabled
+	model.DB, model.LOG_DB, common.RedisEnabled = db, db, false
+	defer func() { model.DB, model.LOG_DB, common.RedisEnabled = originalDB, originalLogDB, originalRedisEnabled }()
+	logConsumeEnabled, logBatchEnabled := config.LogConsumeEnabled, config.LogBatchEnabled
+	config.LogConsumeEnabled, config.LogBatchEnabled = true, false
+	defer func() { config.LogConsumeEnabled, config.LogBatchEnabled = logConsumeEnabled, logBatchEnabled }()
+	originalAllowance := billingratio.ModelFreeAllowance2JSONString()
+	defer func() { _ = billingratio.UpdateModelFreeAllowanceByJSONString(originalAllowance) }()
+	assert.NoError(t, billingratio.UpdateModelFreeAllowanceByJSONString(`{"dall-e-3":500}`))
+	assert.NoError(t, db.Create(&model.Token{Id: 1, UserId: 1, Key: "key", RemainQuota: 10000}).Error)
+
+	// the first image is free, the second one is billed for what the allowance doesn't cover
+	PostConsumeQuota(context.Background(), 1, 400, 400, 1, 1, 0, 0, 20, 1, 1, "dall-e-3", "token", "")
+	PostConsumeQuota(context.Background(), 1, 400, 400, 1, 1, 0, 0, 20, 1, 1, "dall-e-3", "token", "")
+	var logs []model.Log
+	assert.NoError(t, db.Order("id").Find(&logs).Error)
+	assert.Len(t, logs, 2)
+	assert.Equal(t, 0, logs[0].Quota)
+	assert.Contains(t, logs[0].Content, "免费额度抵扣 400")
+	assert.Equal(t, 300, logs[1].Quota)
+	assert.Contains(t, logs[1].Content, "免费额度抵扣 100")
+	token := &model.Token{}
+	assert.NoError(t, db.First(token, 1).Error)
+	assert.Equal(t, int64(9700), token.RemainQuota)
+}
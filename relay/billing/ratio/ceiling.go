@@ -0,0 +1,43 @@
+package ratio
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync"
+)
+
+// GroupMaxRequestQuota overrides MaxRequestQuota for some groups, 0 means no limit
+var GroupMaxRequestQuota = map[string]int64{}
+var groupMaxRequestQuotaLock sync.RWMutex
+
+func GroupMaxRequestQuota2JSONString() string {
+	groupMaxRequestQuotaLock.RLock()
+	defer groupMaxRequestQuotaLock.RUnlock()
+	jsonBytes, err := json.Marshal(GroupMaxRequestQuota)
+	if err != nil {
+		logger.SysError("error marshalling group max request quota: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupMaxRequestQuotaByJSONString(jsonStr string) error {
+	newGroupMaxRequestQuota := make(map[string]int64)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupMaxRequestQuota)
+	if err != nil {
+		return err
+	}
+	groupMaxRequestQuotaLock.Lock()
+	GroupMaxRequestQuota = newGroupMaxRequestQuota
+	groupMaxRequestQuotaLock.Unlock()
+	return nil
+}
+
+// GetMaxRequestQuota returns the maximum quota a single request of the group may cost
+func GetMaxRequestQuota(group string, defaultMaxRequestQuota int64) int64 {
+	groupMaxRequestQuotaLock.RLock()
+	defer groupMaxRequestQuotaLock.RUnlock()
+	if maxRequestQuota, ok := GroupMaxRequestQuota[group]; ok {
+		return maxRequestQuota
+	}
+	return defaultMaxRequestQuota
+}
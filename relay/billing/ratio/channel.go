@@ -0,0 +1,28 @@
+package ratio
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// ParseChannelMarkup parses the markup of a channel, which is multiplied with the ratio of the requests
+// routed to the channel, e.g. 1.2 for reselling the capacity at a 20% markup, an empty markup is 1
+func ParseChannelMarkup(markup string) (float64, error) {
+	if markup == "" {
+		return 1, nil
+	}
+	value, err := strconv.ParseFloat(markup, 64)
+	if err != nil {
+		return 1, err
+	}
+	if value <= 0 {
+		return 1, fmt.Errorf("markup must be positive, got %s", markup)
+	}
+	return value, nil
+}
+
+// GetChannelMarkup returns the markup of a channel, an invalid markup is 1, as it is validated when the channel is saved
+func GetChannelMarkup(markup string) float64 {
+	value, _ := ParseChannelMarkup(markup)
+	return value
+}
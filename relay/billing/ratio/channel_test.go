@@ -0,0 +1,23 @@
+package ratio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseChannelMarkup(t *testing.T) {
+	markup, err := ParseChannelMarkup("")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, markup)
+
+	markup, err = ParseChannelMarkup("1.2")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.2, markup)
+
+	for _, invalid := range []string{"abc", "0", "-1"} {
+		_, err = ParseChannelMarkup(invalid)
+		assert.Error(t, err, invalid)
+		assert.Equal(t, 1.0, GetChannelMarkup(invalid), invalid)
+	}
+}
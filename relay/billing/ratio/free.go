@@ -0,0 +1,43 @@
+package ratio
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync/atomic"
+)
+
+// modelFreeAllowance is the quota each user can use for free per model before normal billing applies,
+// it is counted after all the ratios, so the completion tokens take more of it, the usage is reset every FreeAllowanceResetPeriod
+var modelFreeAllowance atomic.Pointer[map[string]int64]
+
+func init() {
+	modelFreeAllowanceMap := make(map[string]int64)
+	modelFreeAllowance.Store(&modelFreeAllowanceMap)
+}
+
+// GetModelFreeAllowanceMap returns the current free allowances, the returned map must not be modified
+func GetModelFreeAllowanceMap() map[string]int64 {
+	return *modelFreeAllowance.Load()
+}
+
+func ModelFreeAllowance2JSONString() string {
+	jsonBytes, err := json.Marshal(GetModelFreeAllowanceMap())
+	if err != nil {
+		logger.SysError("error marshalling model free allowance: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelFreeAllowanceByJSONString(jsonStr string) error {
+	newModelFreeAllowance := make(map[string]int64)
+	err := json.Unmarshal([]byte(jsonStr), &newModelFreeAllowance)
+	if err != nil {
+		return err
+	}
+	modelFreeAllowance.Store(&newModelFreeAllowance)
+	return nil
+}
+
+func GetModelFreeAllowance(name string) int64 {
+	return GetModelFreeAllowanceMap()[name]
+}
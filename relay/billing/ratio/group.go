@@ -3,16 +3,32 @@ package ratio
 import (
 	"encoding/json"
 	"github.com/songquanpeng/one-api/common/logger"
+	"sync/atomic"
 )
 
-var GroupRatio = map[string]float64{
+var DefaultGroupRatio = map[string]float64{
 	"default": 1,
 	"vip":     1,
 	"svip":    1,
 }
 
+var groupRatio atomic.Pointer[map[string]float64]
+
+func init() {
+	groupRatioMap := make(map[string]float64, len(DefaultGroupRatio))
+	for k, v := range DefaultGroupRatio {
+		groupRatioMap[k] = v
+	}
+	groupRatio.Store(&groupRatioMap)
+}
+
+// GetGroupRatioMap returns the current group ratios, the returned map must not be modified
+func GetGroupRatioMap() map[string]float64 {
+	return *groupRatio.Load()
+}
+
 func GroupRatio2JSONString() string {
-	jsonBytes, err := json.Marshal(GroupRatio)
+	jsonBytes, err := json.Marshal(GetGroupRatioMap())
 	if err != nil {
 		logger.SysError("error marshalling model ratio: " + err.Error())
 	}
@@ -20,12 +36,17 @@ func GroupRatio2JSONString() string {
 }
 
 func UpdateGroupRatioByJSONString(jsonStr string) error {
-	GroupRatio = make(map[string]float64)
-	return json.Unmarshal([]byte(jsonStr), &GroupRatio)
+	newGroupRatio := make(map[string]float64)
+	err := json.Unmarshal([]byte(jsonStr), &newGroupRatio)
+	if err != nil {
+		return err
+	}
+	groupRatio.Store(&newGroupRatio)
+	return nil
 }
 
 func GetGroupRatio(name string) float64 {
-	ratio, ok := GroupRatio[name]
+	ratio, ok := GetGroupRatioMap()[name]
 	if !ok {
 		logger.SysError("group ratio not found: " + name)
 		return 1
@@ -3,6 +3,7 @@ package ratio
 import (
 	"encoding/json"
 	"strings"
+	"sync/atomic"
 
 	"github.com/songquanpeng/one-api/common/logger"
 )
@@ -13,13 +14,13 @@ const (
 	RMB     = USD / USD2RMB
 )
 
-// ModelRatio
+// DefaultModelRatio
 // https://platform.openai.com/docs/models/model-endpoint-compatibility
 // https://cloud.baidu.com/doc/WENXINWORKSHOP/s/Blfmc9dlf
 // https://openai.com/pricing
 // 1 === $0.002 / 1K tokens
 // 1 === ￥0.014 / 1k tokens
-var ModelRatio = map[string]float64{
+var DefaultModelRatio = map[string]float64{
 	// https://openai.com/pricing
 	"gpt-4":                   15,
 	"gpt-4-0314":              15,
@@ -98,6 +99,10 @@ var ModelRatio = map[string]float64{
 	"gemini-1.0-pro-vision-001": 1,
 	"gemini-1.0-pro-001":        1,
 	"gemini-1.5-pro":            1,
+	"gemini-1.0-pro":            1,
+	"gemini-1.0-pro-002":        1,
+	"gemini-1.5-pro-001":        1.75,  // $3.5 / 1M tokens
+	"gemini-1.5-flash-001":      0.175, // $0.35 / 1M tokens
 	// https://open.bigmodel.cn/pricing
 	"glm-4":         0.1 * RMB,
 	"glm-4v":        0.1 * RMB,
@@ -148,6 +153,9 @@ var ModelRatio = map[string]float64{
 	"mistral-medium-latest": 2.7 / 1000 * USD,
 	"mistral-large-latest":  8.0 / 1000 * USD,
 	"mistral-embed":         0.1 / 1000 * USD,
+	"open-mixtral-8x22b":    2.0 / 1000 * USD,
+	"open-mistral-nemo":     0.3 / 1000 * USD,
+	"codestral-latest":      1.0 / 1000 * USD,
 	// https://wow.groq.com/#:~:text=inquiries%C2%A0here.-,Model,-Current%20Speed
 	"llama3-70b-8192":    0.59 / 1000 * USD,
 	"mixtral-8x7b-32768": 0.27 / 1000 * USD,
@@ -173,22 +181,33 @@ var ModelRatio = map[string]float64{
 	// https://platform.deepseek.com/api-docs/pricing/
 	"deepseek-chat":  1.0 / 1000 * RMB,
 	"deepseek-coder": 1.0 / 1000 * RMB,
+	// https://docs.perplexity.ai/guides/pricing, the searches are not billed
+	"sonar":     1.0 / 1000 * USD,
+	"sonar-pro": 3.0 / 1000 * USD,
 }
 
-var CompletionRatio = map[string]float64{}
+var DefaultCompletionRatio = map[string]float64{}
 
-var DefaultModelRatio map[string]float64
-var DefaultCompletionRatio map[string]float64
+// the ratio maps are replaced as a whole on update, so that in-flight reads always see a complete map
+var modelRatio atomic.Pointer[map[string]float64]
+var completionRatio atomic.Pointer[map[string]float64]
 
 func init() {
-	DefaultModelRatio = make(map[string]float64)
-	for k, v := range ModelRatio {
-		DefaultModelRatio[k] = v
+	modelRatioMap := make(map[string]float64, len(DefaultModelRatio))
+	for k, v := range DefaultModelRatio {
+		modelRatioMap[k] = v
 	}
-	DefaultCompletionRatio = make(map[string]float64)
-	for k, v := range CompletionRatio {
-		DefaultCompletionRatio[k] = v
+	modelRatio.Store(&modelRatioMap)
+	completionRatioMap := make(map[string]float64, len(DefaultCompletionRatio))
+	for k, v := range DefaultCompletionRatio {
+		completionRatioMap[k] = v
 	}
+	completionRatio.Store(&completionRatioMap)
+}
+
+// GetModelRatioMap returns the current model ratios, the returned map must not be modified
+func GetModelRatioMap() map[string]float64 {
+	return *modelRatio.Load()
 }
 
 func AddNewMissingRatio(oldRatio string) string {
@@ -212,7 +231,7 @@ func AddNewMissingRatio(oldRatio string) string {
 }
 
 func ModelRatio2JSONString() string {
-	jsonBytes, err := json.Marshal(ModelRatio)
+	jsonBytes, err := json.Marshal(GetModelRatioMap())
 	if err != nil {
 		logger.SysError("error marshalling model ratio: " + err.Error())
 	}
@@ -220,27 +239,35 @@ func ModelRatio2JSONString() string {
 }
 
 func UpdateModelRatioByJSONString(jsonStr string) error {
-	ModelRatio = make(map[string]float64)
-	return json.Unmarshal([]byte(jsonStr), &ModelRatio)
+	newModelRatio := make(map[string]float64)
+	err := json.Unmarshal([]byte(jsonStr), &newModelRatio)
+	if err != nil {
+		return err
+	}
+	modelRatio.Store(&newModelRatio)
+	return nil
 }
 
 func GetModelRatio(name string) float64 {
 	if strings.HasPrefix(name, "qwen-") && strings.HasSuffix(name, "-internet") {
 		name = strings.TrimSuffix(name, "-internet")
 	}
-	ratio, ok := ModelRatio[name]
+	if price, ok := GetModelPrice(name); ok {
+		return Price2Ratio(price.Input)
+	}
+	ratio, ok := GetModelRatioMap()[name]
 	if !ok {
 		ratio, ok = DefaultModelRatio[name]
 	}
 	if !ok {
-		logger.SysError("model ratio not found: " + name)
+		warnUnpricedModel(name, 30)
 		return 30
 	}
 	return ratio
 }
 
 func CompletionRatio2JSONString() string {
-	jsonBytes, err := json.Marshal(CompletionRatio)
+	jsonBytes, err := json.Marshal(*completionRatio.Load())
 	if err != nil {
 		logger.SysError("error marshalling completion ratio: " + err.Error())
 	}
@@ -248,12 +275,23 @@ func CompletionRatio2JSONString() string {
 }
 
 func UpdateCompletionRatioByJSONString(jsonStr string) error {
-	CompletionRatio = make(map[string]float64)
-	return json.Unmarshal([]byte(jsonStr), &CompletionRatio)
+	newCompletionRatio := make(map[string]float64)
+	err := json.Unmarshal([]byte(jsonStr), &newCompletionRatio)
+	if err != nil {
+		return err
+	}
+	completionRatio.Store(&newCompletionRatio)
+	return nil
 }
 
 func GetCompletionRatio(name string) float64 {
-	if ratio, ok := CompletionRatio[name]; ok {
+	if price, ok := GetModelPrice(name); ok {
+		if price.Input == 0 {
+			return 1
+		}
+		return price.Output / price.Input
+	}
+	if ratio, ok := (*completionRatio.Load())[name]; ok {
 		return ratio
 	}
 	if ratio, ok := DefaultCompletionRatio[name]; ok {
@@ -304,6 +342,8 @@ func GetCompletionRatio(name string) float64 {
 		return 3
 	case "command-r-plus":
 		return 5
+	case "sonar-pro":
+		return 5
 	}
 	return 1
 }
@@ -0,0 +1,76 @@
+package ratio
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+)
+
+// TokensPerPriceUnit is the number of tokens a model price refers to
+const TokensPerPriceUnit = 1000 * 1000
+
+// ModelPrice is the price of a model in currency per 1M tokens, the currency is the one QuotaPerUnit refers to,
+// it takes precedence over the model ratio and completion ratio of the model when set
+type ModelPrice struct {
+	Input  float64 `json:"input" yaml:"input"`
+	Output float64 `json:"output" yaml:"output"`
+}
+
+var modelPrice atomic.Pointer[map[string]ModelPrice]
+
+func init() {
+	modelPriceMap := make(map[string]ModelPrice)
+	modelPrice.Store(&modelPriceMap)
+}
+
+// GetModelPriceMap returns the current model prices, the returned map must not be modified
+func GetModelPriceMap() map[string]ModelPrice {
+	return *modelPrice.Load()
+}
+
+func ModelPrice2JSONString() string {
+	jsonBytes, err := json.Marshal(GetModelPriceMap())
+	if err != nil {
+		logger.SysError("error marshalling model price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelPriceByJSONString(jsonStr string) error {
+	newModelPrice := make(map[string]ModelPrice)
+	err := json.Unmarshal([]byte(jsonStr), &newModelPrice)
+	if err != nil {
+		return err
+	}
+	if err = validateModelPrice(newModelPrice); err != nil {
+		return err
+	}
+	modelPrice.Store(&newModelPrice)
+	return nil
+}
+
+// GetModelPrice returns the price of the model, the ModelPrice option takes precedence over the pricing file,
+// ok is false if the model is billed by ratio
+func GetModelPrice(name string) (price ModelPrice, ok bool) {
+	if price, ok = GetModelPriceMap()[name]; ok {
+		return
+	}
+	price, ok = GetFilePriceMap()[name]
+	return
+}
+
+// Price2Ratio converts a price in currency per 1M tokens to a model ratio,
+// ratio 1 means 1 quota per token, and QuotaPerUnit quota is worth 1 currency unit
+func Price2Ratio(price float64) float64 {
+	return price * config.QuotaPerUnit / TokensPerPriceUnit
+}
+
+// Ratio2Price converts a model ratio to a price in currency per 1M tokens
+func Ratio2Price(ratio float64) float64 {
+	if config.QuotaPerUnit == 0 {
+		return 0
+	}
+	return ratio * TokensPerPriceUnit / config.QuotaPerUnit
+}
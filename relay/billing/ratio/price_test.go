@@ -0,0 +1,46 @@
+package ratio
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrice2Ratio(t *testing.T) {
+	defaultQuotaPerUnit := config.QuotaPerUnit
+	defer func() { config.QuotaPerUnit = defaultQuotaPerUnit }()
+
+	// $2 / 1M tokens is the price of ratio 1
+	assert.InDelta(t, 1, Price2Ratio(2), 1e-9)
+	assert.InDelta(t, 15, Price2Ratio(30), 1e-9)
+	assert.InDelta(t, 30, Ratio2Price(15), 1e-9)
+
+	config.QuotaPerUnit = 1000 * 1000
+	assert.InDelta(t, 2, Price2Ratio(2), 1e-9)
+	assert.InDelta(t, 2, Ratio2Price(2), 1e-9)
+}
+
+func TestModelPricePrecedence(t *testing.T) {
+	defer func() { _ = UpdateModelPriceByJSONString("{}") }()
+
+	assert.Equal(t, float64(15), GetModelRatio("gpt-4"))
+	assert.Equal(t, float64(2), GetCompletionRatio("gpt-4"))
+
+	err := UpdateModelPriceByJSONString(`{"gpt-4": {"input": 10, "output": 40}}`)
+	assert.NoError(t, err)
+	assert.InDelta(t, 5, GetModelRatio("gpt-4"), 1e-9)
+	assert.InDelta(t, 4, GetCompletionRatio("gpt-4"), 1e-9)
+	// models without a price are still billed by ratio
+	assert.Equal(t, float64(30), GetModelRatio("gpt-4-32k"))
+}
+
+func TestUpdateModelPriceByJSONString(t *testing.T) {
+	defer func() { _ = UpdateModelPriceByJSONString("{}") }()
+
+	assert.Error(t, UpdateModelPriceByJSONString(`{"gpt-4": {"input": -1, "output": 2}}`))
+	assert.Error(t, UpdateModelPriceByJSONString(`{"gpt-4": {"output": 2}}`))
+	assert.Empty(t, GetModelPriceMap())
+	assert.NoError(t, UpdateModelPriceByJSONString(`{"gpt-4": {"input": 0, "output": 0}}`))
+	assert.Equal(t, float64(0), GetModelRatio("gpt-4"))
+}
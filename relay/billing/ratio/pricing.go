@@ -0,0 +1,162 @@
+package ratio
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPricingFile is the value of PRICING_FILE which loads the pricing file bundled with the binary
+const DefaultPricingFile = "default"
+
+//go:embed pricing.yaml
+var defaultPricing []byte
+
+// the prices loaded from the pricing file, they are overridden by the ModelPrice option
+var filePrice atomic.Pointer[map[string]ModelPrice]
+
+func init() {
+	filePriceMap := make(map[string]ModelPrice)
+	filePrice.Store(&filePriceMap)
+}
+
+// GetFilePriceMap returns the prices loaded from the pricing file, the returned map must not be modified
+func GetFilePriceMap() map[string]ModelPrice {
+	return *filePrice.Load()
+}
+
+func validateModelPrice(prices map[string]ModelPrice) error {
+	for name, price := range prices {
+		if price.Input < 0 || price.Output < 0 {
+			return fmt.Errorf("price of model %s must not be negative", name)
+		}
+		// the output price is expressed relative to the input one, see GetCompletionRatio
+		if price.Input == 0 && price.Output != 0 {
+			return fmt.Errorf("input price of model %s must be set when its output price is set", name)
+		}
+	}
+	return nil
+}
+
+// ParsePricingFile parses a pricing file mapping the model names to their input and output prices
+// in USD per 1M tokens, files with the .json extension are parsed as json and the others as yaml
+func ParsePricingFile(path string, data []byte) (map[string]ModelPrice, error) {
+	prices := make(map[string]ModelPrice)
+	var err error
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		err = json.Unmarshal(data, &prices)
+	} else {
+		err = yaml.Unmarshal(data, &prices)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid pricing file %s: %w", path, err)
+	}
+	if err = validateModelPrice(prices); err != nil {
+		return nil, fmt.Errorf("invalid pricing file %s: %w", path, err)
+	}
+	return prices, nil
+}
+
+func readPricingFile(path string) ([]byte, error) {
+	if path == DefaultPricingFile {
+		return defaultPricing, nil
+	}
+	return os.ReadFile(path)
+}
+
+// LoadPricingFile replaces the prices of the pricing file, the previous prices are kept if the file is invalid
+func LoadPricingFile(path string) error {
+	data, err := readPricingFile(path)
+	if err != nil {
+		return err
+	}
+	prices, err := ParsePricingFile(path, data)
+	if err != nil {
+		return err
+	}
+	filePrice.Store(&prices)
+	logger.SysLog(fmt.Sprintf("loaded prices of %d models from pricing file %s", len(prices), path))
+	if models := getOverriddenRatioModels(prices); len(models) > 0 {
+		logger.SysError(fmt.Sprintf("pricing file %s overrides the model ratio or the completion ratio set for models %s, which no longer take effect",
+			path, strings.Join(models, ", ")))
+	}
+	return nil
+}
+
+// getOverriddenRatioModels returns the models of the pricing file whose model ratio or completion ratio
+// has been set to other than the default one, as the prices of the file take precedence over them
+func getOverriddenRatioModels(prices map[string]ModelPrice) []string {
+	var models []string
+	modelRatioMap := GetModelRatioMap()
+	completionRatioMap := *completionRatio.Load()
+	for name := range prices {
+		if _, ok := GetModelPriceMap()[name]; ok {
+			// the ModelPrice option takes precedence over the file as well
+			continue
+		}
+		ratio, ok := modelRatioMap[name]
+		if defaultRatio, isDefault := DefaultModelRatio[name]; ok && (!isDefault || ratio != defaultRatio) {
+			models = append(models, name)
+			continue
+		}
+		ratio, ok = completionRatioMap[name]
+		if defaultRatio, isDefault := DefaultCompletionRatio[name]; ok && (!isDefault || ratio != defaultRatio) {
+			models = append(models, name)
+		}
+	}
+	sort.Strings(models)
+	return models
+}
+
+// WatchPricingFile reloads the pricing file whenever its modification time changes,
+// the bundled pricing file never changes so it is not watched
+func WatchPricingFile(path string, frequency int) {
+	if path == DefaultPricingFile {
+		return
+	}
+	var lastModTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		lastModTime = info.ModTime()
+	}
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		lastModTime = reloadPricingFileIfChanged(path, lastModTime)
+	}
+}
+
+// reloadPricingFileIfChanged reloads the pricing file if it was modified after lastModTime,
+// and returns its current modification time
+func reloadPricingFileIfChanged(path string, lastModTime time.Time) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		logger.SysError("failed to stat pricing file: " + err.Error())
+		return lastModTime
+	}
+	if info.ModTime().Equal(lastModTime) {
+		return lastModTime
+	}
+	if err = LoadPricingFile(path); err != nil {
+		logger.SysError("failed to reload pricing file: " + err.Error())
+	}
+	return info.ModTime()
+}
+
+// models which have been reported as unpriced, so that the warning is logged once per model
+var unpricedModels sync.Map
+
+func warnUnpricedModel(name string, ratio float64) {
+	if _, loaded := unpricedModels.LoadOrStore(name, struct{}{}); loaded {
+		return
+	}
+	logger.SysError(fmt.Sprintf("no pricing configured for model %s, add it to the pricing file or the model ratio, billed with ratio %v for now", name, ratio))
+}
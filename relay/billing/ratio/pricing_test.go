@@ -0,0 +1,94 @@
+package ratio
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePricingFile(t *testing.T) {
+	prices, err := ParsePricingFile("pricing.json", []byte(`{"gpt-4": {"input": 30, "output": 60}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, ModelPrice{Input: 30, Output: 60}, prices["gpt-4"])
+
+	prices, err = ParsePricingFile("pricing.yaml", []byte("gpt-4:\n  input: 30\n  output: 60\n"))
+	assert.NoError(t, err)
+	assert.Equal(t, ModelPrice{Input: 30, Output: 60}, prices["gpt-4"])
+
+	_, err = ParsePricingFile("pricing.yaml", []byte("gpt-4: { input: 0, output: 60 }"))
+	assert.Error(t, err)
+	_, err = ParsePricingFile("pricing.json", []byte("gpt-4:"))
+	assert.Error(t, err)
+}
+
+func TestDefaultPricingFile(t *testing.T) {
+	prices, err := ParsePricingFile("pricing.yaml", defaultPricing)
+	assert.NoError(t, err)
+	for _, name := range []string{"gpt-4o", "claude-3-5-sonnet-20240620", "gemini-1.5-pro"} {
+		assert.Contains(t, prices, name)
+	}
+}
+
+func TestLoadPricingFile(t *testing.T) {
+	defer func() {
+		filePriceMap := make(map[string]ModelPrice)
+		filePrice.Store(&filePriceMap)
+		_ = UpdateModelPriceByJSONString("{}")
+	}()
+	path := filepath.Join(t.TempDir(), "pricing.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("gpt-4: { input: 10, output: 40 }"), 0644))
+	assert.NoError(t, LoadPricingFile(path))
+	assert.InDelta(t, 5, GetModelRatio("gpt-4"), 1e-9)
+	assert.InDelta(t, 4, GetCompletionRatio("gpt-4"), 1e-9)
+
+	// the ModelPrice option takes precedence over the pricing file
+	assert.NoError(t, UpdateModelPriceByJSONString(`{"gpt-4": {"input": 30, "output": 60}}`))
+	assert.InDelta(t, 15, GetModelRatio("gpt-4"), 1e-9)
+
+	// an invalid file keeps the previous prices
+	assert.NoError(t, os.WriteFile(path, []byte("gpt-4: ["), 0644))
+	assert.Error(t, LoadPricingFile(path))
+	assert.Contains(t, GetFilePriceMap(), "gpt-4")
+}
+
+func TestReloadPricingFileIfChanged(t *testing.T) {
+	defer func() {
+		filePriceMap := make(map[string]ModelPrice)
+		filePrice.Store(&filePriceMap)
+	}()
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"gpt-4": {"input": 10, "output": 40}}`), 0644))
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	lastModTime := reloadPricingFileIfChanged(path, time.Time{})
+	assert.Equal(t, info.ModTime(), lastModTime)
+	assert.Equal(t, float64(10), GetFilePriceMap()["gpt-4"].Input)
+
+	// an unchanged file is not reloaded
+	filePriceMap := make(map[string]ModelPrice)
+	filePrice.Store(&filePriceMap)
+	assert.Equal(t, lastModTime, reloadPricingFileIfChanged(path, lastModTime))
+	assert.Empty(t, GetFilePriceMap())
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"gpt-4": {"input": 20, "output": 40}}`), 0644))
+	// make sure the modification time changes on file systems with a coarse resolution
+	modTime := lastModTime.Add(time.Minute)
+	assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	assert.True(t, reloadPricingFileIfChanged(path, lastModTime).Equal(modTime))
+	assert.Equal(t, float64(20), GetFilePriceMap()["gpt-4"].Input)
+}
+
+func TestGetOverriddenRatioModels(t *testing.T) {
+	modelRatioJSON := ModelRatio2JSONString()
+	defer func() { _ = UpdateModelRatioByJSONString(modelRatioJSON) }()
+
+	prices := map[string]ModelPrice{"gpt-4": {Input: 30, Output: 60}, "gpt-3.5-turbo": {Input: 0.5, Output: 1.5}}
+	assert.Empty(t, getOverriddenRatioModels(prices))
+
+	_ = UpdateModelRatioByJSONString(`{"gpt-4": 20, "gpt-3.5-turbo": ` + strconv.FormatFloat(DefaultModelRatio["gpt-3.5-turbo"], 'f', -1, 64) + `}`)
+	assert.Equal(t, []string{"gpt-4"}, getOverriddenRatioModels(prices))
+}
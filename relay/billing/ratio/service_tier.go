@@ -0,0 +1,55 @@
+package ratio
+
+import (
+	"encoding/json"
+	"github.com/songquanpeng/one-api/common/logger"
+	"sync/atomic"
+)
+
+// DefaultServiceTierRatio is multiplied with the ratio of the requests served in the tier, by the service_tier of the response,
+// https://platform.openai.com/docs/guides/flex-processing
+var DefaultServiceTierRatio = map[string]float64{
+	"flex": 0.5,
+}
+
+var serviceTierRatio atomic.Pointer[map[string]float64]
+
+func init() {
+	serviceTierRatioMap := make(map[string]float64, len(DefaultServiceTierRatio))
+	for k, v := range DefaultServiceTierRatio {
+		serviceTierRatioMap[k] = v
+	}
+	serviceTierRatio.Store(&serviceTierRatioMap)
+}
+
+// GetServiceTierRatioMap returns the current service tier ratios, the returned map must not be modified
+func GetServiceTierRatioMap() map[string]float64 {
+	return *serviceTierRatio.Load()
+}
+
+func ServiceTierRatio2JSONString() string {
+	jsonBytes, err := json.Marshal(GetServiceTierRatioMap())
+	if err != nil {
+		logger.SysError("error marshalling service tier ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateServiceTierRatioByJSONString(jsonStr string) error {
+	newServiceTierRatio := make(map[string]float64)
+	err := json.Unmarshal([]byte(jsonStr), &newServiceTierRatio)
+	if err != nil {
+		return err
+	}
+	serviceTierRatio.Store(&newServiceTierRatio)
+	return nil
+}
+
+// GetServiceTierRatio returns the ratio of the service tier, 1 for the tiers without one
+func GetServiceTierRatio(serviceTier string) float64 {
+	ratio, ok := GetServiceTierRatioMap()[serviceTier]
+	if !ok {
+		return 1
+	}
+	return ratio
+}
@@ -38,6 +38,8 @@ const (
 	Coze
 	Cohere
 	DeepSeek
+	VertexAI
+	Perplexity
 
 	Dummy
 )
@@ -38,6 +38,8 @@ var ChannelBaseURLs = []string{
 	"https://api.coze.com",                      // 34
 	"https://api.cohere.ai",                     // 35
 	"https://api.deepseek.com",                  // 36
+	"",                                          // 37
+	"https://api.perplexity.ai",                 // 38
 }
 
 func init() {
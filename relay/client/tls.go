@@ -0,0 +1,118 @@
+package client
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+)
+
+// TLSConfig is the tls configuration of a channel, the certificates are in PEM format
+type TLSConfig struct {
+	ClientCert string `json:"client_cert"`
+	ClientKey  string `json:"client_key"`
+	// CACert replaces the system roots for verifying the upstream, e.g. with the ca of a self-signed certificate
+	CACert string `json:"ca_cert"`
+	// SkipVerify accepts any certificate of the upstream, prefer CACert where possible
+	SkipVerify bool `json:"skip_verify"`
+}
+
+func (c TLSConfig) isDefault() bool {
+	return c == TLSConfig{}
+}
+
+// Validate parses the client certificate with its key and the ca bundle,
+// so that a channel configured with invalid ones is rejected when it is saved, rather than failing its requests
+func (c TLSConfig) Validate() error {
+	_, err := buildTLSConfig(c)
+	return err
+}
+
+// maxTLSClients bounds the clients kept for the tls configurations, as each edit of a configuration makes a new one
+const maxTLSClients = 64
+
+// the clients of the channels with a tls configuration, keyed by the hash of the configuration,
+// so that the channels configured alike share the connections as well, the least recently used is evicted first
+var tlsClients = make(map[string]*http.Client)
+var tlsClientKeys []string
+var tlsClientsLock sync.Mutex
+
+// GetHTTPClient returns the client to relay with, a dedicated one for a channel with a tls configuration,
+// the shared HTTPClient otherwise
+func GetHTTPClient(tlsConfig TLSConfig) (*http.Client, error) {
+	if tlsConfig.isDefault() {
+		return HTTPClient, nil
+	}
+	jsonBytes, _ := json.Marshal(tlsConfig)
+	hash := sha256.Sum256(jsonBytes)
+	key := hex.EncodeToString(hash[:])
+	tlsClientsLock.Lock()
+	defer tlsClientsLock.Unlock()
+	if httpClient, ok := tlsClients[key]; ok {
+		touchTLSClient(key)
+		return httpClient, nil
+	}
+	clientTLSConfig, err := buildTLSConfig(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	transport := baseTransport().Clone()
+	transport.TLSClientConfig = clientTLSConfig
+	httpClient := &http.Client{
+		Transport: transport,
+		Timeout:   HTTPClient.Timeout,
+	}
+	if len(tlsClientKeys) >= maxTLSClients {
+		evicted := tlsClientKeys[0]
+		tlsClients[evicted].CloseIdleConnections()
+		delete(tlsClients, evicted)
+		tlsClientKeys = tlsClientKeys[1:]
+	}
+	tlsClients[key] = httpClient
+	tlsClientKeys = append(tlsClientKeys, key)
+	return httpClient, nil
+}
+
+// touchTLSClient moves the key to the end of the keys, which are ordered from the least recently used
+func touchTLSClient(key string) {
+	for i, k := range tlsClientKeys {
+		if k == key {
+			tlsClientKeys = append(append(tlsClientKeys[:i:i], tlsClientKeys[i+1:]...), key)
+			return
+		}
+	}
+}
+
+// baseTransport returns the transport of the shared HTTPClient, so that the dedicated clients relay alike,
+// e.g. through the same proxy
+func baseTransport() *http.Transport {
+	if transport, ok := HTTPClient.Transport.(*http.Transport); ok {
+		return transport
+	}
+	return http.DefaultTransport.(*http.Transport)
+}
+
+func buildTLSConfig(tlsConfig TLSConfig) (*tls.Config, error) {
+	clientTLSConfig := &tls.Config{
+		InsecureSkipVerify: tlsConfig.SkipVerify,
+	}
+	if tlsConfig.ClientCert != "" || tlsConfig.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(tlsConfig.ClientCert), []byte(tlsConfig.ClientKey))
+		if err != nil {
+			return nil, err
+		}
+		clientTLSConfig.Certificates = []tls.Certificate{cert}
+	}
+	if tlsConfig.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(tlsConfig.CACert)) {
+			return nil, errors.New("no valid certificate found in the ca bundle")
+		}
+		clientTLSConfig.RootCAs = pool
+	}
+	return clientTLSConfig, nil
+}
@@ -0,0 +1,131 @@
+package client
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newClientCertificate(t *testing.T) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "one-api"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	assert.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return string(certPEM), string(keyPEM)
+}
+
+func TestGetHTTPClient(t *testing.T) {
+	httpClient, err := GetHTTPClient(TLSConfig{})
+	assert.NoError(t, err)
+	assert.Same(t, HTTPClient, httpClient)
+
+	certPEM, keyPEM := newClientCertificate(t)
+	httpClient, err = GetHTTPClient(TLSConfig{ClientCert: certPEM, ClientKey: keyPEM})
+	assert.NoError(t, err)
+	assert.NotSame(t, HTTPClient, httpClient)
+	assert.Len(t, httpClient.Transport.(*http.Transport).TLSClientConfig.Certificates, 1)
+	// the client is cached per certificate
+	cachedClient, err := GetHTTPClient(TLSConfig{ClientCert: certPEM, ClientKey: keyPEM})
+	assert.NoError(t, err)
+	assert.Same(t, httpClient, cachedClient)
+	otherCertPEM, otherKeyPEM := newClientCertificate(t)
+	otherClient, err := GetHTTPClient(TLSConfig{ClientCert: otherCertPEM, ClientKey: otherKeyPEM})
+	assert.NoError(t, err)
+	assert.NotSame(t, httpClient, otherClient)
+
+	_, err = GetHTTPClient(TLSConfig{ClientCert: certPEM, ClientKey: otherKeyPEM})
+	assert.Error(t, err)
+	_, err = GetHTTPClient(TLSConfig{ClientCert: certPEM})
+	assert.Error(t, err)
+}
+
+func TestTLSConfigValidate(t *testing.T) {
+	certPEM, keyPEM := newClientCertificate(t)
+	_, otherKeyPEM := newClientCertificate(t)
+	assert.NoError(t, TLSConfig{}.Validate())
+	assert.NoError(t, TLSConfig{ClientCert: certPEM, ClientKey: keyPEM, CACert: certPEM}.Validate())
+	assert.Error(t, TLSConfig{ClientCert: certPEM}.Validate())
+	assert.Error(t, TLSConfig{ClientCert: certPEM, ClientKey: otherKeyPEM}.Validate())
+	assert.Error(t, TLSConfig{ClientCert: "not a certificate", ClientKey: keyPEM}.Validate())
+	assert.Error(t, TLSConfig{CACert: "not a certificate"}.Validate())
+}
+
+func TestGetHTTPClientVerification(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	// the self-signed certificate of the server is rejected by default
+	_, err := HTTPClient.Get(server.URL)
+	assert.Error(t, err)
+
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	httpClient, err := GetHTTPClient(TLSConfig{CACert: string(caPEM)})
+	assert.NoError(t, err)
+	resp, err := httpClient.Get(server.URL)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+	}
+
+	httpClient, err = GetHTTPClient(TLSConfig{SkipVerify: true})
+	assert.NoError(t, err)
+	resp, err = httpClient.Get(server.URL)
+	if assert.NoError(t, err) {
+		_ = resp.Body.Close()
+	}
+
+	_, err = GetHTTPClient(TLSConfig{CACert: "not a certificate"})
+	assert.Error(t, err)
+}
+
+func TestGetHTTPClientEviction(t *testing.T) {
+	defer func(clients map[string]*http.Client, keys []string) {
+		tlsClients, tlsClientKeys = clients, keys
+	}(tlsClients, tlsClientKeys)
+	tlsClients, tlsClientKeys = make(map[string]*http.Client), nil
+	for i := 0; i < maxTLSClients; i++ {
+		key := strconv.Itoa(i)
+		tlsClients[key] = &http.Client{}
+		tlsClientKeys = append(tlsClientKeys, key)
+	}
+	touchTLSClient("0")
+
+	// the least recently used client is evicted
+	httpClient, err := GetHTTPClient(TLSConfig{SkipVerify: true})
+	assert.NoError(t, err)
+	assert.Same(t, httpClient, tlsClients[tlsClientKeys[maxTLSClients-1]])
+	assert.Len(t, tlsClients, maxTLSClients)
+	assert.Len(t, tlsClientKeys, maxTLSClients)
+	assert.Contains(t, tlsClients, "0")
+	assert.NotContains(t, tlsClients, "1")
+
+	// the transport of the shared client is the base of the dedicated ones
+	defer func(transport http.RoundTripper) { HTTPClient.Transport = transport }(HTTPClient.Transport)
+	HTTPClient.Transport = &http.Transport{Proxy: http.ProxyFromEnvironment, MaxIdleConnsPerHost: 7}
+	certPEM, keyPEM := newClientCertificate(t)
+	httpClient, err = GetHTTPClient(TLSConfig{ClientCert: certPEM, ClientKey: keyPEM})
+	assert.NoError(t, err)
+	assert.Equal(t, 7, httpClient.Transport.(*http.Transport).MaxIdleConnsPerHost)
+	assert.NotNil(t, httpClient.Transport.(*http.Transport).Proxy)
+}
@@ -1,3 +1,4 @@
 package constant
 
 var StopFinishReason = "stop"
+var ToolCallsFinishReason = "tool_calls"
@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+const (
+	mimeJSON        = "application/json"
+	mimeEventStream = "text/event-stream"
+)
+
+// acceptedMediaTypes returns the media ranges of an Accept header which are not refused with q=0
+func acceptedMediaTypes(accept string) []string {
+	var mediaTypes []string
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		mediaType := strings.ToLower(strings.TrimSpace(params[0]))
+		if mediaType == "" {
+			continue
+		}
+		refused := false
+		for _, param := range params[1:] {
+			key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+			if strings.EqualFold(strings.TrimSpace(key), "q") {
+				q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				refused = err == nil && q <= 0
+			}
+		}
+		if !refused {
+			mediaTypes = append(mediaTypes, mediaType)
+		}
+	}
+	return mediaTypes
+}
+
+func mediaTypeMatches(mediaRange string, mediaType string) bool {
+	if mediaRange == "*/*" || mediaRange == mediaType {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(mediaRange, "/*")
+	return ok && strings.HasPrefix(mediaType, prefix+"/")
+}
+
+// negotiateStream maps the Accept header of a text request to whether the response is streamed,
+// text/event-stream alone implies a stream, and an error is returned if no supported response format is accepted,
+// the stream flag of the request wins over application/json as the openai sdks send it with streams too
+func negotiateStream(accept string, relayMode int, isStream bool) (bool, error) {
+	mediaTypes := acceptedMediaTypes(accept)
+	if len(mediaTypes) == 0 {
+		return isStream, nil
+	}
+	canStream := relayMode == relaymode.ChatCompletions || relayMode == relaymode.Completions
+	acceptsJSON, acceptsEventStream := false, false
+	for _, mediaType := range mediaTypes {
+		acceptsJSON = acceptsJSON || mediaTypeMatches(mediaType, mimeJSON)
+		acceptsEventStream = acceptsEventStream || (canStream && mediaTypeMatches(mediaType, mimeEventStream))
+	}
+	switch {
+	case acceptsJSON:
+		return isStream, nil
+	case acceptsEventStream:
+		return true, nil
+	}
+	if canStream {
+		return false, fmt.Errorf("accept %q is not supported, supported types are %s and %s", accept, mimeJSON, mimeEventStream)
+	}
+	return false, fmt.Errorf("accept %q is not supported, the supported type is %s", accept, mimeJSON)
+}
+
+// negotiateTextResponse honors the Accept header of the client for text requests,
+// it returns whether the stream flag of the request has been changed
+func negotiateTextResponse(c *gin.Context, relayMode int, textRequest *model.GeneralOpenAIRequest) (bool, *model.ErrorWithStatusCode) {
+	isStream, err := negotiateStream(c.Request.Header.Get("Accept"), relayMode, textRequest.Stream)
+	if err != nil {
+		return false, openai.ErrorWrapper(err, "unsupported_accept", http.StatusNotAcceptable)
+	}
+	if isStream == textRequest.Stream {
+		return false, nil
+	}
+	textRequest.Stream = isStream
+	return true, nil
+}
@@ -0,0 +1,335 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
+	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// https://platform.openai.com/docs/api-reference/assistants
+// Assistants, threads, messages and runs are stateful objects stored by the upstream,
+// so all these requests are relayed to the single channel pinned by ASSISTANTS_CHANNEL_ID.
+
+const assistantsRunBilledExpiration = 7 * 24 * time.Hour
+
+type assistantsRun struct {
+	Id       string            `json:"id"`
+	Object   string            `json:"object"`
+	ThreadId string            `json:"thread_id"`
+	Model    string            `json:"model"`
+	Status   string            `json:"status"`
+	Usage    *relaymodel.Usage `json:"usage"`
+}
+
+type assistantsRunList struct {
+	Object string          `json:"object"`
+	Data   []assistantsRun `json:"data"`
+}
+
+var billedRuns = make(map[string]int64)
+var billedRunsLock sync.Mutex
+
+func RelayAssistantsHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota <= 0 {
+		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	fullRequestURL := openai.GetFullRequestURL(meta.BaseURL, meta.RequestURLPath, meta.ChannelType)
+	req, err := http.NewRequest(c.Request.Method, fullRequestURL, bytes.NewBuffer(requestBody))
+	if err != nil {
+		return openai.ErrorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	req.Header.Set("Authorization", "Bearer "+meta.APIKey)
+	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
+	openAIBeta := c.GetString(ctxkey.ConfigOpenAIBeta)
+	if openAIBeta == "" {
+		openAIBeta = c.Request.Header.Get("OpenAI-Beta")
+	}
+	if openAIBeta == "" {
+		openAIBeta = "assistants=v2"
+	}
+	req.Header.Set("OpenAI-Beta", openAIBeta)
+
+	httpClient, err := adaptor.GetHTTPClient(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_http_client_failed", http.StatusInternalServerError)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return RelayErrorHandler(resp)
+	}
+	markUpstreamResponded(c)
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return assistantsStreamHandler(c, resp, meta)
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+	}
+	trackAssistantsObjects(c, meta, responseBody)
+	if c.Request.Method == http.MethodGet && strings.TrimSuffix(c.Request.URL.Path, "/") == "/v1/assistants" {
+		responseBody = filterAssistantsList(c, meta, responseBody)
+		resp.Header.Del("Content-Length")
+	}
+	billAssistantsResponse(ctx, meta, responseBody)
+	adaptor.CopyResponseHeaders(c, resp)
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return openai.ErrorWrapper(err, "write_response_body_failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func assistantsStreamHandler(c *gin.Context, resp *http.Response, meta *meta.Meta) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	slowClientWriter := guardSlowClient(c)
+	defer finishSlowClientGuard(c, slowClientWriter)
+	adaptor.CopyResponseHeaders(c, resp)
+	common.SetEventStreamHeaders(c)
+	scanner := adaptor.NewStreamScanner(c, resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, ":") {
+			// drop SSE comments, e.g. keep-alive padding, they only confuse naive clients
+			continue
+		}
+		if strings.HasPrefix(line, "data: ") {
+			data := []byte(strings.TrimPrefix(line, "data: "))
+			// the thread.created event carries the thread created along with the run
+			trackAssistantsObjects(c, meta, data)
+			// the thread.run.completed event carries the run object with usage
+			billAssistantsResponse(ctx, meta, data)
+		}
+		_, err := c.Writer.WriteString(line + "\n")
+		if err != nil {
+			break
+		}
+		c.Writer.Flush()
+	}
+	err := resp.Body.Close()
+	if err != nil {
+		return openai.ErrorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+type assistantsObject struct {
+	Id       string `json:"id"`
+	Object   string `json:"object"`
+	ThreadId string `json:"thread_id"`
+	Deleted  bool   `json:"deleted"`
+}
+
+// trackAssistantsObjects records the user as the owner of the assistant or the thread created by the request,
+// a thread created along with a run is found in the run, and forgets the objects deleted by the request
+func trackAssistantsObjects(c *gin.Context, meta *meta.Meta, responseBody []byte) {
+	var object assistantsObject
+	if err := json.Unmarshal(responseBody, &object); err != nil || object.Id == "" {
+		return
+	}
+	path := strings.TrimSuffix(c.Request.URL.Path, "/")
+	var id, objectType string
+	switch c.Request.Method {
+	case http.MethodPost:
+		switch {
+		case object.Object == "assistant" && path == "/v1/assistants":
+			id, objectType = object.Id, model.AssistantsObjectTypeAssistant
+		case object.Object == "thread" && (path == "/v1/threads" || path == "/v1/threads/runs"):
+			id, objectType = object.Id, model.AssistantsObjectTypeThread
+		case object.Object == "thread.run" && path == "/v1/threads/runs":
+			id, objectType = object.ThreadId, model.AssistantsObjectTypeThread
+		}
+	case http.MethodDelete:
+		if object.Deleted && (object.Object == "assistant.deleted" || object.Object == "thread.deleted") {
+			if err := model.DeleteAssistantsObject(object.Id); err != nil {
+				logger.Error(c.Request.Context(), "failed to delete assistants object: "+err.Error())
+			}
+		}
+		return
+	}
+	if id == "" {
+		return
+	}
+	if err := model.RecordAssistantsObject(id, objectType, meta.UserId, meta.TokenId, meta.TokenName); err != nil {
+		logger.Error(c.Request.Context(), "failed to record assistants object: "+err.Error())
+	}
+}
+
+// filterAssistantsList keeps the assistants of the user in the list of the assistants of the pinned channel,
+// the assistants without a record are only listed to the admins, like they are only accessible to them
+func filterAssistantsList(c *gin.Context, meta *meta.Meta, responseBody []byte) []byte {
+	var list map[string]json.RawMessage
+	if err := json.Unmarshal(responseBody, &list); err != nil {
+		return responseBody
+	}
+	var items []json.RawMessage
+	if err := json.Unmarshal(list["data"], &items); err != nil {
+		return responseBody
+	}
+	ids := make([]string, 0, len(items))
+	objects := make([]assistantsObject, len(items))
+	for i, item := range items {
+		_ = json.Unmarshal(item, &objects[i])
+		ids = append(ids, objects[i].Id)
+	}
+	owners, err := model.GetAssistantsObjectOwners(ids)
+	if err != nil {
+		logger.Error(c.Request.Context(), "failed to get the owners of the assistants: "+err.Error())
+		owners = nil
+	}
+	isAdmin := c.GetInt(ctxkey.Role) >= model.RoleAdminUser
+	kept := make([]json.RawMessage, 0, len(items))
+	for i, item := range items {
+		owner, ok := owners[objects[i].Id]
+		if (ok && owner == meta.UserId) || (!ok && isAdmin && err == nil) {
+			kept = append(kept, item)
+		}
+	}
+	list["data"], _ = json.Marshal(kept)
+	filtered, err := json.Marshal(list)
+	if err != nil {
+		return responseBody
+	}
+	return filtered
+}
+
+// billAssistantsResponse bills the runs found in the response, a run's usage is the sum of its run steps,
+// runs are polled repeatedly by clients so each run is only billed once
+func billAssistantsResponse(ctx context.Context, meta *meta.Meta, responseBody []byte) {
+	var runList assistantsRunList
+	if err := json.Unmarshal(responseBody, &runList); err == nil && runList.Object == "list" {
+		for _, run := range runList.Data {
+			billAssistantsRun(ctx, meta, run)
+		}
+		return
+	}
+	var run assistantsRun
+	if err := json.Unmarshal(responseBody, &run); err == nil {
+		billAssistantsRun(ctx, meta, run)
+	}
+}
+
+func billAssistantsRun(ctx context.Context, meta *meta.Meta, run assistantsRun) {
+	if run.Object != "thread.run" || run.Usage == nil || run.Id == "" {
+		return
+	}
+	if !markRunBilled(run.Id) {
+		return
+	}
+	normalizeUsage(ctx, meta, run.Usage)
+	model.RecordChannelTokens(meta.ChannelId, run.Usage.PromptTokens+run.Usage.CompletionTokens)
+	payer := getAssistantsRunPayer(ctx, meta, run.ThreadId)
+	modelRatio := billingratio.GetModelRatio(run.Model)
+	groupRatio := billingratio.GetGroupRatio(payer.group)
+	completionRatio := billingratio.GetCompletionRatio(run.Model)
+	ratio := modelRatio * groupRatio * meta.ChannelMarkup
+	quota := int64(math.Ceil((float64(run.Usage.PromptTokens) + float64(run.Usage.CompletionTokens)*completionRatio) * ratio))
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	billing.Go(func() {
+		quota, freeLogContent := billing.ConsumeFreeAllowance(payer.userId, run.Model, quota)
+		err := model.PostConsumeTokenQuota(payer.tokenId, quota)
+		if err != nil {
+			logger.Error(ctx, "error consuming token remain quota: "+err.Error())
+		}
+		err = model.CacheUpdateUserQuota(ctx, payer.userId)
+		if err != nil {
+			logger.Error(ctx, "error update user quota cache: "+err.Error())
+		}
+		logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f，Assistants 运行 %s", modelRatio, groupRatio, completionRatio, run.Id) + billing.ChannelMarkupLogContent(meta.ChannelMarkup) + freeLogContent
+		model.RecordThreadConsumeLog(ctx, run.ThreadId, payer.userId, meta.ChannelId, run.Usage.PromptTokens, run.Usage.CompletionTokens, run.Model, payer.tokenName, quota, logContent)
+		model.UpdateUserUsedQuotaAndRequestCount(payer.userId, quota)
+		model.UpdateChannelUsedQuota(meta.ChannelId, quota)
+	})
+}
+
+// assistantsRunPayer is the user and the token a run is billed to
+type assistantsRunPayer struct {
+	userId    int
+	tokenId   int
+	tokenName string
+	group     string
+}
+
+// getAssistantsRunPayer returns the owner of the thread of the run, who created the run, rather than whoever
+// happens to see it completed, the runs of a thread without a record are billed to the user of the request
+func getAssistantsRunPayer(ctx context.Context, meta *meta.Meta, threadId string) assistantsRunPayer {
+	payer := assistantsRunPayer{userId: meta.UserId, tokenId: meta.TokenId, tokenName: meta.TokenName, group: meta.Group}
+	owner, err := model.GetAssistantsObject(threadId)
+	if err != nil {
+		logger.Error(ctx, "failed to get the owner of the thread: "+err.Error())
+		return payer
+	}
+	if owner == nil || owner.UserId == meta.UserId && owner.TokenId == meta.TokenId {
+		return payer
+	}
+	group, err := model.CacheGetUserEffectiveGroup(owner.UserId)
+	if err != nil {
+		logger.Error(ctx, "failed to get the group of the owner of the thread: "+err.Error())
+		group = meta.Group
+	}
+	return assistantsRunPayer{userId: owner.UserId, tokenId: owner.TokenId, tokenName: owner.TokenName, group: group}
+}
+
+// markRunBilled returns false if the run has already been billed,
+// the runs are still billed once per instance if redis fails, rather than not at all
+func markRunBilled(runId string) bool {
+	if common.RedisEnabled {
+		ok, err := common.RDB.SetNX(context.Background(), "assistants_run_billed:"+runId, "1", assistantsRunBilledExpiration).Result()
+		if err == nil {
+			return ok
+		}
+		logger.SysError("Redis set assistants run billed error: " + err.Error())
+	}
+	billedRunsLock.Lock()
+	defer billedRunsLock.Unlock()
+	if _, ok := billedRuns[runId]; ok {
+		return false
+	}
+	now := time.Now().Unix()
+	for id, billedTime := range billedRuns {
+		if now-billedTime > int64(assistantsRunBilledExpiration.Seconds()) {
+			delete(billedRuns, id)
+		}
+	}
+	billedRuns[runId] = now
+	return true
+}
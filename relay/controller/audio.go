@@ -13,16 +13,20 @@ import (
 	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor"
 	"github.com/songquanpeng/one-api/relay/adaptor/azure"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
 	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
-	"github.com/songquanpeng/one-api/relay/client"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 	"io"
+	"math"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"path"
 	"strings"
 )
 
@@ -50,20 +54,40 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		if len(ttsRequest.Input) > 4096 {
 			return openai.ErrorWrapper(errors.New("input is too long (over 4096 characters)"), "text_too_long", http.StatusBadRequest)
 		}
+	} else if isJSONRequest(c) {
+		// the audio of json requests is a data uri, it is forwarded and billed as a multipart upload
+		err := convertJSONAudioRequest(c)
+		if err != nil {
+			return openai.ErrorWrapper(err, "invalid_audio_request", http.StatusBadRequest)
+		}
 	}
 
 	modelRatio := billingratio.GetModelRatio(audioModel)
 	groupRatio := billingratio.GetGroupRatio(group)
-	ratio := modelRatio * groupRatio
+	channelMarkup := billingratio.GetChannelMarkup(c.GetString(ctxkey.ConfigMarkup))
+	ratio := modelRatio * groupRatio * channelMarkup
 	var quota int64
 	var preConsumedQuota int64
+	// the characters of a speech are billed as its prompt tokens, and the tokens of a transcription as its completion tokens
+	var promptTokens, completionTokens int
+	// the duration of the audio to transcribe, noted in the consume log
+	var audioSeconds float64
 	switch relayMode {
 	case relaymode.AudioSpeech:
+		promptTokens = len(ttsRequest.Input)
 		preConsumedQuota = int64(float64(len(ttsRequest.Input)) * ratio)
 		quota = preConsumedQuota
 	default:
 		preConsumedQuota = int64(float64(config.PreConsumedQuota) * ratio)
 	}
+	// the duration of the audio to transcribe is only known from the response, so the pre-consumed quota is the estimate
+	if bizErr := checkMaxRequestQuota(group, preConsumedQuota); bizErr != nil {
+		return bizErr
+	}
+	if model.GetFreeAllowanceRemaining(userId, audioModel) >= preConsumedQuota {
+		// the request is expected to be covered by the user's free allowance
+		preConsumedQuota = 0
+	}
 	userQuota, err := model.CacheGetUserQuota(ctx, userId)
 	if err != nil {
 		return openai.ErrorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
@@ -77,9 +101,9 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	if err != nil {
 		return openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
-	if userQuota > 100*preConsumedQuota {
+	if userQuota > 100*preConsumedQuota && c.GetInt(ctxkey.TokenQuotaPoolId) == 0 {
 		// in this case, we do not pre-consume quota
-		// because the user has enough quota
+		// because the user has enough quota, unless the token shares a pool, which must be reserved
 		preConsumedQuota = 0
 	}
 	if preConsumedQuota > 0 {
@@ -96,13 +120,13 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		if preConsumedQuota > 0 {
 			// we need to roll back the pre-consumed quota
 			defer func(ctx context.Context) {
-				go func() {
+				billing.Go(func() {
 					// negative means add quota back for token & user
 					err := model.PostConsumeTokenQuota(tokenId, -preConsumedQuota)
 					if err != nil {
 						logger.Error(ctx, fmt.Sprintf("error rollback pre-consumed quota: %s", err.Error()))
 					}
-				}()
+				})
 			}(c.Request.Context())
 		}
 	}()
@@ -144,7 +168,34 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		return openai.ErrorWrapper(err, "new_request_body_failed", http.StatusInternalServerError)
 	}
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody.Bytes()))
-	responseFormat := c.DefaultPostForm("response_format", "json")
+	responseFormat := c.DefaultPostForm("response_format", "")
+	if relayMode != relaymode.AudioSpeech {
+		fileHeader, err := c.FormFile("file")
+		if err != nil {
+			return openai.ErrorWrapper(errors.New("file is required"), "invalid_audio_request", http.StatusBadRequest)
+		}
+		if err = checkAudioFileSize(fileHeader.Size); err != nil {
+			return openai.ErrorWrapper(err, "invalid_audio_file", http.StatusBadRequest)
+		}
+		// the json requests are converted to multipart uploads by now, so both are measured alike
+		audioSeconds = getAudioFileDuration(ctx, fileHeader)
+		if responseFormat == "" {
+			responseFormat = c.GetString(ctxkey.ConfigAudioResponseFormat)
+			if responseFormat != "" {
+				// some backends reject the request when response_format is omitted
+				requestBody, err = setMultipartFormValue(c, "response_format", responseFormat)
+				if err != nil {
+					return openai.ErrorWrapper(err, "set_response_format_failed", http.StatusInternalServerError)
+				}
+				c.Request.ContentLength = int64(requestBody.Len())
+			} else {
+				responseFormat = "json"
+			}
+		}
+		if !isAudioResponseFormatSupported(channelType, responseFormat) {
+			return openai.ErrorWrapper(fmt.Errorf("response_format %s is not supported by this channel", responseFormat), "unsupported_response_format", http.StatusBadRequest)
+		}
+	}
 
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
@@ -163,10 +214,17 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
 	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
 
-	resp, err := client.HTTPClient.Do(req)
+	httpClient, err := adaptor.GetHTTPClient(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "get_http_client_failed", http.StatusInternalServerError)
+	}
+	resp, err := httpClient.Do(req)
 	if err != nil {
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
+	if resp.StatusCode == http.StatusOK {
+		markUpstreamResponded(c)
+	}
 
 	err = req.Body.Close()
 	if err != nil {
@@ -212,7 +270,8 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		if err != nil {
 			return openai.ErrorWrapper(err, "get_text_from_body_err", http.StatusInternalServerError)
 		}
-		quota = int64(openai.CountTokenText(text, audioModel))
+		completionTokens = openai.CountTokenText(text, audioModel)
+		quota = getAudioTextQuota(completionTokens, ratio)
 		resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
 	}
 	if resp.StatusCode != http.StatusOK {
@@ -221,12 +280,14 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	succeed = true
 	quotaDelta := quota - preConsumedQuota
 	defer func(ctx context.Context) {
-		go billing.PostConsumeQuota(ctx, tokenId, quotaDelta, quota, userId, channelId, modelRatio, groupRatio, audioModel, tokenName)
+		billing.Go(func() {
+			billing.PostConsumeQuota(ctx, tokenId, quotaDelta, quota, userId, channelId, promptTokens, completionTokens, modelRatio, groupRatio, channelMarkup, audioModel, tokenName,
+				getAudioDurationLogContent(audioSeconds))
+		})
 	}(c.Request.Context())
 
-	for k, v := range resp.Header {
-		c.Writer.Header().Set(k, v[0])
-	}
+	adaptor.CopyResponseHeaders(c, resp)
+	setServedByHeader(c)
 	c.Writer.WriteHeader(resp.StatusCode)
 
 	_, err = io.Copy(c.Writer, resp.Body)
@@ -240,6 +301,139 @@ func RelayAudioHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 	return nil
 }
 
+// getAudioFileDuration returns the seconds of an uploaded wav, mp3 or flac file, told by its extension,
+// or 0 if the duration can't be told
+func getAudioFileDuration(ctx context.Context, fileHeader *multipart.FileHeader) float64 {
+	format := strings.TrimPrefix(strings.ToLower(path.Ext(fileHeader.Filename)), ".")
+	if format != "wav" && format != "mp3" && format != "flac" {
+		return 0
+	}
+	file, err := fileHeader.Open()
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+	audio, err := io.ReadAll(file)
+	if err != nil {
+		return 0
+	}
+	seconds, err := openai.GetAudioDuration(audio, format)
+	if err != nil {
+		logger.Warnf(ctx, "failed to get the duration of audio file %s: %s", fileHeader.Filename, err.Error())
+		return 0
+	}
+	return seconds
+}
+
+// getAudioDurationLogContent returns the part of the log content telling the duration of the audio, empty if unknown
+func getAudioDurationLogContent(seconds float64) string {
+	if seconds <= 0 {
+		return ""
+	}
+	return fmt.Sprintf("，音频时长 %.1f 秒", seconds)
+}
+
+// checkAudioFileSize rejects the empty and tiny files, which are unlikely to be valid audio
+func checkAudioFileSize(size int64) error {
+	if size == 0 {
+		return errors.New("file is empty")
+	}
+	if size < int64(config.AudioMinFileSize) {
+		return fmt.Errorf("file is too small (%d bytes, at least %d bytes)", size, config.AudioMinFileSize)
+	}
+	return nil
+}
+
+// getAudioTextQuota returns the quota of the tokens of a transcription, which are billed at least as many
+// as the tokens of the minimum billable duration of audio, as the text of a tiny piece of audio may be almost free
+func getAudioTextQuota(textTokens int, ratio float64) int64 {
+	tokens := textTokens
+	if minTokens := openai.CountAudioSecondsTokens(config.AudioMinBillableSeconds); tokens < minTokens {
+		tokens = minTokens
+	}
+	quota := int64(math.Ceil(float64(tokens) * ratio))
+	if ratio != 0 && tokens > 0 && quota <= 0 {
+		quota = 1
+	}
+	return quota
+}
+
+// https://platform.openai.com/docs/api-reference/audio/createTranscription#audio-createtranscription-response_format
+var audioResponseFormats = []string{"json", "text", "srt", "verbose_json", "vtt"}
+
+// https://console.groq.com/docs/speech-text
+var channelAudioResponseFormats = map[int][]string{
+	channeltype.Groq: {"json", "text", "verbose_json"},
+}
+
+func isAudioResponseFormatSupported(channelType int, responseFormat string) bool {
+	formats, ok := channelAudioResponseFormats[channelType]
+	if !ok {
+		formats = audioResponseFormats
+	}
+	for _, format := range formats {
+		if format == responseFormat {
+			return true
+		}
+	}
+	return false
+}
+
+func setMultipartFormValue(c *gin.Context, key string, value string) (*bytes.Buffer, error) {
+	form := c.Request.MultipartForm
+	if form == nil {
+		return nil, errors.New("request is not a multipart form")
+	}
+	_, params, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	// keep the boundary so that the original Content-Type header is still valid
+	err = writer.SetBoundary(params["boundary"])
+	if err != nil {
+		return nil, err
+	}
+	for k, values := range form.Value {
+		if k == key {
+			continue
+		}
+		for _, v := range values {
+			err = writer.WriteField(k, v)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = writer.WriteField(key, value)
+	if err != nil {
+		return nil, err
+	}
+	for _, files := range form.File {
+		for _, fileHeader := range files {
+			part, err := writer.CreatePart(fileHeader.Header)
+			if err != nil {
+				return nil, err
+			}
+			file, err := fileHeader.Open()
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(part, file)
+			_ = file.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
 func getTextFromVTT(body []byte) (string, error) {
 	return getTextFromSRT(body)
 }
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+)
+
+// the file extensions of the audio types accepted by the upstreams, the file name tells them the format
+var audioFileExtensions = map[string]string{
+	"audio/wav":    "wav",
+	"audio/x-wav":  "wav",
+	"audio/wave":   "wav",
+	"audio/mpeg":   "mp3",
+	"audio/mp3":    "mp3",
+	"audio/flac":   "flac",
+	"audio/x-flac": "flac",
+	"audio/mp4":    "m4a",
+	"audio/m4a":    "m4a",
+	"audio/x-m4a":  "m4a",
+	"audio/ogg":    "ogg",
+	"audio/webm":   "webm",
+}
+
+func isJSONRequest(c *gin.Context) bool {
+	mediaType, _, err := mime.ParseMediaType(c.Request.Header.Get("Content-Type"))
+	return err == nil && mediaType == mimeJSON
+}
+
+// decodeAudioDataURI decodes a base64 data uri like data:audio/wav;base64,..., and returns its media type and data
+func decodeAudioDataURI(uri string) (string, []byte, error) {
+	rest, ok := strings.CutPrefix(uri, "data:")
+	if !ok {
+		return "", nil, errors.New("file must be a data uri")
+	}
+	header, payload, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", nil, errors.New("file is not a valid data uri")
+	}
+	header, ok = strings.CutSuffix(header, ";base64")
+	if !ok {
+		return "", nil, errors.New("file must be base64 encoded")
+	}
+	mediaType, _, err := mime.ParseMediaType(header)
+	if err != nil {
+		return "", nil, fmt.Errorf("file has an invalid media type: %w", err)
+	}
+	if _, ok := audioFileExtensions[mediaType]; !ok {
+		return "", nil, fmt.Errorf("file has an unsupported media type %s", mediaType)
+	}
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		return "", nil, fmt.Errorf("file is not valid base64: %w", err)
+	}
+	if len(data) == 0 {
+		return "", nil, errors.New("file is empty")
+	}
+	return mediaType, data, nil
+}
+
+func writeAudioFormField(writer *multipart.Writer, key string, value any) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case string:
+		return writer.WriteField(key, v)
+	case []any:
+		// arrays such as timestamp_granularities are sent as repeated fields
+		if !strings.HasSuffix(key, "[]") {
+			key += "[]"
+		}
+		for _, item := range v {
+			if err := writeAudioFormField(writer, key, item); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]any:
+		return fmt.Errorf("field %s must not be an object", key)
+	default:
+		return writer.WriteField(key, fmt.Sprint(v))
+	}
+}
+
+// encodeAudioMultipart turns a json transcription request into the multipart form the upstreams expect,
+// the file field holds the audio as a data uri and the other fields are copied as form values
+func encodeAudioMultipart(body []byte) (*bytes.Buffer, string, error) {
+	var request map[string]any
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&request); err != nil {
+		return nil, "", err
+	}
+	file, ok := request["file"].(string)
+	if !ok {
+		return nil, "", errors.New("file is required")
+	}
+	mediaType, data, err := decodeAudioDataURI(file)
+	if err != nil {
+		return nil, "", err
+	}
+	delete(request, "file")
+
+	multipartBody := &bytes.Buffer{}
+	writer := multipart.NewWriter(multipartBody)
+	keys := make([]string, 0, len(request))
+	for key := range request {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err = writeAudioFormField(writer, key, request[key]); err != nil {
+			return nil, "", err
+		}
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="file"; filename="audio.%s"`, audioFileExtensions[mediaType]))
+	header.Set("Content-Type", mediaType)
+	part, err := writer.CreatePart(header)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err = part.Write(data); err != nil {
+		return nil, "", err
+	}
+	if err = writer.Close(); err != nil {
+		return nil, "", err
+	}
+	return multipartBody, writer.FormDataContentType(), nil
+}
+
+// convertJSONAudioRequest replaces a json transcription request with its multipart form,
+// the cached request body is replaced as well so that retries send the multipart form too
+func convertJSONAudioRequest(c *gin.Context) error {
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return err
+	}
+	multipartBody, contentType, err := encodeAudioMultipart(requestBody)
+	if err != nil {
+		return err
+	}
+	c.Set(common.KeyRequestBody, multipartBody.Bytes())
+	c.Request.Header.Set("Content-Type", contentType)
+	c.Request.ContentLength = int64(multipartBody.Len())
+	c.Request.Body = io.NopCloser(bytes.NewReader(multipartBody.Bytes()))
+	return nil
+}
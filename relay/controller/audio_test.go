@@ -0,0 +1,34 @@
+package controller
+
+import (
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAudioFileSize(t *testing.T) {
+	minFileSize := config.AudioMinFileSize
+	defer func() { config.AudioMinFileSize = minFileSize }()
+	config.AudioMinFileSize = 1024
+
+	assert.EqualError(t, checkAudioFileSize(0), "file is empty")
+	assert.EqualError(t, checkAudioFileSize(44), "file is too small (44 bytes, at least 1024 bytes)")
+	assert.NoError(t, checkAudioFileSize(1024))
+}
+
+func TestGetAudioTextQuota(t *testing.T) {
+	minBillableSeconds := config.AudioMinBillableSeconds
+	defer func() { config.AudioMinBillableSeconds = minBillableSeconds }()
+
+	config.AudioMinBillableSeconds = 1
+	// the text of a tiny piece of audio is billed as a second of audio, i.e. 10 tokens, at the ratio of the model
+	assert.Equal(t, int64(150), getAudioTextQuota(0, 15))
+	assert.Equal(t, int64(150), getAudioTextQuota(2, 15))
+	assert.Equal(t, int64(630), getAudioTextQuota(42, 15))
+	assert.Equal(t, int64(5), getAudioTextQuota(2, 0.5))
+
+	config.AudioMinBillableSeconds = 0
+	assert.Equal(t, int64(0), getAudioTextQuota(0, 15))
+	assert.Equal(t, int64(1), getAudioTextQuota(1, 0.1))
+}
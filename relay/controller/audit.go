@@ -0,0 +1,91 @@
+package controller
+
+import (
+	"bytes"
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"strings"
+)
+
+// auditResponseWriter keeps a bounded copy of what is written to the client
+type auditResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *auditResponseWriter) capture(b []byte) {
+	remaining := config.AuditMaxContentLength - w.body.Len()
+	if remaining <= 0 {
+		return
+	}
+	if len(b) > remaining {
+		b = b[:remaining]
+	}
+	w.body.Write(b)
+}
+
+func (w *auditResponseWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditResponseWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+func shouldAudit(group string) bool {
+	if !config.AuditEnabled {
+		return false
+	}
+	if config.AuditGroups == "" {
+		return true
+	}
+	for _, auditGroup := range strings.Split(config.AuditGroups, ",") {
+		if strings.TrimSpace(auditGroup) == group {
+			return true
+		}
+	}
+	return false
+}
+
+// startAudit starts capturing the response of the request, it returns nil if the group is not audited
+func startAudit(c *gin.Context, meta *meta.Meta) *auditResponseWriter {
+	if !shouldAudit(meta.Group) {
+		return nil
+	}
+	writer := &auditResponseWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+	c.Writer = writer
+	return writer
+}
+
+// finishAudit stops capturing the response, the audit log is only recorded for succeeded requests
+func finishAudit(c *gin.Context, meta *meta.Meta, writer *auditResponseWriter, succeeded bool) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if !succeeded {
+		return
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		logger.Error(c.Request.Context(), "failed to get request body for audit: "+err.Error())
+	}
+	if len(requestBody) > config.AuditMaxContentLength {
+		requestBody = requestBody[:config.AuditMaxContentLength]
+	}
+	model.RecordAuditLog(&model.AuditLog{
+		RequestId:  c.GetString(logger.RequestIdKey),
+		UserId:     meta.UserId,
+		TokenName:  meta.TokenName,
+		ChannelId:  meta.ChannelId,
+		ModelName:  meta.OriginModelName,
+		Prompt:     string(requestBody),
+		Completion: writer.body.String(),
+	})
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// coalescedCall is an upstream request shared by identical requests in flight,
+// the first of them sends it and the others wait for its response
+type coalescedCall struct {
+	done   chan struct{}
+	once   sync.Once
+	status int
+	header http.Header
+	body   []byte
+	usage  *model.Usage
+}
+
+// coalescedResponseHeaders are the headers of the shared response which describe its body, the waiting requests
+// may be of other users and tokens, so they keep their own request id, token expiry and rate limit headers
+var coalescedResponseHeaders = []string{"Content-Type", "Content-Encoding", JSONWarningHeader}
+
+var coalescedCalls = make(map[string]*coalescedCall)
+var coalescedCallsLock sync.Mutex
+
+// isDeterministicRequest reports whether identical requests are expected to get the same response,
+// which holds for embeddings, and for completions asking for temperature 0 explicitly
+func isDeterministicRequest(c *gin.Context, relayMode int) bool {
+	switch relayMode {
+	case relaymode.Embeddings:
+		return true
+	case relaymode.ChatCompletions, relaymode.Completions:
+		var request struct {
+			Temperature *float64 `json:"temperature"`
+		}
+		err := common.UnmarshalBodyReusable(c, &request)
+		return err == nil && request.Temperature != nil && *request.Temperature == 0
+	}
+	return false
+}
+
+// getCoalescingKey returns the key identical requests share, empty if the request is not coalesced
+func getCoalescingKey(c *gin.Context, meta *meta.Meta) string {
+	if !config.RequestCoalescingEnabled || meta.IsStream || !isDeterministicRequest(c, meta.Mode) {
+		return ""
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return ""
+	}
+	// the models are part of the key, as the same body may be downgraded or mapped otherwise
+	hash := sha256.New()
+	hash.Write([]byte(c.Request.URL.Path + "\n" + meta.Group + "\n" + meta.OriginModelName + "\n" + meta.ActualModelName + "\n"))
+	hash.Write(requestBody)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// joinCoalescedCall returns the call of the identical request in flight, or starts a new call,
+// the second return value is true if the caller starts the call and must finish it
+func joinCoalescedCall(key string) (*coalescedCall, bool) {
+	coalescedCallsLock.Lock()
+	defer coalescedCallsLock.Unlock()
+	if call, ok := coalescedCalls[key]; ok {
+		return call, false
+	}
+	call := &coalescedCall{done: make(chan struct{})}
+	coalescedCalls[key] = call
+	return call, true
+}
+
+// finish shares the response with the waiting requests, a nil usage tells them the call failed,
+// only the first finish counts, so it can be deferred for the failures as well
+func (call *coalescedCall) finish(key string, writer *coalescingResponseWriter, usage *model.Usage) {
+	call.once.Do(func() {
+		coalescedCallsLock.Lock()
+		delete(coalescedCalls, key)
+		coalescedCallsLock.Unlock()
+		if writer != nil && usage != nil {
+			call.status = writer.Status()
+			call.header = writer.Header().Clone()
+			call.body = writer.body.Bytes()
+			call.usage = usage
+		}
+		close(call.done)
+	})
+}
+
+// waitCoalescedCall waits for the call and writes its response, it returns the usage to bill
+// the request for, or nil if the call failed and nothing is written, and an error if the client went away first
+func waitCoalescedCall(c *gin.Context, call *coalescedCall) (*model.Usage, error) {
+	select {
+	case <-call.done:
+	case <-c.Request.Context().Done():
+		return nil, c.Request.Context().Err()
+	}
+	if call.usage == nil {
+		return nil, nil
+	}
+	for _, key := range coalescedResponseHeaders {
+		if value := call.header.Get(key); value != "" {
+			c.Writer.Header().Set(key, value)
+		}
+	}
+	setServedByHeader(c)
+	c.Writer.WriteHeader(call.status)
+	_, _ = c.Writer.Write(call.body)
+	usage := *call.usage
+	return &usage, nil
+}
+
+// coalescingResponseWriter keeps a copy of the response written to the client, to be shared with the waiting requests
+type coalescingResponseWriter struct {
+	gin.ResponseWriter
+	body *bytes.Buffer
+}
+
+func (w *coalescingResponseWriter) Write(b []byte) (int, error) {
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *coalescingResponseWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
+
+func startCoalescing(c *gin.Context, call *coalescedCall) *coalescingResponseWriter {
+	if call == nil {
+		return nil
+	}
+	writer := &coalescingResponseWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+	c.Writer = writer
+	return writer
+}
+
+// finishCoalescing stops copying the response, and shares it if the request succeeded
+func finishCoalescing(c *gin.Context, key string, call *coalescedCall, writer *coalescingResponseWriter, usage *model.Usage, succeeded bool) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if !succeeded || usage == nil || !json.Valid(writer.body.Bytes()) {
+		call.finish(key, nil, nil)
+		return
+	}
+	call.finish(key, writer, usage)
+}
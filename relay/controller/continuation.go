@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// autoContinuePrompt asks the model to go on after the content it was cut off at
+const autoContinuePrompt = "Continue exactly where you stopped, without repeating anything."
+
+func shouldAutoContinue(meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) bool {
+	return config.MaxAutoContinuations > 0 && meta.Mode == relaymode.ChatCompletions && !meta.IsStream &&
+		textRequest.N <= 1 && len(textRequest.Tools) == 0
+}
+
+// doChatSegment lets the adaptor handle the response of a segment, the response is kept instead of being written to the client
+func doChatSegment(c *gin.Context, meta *meta.Meta, a adaptor.Adaptor, resp *http.Response) (*bufferResponseWriter, *model.Usage, *model.ErrorWithStatusCode) {
+	writer := newBufferResponseWriter(c.Writer)
+	originalWriter := c.Writer
+	c.Writer = writer
+	usage, respErr := a.DoResponse(c, resp, meta)
+	c.Writer = originalWriter
+	if respErr != nil {
+		return nil, nil, respErr
+	}
+	if usage == nil {
+		usage = &model.Usage{}
+	}
+	return writer, usage, nil
+}
+
+// requestChatContinuation asks for the continuation of the content generated so far
+func requestChatContinuation(c *gin.Context, meta *meta.Meta, a adaptor.Adaptor, textRequest *model.GeneralOpenAIRequest, content string) (*http.Response, *model.ErrorWithStatusCode) {
+	continuationRequest := *textRequest
+	continuationRequest.Messages = make([]model.Message, 0, len(textRequest.Messages)+2)
+	continuationRequest.Messages = append(continuationRequest.Messages, textRequest.Messages...)
+	continuationRequest.Messages = append(continuationRequest.Messages,
+		model.Message{Role: "assistant", Content: content},
+		model.Message{Role: "user", Content: autoContinuePrompt},
+	)
+	meta.PromptTokens, _, _ = getPromptTokens(&continuationRequest, meta.Mode)
+	convertedRequest, err := a.ConvertRequest(c, meta.Mode, &continuationRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	resp, err := a.DoRequest(c, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	recordUpstreamRateLimit(c, meta.ChannelId, resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, RelayErrorHandler(resp)
+	}
+	return resp, nil
+}
+
+// stitchChatResponse replaces the content, finish reason and usage of the response of the first segment,
+// the other fields of the response are kept as is
+func stitchChatResponse(body []byte, content string, finishReason string, usage model.Usage) ([]byte, error) {
+	var response map[string]any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	choices, ok := response["choices"].([]any)
+	if !ok || len(choices) != 1 {
+		return nil, errors.New("response must have exactly one choice")
+	}
+	choice, ok := choices[0].(map[string]any)
+	if !ok {
+		return nil, errors.New("choice is not an object")
+	}
+	message, ok := choice["message"].(map[string]any)
+	if !ok {
+		return nil, errors.New("choice has no message")
+	}
+	message["content"] = content
+	choice["finish_reason"] = finishReason
+	response["usage"] = usage
+	return json.Marshal(response)
+}
+
+// doResponseWithAutoContinue continues a chat completion truncated by max_tokens up to MAX_AUTO_CONTINUATIONS times,
+// the contents of the segments are concatenated and their usages are summed, a failed continuation ends it
+// with the content generated so far
+func doResponseWithAutoContinue(c *gin.Context, meta *meta.Meta, a adaptor.Adaptor, textRequest *model.GeneralOpenAIRequest, resp *http.Response) (*model.Usage, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	writer, usage, respErr := doChatSegment(c, meta, a, resp)
+	if respErr != nil {
+		return nil, respErr
+	}
+	body := writer.body.Bytes()
+	for k, v := range writer.Header() {
+		if k != "Content-Length" {
+			c.Writer.Header()[k] = v
+		}
+	}
+	var segment openai.TextResponse
+	if err := json.Unmarshal(body, &segment); err != nil || len(segment.Choices) != 1 || !segment.Choices[0].IsStringContent() {
+		c.Data(writer.Status(), "application/json", body)
+		return usage, nil
+	}
+	content := segment.Choices[0].StringContent()
+	finishReason := segment.Choices[0].FinishReason
+	total := *usage
+	// the prompt tokens counted by the gateway for all the segments, to be reconciled with the upstream
+	promptTokens := meta.PromptTokens
+	continuations := 0
+	for ; finishReason == "length" && continuations < config.MaxAutoContinuations; continuations++ {
+		resp, bizErr := requestChatContinuation(c, meta, a, textRequest, content)
+		if bizErr != nil {
+			logger.Warnf(ctx, "continuation %d failed: %s", continuations+1, bizErr.Message)
+			break
+		}
+		continuationWriter, continuationUsage, bizErr := doChatSegment(c, meta, a, resp)
+		if bizErr != nil {
+			logger.Warnf(ctx, "continuation %d failed: %s", continuations+1, bizErr.Message)
+			break
+		}
+		promptTokens += meta.PromptTokens
+		// the continuation is billed even if it can't be stitched, as the upstream charged for it
+		total.PromptTokens += continuationUsage.PromptTokens
+		total.CompletionTokens += continuationUsage.CompletionTokens
+		total.TotalTokens += continuationUsage.TotalTokens
+		var continuation openai.TextResponse
+		if err := json.Unmarshal(continuationWriter.body.Bytes(), &continuation); err != nil || len(continuation.Choices) != 1 {
+			logger.Warnf(ctx, "continuation %d returned an unexpected response", continuations+1)
+			break
+		}
+		content += continuation.Choices[0].StringContent()
+		finishReason = continuation.Choices[0].FinishReason
+	}
+	meta.PromptTokens = promptTokens
+	if continuations == 0 {
+		c.Data(writer.Status(), "application/json", body)
+		return &total, nil
+	}
+	logger.Infof(ctx, "stitched %d continuations of the completion truncated by max_tokens, finish reason is %s", continuations, finishReason)
+	stitched, err := stitchChatResponse(body, content, finishReason, total)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "stitch_response_failed", http.StatusInternalServerError)
+	}
+	c.Data(http.StatusOK, "application/json", stitched)
+	return &total, nil
+}
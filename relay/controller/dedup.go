@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+// DeduplicatedHeader is set on the responses given to duplicate requests, which are not sent upstream nor billed
+const DeduplicatedHeader = "X-One-API-Deduplicated"
+
+// dedupCall is a request whose duplicates of the same token get its response, while it is in flight
+// and until its window has passed since it completed
+type dedupCall struct {
+	done      chan struct{}
+	once      sync.Once
+	window    time.Duration
+	expiresAt time.Time
+	status    int
+	header    http.Header
+	body      []byte
+	succeeded bool
+}
+
+var dedupCalls = make(map[string]*dedupCall)
+var dedupCallsLock sync.Mutex
+
+// getDedupKey returns the key the duplicates of the request share, empty if the group has no dedup window,
+// unlike the coalescing key it is bound to the token, as the duplicates are not billed, the accept header,
+// the stream forcing header and the query string are part of the key, as they change the response
+func getDedupKey(c *gin.Context, meta *meta.Meta) (string, time.Duration) {
+	window := model.GetGroupDedupWindow(meta.Group)
+	if window <= 0 {
+		return "", 0
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return "", 0
+	}
+	hash := sha256.New()
+	hash.Write([]byte(strconv.Itoa(meta.TokenId) + "\n" + c.Request.URL.Path + "\n" + c.Request.URL.RawQuery + "\n" +
+		c.Request.Header.Get("Accept") + "\n" + c.Request.Header.Get(ForceStreamHeader) + "\n"))
+	hash.Write(requestBody)
+	return hex.EncodeToString(hash.Sum(nil)), time.Duration(window) * time.Millisecond
+}
+
+// joinDedupCall returns the call the request duplicates, or starts a new call, the second return value
+// is true if the caller starts the call and must finish it
+func joinDedupCall(key string, window time.Duration) (*dedupCall, bool) {
+	dedupCallsLock.Lock()
+	defer dedupCallsLock.Unlock()
+	if call, ok := dedupCalls[key]; ok && (call.expiresAt.IsZero() || time.Now().Before(call.expiresAt)) {
+		return call, false
+	}
+	call := &dedupCall{done: make(chan struct{}), window: window}
+	dedupCalls[key] = call
+	return call, true
+}
+
+// finish keeps the response for the duplicates until the window has passed, a failed call is forgotten at once,
+// so that its duplicates are sent on their own, only the first finish counts, so it can be deferred for the failures
+func (call *dedupCall) finish(key string, writer *coalescingResponseWriter, succeeded bool) {
+	call.once.Do(func() {
+		dedupCallsLock.Lock()
+		if writer != nil && succeeded {
+			call.status = writer.Status()
+			call.header = writer.Header().Clone()
+			call.body = writer.body.Bytes()
+			call.succeeded = true
+			call.expiresAt = time.Now().Add(call.window)
+			time.AfterFunc(call.window, func() { forgetDedupCall(key, call) })
+		} else {
+			delete(dedupCalls, key)
+		}
+		dedupCallsLock.Unlock()
+		close(call.done)
+	})
+}
+
+// forgetDedupCall drops the call once its window has passed, unless a new call of the key has replaced it
+func forgetDedupCall(key string, call *dedupCall) {
+	dedupCallsLock.Lock()
+	defer dedupCallsLock.Unlock()
+	if dedupCalls[key] == call {
+		delete(dedupCalls, key)
+	}
+}
+
+// waitDedupCall waits for the call and writes its response, it returns false if the call failed and nothing is written,
+// and an error if the client went away first
+func waitDedupCall(c *gin.Context, call *dedupCall) (bool, error) {
+	select {
+	case <-call.done:
+	case <-c.Request.Context().Done():
+		return false, c.Request.Context().Err()
+	}
+	if !call.succeeded {
+		return false, nil
+	}
+	for k, v := range call.header {
+		c.Writer.Header()[k] = v
+	}
+	c.Writer.Header().Set(DeduplicatedHeader, "true")
+	c.Writer.WriteHeader(call.status)
+	_, _ = c.Writer.Write(call.body)
+	return true, nil
+}
+
+func startDedup(c *gin.Context, call *dedupCall) *coalescingResponseWriter {
+	if call == nil {
+		return nil
+	}
+	writer := &coalescingResponseWriter{ResponseWriter: c.Writer, body: &bytes.Buffer{}}
+	c.Writer = writer
+	return writer
+}
+
+// finishDedup stops copying the response, and keeps it for the duplicates if the request succeeded
+func finishDedup(c *gin.Context, key string, call *dedupCall, writer *coalescingResponseWriter, succeeded bool) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	call.finish(key, writer, succeeded)
+}
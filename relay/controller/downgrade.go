@@ -0,0 +1,18 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// applyModelDowngrade replaces the model of the request with the cheaper one the distributor picked
+// the channel for, so that the request is billed for it and the response reports it
+func applyModelDowngrade(c *gin.Context, textRequest *relaymodel.GeneralOpenAIRequest) bool {
+	downgradedFrom := c.GetString(ctxkey.DowngradedFrom)
+	if downgradedFrom == "" || textRequest.Model != downgradedFrom {
+		return false
+	}
+	textRequest.Model = c.GetString(ctxkey.RequestModel)
+	return true
+}
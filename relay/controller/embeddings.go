@@ -0,0 +1,218 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// PartialResultsHeader lets a client opt in to partial results of embeddings requests, the inputs are sent
+// in sub-batches of EMBEDDINGS_BATCH_SIZE, and the indices of the inputs of the failed ones are reported
+// in failed_indices instead of failing the whole request
+const PartialResultsHeader = "X-One-API-Partial-Results"
+
+func isPartialResultsEnabled(c *gin.Context) bool {
+	partialResults, err := strconv.ParseBool(c.GetHeader(PartialResultsHeader))
+	return err == nil && partialResults
+}
+
+// splitEmbeddingsInput splits a list of inputs into sub-batches of batchSize, the inputs are either texts
+// or token arrays, a single text or token array is never split
+func splitEmbeddingsInput(input any, batchSize int) [][]any {
+	inputs, ok := input.([]any)
+	if !ok || batchSize <= 0 || len(inputs) <= batchSize {
+		return nil
+	}
+	for _, item := range inputs {
+		switch item.(type) {
+		case string, []any:
+		default:
+			// a token array of a single input
+			return nil
+		}
+	}
+	var batches [][]any
+	for start := 0; start < len(inputs); start += batchSize {
+		end := start + batchSize
+		if end > len(inputs) {
+			end = len(inputs)
+		}
+		batches = append(batches, inputs[start:end])
+	}
+	return batches
+}
+
+type embeddingsBatchItem struct {
+	Object string `json:"object"`
+	Index  int    `json:"index"`
+	// kept as is, as the embedding may be a base64 string as well
+	Embedding json.RawMessage `json:"embedding"`
+}
+
+type embeddingsBatchResponse struct {
+	Object        string                `json:"object"`
+	Data          []embeddingsBatchItem `json:"data"`
+	Model         string                `json:"model"`
+	Usage         model.Usage           `json:"usage"`
+	FailedIndices []int                 `json:"failed_indices,omitempty"`
+}
+
+// mergeEmbeddingsBatch adds the items of the batch starting at offset to the merged response
+func mergeEmbeddingsBatch(merged *embeddingsBatchResponse, batch *embeddingsBatchResponse, offset int) {
+	for _, item := range batch.Data {
+		item.Index += offset
+		merged.Data = append(merged.Data, item)
+	}
+	if merged.Model == "" {
+		merged.Model = batch.Model
+	}
+	merged.Usage.PromptTokens += batch.Usage.PromptTokens
+	merged.Usage.CompletionTokens += batch.Usage.CompletionTokens
+	merged.Usage.TotalTokens += batch.Usage.TotalTokens
+}
+
+// relayEmbeddingsBatch sends a sub-batch of an embeddings request, the response is decoded
+// instead of being written to the client
+func relayEmbeddingsBatch(c *gin.Context, meta *meta.Meta, a adaptor.Adaptor, textRequest *model.GeneralOpenAIRequest, batch []any) (*embeddingsBatchResponse, *model.ErrorWithStatusCode) {
+	batchRequest := *textRequest
+	batchRequest.Input = batch
+	meta.PromptTokens, _, _ = getPromptTokens(&batchRequest, meta.Mode)
+	convertedRequest, err := a.ConvertRequest(c, meta.Mode, &batchRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	resp, err := a.DoRequest(c, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	recordUpstreamRateLimit(c, meta.ChannelId, resp)
+	if resp.StatusCode != http.StatusOK {
+		return nil, RelayErrorHandler(resp)
+	}
+	writer := newBufferResponseWriter(c.Writer)
+	originalWriter := c.Writer
+	c.Writer = writer
+	usage, respErr := a.DoResponse(c, resp, meta)
+	c.Writer = originalWriter
+	if respErr != nil {
+		return nil, respErr
+	}
+	var batchResponse embeddingsBatchResponse
+	if err = json.Unmarshal(writer.body.Bytes(), &batchResponse); err != nil {
+		return nil, openai.ErrorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if usage != nil {
+		// the usage the adaptor bills, which is estimated if the upstream doesn't report it
+		batchResponse.Usage = *usage
+	}
+	return &batchResponse, nil
+}
+
+// relayEmbeddingsInBatches sends the sub-batches of an embeddings request one after another and writes
+// the results of the successful ones, it fails only if all of them fail, and returns the usage of the successful ones
+func relayEmbeddingsInBatches(c *gin.Context, meta *meta.Meta, a adaptor.Adaptor, textRequest *model.GeneralOpenAIRequest, batches [][]any) (*model.Usage, *model.ErrorWithStatusCode) {
+	ctx := c.Request.Context()
+	merged := &embeddingsBatchResponse{Object: "list"}
+	succeeded := 0
+	var lastErr *model.ErrorWithStatusCode
+	offset := 0
+	for i, batch := range batches {
+		batchResponse, bizErr := relayEmbeddingsBatch(c, meta, a, textRequest, batch)
+		if bizErr != nil {
+			logger.Warnf(ctx, "embeddings sub-batch %d/%d failed: %s", i+1, len(batches), bizErr.Message)
+			for j := range batch {
+				merged.FailedIndices = append(merged.FailedIndices, offset+j)
+			}
+			lastErr = bizErr
+		} else {
+			mergeEmbeddingsBatch(merged, batchResponse, offset)
+			succeeded++
+		}
+		offset += len(batch)
+	}
+	if succeeded == 0 {
+		return nil, lastErr
+	}
+	if len(merged.FailedIndices) > 0 {
+		logger.Warnf(ctx, "%d of %d embeddings sub-batches failed, returned partial results", len(batches)-succeeded, len(batches))
+	}
+	sort.Slice(merged.Data, func(i, j int) bool {
+		return merged.Data[i].Index < merged.Data[j].Index
+	})
+	meta.PromptTokens = merged.Usage.PromptTokens
+	c.JSON(http.StatusOK, merged)
+	return &merged.Usage, nil
+}
+
+// bufferResponseWriter keeps what is written to it, so that a response can be processed before reaching the client
+type bufferResponseWriter struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBufferResponseWriter(w gin.ResponseWriter) *bufferResponseWriter {
+	return &bufferResponseWriter{ResponseWriter: w, header: make(http.Header)}
+}
+
+func (w *bufferResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferResponseWriter) Write(data []byte) (int, error) {
+	w.WriteHeaderNow()
+	return w.body.Write(data)
+}
+
+func (w *bufferResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *bufferResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *bufferResponseWriter) WriteHeaderNow() {
+	w.WriteHeader(http.StatusOK)
+}
+
+func (w *bufferResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *bufferResponseWriter) Size() int {
+	return w.body.Len()
+}
+
+func (w *bufferResponseWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *bufferResponseWriter) Flush() {
+}
+
+// release writes the held header and status with the body, which may have been processed, to c.Writer,
+// which must be restored to the wrapped writer before
+func (w *bufferResponseWriter) release(c *gin.Context, body []byte) {
+	for k, v := range w.header {
+		c.Writer.Header()[k] = v
+	}
+	c.Writer.WriteHeader(w.Status())
+	_, _ = c.Writer.Write(body)
+}
@@ -1,26 +1,42 @@
 package controller
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/songquanpeng/one-api/common"
 	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/controller/validator"
 	"github.com/songquanpeng/one-api/relay/meta"
 	relaymodel "github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
+	"io"
 	"math"
 	"net/http"
+	"strconv"
+	"strings"
 )
 
-func getAndValidateTextRequest(c *gin.Context, relayMode int) (*relaymodel.GeneralOpenAIRequest, error) {
+// TokenExpiresAtHeader carries the unix timestamp at which a soon-to-expire token expires
+const TokenExpiresAtHeader = "X-Token-Expires-At"
+
+// ParamOverrideHeaderPrefix is the prefix of the headers admin tokens use to override request params,
+// e.g. X-Override-Temperature overrides temperature and X-Override-Top-P overrides top_p
+const ParamOverrideHeaderPrefix = "X-Override-"
+
+func getTextRequest(c *gin.Context, relayMode int) (*relaymodel.GeneralOpenAIRequest, error) {
 	textRequest := &relaymodel.GeneralOpenAIRequest{}
 	err := common.UnmarshalBodyReusable(c, textRequest)
 	if err != nil {
@@ -32,6 +48,14 @@ func getAndValidateTextRequest(c *gin.Context, relayMode int) (*relaymodel.Gener
 	if relayMode == relaymode.Embeddings && textRequest.Model == "" {
 		textRequest.Model = c.Param("model")
 	}
+	return textRequest, nil
+}
+
+func getAndValidateTextRequest(c *gin.Context, relayMode int) (*relaymodel.GeneralOpenAIRequest, error) {
+	textRequest, err := getTextRequest(c, relayMode)
+	if err != nil {
+		return nil, err
+	}
 	err = validator.ValidateTextRequest(textRequest, relayMode)
 	if err != nil {
 		return nil, err
@@ -111,28 +135,82 @@ func getImageCostRatio(imageRequest *relaymodel.ImageRequest) (float64, error) {
 	return imageCostRatio, nil
 }
 
-func getPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int) int {
+// getPromptTokens returns the prompt tokens of the request, and the parts of them spent on images and on audio
+func getPromptTokens(textRequest *relaymodel.GeneralOpenAIRequest, relayMode int) (int, int, int) {
 	switch relayMode {
 	case relaymode.ChatCompletions:
-		return openai.CountTokenMessages(textRequest.Messages, textRequest.Model)
+		textTokens, imageTokens, audioTokens := openai.CountTokenMessagesByType(textRequest.Messages, textRequest.Model)
+		return textTokens + imageTokens + audioTokens, imageTokens, audioTokens
 	case relaymode.Completions:
-		return openai.CountTokenInput(textRequest.Prompt, textRequest.Model)
+		return openai.CountTokenInput(textRequest.Prompt, textRequest.Model), 0, 0
 	case relaymode.Moderations:
-		return openai.CountTokenInput(textRequest.Input, textRequest.Model)
+		return openai.CountTokenInput(textRequest.Input, textRequest.Model), 0, 0
+	case relaymode.Edits:
+		return openai.CountTokenInput(textRequest.Input, textRequest.Model) + openai.CountTokenText(textRequest.Instruction, textRequest.Model), 0, 0
+	case relaymode.Embeddings:
+		// used when upstream doesn't report the usage of embeddings
+		return openai.CountTokenInput(textRequest.Input, textRequest.Model), 0, 0
 	}
-	return 0
+	return 0, 0, 0
+}
+
+// splitPromptTokens splits the reported prompt tokens into text, image and audio ones, the image and the audio parts
+// are the estimations made before the request since upstreams only report the total
+func splitPromptTokens(promptTokens int, imagePromptTokens int, audioPromptTokens int) (int, int, int) {
+	if imagePromptTokens > promptTokens {
+		imagePromptTokens = promptTokens
+	}
+	if audioPromptTokens > promptTokens-imagePromptTokens {
+		audioPromptTokens = promptTokens - imagePromptTokens
+	}
+	return promptTokens - imagePromptTokens - audioPromptTokens, imagePromptTokens, audioPromptTokens
 }
 
 func getPreConsumedQuota(textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64) int64 {
 	preConsumedTokens := config.PreConsumedQuota
 	if textRequest.MaxTokens != 0 {
-		preConsumedTokens = int64(promptTokens) + int64(textRequest.MaxTokens)
+		// reserve a little more than requested, the excess is refunded on settlement
+		completionTokens := int64(math.Ceil(float64(textRequest.MaxTokens) * config.PreConsumedCompletionMultiplier))
+		preConsumedTokens = int64(promptTokens) + completionTokens
 	}
 	return int64(float64(preConsumedTokens) * ratio)
 }
 
+// getEstimatedCompletionTokens returns the max tokens of the request, or the room the prompt leaves
+// in the context of the model when they are not set, as the completion may take all of it
+func getEstimatedCompletionTokens(textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int) int {
+	if textRequest.MaxTokens != 0 {
+		return textRequest.MaxTokens
+	}
+	maxContext := model.GetModelCapabilities(textRequest.Model).MaxContext
+	if maxContext > promptTokens {
+		return maxContext - promptTokens
+	}
+	return 0
+}
+
+// checkMaxRequestQuota rejects the requests whose estimated quota exceeds the limit of a single request of the group
+func checkMaxRequestQuota(group string, estimatedQuota int64) *relaymodel.ErrorWithStatusCode {
+	maxRequestQuota := billingratio.GetMaxRequestQuota(group, config.MaxRequestQuota)
+	if maxRequestQuota <= 0 || estimatedQuota <= maxRequestQuota {
+		return nil
+	}
+	err := fmt.Errorf("estimated quota %d of this request exceeds the limit %d of a single request", estimatedQuota, maxRequestQuota)
+	return openai.ErrorWrapper(err, "request_quota_exceeded", http.StatusBadRequest)
+}
+
 func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIRequest, promptTokens int, ratio float64, meta *meta.Meta) (int64, *relaymodel.ErrorWithStatusCode) {
+	completionRatio := billingratio.GetCompletionRatio(textRequest.Model)
+	completionTokens := getEstimatedCompletionTokens(textRequest, promptTokens)
+	estimatedQuota := int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
+	if bizErr := checkMaxRequestQuota(meta.Group, estimatedQuota); bizErr != nil {
+		return 0, bizErr
+	}
 	preConsumedQuota := getPreConsumedQuota(textRequest, promptTokens, ratio)
+	if model.GetFreeAllowanceRemaining(meta.UserId, textRequest.Model) >= estimatedQuota {
+		// the request is expected to be covered by the user's free allowance
+		preConsumedQuota = 0
+	}
 
 	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
 	if err != nil {
@@ -145,9 +223,10 @@ func preConsumeQuota(ctx context.Context, textRequest *relaymodel.GeneralOpenAIR
 	if err != nil {
 		return preConsumedQuota, openai.ErrorWrapper(err, "decrease_user_quota_failed", http.StatusInternalServerError)
 	}
-	if userQuota > 100*preConsumedQuota {
+	if userQuota > 100*preConsumedQuota && meta.TokenQuotaPoolId == 0 {
 		// in this case, we do not pre-consume quota
-		// because the user has enough quota
+		// because the user has enough quota, unless the token shares a pool, which must be reserved
+		// so that the concurrent requests of its member tokens can't overdraw it
 		preConsumedQuota = 0
 		logger.Info(ctx, fmt.Sprintf("user %d has enough quota %d, trusted and no need to pre-consume", meta.UserId, userQuota))
 	}
@@ -168,17 +247,45 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 	var quota int64
 	completionRatio := billingratio.GetCompletionRatio(textRequest.Model)
 	promptTokens := usage.PromptTokens
-	completionTokens := usage.CompletionTokens
-	quota = int64(math.Ceil((float64(promptTokens) + float64(completionTokens)*completionRatio) * ratio))
+	completionTokens := getBilledCompletionTokens(usage)
+	textPromptTokens, imagePromptTokens, audioPromptTokens := splitPromptTokens(promptTokens, meta.ImagePromptTokens, meta.AudioPromptTokens)
+	imageTokenRatio := config.ImageTokenRatio
+	audioTokenRatio := config.AudioTokenRatio
+	// e.g. the flex tier is cheaper than the default one
+	serviceTierRatio := billingratio.GetServiceTierRatio(meta.ServiceTier)
+	ratio *= serviceTierRatio
+	quota = int64(math.Ceil((float64(textPromptTokens) + float64(imagePromptTokens)*imageTokenRatio + float64(audioPromptTokens)*audioTokenRatio +
+		float64(completionTokens)*completionRatio) * ratio))
 	if ratio != 0 && quota <= 0 {
 		quota = 1
 	}
 	totalTokens := promptTokens + completionTokens
+	model.RecordChannelTokens(meta.ChannelId, totalTokens)
 	if totalTokens == 0 {
 		// in this case, must be some error happened
 		// we cannot just return, because we may have to return the pre-consumed quota
 		quota = 0
 	}
+	logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f", modelRatio, groupRatio, completionRatio)
+	if imagePromptTokens > 0 {
+		logContent += fmt.Sprintf("，图片倍率 %.2f", imageTokenRatio)
+	}
+	if audioPromptTokens > 0 {
+		logContent += fmt.Sprintf("，音频提示 %d tokens，音频倍率 %.2f", audioPromptTokens, audioTokenRatio)
+	}
+	// the reasoning tokens are part of the completion tokens, so a higher effort is billed for the tokens it takes
+	if textRequest.ReasoningEffort != "" {
+		logContent += fmt.Sprintf("，推理强度 %s", textRequest.ReasoningEffort)
+	}
+	if details := usage.CompletionTokensDetails; details != nil && (details.AcceptedPredictionTokens > 0 || details.RejectedPredictionTokens > 0) {
+		logContent += fmt.Sprintf("，预测输出接受 %d tokens，拒绝 %d tokens", details.AcceptedPredictionTokens, details.RejectedPredictionTokens)
+	}
+	logContent += billing.ChannelMarkupLogContent(meta.ChannelMarkup)
+	if serviceTierRatio != 1 {
+		logContent += fmt.Sprintf("，服务层级 %s 倍率 %.2f", meta.ServiceTier, serviceTierRatio)
+	}
+	quota, freeLogContent := billing.ConsumeFreeAllowance(meta.UserId, textRequest.Model, quota)
+	logContent += freeLogContent
 	quotaDelta := quota - preConsumedQuota
 	err := model.PostConsumeTokenQuota(meta.TokenId, quotaDelta)
 	if err != nil {
@@ -188,12 +295,27 @@ func postConsumeQuota(ctx context.Context, usage *relaymodel.Usage, meta *meta.M
 	if err != nil {
 		logger.Error(ctx, "error update user quota cache: "+err.Error())
 	}
-	logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，补全倍率 %.2f", modelRatio, groupRatio, completionRatio)
-	model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, promptTokens, completionTokens, textRequest.Model, meta.TokenName, quota, logContent)
+	// the image tokens are logged as a part of the prompt tokens, the audio ones are noted in the content
+	model.RecordConsumeLogWithImageTokens(ctx, meta.UserId, meta.ChannelId, promptTokens, imagePromptTokens, completionTokens, textRequest.Model, meta.TokenName, quota, logContent)
 	model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
 	model.UpdateChannelUsedQuota(meta.ChannelId, quota)
 }
 
+// getBilledCompletionTokens returns the completion tokens the request is billed for, the accepted and the rejected
+// prediction tokens are both billed at the completion rate, as openai counts them in the completion tokens,
+// which are raised to cover them for the upstreams reporting them apart
+func getBilledCompletionTokens(usage *relaymodel.Usage) int {
+	completionTokens := usage.CompletionTokens
+	details := usage.CompletionTokensDetails
+	if details == nil {
+		return completionTokens
+	}
+	if predictionTokens := details.AcceptedPredictionTokens + details.RejectedPredictionTokens; completionTokens < predictionTokens {
+		completionTokens = predictionTokens
+	}
+	return completionTokens
+}
+
 func getMappedModelName(modelName string, mapping map[string]string) (string, bool) {
 	if mapping == nil {
 		return modelName, false
@@ -204,3 +326,365 @@ func getMappedModelName(modelName string, mapping map[string]string) (string, bo
 	}
 	return modelName, false
 }
+
+// supportsInputAudio reports whether the channel accepts the audio of the messages for the model, gemini converts it,
+// of the openai compatible channels only openai and azure take it, for their audio models, the others
+// would reject it or drop it silently
+func supportsInputAudio(meta *meta.Meta) bool {
+	if meta.APIType == apitype.Gemini {
+		return true
+	}
+	return isOpenAIFamilyChannel(meta.ChannelType) && strings.Contains(meta.ActualModelName, "audio")
+}
+
+func isOpenAIFamilyChannel(channelType int) bool {
+	return channelType == channeltype.OpenAI || channelType == channeltype.Azure
+}
+
+// stripUnsupportedFields removes the fields of a request to an openai compatible channel which its upstream
+// doesn't understand, it returns whether anything is stripped, in which case the request body must be rebuilt
+func stripUnsupportedFields(ctx context.Context, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest) bool {
+	// store & metadata are only understood by openai itself, strip them for other compatible channels
+	shouldStripStore := (textRequest.Store || textRequest.Metadata != nil) && !isOpenAIFamilyChannel(meta.ChannelType)
+	if shouldStripStore {
+		textRequest.Store = false
+		textRequest.Metadata = nil
+	}
+	// the token ids of logit_bias depend on the tokenizer, only the openai family is known to accept them
+	shouldStripLogitBias := textRequest.LogitBias != nil && !isOpenAIFamilyChannel(meta.ChannelType)
+	if shouldStripLogitBias {
+		logger.Warnf(ctx, "logit_bias is not supported by channel #%d, stripped", meta.ChannelId)
+		textRequest.LogitBias = nil
+	}
+	// while top_k is rejected by openai itself
+	shouldStripTopK := textRequest.TopK != 0 && isOpenAIFamilyChannel(meta.ChannelType)
+	if shouldStripTopK {
+		textRequest.TopK = 0
+	}
+	// service_tier selects the processing tier of openai, other backends don't know it
+	shouldStripServiceTier := textRequest.ServiceTier != "" && !isOpenAIFamilyChannel(meta.ChannelType)
+	if shouldStripServiceTier {
+		textRequest.ServiceTier = ""
+	}
+	// reasoning_effort is rejected by the models which don't reason
+	shouldStripReasoningEffort := textRequest.ReasoningEffort != "" &&
+		(!isOpenAIFamilyChannel(meta.ChannelType) || !openai.IsReasoningModel(meta.ActualModelName))
+	if shouldStripReasoningEffort {
+		textRequest.ReasoningEffort = ""
+	}
+	// predicted outputs are an openai feature, other backends would reject or ignore them
+	shouldStripPrediction := textRequest.Prediction != nil && !isOpenAIFamilyChannel(meta.ChannelType)
+	if shouldStripPrediction {
+		textRequest.Prediction = nil
+	}
+	return shouldStripStore || shouldStripLogitBias || shouldStripTopK || shouldStripServiceTier || shouldStripReasoningEffort || shouldStripPrediction
+}
+
+// shouldKeepFirstToolCall reports whether the gateway enforces parallel_tool_calls: false for the channel,
+// the responses of the openai adaptor are filtered as they are relayed, the others by the response normalizer
+func shouldKeepFirstToolCall(c *gin.Context, textRequest *relaymodel.GeneralOpenAIRequest) bool {
+	if textRequest.ParallelToolCalls == nil || *textRequest.ParallelToolCalls {
+		return false
+	}
+	return c.GetString(ctxkey.ConfigSingleToolCall) == "true"
+}
+
+// checkEmptyResponseBody returns an error if the response body is empty or only contains whitespace,
+// the body is reset so that it can be read again
+func checkEmptyResponseBody(resp *http.Response) error {
+	if resp.Body == nil {
+		return errors.New("upstream returned an empty response body")
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
+	if len(bytes.TrimSpace(responseBody)) == 0 {
+		return errors.New("upstream returned an empty response body")
+	}
+	return nil
+}
+
+// ChannelAtCapacityErrorCode is the code of the error returned when the local rpm or tpm limit of a channel is reached,
+// such an error makes the request fail over to another channel without the channel being disabled
+const ChannelAtCapacityErrorCode = "channel_at_capacity"
+
+// CheckChannelCapacity counts the request against the local rate limits of the selected channel
+func CheckChannelCapacity(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	rpm, tpm := model.ParseLocalRateLimit(c.GetString(ctxkey.ConfigRPM), c.GetString(ctxkey.ConfigTPM))
+	channelId := c.GetInt(ctxkey.ChannelId)
+	if model.AcquireChannelRequest(channelId, rpm, tpm) {
+		return nil
+	}
+	return openai.ErrorWrapper(fmt.Errorf("channel #%d is at capacity", channelId), ChannelAtCapacityErrorCode, http.StatusTooManyRequests)
+}
+
+// CheckTokenExpiry rejects tokens which have expired since they were authenticated,
+// and reports the expiry time in a response header when the token is about to expire
+func CheckTokenExpiry(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	expiredTime := c.GetInt64(ctxkey.TokenExpiredTime)
+	if expiredTime <= 0 {
+		// -1 means never expired
+		return nil
+	}
+	now := helper.GetTimestamp()
+	if model.IsTokenExpired(expiredTime) {
+		return &relaymodel.ErrorWithStatusCode{
+			Error: relaymodel.Error{
+				Message: "token expired",
+				Type:    "invalid_request_error",
+				Code:    "token_expired",
+			},
+			StatusCode: http.StatusUnauthorized,
+		}
+	}
+	if expiredTime-now <= int64(config.TokenExpiryWarningWindow) {
+		c.Header(TokenExpiresAtHeader, strconv.FormatInt(expiredTime, 10))
+	}
+	return nil
+}
+
+// checkInputTokenLimit rejects the prompts longer than the model accepts, with the error openai returns for them,
+// so that they don't take a round trip to the upstream, the limit of the mapped model applies if it has one
+func checkInputTokenLimit(meta *meta.Meta, promptTokens int) *relaymodel.ErrorWithStatusCode {
+	modelName := meta.ActualModelName
+	limit := model.GetModelMaxInputTokens(modelName)
+	if limit <= 0 {
+		modelName = meta.OriginModelName
+		limit = model.GetModelMaxInputTokens(modelName)
+	}
+	if limit <= 0 || promptTokens <= limit {
+		return nil
+	}
+	return &relaymodel.ErrorWithStatusCode{
+		Error: relaymodel.Error{
+			Message: fmt.Sprintf("This model's maximum context length is %d tokens. However, your messages resulted in %d tokens. Please reduce the length of the messages.", limit, promptTokens),
+			Type:    "invalid_request_error",
+			Param:   "messages",
+			Code:    "context_length_exceeded",
+		},
+		StatusCode: http.StatusBadRequest,
+	}
+}
+
+// normalizeUsage makes the total tokens of the usage the sum of the prompt and the completion tokens, the upstreams
+// which leave the cached or the reasoning tokens, or both, out of the prompt or the completion tokens while counting
+// them in the total get them added back, so that they are billed, it returns whether the usage has been changed
+func normalizeUsage(ctx context.Context, meta *meta.Meta, usage *relaymodel.Usage) bool {
+	if usage == nil {
+		return false
+	}
+	original := *usage
+	cachedTokens, reasoningTokens := 0, 0
+	if usage.PromptTokensDetails != nil {
+		cachedTokens = usage.PromptTokensDetails.CachedTokens
+	}
+	if usage.CompletionTokensDetails != nil {
+		reasoningTokens = usage.CompletionTokensDetails.ReasoningTokens
+	}
+	sum := usage.PromptTokens + usage.CompletionTokens
+	if usage.TotalTokens != sum {
+		switch usage.TotalTokens {
+		case sum + cachedTokens + reasoningTokens:
+			usage.PromptTokens += cachedTokens
+			usage.CompletionTokens += reasoningTokens
+		case sum + cachedTokens:
+			usage.PromptTokens += cachedTokens
+		case sum + reasoningTokens:
+			usage.CompletionTokens += reasoningTokens
+		}
+	}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	if usage.PromptTokens == original.PromptTokens && usage.CompletionTokens == original.CompletionTokens && usage.TotalTokens == original.TotalTokens {
+		return false
+	}
+	// many upstreams don't report the total at all, which is only filled in
+	if original.TotalTokens == 0 {
+		return true
+	}
+	logger.WarnWithFields(ctx, "inconsistent usage reported by upstream", logger.Fields{
+		"channel_id":                 meta.ChannelId,
+		"model":                      meta.ActualModelName,
+		"upstream_prompt_tokens":     original.PromptTokens,
+		"upstream_completion_tokens": original.CompletionTokens,
+		"upstream_total_tokens":      original.TotalTokens,
+		"prompt_tokens":              usage.PromptTokens,
+		"completion_tokens":          usage.CompletionTokens,
+		"total_tokens":               usage.TotalTokens,
+	})
+	return true
+}
+
+// reconcilePromptTokens compares the prompt tokens counted by the gateway with the ones reported by upstream,
+// so that backends under-reporting their usage can be caught
+func reconcilePromptTokens(ctx context.Context, meta *meta.Meta, usage *relaymodel.Usage) {
+	if usage == nil || meta.PromptTokens == 0 {
+		return
+	}
+	gatewayTokens := meta.PromptTokens
+	upstreamTokens := usage.PromptTokens
+	discrepancy := math.Abs(float64(upstreamTokens-gatewayTokens)) / float64(gatewayTokens)
+	if discrepancy > config.PromptTokensDiscrepancyThreshold {
+		logger.WarnWithFields(ctx, "prompt tokens discrepancy between gateway and upstream", logger.Fields{
+			"channel_id":      meta.ChannelId,
+			"model":           meta.ActualModelName,
+			"gateway_tokens":  gatewayTokens,
+			"upstream_tokens": upstreamTokens,
+		})
+	}
+	if config.GatewayPromptTokensBillingEnabled && upstreamTokens != gatewayTokens {
+		usage.TotalTokens += gatewayTokens - upstreamTokens
+		usage.PromptTokens = gatewayTokens
+	}
+}
+
+// UpstreamRateLimitHeaderPrefix is the prefix of the upstream rate limit headers exposed to the client
+const UpstreamRateLimitHeaderPrefix = "X-Upstream-Ratelimit-"
+
+// recordUpstreamRateLimit records the upstream rate limits of the channel for load balancing,
+// and exposes them to the client with the X-Upstream- prefix when enabled
+func recordUpstreamRateLimit(c *gin.Context, channelId int, resp *http.Response) {
+	model.UpdateChannelRateLimit(channelId, resp.Header)
+	if !config.UpstreamRateLimitHeadersEnabled {
+		return
+	}
+	for k, v := range resp.Header {
+		if len(v) != 0 && strings.HasPrefix(strings.ToLower(k), "x-ratelimit-") {
+			c.Writer.Header().Set(UpstreamRateLimitHeaderPrefix+strings.TrimPrefix(strings.ToLower(k), "x-ratelimit-"), v[0])
+		}
+	}
+}
+
+// ClearUpstreamRateLimitHeaders removes the upstream rate limit headers of a failed attempt before a retry,
+// so that the client doesn't get the limits of a channel which didn't serve the request
+func ClearUpstreamRateLimitHeaders(c *gin.Context) {
+	header := c.Writer.Header()
+	for k := range header {
+		if strings.HasPrefix(k, UpstreamRateLimitHeaderPrefix) {
+			header.Del(k)
+		}
+	}
+}
+
+// injectChannelDefaultParams sets the default params of the channel, a json object in the default_params key
+// of its config, for the fields the client didn't set
+func injectChannelDefaultParams(c *gin.Context, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest) bool {
+	defaultParams := c.GetString(ctxkey.ConfigDefaultParams)
+	if defaultParams == "" {
+		return false
+	}
+	var params map[string]any
+	err := json.Unmarshal([]byte(defaultParams), &params)
+	if err != nil || len(params) == 0 {
+		return false
+	}
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return false
+	}
+	var clientParams map[string]json.RawMessage
+	err = json.Unmarshal(requestBody, &clientParams)
+	if err != nil {
+		return false
+	}
+	injectedParams := make(map[string]any)
+	for k, v := range params {
+		if _, ok := clientParams[k]; !ok {
+			injectedParams[k] = v
+		}
+	}
+	if len(injectedParams) == 0 {
+		return false
+	}
+	jsonParams, err := json.Marshal(injectedParams)
+	if err != nil {
+		return false
+	}
+	err = json.Unmarshal(jsonParams, textRequest)
+	if err != nil {
+		logger.Errorf(c.Request.Context(), "failed to inject default params of channel #%d: %s", meta.ChannelId, err.Error())
+		return false
+	}
+	logger.Infof(c.Request.Context(), "default params of channel #%d injected: %s", meta.ChannelId, string(jsonParams))
+	return true
+}
+
+// getParamOverrides returns the request params given by the X-Override-* headers
+func getParamOverrides(c *gin.Context) map[string]any {
+	overrides := make(map[string]any)
+	for name, values := range c.Request.Header {
+		if len(values) == 0 || !strings.HasPrefix(name, ParamOverrideHeaderPrefix) {
+			continue
+		}
+		field := strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(name, ParamOverrideHeaderPrefix)), "-", "_")
+		var value any
+		if err := json.Unmarshal([]byte(values[0]), &value); err != nil {
+			value = values[0]
+		}
+		overrides[field] = value
+	}
+	return overrides
+}
+
+// CheckParamOverrides rejects the X-Override-* headers unless the token belongs to an admin user, only the fields
+// listed in PARAM_OVERRIDE_FIELDS can be overridden, and only for the text relay modes, as the others would ignore them,
+// it is called once for a request, so that the overrides are logged once however many times it is retried
+func CheckParamOverrides(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatusCode {
+	overrides := getParamOverrides(c)
+	if len(overrides) == 0 {
+		return nil
+	}
+	if c.GetInt(ctxkey.Role) < model.RoleAdminUser {
+		return openai.ErrorWrapper(errors.New("only tokens of admin users can override request params"), "param_override_forbidden", http.StatusForbidden)
+	}
+	switch relayMode {
+	case relaymode.ChatCompletions, relaymode.Completions, relaymode.Embeddings, relaymode.Moderations, relaymode.Edits:
+	default:
+		return openai.ErrorWrapper(errors.New("request params can only be overridden for chat, completions, embeddings, moderations and edits requests"), "param_override_not_supported", http.StatusBadRequest)
+	}
+	for field := range overrides {
+		if !isParamOverridable(field) {
+			return openai.ErrorWrapper(fmt.Errorf("param %s can not be overridden", field), "param_override_not_allowed", http.StatusBadRequest)
+		}
+	}
+	jsonOverrides, err := json.Marshal(overrides)
+	if err != nil {
+		return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	userId := c.GetInt(ctxkey.Id)
+	logger.InfoWithFields(c.Request.Context(), "request params overridden", logger.Fields{
+		"user_id":   userId,
+		"token_id":  c.GetInt(ctxkey.TokenId),
+		"overrides": string(jsonOverrides),
+	})
+	model.RecordLog(userId, model.LogTypeSystem, fmt.Sprintf("令牌 %s 覆盖了请求参数：%s", c.GetString(ctxkey.TokenName), string(jsonOverrides)))
+	return nil
+}
+
+// applyParamOverrides overrides the request params checked by CheckParamOverrides
+func applyParamOverrides(c *gin.Context, textRequest *relaymodel.GeneralOpenAIRequest) (bool, *relaymodel.ErrorWithStatusCode) {
+	overrides := getParamOverrides(c)
+	if len(overrides) == 0 {
+		return false, nil
+	}
+	jsonOverrides, err := json.Marshal(overrides)
+	if err != nil {
+		return false, openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	err = json.Unmarshal(jsonOverrides, textRequest)
+	if err != nil {
+		return false, openai.ErrorWrapper(fmt.Errorf("invalid param override: %s", err.Error()), "invalid_param_override", http.StatusBadRequest)
+	}
+	return true, nil
+}
+
+func isParamOverridable(field string) bool {
+	for _, allowed := range strings.Split(config.ParamOverrideFields, ",") {
+		if strings.TrimSpace(allowed) == field {
+			return true
+		}
+	}
+	return false
+}
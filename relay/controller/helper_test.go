@@ -0,0 +1,1063 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/anthropic"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/client"
+	"github.com/songquanpeng/one-api/relay/controller/validator"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestCheckEmptyResponseBody(t *testing.T) {
+	for _, body := range []string{"", " \n\t\r\n "} {
+		resp := &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+		assert.Error(t, checkEmptyResponseBody(resp))
+	}
+
+	body := `{"id":"chatcmpl-123","object":"chat.completion"}`
+	resp := &http.Response{
+		StatusCode: http.StatusOK,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	}
+	assert.NoError(t, checkEmptyResponseBody(resp))
+	// the body should still be readable afterwards
+	responseBody, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(responseBody))
+}
+
+func newTokenContext(expiredTime int64) (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(ctxkey.TokenExpiredTime, expiredTime)
+	return c, w
+}
+
+func TestCheckTokenExpiry(t *testing.T) {
+	now := helper.GetTimestamp()
+
+	c, w := newTokenContext(now - int64(config.ClockSkewTolerance) - 60)
+	bizErr := CheckTokenExpiry(c)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusUnauthorized, bizErr.StatusCode)
+	assert.Equal(t, "token expired", bizErr.Error.Message)
+	assert.Empty(t, w.Header().Get(TokenExpiresAtHeader))
+
+	// expired within the clock skew tolerance
+	clockSkewTolerance := config.ClockSkewTolerance
+	config.ClockSkewTolerance = 60
+	defer func() {
+		config.ClockSkewTolerance = clockSkewTolerance
+	}()
+	c, _ = newTokenContext(now - 30)
+	assert.Nil(t, CheckTokenExpiry(c))
+
+	expiresAt := now + 60
+	c, w = newTokenContext(expiresAt)
+	assert.Nil(t, CheckTokenExpiry(c))
+	assert.Equal(t, strconv.FormatInt(expiresAt, 10), w.Header().Get(TokenExpiresAtHeader))
+
+	c, w = newTokenContext(now + int64(config.TokenExpiryWarningWindow) + 60)
+	assert.Nil(t, CheckTokenExpiry(c))
+	assert.Empty(t, w.Header().Get(TokenExpiresAtHeader))
+
+	c, w = newTokenContext(-1)
+	assert.Nil(t, CheckTokenExpiry(c))
+	assert.Empty(t, w.Header().Get(TokenExpiresAtHeader))
+}
+
+func TestGetPromptTokens(t *testing.T) {
+	approximateTokenEnabled := config.ApproximateTokenEnabled
+	config.ApproximateTokenEnabled = true
+	defer func() {
+		config.ApproximateTokenEnabled = approximateTokenEnabled
+	}()
+
+	text := "The food was delicious and the waiter was very friendly."
+	expected := int(float64(len(text)) * 0.38)
+	tokens := make([]any, expected)
+	for i := range tokens {
+		tokens[i] = float64(i)
+	}
+	testCases := []struct {
+		name        string
+		relayMode   int
+		textRequest *relaymodel.GeneralOpenAIRequest
+	}{
+		{"completions", relaymode.Completions, &relaymodel.GeneralOpenAIRequest{Model: "gpt-3.5-turbo-instruct", Prompt: text}},
+		{"embeddings", relaymode.Embeddings, &relaymodel.GeneralOpenAIRequest{Model: "text-embedding-3-small", Input: text}},
+		{"embeddings with array input", relaymode.Embeddings, &relaymodel.GeneralOpenAIRequest{Model: "text-embedding-3-small", Input: []any{text}}},
+		{"moderations", relaymode.Moderations, &relaymodel.GeneralOpenAIRequest{Model: "text-moderation-latest", Input: text}},
+		{"embeddings with token array input", relaymode.Embeddings, &relaymodel.GeneralOpenAIRequest{Model: "text-embedding-3-small", Input: tokens}},
+		{"embeddings with token arrays input", relaymode.Embeddings, &relaymodel.GeneralOpenAIRequest{Model: "text-embedding-3-small", Input: []any{tokens[:1], tokens[1:]}}},
+		{"moderations with array input", relaymode.Moderations, &relaymodel.GeneralOpenAIRequest{Model: "text-moderation-latest", Input: []any{text}}},
+		{"edits", relaymode.Edits, &relaymodel.GeneralOpenAIRequest{Model: "text-davinci-edit-001", Input: text}},
+	}
+	for _, testCase := range testCases {
+		t.Run(testCase.name, func(t *testing.T) {
+			promptTokens, imagePromptTokens, audioPromptTokens := getPromptTokens(testCase.textRequest, testCase.relayMode)
+			assert.Equal(t, expected, promptTokens)
+			assert.Equal(t, 0, imagePromptTokens)
+			assert.Equal(t, 0, audioPromptTokens)
+		})
+	}
+}
+
+func TestGetPromptTokensWithImages(t *testing.T) {
+	approximateTokenEnabled := config.ApproximateTokenEnabled
+	config.ApproximateTokenEnabled = true
+	defer func() { config.ApproximateTokenEnabled = approximateTokenEnabled }()
+
+	text := "What is in this image? Describe it in detail."
+	textOnlyRequest := &relaymodel.GeneralOpenAIRequest{
+		Model:    "gpt-4-vision-preview",
+		Messages: []relaymodel.Message{{Role: "user", Content: text}},
+	}
+	mixedRequest := &relaymodel.GeneralOpenAIRequest{
+		Model: "gpt-4-vision-preview",
+		Messages: []relaymodel.Message{{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": text},
+			// low detail images cost a fixed number of tokens, so the image is not fetched
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/cat.png", "detail": "low"}},
+			map[string]any{"type": "image_url", "image_url": map[string]any{"url": "https://example.com/dog.png", "detail": "low"}},
+		}}},
+	}
+
+	textTokens, _, _ := getPromptTokens(textOnlyRequest, relaymode.ChatCompletions)
+	promptTokens, imagePromptTokens, _ := getPromptTokens(mixedRequest, relaymode.ChatCompletions)
+	assert.Equal(t, 2*85, imagePromptTokens)
+	assert.Equal(t, textTokens+imagePromptTokens, promptTokens)
+
+	// the split follows the usage reported by the upstream
+	textPromptTokens, imagePromptTokens, _ := splitPromptTokens(promptTokens+5, imagePromptTokens, 0)
+	assert.Equal(t, textTokens+5, textPromptTokens)
+	assert.Equal(t, 2*85, imagePromptTokens)
+	textPromptTokens, imagePromptTokens, _ = splitPromptTokens(100, 2*85, 0)
+	assert.Equal(t, 0, textPromptTokens)
+	assert.Equal(t, 100, imagePromptTokens)
+	textPromptTokens, imagePromptTokens, audioPromptTokens := splitPromptTokens(100, 60, 50)
+	assert.Equal(t, 0, textPromptTokens)
+	assert.Equal(t, 60, imagePromptTokens)
+	assert.Equal(t, 40, audioPromptTokens)
+}
+
+func TestGetPromptTokensWithAudio(t *testing.T) {
+	approximateTokenEnabled := config.ApproximateTokenEnabled
+	config.ApproximateTokenEnabled = true
+	defer func() { config.ApproximateTokenEnabled = approximateTokenEnabled }()
+
+	// a second of mp3 at 128 kbps
+	audio := base64.StdEncoding.EncodeToString(make([]byte, 16000))
+	request := &relaymodel.GeneralOpenAIRequest{
+		Model: "gpt-4o-audio-preview",
+		Messages: []relaymodel.Message{{Role: "user", Content: []any{
+			map[string]any{"type": "input_audio", "input_audio": map[string]any{"data": audio, "format": "mp3"}},
+		}}},
+	}
+	promptTokens, imagePromptTokens, audioPromptTokens := getPromptTokens(request, relaymode.ChatCompletions)
+	assert.Equal(t, 0, imagePromptTokens)
+	assert.Equal(t, 10, audioPromptTokens)
+	assert.Greater(t, promptTokens, audioPromptTokens)
+
+	assert.True(t, supportsInputAudio(&meta.Meta{APIType: apitype.OpenAI, ChannelType: channeltype.OpenAI, ActualModelName: "gpt-4o-audio-preview"}))
+	assert.True(t, supportsInputAudio(&meta.Meta{APIType: apitype.Gemini, ChannelType: channeltype.Gemini, ActualModelName: "gemini-1.5-pro"}))
+	assert.False(t, supportsInputAudio(&meta.Meta{APIType: apitype.OpenAI, ChannelType: channeltype.OpenAI, ActualModelName: "gpt-4o"}))
+	assert.False(t, supportsInputAudio(&meta.Meta{APIType: apitype.OpenAI, ChannelType: channeltype.DeepSeek, ActualModelName: "deepseek-audio"}))
+}
+
+func TestGetParamOverrides(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Request.Header.Set("X-Override-Temperature", "0.2")
+	c.Request.Header.Set("X-Override-Top-P", "0.9")
+	c.Request.Header.Set("X-Override-User", "tester")
+	c.Request.Header.Set("X-Request-Id", "ignored")
+
+	overrides := getParamOverrides(c)
+	assert.Equal(t, map[string]any{"temperature": 0.2, "top_p": 0.9, "user": "tester"}, overrides)
+	assert.True(t, isParamOverridable("top_p"))
+	assert.False(t, isParamOverridable("user"))
+
+	// checked by the role the token authentication has set, and only for the text relay modes
+	bizErr := CheckParamOverrides(c, relaymode.ChatCompletions)
+	assert.Equal(t, http.StatusForbidden, bizErr.StatusCode)
+	c.Set(ctxkey.Role, model.RoleAdminUser)
+	bizErr = CheckParamOverrides(c, relaymode.ImagesGenerations)
+	assert.Equal(t, "param_override_not_supported", bizErr.Code)
+	bizErr = CheckParamOverrides(c, relaymode.ChatCompletions)
+	assert.Equal(t, "param_override_not_allowed", bizErr.Code)
+}
+
+func TestInjectChannelDefaultParams(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"gpt-4o","temperature":0.2}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	textRequest, err := getTextRequest(c, relaymode.ChatCompletions)
+	assert.NoError(t, err)
+	assert.False(t, injectChannelDefaultParams(c, &meta.Meta{ChannelId: 1}, textRequest))
+
+	// the params the client set are kept
+	c.Set(ctxkey.ConfigDefaultParams, `{"temperature":0.7,"max_tokens":1024}`)
+	assert.True(t, injectChannelDefaultParams(c, &meta.Meta{ChannelId: 1}, textRequest))
+	assert.Equal(t, 0.2, textRequest.Temperature)
+	assert.Equal(t, 1024, textRequest.MaxTokens)
+
+	// the default params are validated as the ones of the client
+	c.Set(ctxkey.ConfigDefaultParams, `{"reasoning_effort":"extreme"}`)
+	assert.True(t, injectChannelDefaultParams(c, &meta.Meta{ChannelId: 1}, textRequest))
+	assert.Error(t, validator.ValidateTextRequest(textRequest, relaymode.ChatCompletions))
+}
+
+func TestNegotiateStream(t *testing.T) {
+	cases := []struct {
+		accept    string
+		relayMode int
+		isStream  bool
+		want      bool
+		wantErr   bool
+	}{
+		{"", relaymode.ChatCompletions, true, true, false},
+		{"*/*", relaymode.ChatCompletions, false, false, false},
+		// the openai sdks send application/json with streams too
+		{"application/json", relaymode.ChatCompletions, true, true, false},
+		{"text/event-stream", relaymode.ChatCompletions, false, true, false},
+		{"text/event-stream, application/json;q=0", relaymode.Completions, false, true, false},
+		{"text/event-stream;q=0.5, application/json", relaymode.ChatCompletions, false, false, false},
+		{"text/*", relaymode.ChatCompletions, false, true, false},
+		{"text/plain", relaymode.ChatCompletions, false, false, true},
+		{"text/event-stream", relaymode.Embeddings, false, false, true},
+		{"application/*", relaymode.Embeddings, false, false, false},
+	}
+	for _, tc := range cases {
+		isStream, err := negotiateStream(tc.accept, tc.relayMode, tc.isStream)
+		if tc.wantErr {
+			assert.Error(t, err, tc.accept)
+			continue
+		}
+		assert.NoError(t, err, tc.accept)
+		assert.Equal(t, tc.want, isStream, tc.accept)
+	}
+}
+
+func TestEncodeAudioMultipart(t *testing.T) {
+	body := `{"model":"whisper-1","file":"data:audio/wav;base64,UklGRg==","temperature":0.2,"timestamp_granularities":["word","segment"]}`
+	multipartBody, contentType, err := encodeAudioMultipart([]byte(body))
+	assert.NoError(t, err)
+
+	req := httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", multipartBody)
+	req.Header.Set("Content-Type", contentType)
+	assert.NoError(t, req.ParseMultipartForm(1<<20))
+	assert.Equal(t, []string{"whisper-1"}, req.MultipartForm.Value["model"])
+	assert.Equal(t, []string{"0.2"}, req.MultipartForm.Value["temperature"])
+	assert.Equal(t, []string{"word", "segment"}, req.MultipartForm.Value["timestamp_granularities[]"])
+	fileHeader := req.MultipartForm.File["file"][0]
+	assert.Equal(t, "audio.wav", fileHeader.Filename)
+	assert.Equal(t, "audio/wav", fileHeader.Header.Get("Content-Type"))
+	file, err := fileHeader.Open()
+	assert.NoError(t, err)
+	data, _ := io.ReadAll(file)
+	assert.Equal(t, "RIFF", string(data))
+
+	for _, body := range []string{
+		`{"model":"whisper-1"}`,
+		`{"file":"https://example.com/audio.wav"}`,
+		`{"file":"data:audio/wav,RIFF"}`,
+		`{"file":"data:image/png;base64,UklGRg=="}`,
+		`{"file":"data:audio/wav;base64,!!"}`,
+	} {
+		_, _, err = encodeAudioMultipart([]byte(body))
+		assert.Error(t, err, body)
+	}
+
+	// the duration of the converted audio is measured like the one of an upload, 1.5 seconds of 16 kHz 16-bit mono wav
+	wav := make([]byte, 44+48000)
+	copy(wav[0:4], "RIFF")
+	copy(wav[8:12], "WAVE")
+	binary.LittleEndian.PutUint32(wav[28:32], 32000)
+	body = `{"model":"whisper-1","file":"data:audio/wav;base64,` + base64.StdEncoding.EncodeToString(wav) + `"}`
+	multipartBody, contentType, err = encodeAudioMultipart([]byte(body))
+	assert.NoError(t, err)
+	req = httptest.NewRequest(http.MethodPost, "/v1/audio/transcriptions", multipartBody)
+	req.Header.Set("Content-Type", contentType)
+	assert.NoError(t, req.ParseMultipartForm(1<<20))
+	assert.Equal(t, 1.5, getAudioFileDuration(context.Background(), req.MultipartForm.File["file"][0]))
+	assert.Equal(t, 0.0, getAudioFileDuration(context.Background(), fileHeader))
+	assert.Equal(t, "，音频时长 1.5 秒", getAudioDurationLogContent(1.5))
+}
+
+func TestTrimMessageHistory(t *testing.T) {
+	countTokens := func(message relaymodel.Message) int {
+		return len(message.StringContent())
+	}
+	messages := []relaymodel.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "1111"},
+		{Role: "assistant", Content: "2222"},
+		{Role: "user", Content: "3333"},
+		{Role: "assistant", ToolCalls: []relaymodel.Tool{{Id: "call"}}},
+		{Role: "tool", Content: "55"},
+		{Role: "user", Content: "6666"},
+	}
+
+	kept, trimmedMessages, trimmedTokens := trimMessageHistory(messages, 100, countTokens)
+	assert.Equal(t, messages, kept)
+	assert.Zero(t, trimmedMessages)
+	assert.Zero(t, trimmedTokens)
+
+	kept, trimmedMessages, trimmedTokens = trimMessageHistory(messages, 13, countTokens)
+	assert.Equal(t, []relaymodel.Message{messages[0], messages[3], messages[4], messages[5], messages[6]}, kept)
+	assert.Equal(t, 2, trimmedMessages)
+	assert.Equal(t, 8, trimmedTokens)
+
+	// the tool result goes with its tool call
+	kept, trimmedMessages, trimmedTokens = trimMessageHistory(messages, 8, countTokens)
+	assert.Equal(t, []relaymodel.Message{messages[0], messages[6]}, kept)
+	assert.Equal(t, 5, trimmedMessages)
+	assert.Equal(t, 14, trimmedTokens)
+
+	// the system message and the last message are kept even over the budget
+	kept, _, _ = trimMessageHistory(messages, 1, countTokens)
+	assert.Equal(t, []relaymodel.Message{messages[0], messages[6]}, kept)
+
+	// the tool results left without their tool call are dropped wherever they are
+	messages = []relaymodel.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "assistant", Content: "1111", ToolCalls: []relaymodel.Tool{{Id: "call"}}},
+		{Role: "tool", Content: "22"},
+		{Role: "user", Content: "3333"},
+	}
+	kept, trimmedMessages, trimmedTokens = trimMessageHistory(messages, 9, countTokens)
+	assert.Equal(t, []relaymodel.Message{messages[0], messages[3]}, kept)
+	assert.Equal(t, 2, trimmedMessages)
+	assert.Equal(t, 6, trimmedTokens)
+	messages = []relaymodel.Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "1111"},
+		{Role: "assistant", Content: "22", ToolCalls: []relaymodel.Tool{{Id: "call"}}},
+		{Role: "tool", Content: "3333"},
+	}
+	kept, trimmedMessages, _ = trimMessageHistory(messages, 6, countTokens)
+	assert.Equal(t, []relaymodel.Message{messages[0]}, kept)
+	assert.Equal(t, 3, trimmedMessages)
+}
+
+func TestSplitEmbeddingsInput(t *testing.T) {
+	inputs := []any{"a", "b", "c", "d", "e"}
+	assert.Equal(t, [][]any{{"a", "b"}, {"c", "d"}, {"e"}}, splitEmbeddingsInput(inputs, 2))
+	assert.Nil(t, splitEmbeddingsInput(inputs, 5))
+	assert.Nil(t, splitEmbeddingsInput("a", 1))
+	// the token arrays of several inputs are split, while the token array of a single input is not
+	tokenArrays := []any{[]any{1.0, 2.0}, []any{3.0}}
+	assert.Equal(t, [][]any{{[]any{1.0, 2.0}}, {[]any{3.0}}}, splitEmbeddingsInput(tokenArrays, 1))
+	assert.Nil(t, splitEmbeddingsInput([]any{1.0, 2.0, 3.0}, 1))
+}
+
+func TestMergeEmbeddingsBatch(t *testing.T) {
+	merged := &embeddingsBatchResponse{Object: "list"}
+	mergeEmbeddingsBatch(merged, &embeddingsBatchResponse{
+		Data:  []embeddingsBatchItem{{Object: "embedding", Index: 0, Embedding: []byte("[0.1]")}, {Object: "embedding", Index: 1, Embedding: []byte("[0.2]")}},
+		Model: "text-embedding-3-small",
+		Usage: relaymodel.Usage{PromptTokens: 4, TotalTokens: 4},
+	}, 0)
+	mergeEmbeddingsBatch(merged, &embeddingsBatchResponse{
+		Data:  []embeddingsBatchItem{{Object: "embedding", Index: 0, Embedding: []byte(`"AAAA"`)}},
+		Usage: relaymodel.Usage{PromptTokens: 2, TotalTokens: 2},
+	}, 4)
+	assert.Equal(t, []int{0, 1, 4}, []int{merged.Data[0].Index, merged.Data[1].Index, merged.Data[2].Index})
+	assert.Equal(t, "text-embedding-3-small", merged.Model)
+	assert.Equal(t, 6, merged.Usage.PromptTokens)
+	assert.Equal(t, 6, merged.Usage.TotalTokens)
+}
+
+func TestUsageChunk(t *testing.T) {
+	streamConfig := config.StreamUsageChunkEnabled
+	config.StreamUsageChunkEnabled = true
+	defer func() { config.StreamUsageChunkEnabled = streamConfig }()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	streamMeta := &meta.Meta{Mode: relaymode.ChatCompletions, IsStream: true, OriginModelName: "gpt-4o"}
+
+	writer := startUsageChunk(c, streamMeta, &relaymodel.GeneralOpenAIRequest{})
+	assert.NotNil(t, writer)
+	c.Render(-1, common.CustomEvent{Data: `data: {"choices":[{"index":0,"delta":{"content":"hi"}}]}`})
+	c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
+	assert.NotContains(t, w.Body.String(), "[DONE]")
+	finishUsageChunk(c, streamMeta, writer, &relaymodel.Usage{PromptTokens: 3, CompletionTokens: 1, TotalTokens: 4})
+
+	events := strings.Split(strings.TrimSuffix(w.Body.String(), "\n\n"), "\n\n")
+	assert.Len(t, events, 3)
+	assert.Contains(t, events[1], `"object":"chat.completion.chunk"`)
+	assert.Contains(t, events[1], `"model":"gpt-4o","choices":[],"usage":{"prompt_tokens":3,"completion_tokens":1,"total_tokens":4}`)
+	assert.Equal(t, "data: [DONE]", events[2])
+
+	// the clients asking for include_usage get the usage from the stream itself
+	assert.Nil(t, startUsageChunk(c, streamMeta, &relaymodel.GeneralOpenAIRequest{StreamOptions: &relaymodel.StreamOptions{IncludeUsage: true}}))
+	assert.Nil(t, startUsageChunk(c, &meta.Meta{Mode: relaymode.ChatCompletions}, &relaymodel.GeneralOpenAIRequest{}))
+}
+
+func TestStitchChatResponse(t *testing.T) {
+	body := []byte(`{"id":"chatcmpl-1","object":"chat.completion","system_fingerprint":"fp_1","choices":[{"index":0,"message":{"role":"assistant","content":"Hello"},"finish_reason":"length"}],"usage":{"prompt_tokens":10,"completion_tokens":5,"total_tokens":15}}`)
+	usage := relaymodel.Usage{PromptTokens: 25, CompletionTokens: 9, TotalTokens: 34}
+	stitched, err := stitchChatResponse(body, "Hello, world", "stop", usage)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"id":"chatcmpl-1","object":"chat.completion","system_fingerprint":"fp_1","choices":[{"index":0,"message":{"role":"assistant","content":"Hello, world"},"finish_reason":"stop"}],"usage":{"prompt_tokens":25,"completion_tokens":9,"total_tokens":34}}`, string(stitched))
+
+	_, err = stitchChatResponse([]byte(`{"choices":[]}`), "Hello", "stop", usage)
+	assert.Error(t, err)
+}
+
+func TestCoalescedCall(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	call, isLeader := joinCoalescedCall("key")
+	assert.True(t, isLeader)
+	follower, isLeader := joinCoalescedCall("key")
+	assert.False(t, isLeader)
+	assert.Same(t, call, follower)
+
+	followerRecorder := httptest.NewRecorder()
+	followerContext, _ := gin.CreateTestContext(followerRecorder)
+	followerContext.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	usageChan := make(chan *relaymodel.Usage)
+	go func() {
+		usage, _ := waitCoalescedCall(followerContext, follower)
+		usageChan <- usage
+	}()
+
+	followerContext.Header(logger.RequestIdKey, "follower")
+
+	leaderRecorder := httptest.NewRecorder()
+	leaderContext, _ := gin.CreateTestContext(leaderRecorder)
+	// the leader is of another token, its own headers must not reach the follower
+	leaderContext.Header(logger.RequestIdKey, "leader")
+	leaderContext.Header(TokenExpiresAtHeader, "1700000000")
+	leaderContext.Header("X-One-API-Downgraded-From", "gpt-4")
+	leaderContext.Header("X-Upstream-Ratelimit-Remaining-Requests", "10")
+	writer := startCoalescing(leaderContext, call)
+	leaderContext.JSON(http.StatusOK, gin.H{"object": "list"})
+	finishCoalescing(leaderContext, "key", call, writer, &relaymodel.Usage{PromptTokens: 5, TotalTokens: 5}, true)
+
+	usage := <-usageChan
+	assert.Equal(t, 5, usage.TotalTokens)
+	assert.Equal(t, leaderRecorder.Body.String(), followerRecorder.Body.String())
+	assert.Equal(t, "application/json; charset=utf-8", followerRecorder.Header().Get("Content-Type"))
+	assert.Equal(t, "follower", followerRecorder.Header().Get(logger.RequestIdKey))
+	assert.Empty(t, followerRecorder.Header().Get(TokenExpiresAtHeader))
+	assert.Empty(t, followerRecorder.Header().Get("X-One-API-Downgraded-From"))
+	assert.Empty(t, followerRecorder.Header().Get("X-Upstream-Ratelimit-Remaining-Requests"))
+
+	// the finished call is no longer joined, and a failed call lets the waiting requests go on their own
+	call, isLeader = joinCoalescedCall("key")
+	assert.True(t, isLeader)
+	follower, _ = joinCoalescedCall("key")
+	call.finish("key", nil, nil)
+	followerContext, _ = gin.CreateTestContext(httptest.NewRecorder())
+	followerContext.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	usage, err := waitCoalescedCall(followerContext, follower)
+	assert.NoError(t, err)
+	assert.Nil(t, usage)
+	assert.Empty(t, coalescedCalls)
+
+	// a waiting request whose client went away stops waiting
+	call, _ = joinCoalescedCall("key")
+	follower, _ = joinCoalescedCall("key")
+	ctx, cancel := context.WithCancel(context.Background())
+	followerContext.Request = followerContext.Request.WithContext(ctx)
+	cancel()
+	_, err = waitCoalescedCall(followerContext, follower)
+	assert.ErrorIs(t, err, context.Canceled)
+	call.finish("key", nil, nil)
+}
+
+func TestRepairJSON(t *testing.T) {
+	for input, expected := range map[string]string{
+		`{"a": 1, "b": [1, 2,],}`:  `{"a": 1, "b": [1, 2]}`,
+		`{"a": "x, y`:              `{"a": "x, y"}`,
+		`{"a": [{"b": "c\`:         `{"a": [{"b": "c"}]}`,
+		`{"a": "}, ]", "b": 2 , }`: `{"a": "}, ]", "b": 2  }`,
+		`[1, 2, {"a": 1},` + "\n":  `[1, 2, {"a": 1}` + "\n]",
+		`{"a": 1}`:                 `{"a": 1}`,
+	} {
+		assert.Equal(t, expected, repairJSON(input), input)
+	}
+}
+
+func TestStreamEventWriter(t *testing.T) {
+	config.StreamEventIdEnabled = true
+	config.StreamRetryInterval = 3000
+	defer func() {
+		config.StreamEventIdEnabled = false
+		config.StreamRetryInterval = 0
+	}()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	writer := startStreamEvent(c, &meta.Meta{IsStream: true})
+	// an event split over writes, a comment, and two events at once
+	_, _ = c.Writer.WriteString(`data: {"a":`)
+	_, _ = c.Writer.WriteString("1}")
+	_, _ = c.Writer.WriteString("\n\n")
+	_, _ = c.Writer.WriteString(": ping\n\n")
+	_, _ = c.Writer.Write([]byte("data: {\"a\":2}\n\ndata: [DONE]\n\n"))
+	finishStreamEvent(c, writer)
+	assert.Equal(t, "retry: 3000\nid: 1\ndata: {\"a\":1}\n\n: ping\n\nid: 2\ndata: {\"a\":2}\n\nid: 3\ndata: [DONE]\n\n", w.Body.String())
+}
+
+func TestDetectDeniedScript(t *testing.T) {
+	scripts := []string{"Cyrillic", "Arabic"}
+	assert.Equal(t, "", detectDeniedScript([]string{"Hello, how are you?"}, scripts, 0.3))
+	assert.Equal(t, "Cyrillic", detectDeniedScript([]string{"Привет, как дела?"}, scripts, 0.3))
+	// a word quoted in a denied script
+	assert.Equal(t, "", detectDeniedScript([]string{"How do you pronounce Москва in English?"}, scripts, 0.3))
+	assert.Equal(t, "Arabic", detectDeniedScript([]string{"hi", "مرحبا كيف حالك"}, scripts, 0.3))
+	assert.Equal(t, "", detectDeniedScript([]string{"12345 !?"}, scripts, 0.3))
+}
+
+func TestCheckInputTokenLimit(t *testing.T) {
+	assert.NoError(t, model.UpdateModelMaxInputTokensByJSONString(`{"gpt-4": 8192}`))
+	defer func() {
+		_ = model.UpdateModelMaxInputTokensByJSONString(`{}`)
+	}()
+	meta := &meta.Meta{OriginModelName: "gpt-4", ActualModelName: "gpt-4"}
+	assert.Nil(t, checkInputTokenLimit(meta, 8192))
+	bizErr := checkInputTokenLimit(meta, 8193)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusBadRequest, bizErr.StatusCode)
+	assert.Equal(t, "context_length_exceeded", bizErr.Code)
+	assert.Equal(t, "This model's maximum context length is 8192 tokens. However, your messages resulted in 8193 tokens. Please reduce the length of the messages.", bizErr.Message)
+
+	// the limit of the model asked for applies to the mapped one without a limit
+	meta.ActualModelName = "gpt-4-deployment"
+	assert.NotNil(t, checkInputTokenLimit(meta, 8193))
+	meta.OriginModelName = "gpt-3.5-turbo"
+	assert.Nil(t, checkInputTokenLimit(meta, 100000))
+}
+
+func TestCheckMaxRequestQuota(t *testing.T) {
+	request := &relaymodel.GeneralOpenAIRequest{Model: "gpt-4", MaxTokens: 100}
+	assert.Equal(t, 100, getEstimatedCompletionTokens(request, 1000))
+	// without max tokens the completion may take the rest of the context
+	request.MaxTokens = 0
+	assert.Equal(t, 8192-1000, getEstimatedCompletionTokens(request, 1000))
+	request.Model = "unknown-model"
+	assert.Equal(t, 0, getEstimatedCompletionTokens(request, 1000))
+
+	config.MaxRequestQuota = 1000
+	defer func() { config.MaxRequestQuota = 0 }()
+	assert.Nil(t, checkMaxRequestQuota("default", 1000))
+	bizErr := checkMaxRequestQuota("default", 1001)
+	assert.NotNil(t, bizErr)
+	assert.Equal(t, http.StatusBadRequest, bizErr.StatusCode)
+	assert.Equal(t, "request_quota_exceeded", bizErr.Code)
+}
+
+func TestAggregateStream(t *testing.T) {
+	stream := "data: {\"id\":\"chatcmpl-1\",\"created\":1,\"model\":\"gpt-4o\",\"choices\":[{\"index\":0,\"delta\":{\"role\":\"assistant\",\"content\":\"Hel\"}}]}\n\n" +
+		"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"content\":\"lo\",\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"type\":\"function\",\"function\":{\"name\":\"f\",\"arguments\":\"{\\\"a\\\"\"}}]}}]}\n\n" +
+		"data: {\"id\":\"chatcmpl-1\",\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\":1}\"}}]},\"finish_reason\":\"tool_calls\"}]}\n\n" +
+		"data: [DONE]\n\n"
+	usage := &relaymodel.Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}
+	response := aggregateStream([]byte(stream), relaymode.ChatCompletions, usage)
+	assert.Equal(t, "chatcmpl-1", response.Id)
+	assert.Equal(t, "chat.completion", response.Object)
+	assert.Equal(t, "gpt-4o", response.Model)
+	assert.Equal(t, usage, response.Usage)
+	assert.Len(t, response.Choices, 1)
+	assert.Equal(t, "tool_calls", response.Choices[0].FinishReason)
+	message := response.Choices[0].Message.(relaymodel.Message)
+	assert.Equal(t, "assistant", message.Role)
+	assert.Equal(t, "Hello", message.Content)
+	assert.Equal(t, "call_1", message.ToolCalls[0].Id)
+	assert.Equal(t, "f", message.ToolCalls[0].Function.Name)
+	assert.Equal(t, `{"a":1}`, message.ToolCalls[0].Function.Arguments)
+
+	stream = "data: {\"id\":\"cmpl-1\",\"choices\":[{\"index\":0,\"text\":\"a\"}]}\n\ndata: {\"id\":\"cmpl-1\",\"choices\":[{\"index\":0,\"text\":\"b\",\"finish_reason\":\"stop\"}]}\n\n"
+	response = aggregateStream([]byte(stream), relaymode.Completions, nil)
+	assert.Equal(t, "text_completion", response.Object)
+	assert.Equal(t, "ab", *response.Choices[0].Text)
+	assert.Equal(t, "stop", response.Choices[0].FinishReason)
+}
+
+func TestDedupCall(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	call, isLeader := joinDedupCall("dedup-test", 50*time.Millisecond)
+	assert.True(t, isLeader)
+	duplicate, isLeader := joinDedupCall("dedup-test", 50*time.Millisecond)
+	assert.False(t, isLeader)
+	assert.Same(t, call, duplicate)
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	writer := startDedup(c, call)
+	c.JSON(http.StatusOK, gin.H{"id": "chatcmpl-1"})
+	finishDedup(c, "dedup-test", call, writer, true)
+
+	// a duplicate arriving within the window gets the same response
+	duplicate, isLeader = joinDedupCall("dedup-test", 50*time.Millisecond)
+	assert.False(t, isLeader)
+	duplicateRecorder := httptest.NewRecorder()
+	duplicateContext, _ := gin.CreateTestContext(duplicateRecorder)
+	duplicateContext.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	answered, err := waitDedupCall(duplicateContext, duplicate)
+	assert.NoError(t, err)
+	assert.True(t, answered)
+	assert.Equal(t, recorder.Body.String(), duplicateRecorder.Body.String())
+	assert.Equal(t, "true", duplicateRecorder.Header().Get(DeduplicatedHeader))
+
+	// the expired call is dropped without waiting for another request
+	time.Sleep(60 * time.Millisecond)
+	dedupCallsLock.Lock()
+	_, ok := dedupCalls["dedup-test"]
+	dedupCallsLock.Unlock()
+	assert.False(t, ok)
+	_, isLeader = joinDedupCall("dedup-test", 50*time.Millisecond)
+	assert.True(t, isLeader)
+
+	// a failed call is forgotten at once
+	failed, _ := joinDedupCall("dedup-failed", time.Second)
+	failed.finish("dedup-failed", nil, false)
+	answered, err = waitDedupCall(duplicateContext, failed)
+	assert.NoError(t, err)
+	assert.False(t, answered)
+	_, isLeader = joinDedupCall("dedup-failed", time.Second)
+	assert.True(t, isLeader)
+}
+
+func TestGetDedupKey(t *testing.T) {
+	assert.NoError(t, model.UpdateGroupDedupWindowByJSONString(`{"default": 1000}`))
+	defer func() { _ = model.UpdateGroupDedupWindowByJSONString(`{}`) }()
+	getKey := func(target string, header http.Header) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, target, strings.NewReader(`{"model":"gpt-4o"}`))
+		for k, v := range header {
+			c.Request.Header.Set(k, v[0])
+		}
+		key, window := getDedupKey(c, &meta.Meta{Group: "default", TokenId: 1})
+		assert.Equal(t, time.Second, window)
+		return key
+	}
+	key := getKey("/v1/chat/completions", nil)
+	assert.Equal(t, key, getKey("/v1/chat/completions", nil))
+	// the requests getting another response are not duplicates
+	assert.NotEqual(t, key, getKey("/v1/chat/completions?retry=0", nil))
+	assert.NotEqual(t, key, getKey("/v1/chat/completions", http.Header{"Accept": {"text/event-stream"}}))
+	assert.NotEqual(t, key, getKey("/v1/chat/completions", http.Header{ForceStreamHeader: {"true"}}))
+}
+
+func TestSetServedByHeader(t *testing.T) {
+	debugEnabled := config.DebugEnabled
+	defer func() { config.DebugEnabled = debugEnabled }()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(ctxkey.ChannelId, 12)
+	c.Set(ctxkey.ChannelName, "渠道 1")
+	c.Set(ctxkey.Group, "default")
+	config.DebugEnabled = true
+	setServedByHeader(c)
+	assert.Equal(t, "channel=12; name=%E6%B8%A0%E9%81%93%201; group=default", c.Writer.Header().Get(ServedByHeader))
+
+	// only the tokens of admin users get the header without the debug mode
+	config.DebugEnabled = false
+	c, _ = gin.CreateTestContext(httptest.NewRecorder())
+	c.Set(ctxkey.Role, model.RoleCommonUser)
+	setServedByHeader(c)
+	assert.Empty(t, c.Writer.Header().Get(ServedByHeader))
+	c.Set(ctxkey.Role, model.RoleAdminUser)
+	setServedByHeader(c)
+	assert.NotEmpty(t, c.Writer.Header().Get(ServedByHeader))
+}
+
+func TestGetBilledCompletionTokens(t *testing.T) {
+	usage := &relaymodel.Usage{CompletionTokens: 10}
+	assert.Equal(t, 10, getBilledCompletionTokens(usage))
+
+	// openai counts both the accepted and the rejected prediction tokens in the completion tokens
+	usage.CompletionTokensDetails = &relaymodel.CompletionTokensDetails{AcceptedPredictionTokens: 4, RejectedPredictionTokens: 3}
+	assert.Equal(t, 10, getBilledCompletionTokens(usage))
+
+	// while some upstreams report the rejected ones apart
+	usage.CompletionTokens = 5
+	assert.Equal(t, 7, getBilledCompletionTokens(usage))
+
+	var decoded relaymodel.Usage
+	assert.NoError(t, json.Unmarshal([]byte(`{"prompt_tokens":1,"completion_tokens":8,"total_tokens":9,"completion_tokens_details":{"reasoning_tokens":0,"accepted_prediction_tokens":2,"rejected_prediction_tokens":6}}`), &decoded))
+	assert.Equal(t, 2, decoded.CompletionTokensDetails.AcceptedPredictionTokens)
+	assert.Equal(t, 6, decoded.CompletionTokensDetails.RejectedPredictionTokens)
+	assert.Equal(t, 8, getBilledCompletionTokens(&decoded))
+}
+
+func TestJSONValidation(t *testing.T) {
+	config.JSONValidationEnabled = true
+	defer func() { config.JSONValidationEnabled = false }()
+	textRequest := &relaymodel.GeneralOpenAIRequest{ResponseFormat: &relaymodel.ResponseFormat{Type: "json_object"}}
+	stream := func(contents ...string) string {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		streamMeta := &meta.Meta{Mode: relaymode.ChatCompletions, IsStream: true}
+		writer := startJSONValidation(c, streamMeta, textRequest)
+		for _, content := range contents {
+			c.Render(-1, common.CustomEvent{Data: `data: {"choices":[{"index":0,"delta":{"content":` + strconv.Quote(content) + `}}]}`})
+		}
+		c.Render(-1, common.CustomEvent{Data: doneEvent})
+		finishJSONValidation(c, streamMeta, writer, true)
+		return w.Body.String()
+	}
+	body := stream(`{"a":`, ` 1}`)
+	assert.NotContains(t, body, "warning")
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+
+	body = stream(`{"a":`, ` 1`)
+	// the content is sent as is, followed by the warning and the [DONE]
+	assert.Contains(t, body, `"content":" 1"`)
+	assert.Contains(t, body, `"warning":{"message":"the content of choice 0 is not valid json","type":"invalid_json"}`)
+	assert.True(t, strings.HasSuffix(body, "data: [DONE]\n\n"))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	writer := startJSONValidation(c, &meta.Meta{Mode: relaymode.ChatCompletions}, textRequest)
+	response := `{"choices":[{"index":0,"message":{"role":"assistant","content":"{\"a\": 1,"}},{"index":1,"message":{"role":"assistant","content":"{}"}}]}`
+	c.JSON(http.StatusOK, json.RawMessage(response))
+	finishJSONValidation(c, &meta.Meta{Mode: relaymode.ChatCompletions}, writer, true)
+	assert.Equal(t, "the content of choice 0 is not valid json", w.Header().Get(JSONWarningHeader))
+	assert.JSONEq(t, response, w.Body.String())
+}
+
+func TestNormalizeUsage(t *testing.T) {
+	ctx := context.Background()
+	usageMeta := &meta.Meta{ChannelId: 1, ActualModelName: "o3"}
+	var logs bytes.Buffer
+	errorWriter := gin.DefaultErrorWriter
+	gin.DefaultErrorWriter = &logs
+	defer func() { gin.DefaultErrorWriter = errorWriter }()
+	assert.False(t, normalizeUsage(ctx, usageMeta, nil))
+
+	usage := &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}
+	assert.False(t, normalizeUsage(ctx, usageMeta, usage))
+
+	// the reasoning tokens are left out of the completion tokens
+	usage = &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 35,
+		CompletionTokensDetails: &relaymodel.CompletionTokensDetails{ReasoningTokens: 20}}
+	assert.True(t, normalizeUsage(ctx, usageMeta, usage))
+	assert.Equal(t, 25, usage.CompletionTokens)
+	assert.Equal(t, 35, usage.TotalTokens)
+
+	// the cached tokens are left out of the prompt tokens
+	usage = &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 115,
+		PromptTokensDetails: &relaymodel.PromptTokensDetails{CachedTokens: 100}}
+	assert.True(t, normalizeUsage(ctx, usageMeta, usage))
+	assert.Equal(t, 110, usage.PromptTokens)
+	assert.Equal(t, 115, usage.TotalTokens)
+
+	// the reasoning tokens are part of the completion tokens already, the total is recomputed
+	usage = &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 25, TotalTokens: 40,
+		CompletionTokensDetails: &relaymodel.CompletionTokensDetails{ReasoningTokens: 20}}
+	assert.True(t, normalizeUsage(ctx, usageMeta, usage))
+	assert.Equal(t, 25, usage.CompletionTokens)
+	assert.Equal(t, 35, usage.TotalTokens)
+
+	// both the cached and the reasoning tokens are left out
+	usage = &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 135,
+		PromptTokensDetails:     &relaymodel.PromptTokensDetails{CachedTokens: 100},
+		CompletionTokensDetails: &relaymodel.CompletionTokensDetails{ReasoningTokens: 20}}
+	assert.True(t, normalizeUsage(ctx, usageMeta, usage))
+	assert.Equal(t, 110, usage.PromptTokens)
+	assert.Equal(t, 25, usage.CompletionTokens)
+	assert.Equal(t, 135, usage.TotalTokens)
+
+	assert.Contains(t, logs.String(), "inconsistent usage reported by upstream")
+
+	// a missing total is filled in without a warning
+	logs.Reset()
+	usage = &relaymodel.Usage{PromptTokens: 10, CompletionTokens: 5}
+	assert.True(t, normalizeUsage(ctx, usageMeta, usage))
+	assert.Equal(t, 15, usage.TotalTokens)
+	assert.Empty(t, logs.String())
+}
+
+// throttledWriter accepts a write only every delay, like a client reading slowly, and honors the write deadline
+type throttledWriter struct {
+	header   http.Header
+	delay    time.Duration
+	deadline time.Time
+	body     strings.Builder
+}
+
+func (w *throttledWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *throttledWriter) Write(data []byte) (int, error) {
+	if !w.deadline.IsZero() && time.Now().Add(w.delay).After(w.deadline) {
+		time.Sleep(time.Until(w.deadline))
+		return 0, os.ErrDeadlineExceeded
+	}
+	time.Sleep(w.delay)
+	return w.body.Write(data)
+}
+
+func (w *throttledWriter) WriteHeader(int) {
+}
+
+func (w *throttledWriter) FlushError() error {
+	return nil
+}
+
+func (w *throttledWriter) SetWriteDeadline(deadline time.Time) error {
+	w.deadline = deadline
+	return nil
+}
+
+func (w *throttledWriter) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+// closeNotifyRecorder is a response recorder usable by gin.Context.Stream
+type closeNotifyRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r *closeNotifyRecorder) CloseNotify() <-chan bool {
+	return make(chan bool)
+}
+
+func TestLimitStreamDuration(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, `data: {"type":"message_start","message":{"id":"msg_1","model":"claude-3-haiku","usage":{"input_tokens":9}}}`+"\n\n")
+		_, _ = io.WriteString(w, `data: {"type":"content_block_delta","delta":{"type":"text_delta","text":"Hello"}}`+"\n\n")
+		w.(http.Flusher).Flush()
+		// the stream never ends by itself
+		<-r.Context().Done()
+	}))
+	defer upstream.Close()
+	originalHTTPClient := client.HTTPClient
+	client.HTTPClient = &http.Client{}
+	config.MaxStreamDuration = 1
+	defer func() {
+		client.HTTPClient = originalHTTPClient
+		config.MaxStreamDuration = 60 * 60
+	}()
+
+	w := &closeNotifyRecorder{httptest.NewRecorder()}
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	request := c.Request
+	meta := &meta.Meta{Mode: relaymode.ChatCompletions, APIType: apitype.Anthropic, IsStream: true, BaseURL: upstream.URL}
+	adaptor := &anthropic.Adaptor{}
+	restoreRequest := limitStreamDuration(c, meta)
+	resp, err := adaptor.DoRequest(c, meta, strings.NewReader("{}"))
+	assert.NoError(t, err)
+	writer := startStreamDuration(c, meta)
+	start := time.Now()
+	usage, bizErr := adaptor.DoResponse(c, resp, meta)
+	finishStreamDuration(c, writer)
+	restoreRequest()
+
+	// the deadline cuts the stream of an adaptor which knows nothing of it, and the part streamed is billed
+	assert.Less(t, time.Since(start), 3*time.Second)
+	assert.Nil(t, bizErr)
+	assert.Equal(t, 9, usage.PromptTokens)
+	body := w.Body.String()
+	assert.Contains(t, body, `"content":"Hello"`)
+	assert.Contains(t, body, `"code":"stream_timeout"`)
+	assert.True(t, strings.HasSuffix(body, "data: {\"error\":{\"message\":\"stream exceeded the maximum duration of 1 seconds\",\"type\":\"one_api_error\",\"param\":\"\",\"code\":\"stream_timeout\"}}\n\ndata: [DONE]\n\n"))
+	assert.Equal(t, 1, strings.Count(body, "[DONE]"))
+	assert.Same(t, request, c.Request)
+}
+
+func TestSlowClientWriter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	stream := func(delay time.Duration) (int, string) {
+		w := &throttledWriter{header: make(http.Header), delay: delay}
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		writer := newSlowClientWriter(c.Request.Context(), c.Writer, 50*time.Millisecond)
+		assert.NotNil(t, writer)
+		c.Writer = writer
+		events := 0
+		c.Stream(func(w io.Writer) bool {
+			events++
+			c.Render(-1, common.CustomEvent{Data: "data: {}"})
+			return events < 20
+		})
+		finishSlowClientGuard(c, writer)
+		return events, w.body.String()
+	}
+
+	events, body := stream(time.Millisecond)
+	assert.Equal(t, 20, events)
+	assert.Equal(t, 20, strings.Count(body, "data: {}"))
+
+	events, body = stream(100 * time.Millisecond)
+	assert.Equal(t, 1, events)
+	assert.Empty(t, body)
+
+	// the connections without write deadlines are not guarded
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	assert.Nil(t, newSlowClientWriter(context.Background(), c.Writer, time.Second))
+}
+
+func TestStripUnsupportedFields(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	forwardedBody := func(channelType int) string {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+		textRequest := &relaymodel.GeneralOpenAIRequest{
+			Model:      "deepseek-chat",
+			Messages:   []relaymodel.Message{{Role: "user", Content: "hi"}},
+			Prediction: &relaymodel.Prediction{Type: "content", Content: "hello"},
+		}
+		meta := &meta.Meta{ChannelType: channelType, ActualModelName: "deepseek-chat", Mode: relaymode.ChatCompletions}
+		// the body is rebuilt from the request only if something is stripped
+		if !stripUnsupportedFields(c.Request.Context(), meta, textRequest) {
+			return "original"
+		}
+		a := &openai.Adaptor{}
+		a.Init(meta)
+		convertedRequest, err := a.ConvertRequest(c, meta.Mode, textRequest)
+		assert.NoError(t, err)
+		jsonData, err := json.Marshal(convertedRequest)
+		assert.NoError(t, err)
+		return string(jsonData)
+	}
+	body := forwardedBody(channeltype.DeepSeek)
+	assert.NotEqual(t, "original", body)
+	assert.NotContains(t, body, "prediction")
+	assert.Equal(t, "original", forwardedBody(channeltype.OpenAI))
+}
+
+func TestMarkRunBilledWithoutRedis(t *testing.T) {
+	// redis is enabled but unreachable, the run must still be billed once
+	originalRDB, originalRedisEnabled := common.RDB, common.RedisEnabled
+	common.RDB = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	common.RedisEnabled = true
+	defer func() {
+		common.RDB, common.RedisEnabled = originalRDB, originalRedisEnabled
+	}()
+
+	assert.True(t, markRunBilled("run_unreachable_redis"))
+	assert.False(t, markRunBilled("run_unreachable_redis"))
+}
+
+func TestResponseNormalize(t *testing.T) {
+	assert.False(t, shouldNormalizeResponse(&meta.Meta{APIType: apitype.OpenAI, Mode: relaymode.ChatCompletions}))
+	assert.False(t, shouldNormalizeResponse(&meta.Meta{APIType: apitype.Anthropic, Mode: relaymode.ImagesGenerations}))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(ctxkey.ResponseModel, "gpt-4o")
+	streamMeta := &meta.Meta{APIType: apitype.Anthropic, Mode: relaymode.ChatCompletions, IsStream: true}
+	writer := startResponseNormalize(c, streamMeta)
+	// a chunk split over writes, and the [DONE] without its newlines
+	_, _ = c.Writer.WriteString(`data: {"object":"chat.completion.chunk","created":1700000000,`)
+	_, _ = c.Writer.WriteString(`"model":"claude"}` + "\n\n")
+	_, _ = c.Writer.WriteString("data: [DONE]")
+	finishResponseNormalize(c, streamMeta, writer, true)
+	assert.Equal(t, "data: {\"created\":1700000000,\"model\":\"gpt-4o\",\"object\":\"chat.completion.chunk\"}\n\ndata: [DONE]", w.Body.String())
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Set(ctxkey.ResponseModel, "gpt-4o")
+	embeddingsMeta := &meta.Meta{APIType: apitype.Anthropic, Mode: relaymode.Embeddings}
+	writer = startResponseNormalize(c, embeddingsMeta)
+	c.JSON(http.StatusOK, json.RawMessage(`{"data":[{"embedding":[0.1],"index":0}],"model":"my-deployment"}`))
+	finishResponseNormalize(c, embeddingsMeta, writer, true)
+	assert.JSONEq(t, `{"object":"list","data":[{"object":"embedding","embedding":[0.1],"index":0}],"model":"gpt-4o"}`, w.Body.String())
+
+	// the first tool call of each choice is kept if enforced for the channel
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Set(ctxkey.KeepFirstToolCall, true)
+	writer = startResponseNormalize(c, streamMeta)
+	_, _ = c.Writer.WriteString(`data: {"choices":[{"index":0,"delta":{"tool_calls":[{"index":0,"id":"call_1"},{"index":1,"id":"call_2"}]}}]}` + "\n\n")
+	finishResponseNormalize(c, streamMeta, writer, true)
+	assert.Contains(t, w.Body.String(), "call_1")
+	assert.NotContains(t, w.Body.String(), "call_2")
+}
+
+func TestClearUpstreamRateLimitHeaders(t *testing.T) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Header("Content-Type", "application/json")
+	c.Header(UpstreamRateLimitHeaderPrefix+"remaining-requests", "10")
+	ClearUpstreamRateLimitHeaders(c)
+	assert.Empty(t, w.Header().Get(UpstreamRateLimitHeaderPrefix+"remaining-requests"))
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+}
+
+func TestAssistantsOwnership(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{})
+	assert.NoError(t, err)
+	assert.NoError(t, db.AutoMigrate(&model.AssistantsObject{}, &model.User{}))
+	originalDB, originalRedisEnabled := model.DB, common.RedisEnabled
+	model.DB, common.RedisEnabled = db, false
+	defer func() { model.DB, common.RedisEnabled = originalDB, originalRedisEnabled }()
+	owner := &model.User{Username: "owner", Password: "12345678", Group: "vip", AccessToken: "owner", AffCode: "owner"}
+	assert.NoError(t, db.Create(owner).Error)
+
+	newContext := func(method string, path string, userId int) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(method, path, nil)
+		c.Set(ctxkey.Id, userId)
+		return c
+	}
+	ownerMeta := &meta.Meta{UserId: owner.Id, TokenId: 10, TokenName: "owner-token", Group: "vip"}
+	// a thread created along with a run is recorded from the run
+	trackAssistantsObjects(newContext(http.MethodPost, "/v1/threads/runs", owner.Id), ownerMeta, []byte(`{"id":"run_1","object":"thread.run","thread_id":"thread_1"}`))
+	trackAssistantsObjects(newContext(http.MethodPost, "/v1/assistants", owner.Id), ownerMeta, []byte(`{"id":"asst_1","object":"assistant"}`))
+	object, err := model.GetAssistantsObject("thread_1")
+	assert.NoError(t, err)
+	assert.Equal(t, owner.Id, object.UserId)
+
+	// the assistants of the other users are not listed
+	list := []byte(`{"object":"list","data":[{"id":"asst_1","object":"assistant"},{"id":"asst_2","object":"assistant"}],"has_more":false}`)
+	assert.Contains(t, string(filterAssistantsList(newContext(http.MethodGet, "/v1/assistants", owner.Id), ownerMeta, list)), "asst_1")
+	otherMeta := &meta.Meta{UserId: owner.Id + 1, TokenId: 20, TokenName: "other-token", Group: "default"}
+	assert.NotContains(t, string(filterAssistantsList(newContext(http.MethodGet, "/v1/assistants", owner.Id+1), otherMeta, list)), "asst_1")
+
+	// a run is billed to the token which created its thread, not to whoever sees it completed
+	payer := getAssistantsRunPayer(context.Background(), otherMeta, "thread_1")
+	assert.Equal(t, assistantsRunPayer{userId: owner.Id, tokenId: 10, tokenName: "owner-token", group: "vip"}, payer)
+	payer = getAssistantsRunPayer(context.Background(), otherMeta, "thread_old")
+	assert.Equal(t, otherMeta.UserId, payer.userId)
+
+	trackAssistantsObjects(newContext(http.MethodDelete, "/v1/assistants/asst_1", owner.Id), ownerMeta, []byte(`{"id":"asst_1","object":"assistant.deleted","deleted":true}`))
+	object, err = model.GetAssistantsObject("asst_1")
+	assert.NoError(t, err)
+	assert.Nil(t, object)
+}
@@ -0,0 +1,92 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func isSystemMessage(message relaymodel.Message) bool {
+	return message.Role == "system" || message.Role == "developer"
+}
+
+// trimMessageHistory drops the oldest messages until the messages fit maxTokens, the system messages
+// and the last message are always kept, and tool results left without their tool call are dropped too,
+// it returns the kept messages with the number of dropped messages and their tokens
+func trimMessageHistory(messages []relaymodel.Message, maxTokens int, countTokens func(relaymodel.Message) int) ([]relaymodel.Message, int, int) {
+	tokens := make([]int, len(messages))
+	total := 0
+	for i, message := range messages {
+		tokens[i] = countTokens(message)
+		total += tokens[i]
+	}
+	if total <= maxTokens {
+		return messages, 0, 0
+	}
+	dropped := make([]bool, len(messages))
+	trimmedMessages, trimmedTokens := 0, 0
+	drop := func(i int) {
+		dropped[i] = true
+		trimmedMessages++
+		trimmedTokens += tokens[i]
+		total -= tokens[i]
+	}
+	last := len(messages) - 1
+	for i := 0; i < last && total > maxTokens; i++ {
+		if isSystemMessage(messages[i]) {
+			continue
+		}
+		drop(i)
+	}
+	if trimmedMessages == 0 {
+		return messages, 0, 0
+	}
+	// the tool results must follow their tool calls, those left without them are dropped wherever they are,
+	// the last message included, as the upstreams reject them
+	afterToolCalls := false
+	for i, message := range messages {
+		if dropped[i] {
+			continue
+		}
+		if message.Role == "tool" {
+			if !afterToolCalls {
+				drop(i)
+			}
+			continue
+		}
+		afterToolCalls = message.Role == "assistant" && len(message.ToolCalls) > 0
+	}
+	kept := make([]relaymodel.Message, 0, len(messages)-trimmedMessages)
+	for i, message := range messages {
+		if !dropped[i] {
+			kept = append(kept, message)
+		}
+	}
+	return kept, trimmedMessages, trimmedTokens
+}
+
+// applyHistoryLimit trims the messages of a chat request to the history budget of its group,
+// it is done before counting the prompt tokens, so that only the forwarded messages are billed
+func applyHistoryLimit(ctx context.Context, meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest) bool {
+	if meta.Mode != relaymode.ChatCompletions {
+		return false
+	}
+	maxTokens := model.GetGroupMaxHistoryTokens(meta.Group)
+	if maxTokens <= 0 {
+		return false
+	}
+	messages, trimmedMessages, trimmedTokens := trimMessageHistory(textRequest.Messages, maxTokens, func(message relaymodel.Message) int {
+		return openai.CountTokenMessages([]relaymodel.Message{message}, textRequest.Model)
+	})
+	if trimmedMessages == 0 {
+		return false
+	}
+	textRequest.Messages = messages
+	logger.Infof(ctx, "trimmed %d messages (%d tokens) of the history to fit the budget of %d tokens of group %s", trimmedMessages, trimmedTokens, maxTokens, meta.Group)
+	return true
+}
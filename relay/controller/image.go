@@ -12,6 +12,7 @@ import (
 	"github.com/songquanpeng/one-api/model"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
 	"github.com/songquanpeng/one-api/relay/meta"
@@ -90,12 +91,16 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 
 	modelRatio := billingratio.GetModelRatio(imageRequest.Model)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
-	ratio := modelRatio * groupRatio
+	ratio := modelRatio * groupRatio * meta.ChannelMarkup
 	userQuota, err := model.CacheGetUserQuota(ctx, meta.UserId)
 
 	quota := int64(ratio*imageCostRatio*1000) * int64(imageRequest.N)
+	if bizErr := checkMaxRequestQuota(meta.Group, quota); bizErr != nil {
+		return bizErr
+	}
 
-	if userQuota-quota < 0 {
+	// the request expected to be covered by the user's free allowance doesn't need the quota
+	if userQuota-quota < 0 && model.GetFreeAllowanceRemaining(meta.UserId, imageRequest.Model) < quota {
 		return openai.ErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
 
@@ -105,28 +110,18 @@ func RelayImageHelper(c *gin.Context, relayMode int) *relaymodel.ErrorWithStatus
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
+	if resp != nil && resp.StatusCode == http.StatusOK {
+		// the images are billed from now on, even if their response fails
+		markUpstreamResponded(c)
+	}
 
 	defer func(ctx context.Context) {
 		if resp != nil && resp.StatusCode != http.StatusOK {
 			return
 		}
 
-		err := model.PostConsumeTokenQuota(meta.TokenId, quota)
-		if err != nil {
-			logger.SysError("error consuming token remain quota: " + err.Error())
-		}
-		err = model.CacheUpdateUserQuota(ctx, meta.UserId)
-		if err != nil {
-			logger.SysError("error update user quota cache: " + err.Error())
-		}
-		if quota != 0 {
-			tokenName := c.GetString(ctxkey.TokenName)
-			logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
-			model.RecordConsumeLog(ctx, meta.UserId, meta.ChannelId, 0, 0, imageRequest.Model, tokenName, quota, logContent)
-			model.UpdateUserUsedQuotaAndRequestCount(meta.UserId, quota)
-			channelId := c.GetInt(ctxkey.ChannelId)
-			model.UpdateChannelUsedQuota(channelId, quota)
-		}
+		billing.PostConsumeQuota(ctx, meta.TokenId, quota, quota, meta.UserId, meta.ChannelId, 0, 0, modelRatio, groupRatio, meta.ChannelMarkup,
+			imageRequest.Model, c.GetString(ctxkey.TokenName), "")
 	}(c.Request.Context())
 
 	// do response
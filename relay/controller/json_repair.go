@@ -0,0 +1,149 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+func isJSONModeRequest(textRequest *model.GeneralOpenAIRequest) bool {
+	if textRequest.ResponseFormat == nil {
+		return false
+	}
+	return textRequest.ResponseFormat.Type == "json_object" || textRequest.ResponseFormat.Type == "json_schema"
+}
+
+func shouldRepairJSON(meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) bool {
+	// the content of a stream has reached the client before it is complete
+	return config.JSONRepairEnabled && !meta.IsStream && meta.Mode == relaymode.ChatCompletions && isJSONModeRequest(textRequest)
+}
+
+// trimTrailingComma drops the comma ending the output, ignoring the whitespaces after it
+func trimTrailingComma(out []byte) []byte {
+	i := len(out) - 1
+	for i >= 0 && (out[i] == ' ' || out[i] == '\t' || out[i] == '\n' || out[i] == '\r') {
+		i--
+	}
+	if i >= 0 && out[i] == ',' {
+		return append(out[:i], out[i+1:]...)
+	}
+	return out
+}
+
+// repairJSON fixes the common errors of json written by models: the trailing commas are dropped,
+// and the unterminated string and the unclosed objects and arrays of a cut short json are closed
+func repairJSON(s string) string {
+	out := make([]byte, 0, len(s)+8)
+	var closers []byte
+	inString, escaped := false, false
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if inString {
+			out = append(out, ch)
+			if escaped {
+				escaped = false
+			} else if ch == '\\' {
+				escaped = true
+			} else if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+		switch ch {
+		case '"':
+			inString = true
+		case '{':
+			closers = append(closers, '}')
+		case '[':
+			closers = append(closers, ']')
+		case '}', ']':
+			out = trimTrailingComma(out)
+			if len(closers) > 0 {
+				closers = closers[:len(closers)-1]
+			}
+		}
+		out = append(out, ch)
+	}
+	if inString {
+		if escaped {
+			out = out[:len(out)-1]
+		}
+		out = append(out, '"')
+	}
+	out = trimTrailingComma(out)
+	for i := len(closers) - 1; i >= 0; i-- {
+		out = append(out, closers[i])
+	}
+	return string(out)
+}
+
+// repairResponseJSON repairs the json content of the choices of a chat completion response,
+// it returns the body to send, and whether any content is repaired
+func repairResponseJSON(c *gin.Context, body []byte) ([]byte, bool) {
+	var response map[string]any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return body, false
+	}
+	choices, _ := response["choices"].([]any)
+	repaired := false
+	for i, choice := range choices {
+		choiceMap, _ := choice.(map[string]any)
+		message, _ := choiceMap["message"].(map[string]any)
+		content, ok := message["content"].(string)
+		if !ok || content == "" || json.Valid([]byte(content)) {
+			continue
+		}
+		repairedContent := repairJSON(content)
+		if !json.Valid([]byte(repairedContent)) {
+			logger.Warnf(c.Request.Context(), "failed to repair the malformed json content of choice %d", i)
+			continue
+		}
+		message["content"] = repairedContent
+		repaired = true
+		logger.Infof(c.Request.Context(), "repaired the malformed json content of choice %d", i)
+	}
+	if !repaired {
+		return body, false
+	}
+	repairedBody, err := json.Marshal(response)
+	if err != nil {
+		return body, false
+	}
+	return repairedBody, true
+}
+
+// startJSONRepair holds back the response of a json mode request, so that its content can be repaired
+func startJSONRepair(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) *bufferResponseWriter {
+	if !shouldRepairJSON(meta, textRequest) {
+		return nil
+	}
+	writer := newBufferResponseWriter(c.Writer)
+	c.Writer = writer
+	return writer
+}
+
+// finishJSONRepair writes the held back response, with its content repaired if the request succeeded
+func finishJSONRepair(c *gin.Context, writer *bufferResponseWriter, succeeded bool) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if !writer.Written() {
+		return
+	}
+	body := writer.body.Bytes()
+	if succeeded && writer.Status() == http.StatusOK {
+		var repaired bool
+		body, repaired = repairResponseJSON(c, body)
+		if repaired {
+			writer.header.Del("Content-Length")
+		}
+	}
+	writer.release(c, body)
+}
@@ -0,0 +1,190 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// JSONWarningHeader tells the client of a non-stream json mode request which choices have a content
+// that isn't valid json, the streams get a warning chunk before their [DONE] instead
+const JSONWarningHeader = "X-One-API-JSON-Warning"
+
+const invalidJSONWarningType = "invalid_json"
+
+func shouldValidateJSON(meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) bool {
+	return config.JSONValidationEnabled && meta.Mode == relaymode.ChatCompletions && isJSONModeRequest(textRequest)
+}
+
+// getInvalidJSONChoices returns the sorted indices of the choices whose content isn't valid json,
+// the empty contents are skipped, as the choices may have called tools instead
+func getInvalidJSONChoices(contents map[int]string) []int {
+	var indices []int
+	for index, content := range contents {
+		if strings.TrimSpace(content) != "" && !json.Valid([]byte(content)) {
+			indices = append(indices, index)
+		}
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+func invalidJSONWarningMessage(indices []int) string {
+	choices := make([]string, 0, len(indices))
+	for _, index := range indices {
+		choices = append(choices, strconv.Itoa(index))
+	}
+	return fmt.Sprintf("the content of choice %s is not valid json", strings.Join(choices, ", "))
+}
+
+// jsonValidationWriter accumulates the content of the choices of a stream as it passes through, and holds back
+// its [DONE] event, so that the warning chunk can be sent before it, the response itself is never modified
+type jsonValidationWriter struct {
+	doneHoldingWriter
+	isStream bool
+	line     strings.Builder
+	contents map[int]*strings.Builder
+	// the non-stream response, which is held back so that the header can be set
+	buffer *bufferResponseWriter
+}
+
+func (w *jsonValidationWriter) Write(data []byte) (int, error) {
+	return w.WriteString(string(data))
+}
+
+func (w *jsonValidationWriter) WriteString(s string) (int, error) {
+	if w.hold(s) {
+		return len(s), nil
+	}
+	for _, line := range strings.SplitAfter(s, "\n") {
+		w.line.WriteString(line)
+		if strings.HasSuffix(line, "\n") {
+			w.accumulate(w.line.String())
+			w.line.Reset()
+		}
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// accumulate adds the content of a data line of the stream to the choices
+func (w *jsonValidationWriter) accumulate(line string) {
+	data, ok := strings.CutPrefix(strings.TrimSpace(line), "data:")
+	data = strings.TrimSpace(data)
+	if !ok || data == "" || data == "[DONE]" {
+		return
+	}
+	var chunk openai.ChatCompletionsStreamResponse
+	if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+		return
+	}
+	for _, choice := range chunk.Choices {
+		content, ok := choice.Delta.Content.(string)
+		if !ok {
+			continue
+		}
+		builder, ok := w.contents[choice.Index]
+		if !ok {
+			builder = &strings.Builder{}
+			w.contents[choice.Index] = builder
+		}
+		builder.WriteString(content)
+	}
+}
+
+// startJSONValidation starts validating the content of a json mode request, the response of a non-stream request
+// is held back until it is validated, while a stream is sent as is
+func startJSONValidation(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) *jsonValidationWriter {
+	if !shouldValidateJSON(meta, textRequest) {
+		return nil
+	}
+	writer := &jsonValidationWriter{doneHoldingWriter: doneHoldingWriter{ResponseWriter: c.Writer}, isStream: meta.IsStream, contents: make(map[int]*strings.Builder)}
+	if meta.IsStream {
+		c.Writer = writer
+	} else {
+		writer.buffer = newBufferResponseWriter(c.Writer)
+		c.Writer = writer.buffer
+	}
+	return writer
+}
+
+// warningChunk is the chunk without choices which reports the invalid json content of a stream
+func warningChunk(meta *meta.Meta, message string) string {
+	jsonData, _ := json.Marshal(map[string]any{
+		"id":      openai.GenerateResponseId(),
+		"object":  openai.ResponseObject(meta.Mode, true),
+		"created": helper.GetTimestamp(),
+		"model":   meta.OriginModelName,
+		"choices": []any{},
+		"warning": map[string]string{"message": message, "type": invalidJSONWarningType},
+	})
+	return "data: " + string(jsonData)
+}
+
+// finishJSONValidation reports the choices whose content isn't valid json, only a stream which ended with [DONE]
+// is validated, as the content of the others has been cut short
+func finishJSONValidation(c *gin.Context, meta *meta.Meta, writer *jsonValidationWriter, succeeded bool) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if writer.isStream {
+		finishStreamJSONValidation(c, meta, writer)
+		return
+	}
+	buffer := writer.buffer
+	if !buffer.Written() {
+		return
+	}
+	if succeeded && buffer.Status() == http.StatusOK {
+		if indices := getInvalidJSONChoices(getResponseContents(buffer.body.Bytes())); len(indices) > 0 {
+			message := invalidJSONWarningMessage(indices)
+			logger.Warnf(c.Request.Context(), "json mode response: %s", message)
+			buffer.header.Set(JSONWarningHeader, message)
+		}
+	}
+	buffer.release(c, buffer.body.Bytes())
+}
+
+func finishStreamJSONValidation(c *gin.Context, meta *meta.Meta, writer *jsonValidationWriter) {
+	if !writer.doneHeld {
+		return
+	}
+	contents := make(map[int]string, len(writer.contents))
+	for index, builder := range writer.contents {
+		contents[index] = builder.String()
+	}
+	if indices := getInvalidJSONChoices(contents); len(indices) > 0 {
+		message := invalidJSONWarningMessage(indices)
+		logger.Warnf(c.Request.Context(), "json mode stream: %s", message)
+		writer.release(c, warningChunk(meta, message))
+		return
+	}
+	writer.release(c)
+}
+
+// getResponseContents returns the content of the choices of a chat completion response
+func getResponseContents(body []byte) map[int]string {
+	var response openai.TextResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return nil
+	}
+	contents := make(map[int]string, len(response.Choices))
+	for _, choice := range response.Choices {
+		if content, ok := choice.Message.Content.(string); ok {
+			contents[choice.Index] = content
+		}
+	}
+	return contents
+}
@@ -0,0 +1,102 @@
+package controller
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// IsPassThrough reports whether the request should be forwarded verbatim without billing,
+// only channels speaking the openai api can take the request body as is
+func IsPassThrough(c *gin.Context) bool {
+	if !config.RelayPassThroughEnabled && c.GetString(ctxkey.ConfigPassThrough) != "true" {
+		return false
+	}
+	meta := meta.GetByContext(c)
+	return meta.APIType == apitype.OpenAI
+}
+
+// RelayPassThroughHelper forwards the request body to the upstream and copies the response back byte by byte,
+// neither tokens nor quota are counted, so it is meant for trusted internal deployments only
+func RelayPassThroughHelper(c *gin.Context) *relaymodel.ErrorWithStatusCode {
+	ctx := c.Request.Context()
+	meta := meta.GetByContext(c)
+	requestBody, err := common.GetRequestBody(c)
+	if err != nil {
+		return openai.ErrorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	a := relay.GetAdaptor(meta.APIType)
+	if a == nil {
+		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	a.Init(meta)
+	startTime := time.Now()
+	resp, err := a.DoRequest(c, meta, bytes.NewBuffer(requestBody))
+	if err != nil {
+		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	adaptor.CopyResponseHeaders(c, resp)
+	contentType := resp.Header.Get("Content-Type")
+	if contentType != "" {
+		c.Writer.Header().Set("Content-Type", contentType)
+	}
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		slowClientWriter := guardSlowClient(c)
+		defer finishSlowClientGuard(c, slowClientWriter)
+	}
+	c.Writer.WriteHeader(resp.StatusCode)
+	written, err := copyAndFlush(c, resp)
+	if err != nil {
+		logger.Warnf(ctx, "pass-through response interrupted: %s", err.Error())
+	}
+	_ = resp.Body.Close()
+	logger.InfoWithFields(ctx, "pass-through relay", logger.Fields{
+		"user_id":    meta.UserId,
+		"channel_id": meta.ChannelId,
+		"model":      c.GetString(ctxkey.RequestModel),
+		"status":     resp.StatusCode,
+		"bytes":      written,
+		"latency":    time.Since(startTime).Milliseconds(),
+	})
+	return nil
+}
+
+// copyAndFlush copies the response body to the client, flushing after each read so that streams are not buffered
+func copyAndFlush(c *gin.Context, resp *http.Response) (int64, error) {
+	var written int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, readErr := resp.Body.Read(buf)
+		if n > 0 {
+			m, err := c.Writer.Write(buf[:n])
+			written += int64(m)
+			if err != nil {
+				return written, err
+			}
+			c.Writer.Flush()
+		}
+		if readErr != nil {
+			if errors.Is(readErr, io.EOF) {
+				return written, nil
+			}
+			return written, readErr
+		}
+	}
+}
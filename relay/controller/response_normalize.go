@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/apitype"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// shouldNormalizeResponse tells whether the response of an adaptor translating another api is normalized,
+// the responses of the openai adaptor are normalized as they are relayed
+func shouldNormalizeResponse(meta *meta.Meta) bool {
+	if meta.APIType == apitype.OpenAI {
+		return false
+	}
+	return meta.Mode == relaymode.ChatCompletions || meta.Mode == relaymode.Completions || meta.Mode == relaymode.Embeddings
+}
+
+// streamNormalizeWriter normalizes the data lines of a stream, a line may be written in several writes,
+// so the part of the last line not yet ended is held until it is
+type streamNormalizeWriter struct {
+	gin.ResponseWriter
+	relayMode     int
+	responseModel string
+	created       int64
+	pending       string
+	// set when the gateway keeps the first tool call of each choice
+	toolCallFilter *openai.ToolCallStreamFilter
+}
+
+func (w *streamNormalizeWriter) Write(data []byte) (int, error) {
+	return w.WriteString(string(data))
+}
+
+func (w *streamNormalizeWriter) WriteString(s string) (int, error) {
+	w.pending += s
+	end := strings.LastIndexByte(w.pending, '\n')
+	if end < 0 {
+		return len(s), nil
+	}
+	lines := strings.Split(w.pending[:end], "\n")
+	w.pending = w.pending[end+1:]
+	for i, line := range lines {
+		lines[i] = w.normalize(line)
+	}
+	if _, err := w.ResponseWriter.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		return 0, err
+	}
+	return len(s), nil
+}
+
+func (w *streamNormalizeWriter) normalize(line string) string {
+	line = openai.NormalizeStreamFields(line, w.relayMode, w.responseModel, w.created)
+	if w.toolCallFilter != nil && strings.HasPrefix(line, "data: ") {
+		line = w.toolCallFilter.Filter(line)
+	}
+	return line
+}
+
+// startResponseNormalize normalizes the object, the created and the model of the responses of the adaptors
+// translating another api, and keeps their first tool calls if enforced, like the openai adaptor does,
+// a response which isn't a stream is held back to do so
+func startResponseNormalize(c *gin.Context, meta *meta.Meta) gin.ResponseWriter {
+	if !shouldNormalizeResponse(meta) {
+		return nil
+	}
+	var writer gin.ResponseWriter
+	if meta.IsStream {
+		streamWriter := &streamNormalizeWriter{
+			ResponseWriter: c.Writer,
+			relayMode:      meta.Mode,
+			responseModel:  c.GetString(ctxkey.ResponseModel),
+			created:        helper.GetTimestamp(),
+		}
+		if c.GetBool(ctxkey.KeepFirstToolCall) {
+			streamWriter.toolCallFilter = openai.NewToolCallStreamFilter()
+		}
+		writer = streamWriter
+	} else {
+		writer = newBufferResponseWriter(c.Writer)
+	}
+	c.Writer = writer
+	return writer
+}
+
+// finishResponseNormalize writes what is held back, a response which isn't a stream is normalized if the request succeeded
+func finishResponseNormalize(c *gin.Context, meta *meta.Meta, writer gin.ResponseWriter, succeeded bool) {
+	switch writer := writer.(type) {
+	case *streamNormalizeWriter:
+		c.Writer = writer.ResponseWriter
+		if writer.pending != "" {
+			_, _ = c.Writer.WriteString(writer.normalize(writer.pending))
+		}
+	case *bufferResponseWriter:
+		c.Writer = writer.ResponseWriter
+		if !writer.Written() {
+			return
+		}
+		body := writer.body.Bytes()
+		if succeeded && writer.Status() == http.StatusOK {
+			normalized := openai.NormalizeResponseFields(body, meta.Mode, false, c.GetString(ctxkey.ResponseModel), helper.GetTimestamp())
+			if meta.Mode == relaymode.ChatCompletions && c.GetBool(ctxkey.KeepFirstToolCall) {
+				normalized = openai.KeepFirstToolCall(normalized)
+			}
+			if !bytes.Equal(normalized, body) {
+				body = normalized
+				writer.header.Del("Content-Length")
+			}
+		}
+		writer.release(c, body)
+	}
+}
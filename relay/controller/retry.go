@@ -0,0 +1,18 @@
+package controller
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+)
+
+// markUpstreamResponded records that the upstream has answered the request successfully, so that it may have
+// produced & billed a completion which a retry on another channel would produce & bill again
+func markUpstreamResponded(c *gin.Context) {
+	c.Set(ctxkey.UpstreamResponded, true)
+}
+
+// HasUpstreamResponded reports whether the upstream of the current attempt has started answering the request,
+// or the response has started reaching the client, in either case the request must not be retried
+func HasUpstreamResponded(c *gin.Context) bool {
+	return c.GetBool(ctxkey.UpstreamResponded) || c.Writer.Written()
+}
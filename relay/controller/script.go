@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"unicode"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/model"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	relaymodel "github.com/songquanpeng/one-api/relay/model"
+)
+
+// promptTexts returns the texts of the request written by the user, the images and audios are not looked into
+func promptTexts(textRequest *relaymodel.GeneralOpenAIRequest) []string {
+	var texts []string
+	for _, message := range textRequest.Messages {
+		texts = append(texts, message.StringContent())
+	}
+	switch prompt := textRequest.Prompt.(type) {
+	case string:
+		texts = append(texts, prompt)
+	case []any:
+		for _, item := range prompt {
+			if s, ok := item.(string); ok {
+				texts = append(texts, s)
+			}
+		}
+	}
+	texts = append(texts, textRequest.ParseInput()...)
+	return append(texts, textRequest.Instruction)
+}
+
+// detectDeniedScript returns the first of the scripts which makes up more than threshold of the letters of the texts,
+// the characters shared by the scripts such as digits and punctuation are not letters of any of them
+func detectDeniedScript(texts []string, scripts []string, threshold float64) string {
+	letters := 0
+	counts := make([]int, len(scripts))
+	for _, text := range texts {
+		for _, r := range text {
+			if !unicode.IsLetter(r) {
+				continue
+			}
+			letters++
+			for i, script := range scripts {
+				if table, ok := unicode.Scripts[script]; ok && unicode.Is(table, r) {
+					counts[i]++
+				}
+			}
+		}
+	}
+	if letters == 0 {
+		return ""
+	}
+	for i, script := range scripts {
+		if float64(counts[i])/float64(letters) > threshold {
+			return script
+		}
+	}
+	return ""
+}
+
+// checkDeniedScripts rejects the prompts written in a script denied for the group, it is a heuristic on the letters
+// of the prompt, which can't tell the language of a script shared by several ones, e.g. Latin or Cyrillic
+func checkDeniedScripts(meta *meta.Meta, textRequest *relaymodel.GeneralOpenAIRequest) *relaymodel.ErrorWithStatusCode {
+	scripts := model.GetGroupDeniedScripts(meta.Group)
+	if len(scripts) == 0 {
+		return nil
+	}
+	script := detectDeniedScript(promptTexts(textRequest), scripts, config.DeniedScriptThreshold)
+	if script == "" {
+		return nil
+	}
+	return openai.ErrorWrapper(fmt.Errorf("prompts written in the %s script are not allowed", script), "prompt_script_denied", http.StatusBadRequest)
+}
@@ -0,0 +1,24 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/model"
+)
+
+// ServedByHeader tells which channel served a request, for debugging the routing, it is only set
+// for the admins or in debug mode, like the attempts reported with the relay errors
+const ServedByHeader = "X-One-API-Served-By"
+
+func setServedByHeader(c *gin.Context) {
+	if !config.DebugEnabled && c.GetInt(ctxkey.Role) < model.RoleAdminUser {
+		return
+	}
+	// the name is escaped, as the channel names are often not ascii
+	c.Writer.Header().Set(ServedByHeader, fmt.Sprintf("channel=%d; name=%s; group=%s",
+		c.GetInt(ctxkey.ChannelId), url.PathEscape(c.GetString(ctxkey.ChannelName)), c.GetString(ctxkey.Group)))
+}
@@ -0,0 +1,59 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/relay"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// RelayShadowHelper sends a mirrored text request to the shadow channel selected in the context,
+// nothing is billed and the response is written to whatever writer the context holds, usually a discarding one
+func RelayShadowHelper(c *gin.Context) (*model.Usage, *model.ErrorWithStatusCode) {
+	meta := meta.GetByContext(c)
+	textRequest, err := getAndValidateTextRequest(c, meta.Mode)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
+	}
+	// the primary request has been negotiated already, so that the shadow one streams alike
+	if _, bizErr := negotiateTextResponse(c, meta.Mode, textRequest); bizErr != nil {
+		return nil, bizErr
+	}
+	meta.IsStream = textRequest.Stream
+	meta.OriginModelName = textRequest.Model
+	textRequest.Model, _ = getMappedModelName(textRequest.Model, meta.ModelMapping)
+	meta.ActualModelName = textRequest.Model
+	meta.PromptTokens, meta.ImagePromptTokens, meta.AudioPromptTokens = getPromptTokens(textRequest, meta.Mode)
+
+	adaptor := relay.GetAdaptor(meta.APIType)
+	if adaptor == nil {
+		return nil, openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
+	}
+	adaptor.Init(meta)
+	convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+	}
+	jsonData, err := json.Marshal(convertedRequest)
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
+	}
+	resp, err := adaptor.DoRequest(c, meta, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, RelayErrorHandler(resp)
+	}
+	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	if respErr != nil {
+		return nil, respErr
+	}
+	return usage, nil
+}
@@ -0,0 +1,141 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+// slowClientWriter bounds the time a write of a stream may wait for the client, the stream handlers copy
+// one event at a time and flush it, so a slow client holds the upstream back instead of being buffered for,
+// and a client which falls behind for longer than the timeout has its stream aborted as if it were gone
+type slowClientWriter struct {
+	gin.ResponseWriter
+	ctx        context.Context
+	controller *http.ResponseController
+	timeout    time.Duration
+	gone       chan bool
+	goneOnce   sync.Once
+	done       chan struct{}
+	notifyOnce sync.Once
+	aborted    bool
+}
+
+func newSlowClientWriter(ctx context.Context, w gin.ResponseWriter, timeout time.Duration) *slowClientWriter {
+	unwrapper, ok := w.(interface{ Unwrap() http.ResponseWriter })
+	if !ok || timeout <= 0 {
+		return nil
+	}
+	controller := http.NewResponseController(unwrapper.Unwrap())
+	if err := controller.SetWriteDeadline(time.Time{}); err != nil {
+		// the write deadlines are not supported, e.g. by http2 connections of older go versions
+		return nil
+	}
+	return &slowClientWriter{
+		ResponseWriter: w,
+		ctx:            ctx,
+		controller:     controller,
+		timeout:        timeout,
+		gone:           make(chan bool, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+// abort marks the client as gone, the stream handlers stop copying the stream on the next event
+func (w *slowClientWriter) abort(err error) {
+	w.aborted = true
+	w.goneOnce.Do(func() {
+		if errors.Is(err, os.ErrDeadlineExceeded) {
+			logger.Warnf(w.ctx, "client fell behind the stream for more than %s, stream aborted", w.timeout)
+		} else {
+			logger.Warnf(w.ctx, "failed to write the stream to the client, stream aborted: %s", err.Error())
+		}
+		w.gone <- true
+	})
+}
+
+func (w *slowClientWriter) Write(data []byte) (int, error) {
+	if w.aborted {
+		return 0, os.ErrDeadlineExceeded
+	}
+	_ = w.controller.SetWriteDeadline(time.Now().Add(w.timeout))
+	n, err := w.ResponseWriter.Write(data)
+	if err != nil {
+		w.abort(err)
+	}
+	return n, err
+}
+
+func (w *slowClientWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *slowClientWriter) Flush() {
+	if w.aborted {
+		return
+	}
+	w.ResponseWriter.WriteHeaderNow()
+	_ = w.controller.SetWriteDeadline(time.Now().Add(w.timeout))
+	if err := w.controller.Flush(); err != nil {
+		w.abort(err)
+	}
+}
+
+// CloseNotify reports the client as gone when it disconnects or falls behind, which stops c.Stream
+func (w *slowClientWriter) CloseNotify() <-chan bool {
+	w.notifyOnce.Do(func() {
+		clientGone := w.ResponseWriter.CloseNotify()
+		go func() {
+			select {
+			case <-clientGone:
+				w.goneOnce.Do(func() {
+					w.gone <- true
+				})
+			case <-w.done:
+			}
+		}()
+	})
+	return w.gone
+}
+
+// startSlowClientGuard bounds the writes of a stream by STREAM_SLOW_CLIENT_TIMEOUT, it returns nil
+// if the request doesn't stream or the connection doesn't support write deadlines
+func startSlowClientGuard(c *gin.Context, meta *meta.Meta) *slowClientWriter {
+	if !meta.IsStream {
+		return nil
+	}
+	return guardSlowClient(c)
+}
+
+// guardSlowClient bounds the writes of a stream which is only known from the response, like the ones of
+// the assistants and of the pass-through relay, by STREAM_SLOW_CLIENT_TIMEOUT
+func guardSlowClient(c *gin.Context) *slowClientWriter {
+	if config.StreamSlowClientTimeout <= 0 {
+		return nil
+	}
+	writer := newSlowClientWriter(c.Request.Context(), c.Writer, time.Duration(config.StreamSlowClientTimeout)*time.Second)
+	if writer == nil {
+		return nil
+	}
+	c.Writer = writer
+	return writer
+}
+
+func finishSlowClientGuard(c *gin.Context, writer *slowClientWriter) {
+	if writer == nil {
+		return
+	}
+	close(writer.done)
+	if !writer.aborted {
+		_ = writer.controller.SetWriteDeadline(time.Time{})
+	}
+	c.Writer = writer.ResponseWriter
+}
@@ -0,0 +1,76 @@
+package controller
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// latencyWriter records when the first byte of a stream is written to the client
+type latencyWriter struct {
+	gin.ResponseWriter
+	firstByteTime time.Time
+}
+
+func (w *latencyWriter) Write(data []byte) (int, error) {
+	w.markFirstByte()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *latencyWriter) WriteString(s string) (int, error) {
+	w.markFirstByte()
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *latencyWriter) markFirstByte() {
+	if w.firstByteTime.IsZero() {
+		w.firstByteTime = time.Now()
+	}
+}
+
+// startSlowRequestLog starts recording the first byte latency of a stream, it returns nil if the slow requests
+// are not logged or the request is not streamed
+func startSlowRequestLog(c *gin.Context, meta *meta.Meta) *latencyWriter {
+	if config.SlowRequestThreshold <= 0 || !meta.IsStream {
+		return nil
+	}
+	writer := &latencyWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return writer
+}
+
+// finishSlowRequestLog logs the request at warn level if its upstream latency since startTime exceeds
+// SLOW_REQUEST_THRESHOLD, the first byte latency is logged as well for streams
+func finishSlowRequestLog(c *gin.Context, meta *meta.Meta, writer *latencyWriter, startTime time.Time, usage *model.Usage, succeeded bool) {
+	if writer != nil {
+		c.Writer = writer.ResponseWriter
+	}
+	if config.SlowRequestThreshold <= 0 {
+		return
+	}
+	latency := time.Since(startTime)
+	if latency < time.Duration(config.SlowRequestThreshold)*time.Millisecond {
+		return
+	}
+	fields := logger.Fields{
+		"user_id":       meta.UserId,
+		"channel_id":    meta.ChannelId,
+		"model":         meta.ActualModelName,
+		"stream":        meta.IsStream,
+		"succeeded":     succeeded,
+		"latency":       latency.Milliseconds(),
+		"prompt_tokens": meta.PromptTokens,
+	}
+	if usage != nil {
+		fields["prompt_tokens"] = usage.PromptTokens
+		fields["completion_tokens"] = usage.CompletionTokens
+	}
+	if writer != nil && !writer.firstByteTime.IsZero() {
+		fields["first_byte_latency"] = writer.firstByteTime.Sub(startTime).Milliseconds()
+	}
+	logger.WarnWithFields(c.Request.Context(), "slow relay request", fields)
+}
@@ -0,0 +1,233 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+// ForceStreamHeader lets an intermediary proxy override the stream flag of the request body,
+// a stream request forced not to stream is still streamed from the upstream, and the gateway
+// aggregates the stream into a single response, so that it is billed just like the stream
+const ForceStreamHeader = "X-One-API-Force-Stream"
+
+// applyForceStreamHeader honors the ForceStreamHeader if it is enabled, it returns whether the stream flag
+// of the request has been changed, and whether the stream is to be aggregated into a single response
+func applyForceStreamHeader(c *gin.Context, relayMode int, textRequest *model.GeneralOpenAIRequest) (bool, bool) {
+	if !config.ForceStreamHeaderEnabled {
+		return false, false
+	}
+	if relayMode != relaymode.ChatCompletions && relayMode != relaymode.Completions {
+		return false, false
+	}
+	forceStream, err := strconv.ParseBool(c.GetHeader(ForceStreamHeader))
+	if err != nil || forceStream == textRequest.Stream {
+		return false, false
+	}
+	if !forceStream {
+		return false, true
+	}
+	textRequest.Stream = true
+	return true, false
+}
+
+// startStreamAggregation starts buffering the stream which is to be aggregated into a single response,
+// it returns nil if the stream is sent as is
+func startStreamAggregation(c *gin.Context, isAggregated bool) *bufferResponseWriter {
+	if !isAggregated {
+		return nil
+	}
+	writer := newBufferResponseWriter(c.Writer)
+	c.Writer = writer
+	return writer
+}
+
+// finishStreamAggregation writes the aggregated response of a succeeded stream, the buffered stream is dropped
+// on failure, so that the error is written to the client instead
+func finishStreamAggregation(c *gin.Context, meta *meta.Meta, writer *bufferResponseWriter, usage *model.Usage, succeeded bool) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if !succeeded {
+		return
+	}
+	response := aggregateStream(writer.body.Bytes(), meta.Mode, usage)
+	for key, values := range writer.header {
+		switch key {
+		case "Content-Type", "Cache-Control", "Connection", "Transfer-Encoding", "X-Accel-Buffering":
+			continue
+		}
+		for _, value := range values {
+			c.Writer.Header().Add(key, value)
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+type toolCallDelta struct {
+	Index    int    `json:"index"`
+	Id       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+// aggregatedChunk holds the fields of both the chat and the completions chunks
+type aggregatedChunk struct {
+	Id                string `json:"id"`
+	Created           int64  `json:"created"`
+	Model             string `json:"model"`
+	SystemFingerprint string `json:"system_fingerprint"`
+	ServiceTier       string `json:"service_tier"`
+	Choices           []struct {
+		Index int `json:"index"`
+		Delta struct {
+			Role      string          `json:"role"`
+			Content   string          `json:"content"`
+			ToolCalls []toolCallDelta `json:"tool_calls"`
+		} `json:"delta"`
+		Text         string  `json:"text"`
+		FinishReason *string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *model.Usage `json:"usage"`
+}
+
+type aggregatedChoice struct {
+	Index        int     `json:"index"`
+	Message      any     `json:"message,omitempty"`
+	Text         *string `json:"text,omitempty"`
+	FinishReason string  `json:"finish_reason"`
+}
+
+type aggregatedResponse struct {
+	Id                string             `json:"id"`
+	Object            string             `json:"object"`
+	Created           int64              `json:"created"`
+	Model             string             `json:"model"`
+	SystemFingerprint string             `json:"system_fingerprint,omitempty"`
+	ServiceTier       string             `json:"service_tier,omitempty"`
+	Choices           []aggregatedChoice `json:"choices"`
+	Usage             *model.Usage       `json:"usage,omitempty"`
+}
+
+type choiceAggregate struct {
+	role         string
+	content      strings.Builder
+	toolCalls    map[int]*model.Tool
+	arguments    map[int]*strings.Builder
+	finishReason string
+}
+
+// aggregateStream turns the openai chunks of a stream into the response the request would have got without
+// streaming, the usage is the one the request is billed for
+func aggregateStream(stream []byte, relayMode int, usage *model.Usage) *aggregatedResponse {
+	response := &aggregatedResponse{Object: openai.ResponseObject(relayMode, false)}
+	choices := make(map[int]*choiceAggregate)
+	scanner := bufio.NewScanner(bytes.NewReader(stream))
+	scanner.Buffer(make([]byte, 64*1024), len(stream)+1)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		data = strings.TrimSpace(data)
+		if !ok || data == "" || data == "[DONE]" {
+			continue
+		}
+		var chunk aggregatedChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if response.Id == "" {
+			response.Id, response.Created, response.Model = chunk.Id, openai.NormalizeCreated(chunk.Created), chunk.Model
+		}
+		if chunk.SystemFingerprint != "" {
+			response.SystemFingerprint = chunk.SystemFingerprint
+		}
+		if chunk.ServiceTier != "" {
+			response.ServiceTier = chunk.ServiceTier
+		}
+		if chunk.Usage != nil {
+			response.Usage = chunk.Usage
+		}
+		for _, choice := range chunk.Choices {
+			aggregate, ok := choices[choice.Index]
+			if !ok {
+				aggregate = &choiceAggregate{toolCalls: make(map[int]*model.Tool), arguments: make(map[int]*strings.Builder)}
+				choices[choice.Index] = aggregate
+			}
+			if choice.Delta.Role != "" {
+				aggregate.role = choice.Delta.Role
+			}
+			aggregate.content.WriteString(choice.Delta.Content)
+			aggregate.content.WriteString(choice.Text)
+			for _, delta := range choice.Delta.ToolCalls {
+				toolCall, ok := aggregate.toolCalls[delta.Index]
+				if !ok {
+					toolCall = &model.Tool{Type: "function"}
+					aggregate.toolCalls[delta.Index] = toolCall
+					aggregate.arguments[delta.Index] = &strings.Builder{}
+				}
+				if delta.Id != "" {
+					toolCall.Id = delta.Id
+				}
+				if delta.Type != "" {
+					toolCall.Type = delta.Type
+				}
+				toolCall.Function.Name += delta.Function.Name
+				aggregate.arguments[delta.Index].WriteString(delta.Function.Arguments)
+			}
+			if choice.FinishReason != nil && *choice.FinishReason != "" {
+				aggregate.finishReason = *choice.FinishReason
+			}
+		}
+	}
+	if usage != nil {
+		response.Usage = usage
+	}
+
+	indices := make([]int, 0, len(choices))
+	for index := range choices {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	response.Choices = make([]aggregatedChoice, 0, len(indices))
+	for _, index := range indices {
+		aggregate := choices[index]
+		choice := aggregatedChoice{Index: index, FinishReason: aggregate.finishReason}
+		if relayMode == relaymode.Completions {
+			text := aggregate.content.String()
+			choice.Text = &text
+			response.Choices = append(response.Choices, choice)
+			continue
+		}
+		message := model.Message{Role: aggregate.role, Content: aggregate.content.String()}
+		if message.Role == "" {
+			message.Role = "assistant"
+		}
+		toolCallIndices := make([]int, 0, len(aggregate.toolCalls))
+		for toolCallIndex := range aggregate.toolCalls {
+			toolCallIndices = append(toolCallIndices, toolCallIndex)
+		}
+		sort.Ints(toolCallIndices)
+		for _, toolCallIndex := range toolCallIndices {
+			toolCall := aggregate.toolCalls[toolCallIndex]
+			toolCall.Function.Arguments = aggregate.arguments[toolCallIndex].String()
+			message.ToolCalls = append(message.ToolCalls, *toolCall)
+		}
+		choice.Message = message
+		response.Choices = append(response.Choices, choice)
+	}
+	return response
+}
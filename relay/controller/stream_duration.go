@@ -0,0 +1,69 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/logger"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+)
+
+// limitStreamDuration caps the total time of a stream with a deadline on the request, which the upstream request
+// inherits, so that the stream of every adaptor is cut at MAX_STREAM_DURATION even if it keeps producing data,
+// the returned function restores the request once the response is done
+func limitStreamDuration(c *gin.Context, meta *meta.Meta) func() {
+	if !meta.IsStream || config.MaxStreamDuration <= 0 {
+		return func() {}
+	}
+	request := c.Request
+	ctx, cancel := context.WithTimeout(request.Context(), time.Duration(config.MaxStreamDuration)*time.Second)
+	c.Request = request.WithContext(ctx)
+	return func() {
+		cancel()
+		c.Request = request
+	}
+}
+
+func isStreamDurationExceeded(c *gin.Context) bool {
+	return errors.Is(c.Request.Context().Err(), context.DeadlineExceeded)
+}
+
+// startStreamDuration holds back the [DONE] event of a stream whose duration is capped,
+// so that the timeout error is sent before it if the stream is cut
+func startStreamDuration(c *gin.Context, meta *meta.Meta) *doneHoldingWriter {
+	if !meta.IsStream || config.MaxStreamDuration <= 0 {
+		return nil
+	}
+	writer := &doneHoldingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return writer
+}
+
+// finishStreamDuration sends the timeout error followed by [DONE] to a stream cut at its deadline,
+// whether or not the adaptor ended it with [DONE], the part streamed before is billed as usual
+func finishStreamDuration(c *gin.Context, writer *doneHoldingWriter) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if !isStreamDurationExceeded(c) {
+		writer.release(c)
+		return
+	}
+	logger.Warnf(c.Request.Context(), "stream exceeded the maximum duration of %d seconds, closed", config.MaxStreamDuration)
+	errorChunk, _ := json.Marshal(gin.H{
+		"error": model.Error{
+			Message: fmt.Sprintf("stream exceeded the maximum duration of %d seconds", config.MaxStreamDuration),
+			Type:    "one_api_error",
+			Code:    "stream_timeout",
+		},
+	})
+	writer.doneHeld = true
+	writer.release(c, "data: "+string(errorChunk))
+}
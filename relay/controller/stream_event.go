@@ -0,0 +1,79 @@
+package controller
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/meta"
+)
+
+// streamEventWriter adds the id and retry fields to the data events of a stream, the events may be
+// written in several writes or several at once, so the event boundaries are tracked over the writes
+type streamEventWriter struct {
+	gin.ResponseWriter
+	eventId    int
+	retrySent  bool
+	newlines   int
+	eventStart bool
+}
+
+// fields returns the fields to write before the next data event
+func (w *streamEventWriter) fields() string {
+	var fields string
+	if config.StreamRetryInterval > 0 && !w.retrySent {
+		w.retrySent = true
+		fields += "retry: " + strconv.Itoa(config.StreamRetryInterval) + "\n"
+	}
+	if config.StreamEventIdEnabled {
+		w.eventId++
+		fields += "id: " + strconv.Itoa(w.eventId) + "\n"
+	}
+	return fields
+}
+
+func (w *streamEventWriter) Write(data []byte) (int, error) {
+	return w.WriteString(string(data))
+}
+
+func (w *streamEventWriter) WriteString(s string) (int, error) {
+	var builder strings.Builder
+	builder.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		if w.eventStart && strings.HasPrefix(s[i:], "data:") {
+			builder.WriteString(w.fields())
+		}
+		switch s[i] {
+		case '\n':
+			w.newlines++
+			w.eventStart = w.newlines >= 2
+		case '\r':
+		default:
+			w.newlines = 0
+			w.eventStart = false
+		}
+		builder.WriteByte(s[i])
+	}
+	if _, err := w.ResponseWriter.WriteString(builder.String()); err != nil {
+		return 0, err
+	}
+	return len(s), nil
+}
+
+// startStreamEvent starts adding the sse fields to the events of a stream if any of them is enabled
+func startStreamEvent(c *gin.Context, meta *meta.Meta) *streamEventWriter {
+	if !meta.IsStream || (!config.StreamEventIdEnabled && config.StreamRetryInterval <= 0) {
+		return nil
+	}
+	writer := &streamEventWriter{ResponseWriter: c.Writer, eventStart: true}
+	c.Writer = writer
+	return writer
+}
+
+func finishStreamEvent(c *gin.Context, writer *streamEventWriter) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+}
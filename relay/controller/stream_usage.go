@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"encoding/json"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/helper"
+	"github.com/songquanpeng/one-api/relay/adaptor/openai"
+	"github.com/songquanpeng/one-api/relay/meta"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+)
+
+const doneEvent = "data: [DONE]"
+
+// doneHoldingWriter holds back the [DONE] event of a stream, so that chunks can be sent before it,
+// the adaptors render the event and its terminating blank line with separate writes
+type doneHoldingWriter struct {
+	gin.ResponseWriter
+	doneHeld bool
+}
+
+func (w *doneHoldingWriter) Write(data []byte) (int, error) {
+	return w.WriteString(string(data))
+}
+
+func (w *doneHoldingWriter) WriteString(s string) (int, error) {
+	if w.hold(s) {
+		return len(s), nil
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+// hold tells whether s is the [DONE] event or its terminating blank line, which are held back
+func (w *doneHoldingWriter) hold(s string) bool {
+	if !w.doneHeld && s == doneEvent {
+		w.doneHeld = true
+		return true
+	}
+	return w.doneHeld && s == "\n\n"
+}
+
+// release sends the events followed by the held [DONE] event, nothing is sent for a stream which didn't end
+// with [DONE], as it has been cut short, c.Writer must be restored to the wrapped writer before
+func (w *doneHoldingWriter) release(c *gin.Context, events ...string) {
+	if !w.doneHeld {
+		return
+	}
+	for _, event := range events {
+		c.Render(-1, common.CustomEvent{Data: event})
+	}
+	c.Render(-1, common.CustomEvent{Data: doneEvent})
+	c.Writer.Flush()
+}
+
+func shouldAppendUsageChunk(meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) bool {
+	if !config.StreamUsageChunkEnabled || !meta.IsStream {
+		return false
+	}
+	if meta.Mode != relaymode.ChatCompletions && meta.Mode != relaymode.Completions {
+		return false
+	}
+	// the usage is streamed already
+	return textRequest.StreamOptions == nil || !textRequest.StreamOptions.IncludeUsage
+}
+
+// startUsageChunk starts holding back the [DONE] event of the stream if a usage chunk is to be appended
+func startUsageChunk(c *gin.Context, meta *meta.Meta, textRequest *model.GeneralOpenAIRequest) *doneHoldingWriter {
+	if !shouldAppendUsageChunk(meta, textRequest) {
+		return nil
+	}
+	writer := &doneHoldingWriter{ResponseWriter: c.Writer}
+	c.Writer = writer
+	return writer
+}
+
+// usageChunk is the last chunk of a stream with include_usage, which has no choices
+func usageChunk(meta *meta.Meta, usage *model.Usage) string {
+	chunk := openai.ChatCompletionsStreamResponse{
+		Id:      openai.GenerateResponseId(),
+		Object:  openai.ResponseObject(meta.Mode, true),
+		Created: helper.GetTimestamp(),
+		Model:   meta.OriginModelName,
+		Choices: []openai.ChatCompletionsStreamResponseChoice{},
+		Usage:   usage,
+	}
+	jsonData, _ := json.Marshal(chunk)
+	return "data: " + string(jsonData)
+}
+
+// finishUsageChunk sends the usage chunk followed by the held [DONE] event, only a stream which ended
+// with [DONE] gets the usage chunk, as the others have been cut short
+func finishUsageChunk(c *gin.Context, meta *meta.Meta, writer *doneHoldingWriter, usage *model.Usage) {
+	if writer == nil {
+		return
+	}
+	c.Writer = writer.ResponseWriter
+	if usage == nil {
+		writer.release(c)
+		return
+	}
+	writer.release(c, usageChunk(meta, usage))
+}
@@ -7,8 +7,11 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
 	"github.com/songquanpeng/one-api/common/logger"
 	"github.com/songquanpeng/one-api/relay"
 	"github.com/songquanpeng/one-api/relay/adaptor/openai"
@@ -16,33 +19,96 @@ import (
 	"github.com/songquanpeng/one-api/relay/billing"
 	billingratio "github.com/songquanpeng/one-api/relay/billing/ratio"
 	"github.com/songquanpeng/one-api/relay/channeltype"
+	"github.com/songquanpeng/one-api/relay/controller/validator"
 	"github.com/songquanpeng/one-api/relay/meta"
 	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
 )
 
 func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 	ctx := c.Request.Context()
 	meta := meta.GetByContext(c)
-	// get & validate textRequest
-	textRequest, err := getAndValidateTextRequest(c, meta.Mode)
+	textRequest, err := getTextRequest(c, meta.Mode)
 	if err != nil {
-		logger.Errorf(ctx, "getAndValidateTextRequest failed: %s", err.Error())
+		logger.Errorf(ctx, "getTextRequest failed: %s", err.Error())
 		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
 	}
+	// merged before the validation, so that the default params are validated as the ones of the client,
+	// and before pre-consuming, so that a default max_tokens is billed as well
+	isDefaultParamsInjected := injectChannelDefaultParams(c, meta, textRequest)
+	err = validator.ValidateTextRequest(textRequest, meta.Mode)
+	if err != nil {
+		logger.Errorf(ctx, "ValidateTextRequest failed: %s", err.Error())
+		return openai.ErrorWrapper(err, "invalid_text_request", http.StatusBadRequest)
+	}
+	isStreamNegotiated, bizErr := negotiateTextResponse(c, meta.Mode, textRequest)
+	if bizErr != nil {
+		return bizErr
+	}
+	isStreamForced, isStreamAggregated := applyForceStreamHeader(c, meta.Mode, textRequest)
+	// a duplicate is answered before anything is pre-consumed, as it is not billed
+	dedupKey, dedupWindow := getDedupKey(c, meta)
+	var dedup *dedupCall
+	if dedupKey != "" {
+		var isLeader bool
+		dedup, isLeader = joinDedupCall(dedupKey, dedupWindow)
+		if !isLeader {
+			answered, err := waitDedupCall(c, dedup)
+			if err != nil {
+				return openai.ErrorWrapper(err, "client_canceled", http.StatusRequestTimeout)
+			}
+			if answered {
+				logger.Infof(ctx, "answered a duplicate request with the response of the identical one")
+				return nil
+			}
+			// the identical request failed, this one is sent on its own
+			dedup = nil
+		} else {
+			// let the duplicates go on their own if this one fails before its response
+			defer dedup.finish(dedupKey, nil, false)
+		}
+	}
 	meta.IsStream = textRequest.Stream
+	// set on each attempt, as a retry may go to a channel configured otherwise
+	c.Set(ctxkey.KeepFirstToolCall, shouldKeepFirstToolCall(c, textRequest))
+	c.Set(ctxkey.RequestedChoices, textRequest.N)
+	isParamsOverridden, bizErr := applyParamOverrides(c, textRequest)
+	if bizErr != nil {
+		return bizErr
+	}
+
+	isModelDowngraded := applyModelDowngrade(c, textRequest)
 
 	// map model name
 	var isModelMapped bool
 	meta.OriginModelName = textRequest.Model
 	textRequest.Model, isModelMapped = getMappedModelName(textRequest.Model, meta.ModelMapping)
 	meta.ActualModelName = textRequest.Model
+	// set on each attempt, as a retry may go to a channel mapping the model otherwise
+	if isModelMapped {
+		c.Set(ctxkey.ResponseModel, meta.OriginModelName)
+	} else {
+		c.Set(ctxkey.ResponseModel, "")
+	}
+	isHistoryTrimmed := applyHistoryLimit(ctx, meta, textRequest)
+	if !supportsInputAudio(meta) && model.HasInputAudio(textRequest.Messages) {
+		return openai.ErrorWrapper(fmt.Errorf("input_audio is not supported by channel #%d for model %s", meta.ChannelId, meta.ActualModelName), "input_audio_not_supported", http.StatusBadRequest)
+	}
+	if bizErr = checkDeniedScripts(meta, textRequest); bizErr != nil {
+		return bizErr
+	}
 	// get model ratio & group ratio
 	modelRatio := billingratio.GetModelRatio(textRequest.Model)
 	groupRatio := billingratio.GetGroupRatio(meta.Group)
-	ratio := modelRatio * groupRatio
+	ratio := modelRatio * groupRatio * meta.ChannelMarkup
 	// pre-consume quota
-	promptTokens := getPromptTokens(textRequest, meta.Mode)
+	promptTokens, imagePromptTokens, audioPromptTokens := getPromptTokens(textRequest, meta.Mode)
 	meta.PromptTokens = promptTokens
+	meta.ImagePromptTokens = imagePromptTokens
+	meta.AudioPromptTokens = audioPromptTokens
+	if bizErr = checkInputTokenLimit(meta, promptTokens); bizErr != nil {
+		return bizErr
+	}
 	preConsumedQuota, bizErr := preConsumeQuota(ctx, textRequest, promptTokens, ratio, meta)
 	if bizErr != nil {
 		logger.Warnf(ctx, "preConsumeQuota failed: %+v", *bizErr)
@@ -51,17 +117,78 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 
 	adaptor := relay.GetAdaptor(meta.APIType)
 	if adaptor == nil {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return openai.ErrorWrapper(fmt.Errorf("invalid api type: %d", meta.APIType), "invalid_api_type", http.StatusBadRequest)
 	}
+	if meta.Mode == relaymode.Embeddings && isPartialResultsEnabled(c) {
+		if batches := splitEmbeddingsInput(textRequest.Input, config.EmbeddingsBatchSize); batches != nil {
+			usage, bizErr := relayEmbeddingsInBatches(c, meta, adaptor, textRequest, batches)
+			if bizErr != nil {
+				billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+				return bizErr
+			}
+			// only the successful sub-batches are billed
+			billing.Go(func() {
+				postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio)
+			})
+			return nil
+		}
+	}
+
+	coalescingKey := getCoalescingKey(c, meta)
+	var call *coalescedCall
+	if coalescingKey != "" {
+		var isLeader bool
+		call, isLeader = joinCoalescedCall(coalescingKey)
+		if !isLeader {
+			usage, err := waitCoalescedCall(c, call)
+			if err != nil {
+				billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+				return openai.ErrorWrapper(err, "client_canceled", http.StatusRequestTimeout)
+			}
+			if usage != nil {
+				logger.Infof(ctx, "shared the response of an identical request in flight")
+				// billed like the request which was sent upstream
+				billing.Go(func() {
+					postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio)
+				})
+				return nil
+			}
+			// the identical request failed, this one is sent on its own
+			call = nil
+		} else {
+			// let the waiting requests go on their own if this one fails before its response
+			defer call.finish(coalescingKey, nil, nil)
+		}
+	}
 
 	// get request body
 	var requestBody io.Reader
 	if meta.APIType == apitype.OpenAI {
 		// no need to convert request for openai
-		shouldResetRequestBody := isModelMapped || meta.ChannelType == channeltype.Baichuan // frequency_penalty 0 is not acceptable for baichuan
+		isFieldsStripped := stripUnsupportedFields(ctx, meta, textRequest)
+		// ask openai not to pad the chunks at all, instead of stripping the padding afterwards
+		shouldDisableObfuscation := config.StripStreamObfuscation && meta.IsStream && meta.ChannelType == channeltype.OpenAI &&
+			(textRequest.StreamOptions == nil || textRequest.StreamOptions.IncludeObfuscation == nil)
+		if shouldDisableObfuscation {
+			if textRequest.StreamOptions == nil {
+				textRequest.StreamOptions = &model.StreamOptions{}
+			}
+			includeObfuscation := false
+			textRequest.StreamOptions.IncludeObfuscation = &includeObfuscation
+		}
+		shouldResetRequestBody := isModelMapped || isModelDowngraded || isStreamNegotiated || isStreamForced || isDefaultParamsInjected || isParamsOverridden || isHistoryTrimmed || isFieldsStripped || shouldDisableObfuscation ||
+			meta.ChannelType == channeltype.Baichuan || // frequency_penalty 0 is not acceptable for baichuan
+			meta.ChannelType == channeltype.Mistral // mistral rejects some openai fields
 		if shouldResetRequestBody {
-			jsonStr, err := json.Marshal(textRequest)
+			convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
+			if err != nil {
+				billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+				return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
+			}
+			jsonStr, err := json.Marshal(convertedRequest)
 			if err != nil {
+				billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 				return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
 			}
 			requestBody = bytes.NewBuffer(jsonStr)
@@ -69,40 +196,126 @@ func RelayTextHelper(c *gin.Context) *model.ErrorWithStatusCode {
 			requestBody = c.Request.Body
 		}
 	} else {
+		if textRequest.LogitBias != nil {
+			logger.Warnf(ctx, "logit_bias is not supported by channel #%d, stripped", meta.ChannelId)
+		}
 		convertedRequest, err := adaptor.ConvertRequest(c, meta.Mode, textRequest)
 		if err != nil {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 			return openai.ErrorWrapper(err, "convert_request_failed", http.StatusInternalServerError)
 		}
 		jsonData, err := json.Marshal(convertedRequest)
 		if err != nil {
+			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 			return openai.ErrorWrapper(err, "json_marshal_failed", http.StatusInternalServerError)
 		}
 		logger.Debugf(ctx, "converted request: \n%s", string(jsonData))
 		requestBody = bytes.NewBuffer(jsonData)
 	}
+	requestBody, err = transformRequestBody(c, requestBody)
+	if err != nil {
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+		return openai.ErrorWrapper(err, "transform_request_failed", http.StatusInternalServerError)
+	}
+
+	// the deadline of a stream covers the upstream request as well
+	restoreRequest := limitStreamDuration(c, meta)
+	defer restoreRequest()
 
 	// do request
+	upstreamStartTime := time.Now()
 	resp, err := adaptor.DoRequest(c, meta, requestBody)
 	if err != nil {
 		logger.Errorf(ctx, "DoRequest failed: %s", err.Error())
+		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return openai.ErrorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
 	if resp != nil {
-		errorHappened := (resp.StatusCode != http.StatusOK) || (meta.IsStream && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
+		recordUpstreamRateLimit(c, meta.ChannelId, resp)
+		// the openai adaptor handles a stream request answered without an event stream by itself
+		errorHappened := (resp.StatusCode != http.StatusOK) || (meta.IsStream && meta.APIType != apitype.OpenAI && strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json"))
 		if errorHappened {
 			billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 			return RelayErrorHandler(resp)
 		}
+		if !meta.IsStream {
+			err = checkEmptyResponseBody(resp)
+			if err != nil {
+				billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+				// some proxies reply 200 with nothing on error, let it be retried on another channel
+				return openai.ErrorWrapper(err, "empty_response_body", http.StatusBadGateway)
+			}
+		}
+		markUpstreamResponded(c)
+		if !meta.IsStream {
+			if err = transformResponseBody(c, resp); err != nil {
+				billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
+				return openai.ErrorWrapper(err, "transform_response_failed", http.StatusBadGateway)
+			}
+		}
 	}
 
 	// do response
-	usage, respErr := adaptor.DoResponse(c, resp, meta)
+	setServedByHeader(c)
+	// closest to the client, so that every write to the client is bounded
+	slowClientWriter := startSlowClientGuard(c, meta)
+	// outside the writers changing the response, so that the duplicates get exactly what the client gets
+	dedupWriter := startDedup(c, dedup)
+	// the stream is aggregated as the client gets it, including what the other writers append
+	aggregationWriter := startStreamAggregation(c, isStreamAggregated)
+	// outside the writers appending events, so that those are numbered too
+	eventWriter := startStreamEvent(c, meta)
+	auditWriter := startAudit(c, meta)
+	usageWriter := startUsageChunk(c, meta, textRequest)
+	coalescingWriter := startCoalescing(c, call)
+	// outside the repair, so that the repaired content is validated
+	jsonValidationWriter := startJSONValidation(c, meta, textRequest)
+	jsonRepairWriter := startJSONRepair(c, meta, textRequest)
+	// inside the writers changing the response, so that they get the responses of all the adaptors alike
+	normalizeWriter := startResponseNormalize(c, meta)
+	// inside the writers changing the response, so that the timeout error is handled like the events of the adaptor
+	streamDurationWriter := startStreamDuration(c, meta)
+	// the innermost writer, so that the first byte is the one the adaptor writes
+	latencyWriter := startSlowRequestLog(c, meta)
+	var usage *model.Usage
+	var respErr *model.ErrorWithStatusCode
+	// the tier the upstream served the request in, reported in its response
+	c.Set(ctxkey.ServiceTier, "")
+	if resp != nil && shouldAutoContinue(meta, textRequest) {
+		usage, respErr = doResponseWithAutoContinue(c, meta, adaptor, textRequest, resp)
+	} else {
+		usage, respErr = adaptor.DoResponse(c, resp, meta)
+	}
+	meta.ServiceTier = c.GetString(ctxkey.ServiceTier)
+	if respErr == nil {
+		// billed by the prompt and the completion tokens, whatever the total tokens reported
+		normalizeUsage(ctx, meta, usage)
+	}
+	if respErr == nil && config.GatewayPromptTokensEnabled {
+		// reconciled before the usage chunk, so that the client gets the usage it is billed for
+		reconcilePromptTokens(ctx, meta, usage)
+	}
+	finishSlowRequestLog(c, meta, latencyWriter, upstreamStartTime, usage, respErr == nil)
+	finishStreamDuration(c, streamDurationWriter)
+	finishResponseNormalize(c, meta, normalizeWriter, respErr == nil)
+	finishJSONRepair(c, jsonRepairWriter, respErr == nil)
+	finishJSONValidation(c, meta, jsonValidationWriter, respErr == nil)
+	finishCoalescing(c, coalescingKey, call, coalescingWriter, usage, respErr == nil)
+	finishUsageChunk(c, meta, usageWriter, usage)
+	finishAudit(c, meta, auditWriter, respErr == nil)
+	finishStreamEvent(c, eventWriter)
+	finishStreamAggregation(c, meta, aggregationWriter, usage, respErr == nil)
+	finishDedup(c, dedupKey, dedup, dedupWriter, respErr == nil)
+	finishSlowClientGuard(c, slowClientWriter)
 	if respErr != nil {
+		c.Writer.Header().Del(ServedByHeader)
 		logger.Errorf(ctx, "respErr is not nil: %+v", respErr)
 		billing.ReturnPreConsumedQuota(ctx, preConsumedQuota, meta.TokenId)
 		return respErr
 	}
 	// post-consume quota
-	go postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio)
+	billing.Go(func() {
+		postConsumeQuota(ctx, usage, meta, textRequest, ratio, preConsumedQuota, modelRatio, groupRatio)
+	})
 	return nil
 }
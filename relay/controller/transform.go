@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/common/ctxkey"
+	"github.com/songquanpeng/one-api/relay/transform"
+)
+
+// transformRequestBody applies the request transform of the channel to the body sent upstream,
+// after the body is converted for the adaptor
+func transformRequestBody(c *gin.Context, requestBody io.Reader) (io.Reader, error) {
+	expression := c.GetString(ctxkey.ConfigRequestTransform)
+	if expression == "" {
+		return requestBody, nil
+	}
+	body, err := io.ReadAll(requestBody)
+	if err != nil {
+		return nil, err
+	}
+	transformed, err := transform.Apply(expression, body, time.Duration(config.TransformTimeout)*time.Millisecond)
+	if err != nil {
+		return nil, fmt.Errorf("request transform of channel #%d failed: %w", c.GetInt(ctxkey.ChannelId), err)
+	}
+	return bytes.NewReader(transformed), nil
+}
+
+// transformResponseBody applies the response transform of the channel to a non-stream response
+// before the adaptor parses it, the responses of streams and failed requests are not transformed
+func transformResponseBody(c *gin.Context, resp *http.Response) error {
+	expression := c.GetString(ctxkey.ConfigResponseTransform)
+	if expression == "" {
+		return nil
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	transformed, err := transform.Apply(expression, body, time.Duration(config.TransformTimeout)*time.Millisecond)
+	if err != nil {
+		return fmt.Errorf("response transform of channel #%d failed: %w", c.GetInt(ctxkey.ChannelId), err)
+	}
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = int64(len(transformed))
+	resp.Body = io.NopCloser(bytes.NewReader(transformed))
+	return nil
+}
@@ -2,6 +2,8 @@ package validator
 
 import (
 	"errors"
+	"fmt"
+	"github.com/songquanpeng/one-api/common/config"
 	"github.com/songquanpeng/one-api/relay/model"
 	"github.com/songquanpeng/one-api/relay/relaymode"
 	"math"
@@ -14,6 +16,24 @@ func ValidateTextRequest(textRequest *model.GeneralOpenAIRequest, relayMode int)
 	if textRequest.Model == "" {
 		return errors.New("model is required")
 	}
+	switch textRequest.ReasoningEffort {
+	case "", "low", "medium", "high":
+	default:
+		return fmt.Errorf("reasoning_effort %s is invalid, it must be one of low, medium and high", textRequest.ReasoningEffort)
+	}
+	if textRequest.Prediction != nil {
+		if relayMode != relaymode.ChatCompletions {
+			return errors.New("prediction is only supported by chat completions")
+		}
+		if textRequest.Prediction.Type != "content" {
+			return fmt.Errorf("prediction type %s is invalid, it must be content", textRequest.Prediction.Type)
+		}
+	}
+	if config.MaxPromptSize > 0 {
+		if size := promptSize(textRequest); size > config.MaxPromptSize {
+			return fmt.Errorf("prompt is too large: %d bytes, at most %d bytes are allowed", size, config.MaxPromptSize)
+		}
+	}
 	switch relayMode {
 	case relaymode.Completions:
 		if textRequest.Prompt == "" {
@@ -35,3 +55,42 @@ func ValidateTextRequest(textRequest *model.GeneralOpenAIRequest, relayMode int)
 	}
 	return nil
 }
+
+// promptSize returns the size of the text of the request which will be tokenized, without copying it
+func promptSize(textRequest *model.GeneralOpenAIRequest) int {
+	size := inputSize(textRequest.Prompt) + inputSize(textRequest.Input)
+	for _, message := range textRequest.Messages {
+		switch content := message.Content.(type) {
+		case string:
+			size += len(content)
+		case []any:
+			for _, part := range content {
+				if m, ok := part.(map[string]any); ok {
+					if text, ok := m["text"].(string); ok {
+						size += len(text)
+					}
+				}
+			}
+		}
+	}
+	return size
+}
+
+func inputSize(input any) int {
+	size := 0
+	switch v := input.(type) {
+	case string:
+		size = len(v)
+	case []string:
+		for _, s := range v {
+			size += len(s)
+		}
+	case []any:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				size += len(s)
+			}
+		}
+	}
+	return size
+}
@@ -0,0 +1,52 @@
+package validator
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/songquanpeng/one-api/common/config"
+	"github.com/songquanpeng/one-api/relay/model"
+	"github.com/songquanpeng/one-api/relay/relaymode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateTextRequestPromptSize(t *testing.T) {
+	maxPromptSize := config.MaxPromptSize
+	config.MaxPromptSize = 10
+	defer func() {
+		config.MaxPromptSize = maxPromptSize
+	}()
+
+	textRequest := &model.GeneralOpenAIRequest{
+		Model: "gpt-3.5-turbo",
+		Messages: []model.Message{
+			{Role: "user", Content: "hello"},
+			{Role: "user", Content: []any{map[string]any{"type": "text", "text": "world"}}},
+		},
+	}
+	assert.NoError(t, ValidateTextRequest(textRequest, relaymode.ChatCompletions))
+
+	textRequest.Messages = append(textRequest.Messages, model.Message{Role: "user", Content: "!"})
+	err := ValidateTextRequest(textRequest, relaymode.ChatCompletions)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "prompt is too large")
+
+	textRequest = &model.GeneralOpenAIRequest{Model: "text-embedding-ada-002", Input: []any{"hello", strings.Repeat("a", 6)}}
+	assert.Error(t, ValidateTextRequest(textRequest, relaymode.Embeddings))
+
+	config.MaxPromptSize = 0
+	assert.NoError(t, ValidateTextRequest(textRequest, relaymode.Embeddings))
+}
+
+func TestValidateTextRequestReasoningEffort(t *testing.T) {
+	textRequest := &model.GeneralOpenAIRequest{
+		Model:    "o3-mini",
+		Messages: []model.Message{{Role: "user", Content: "hello"}},
+	}
+	for _, effort := range []string{"", "low", "medium", "high"} {
+		textRequest.ReasoningEffort = effort
+		assert.NoError(t, ValidateTextRequest(textRequest, relaymode.ChatCompletions))
+	}
+	textRequest.ReasoningEffort = "extreme"
+	assert.Error(t, ValidateTextRequest(textRequest, relaymode.ChatCompletions))
+}
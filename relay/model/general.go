@@ -4,30 +4,50 @@ type ResponseFormat struct {
 	Type string `json:"type,omitempty"`
 }
 
+type StreamOptions struct {
+	IncludeUsage       bool  `json:"include_usage,omitempty"`
+	IncludeObfuscation *bool `json:"include_obfuscation,omitempty"`
+}
+
+// Prediction is the predicted output of a chat request, whose matching part is generated faster
+type Prediction struct {
+	Type    string `json:"type"`
+	Content any    `json:"content"`
+}
+
 type GeneralOpenAIRequest struct {
-	Messages         []Message       `json:"messages,omitempty"`
-	Model            string          `json:"model,omitempty"`
-	FrequencyPenalty float64         `json:"frequency_penalty,omitempty"`
-	MaxTokens        int             `json:"max_tokens,omitempty"`
-	N                int             `json:"n,omitempty"`
-	PresencePenalty  float64         `json:"presence_penalty,omitempty"`
-	ResponseFormat   *ResponseFormat `json:"response_format,omitempty"`
-	Seed             float64         `json:"seed,omitempty"`
-	Stream           bool            `json:"stream,omitempty"`
-	Temperature      float64         `json:"temperature,omitempty"`
-	TopP             float64         `json:"top_p,omitempty"`
-	TopK             int             `json:"top_k,omitempty"`
-	Tools            []Tool          `json:"tools,omitempty"`
-	ToolChoice       any             `json:"tool_choice,omitempty"`
-	FunctionCall     any             `json:"function_call,omitempty"`
-	Functions        any             `json:"functions,omitempty"`
-	User             string          `json:"user,omitempty"`
-	Prompt           any             `json:"prompt,omitempty"`
-	Input            any             `json:"input,omitempty"`
-	EncodingFormat   string          `json:"encoding_format,omitempty"`
-	Dimensions       int             `json:"dimensions,omitempty"`
-	Instruction      string          `json:"instruction,omitempty"`
-	Size             string          `json:"size,omitempty"`
+	Messages          []Message          `json:"messages,omitempty"`
+	Model             string             `json:"model,omitempty"`
+	FrequencyPenalty  float64            `json:"frequency_penalty,omitempty"`
+	LogitBias         map[string]float64 `json:"logit_bias,omitempty"`
+	MaxTokens         int                `json:"max_tokens,omitempty"`
+	N                 int                `json:"n,omitempty"`
+	PresencePenalty   float64            `json:"presence_penalty,omitempty"`
+	ResponseFormat    *ResponseFormat    `json:"response_format,omitempty"`
+	Seed              float64            `json:"seed,omitempty"`
+	Stop              any                `json:"stop,omitempty"`
+	Stream            bool               `json:"stream,omitempty"`
+	StreamOptions     *StreamOptions     `json:"stream_options,omitempty"`
+	Temperature       float64            `json:"temperature,omitempty"`
+	TopP              float64            `json:"top_p,omitempty"`
+	TopK              int                `json:"top_k,omitempty"`
+	Tools             []Tool             `json:"tools,omitempty"`
+	ToolChoice        any                `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool              `json:"parallel_tool_calls,omitempty"`
+	FunctionCall      any                `json:"function_call,omitempty"`
+	Functions         any                `json:"functions,omitempty"`
+	User              string             `json:"user,omitempty"`
+	Prompt            any                `json:"prompt,omitempty"`
+	Input             any                `json:"input,omitempty"`
+	EncodingFormat    string             `json:"encoding_format,omitempty"`
+	Dimensions        int                `json:"dimensions,omitempty"`
+	Instruction       string             `json:"instruction,omitempty"`
+	Size              string             `json:"size,omitempty"`
+	Store             bool               `json:"store,omitempty"`
+	Metadata          map[string]any     `json:"metadata,omitempty"`
+	ServiceTier       string             `json:"service_tier,omitempty"`
+	ReasoningEffort   string             `json:"reasoning_effort,omitempty"`
+	Prediction        *Prediction        `json:"prediction,omitempty"`
 }
 
 func (r GeneralOpenAIRequest) ParseInput() []string {
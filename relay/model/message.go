@@ -70,6 +70,18 @@ func (m Message) ParseContent() []MessageContent {
 						},
 					})
 				}
+			case ContentTypeInputAudio:
+				if subObj, ok := contentMap["input_audio"].(map[string]any); ok {
+					data, _ := subObj["data"].(string)
+					format, _ := subObj["format"].(string)
+					contentList = append(contentList, MessageContent{
+						Type: ContentTypeInputAudio,
+						InputAudio: &InputAudio{
+							Data:   data,
+							Format: format,
+						},
+					})
+				}
 			}
 		}
 		return contentList
@@ -82,8 +94,31 @@ type ImageURL struct {
 	Detail string `json:"detail,omitempty"`
 }
 
+// InputAudio is the base64 encoded audio of a message, the format is wav or mp3
+type InputAudio struct {
+	Data   string `json:"data"`
+	Format string `json:"format"`
+}
+
 type MessageContent struct {
-	Type     string    `json:"type,omitempty"`
-	Text     string    `json:"text"`
-	ImageURL *ImageURL `json:"image_url,omitempty"`
+	Type       string      `json:"type,omitempty"`
+	Text       string      `json:"text"`
+	ImageURL   *ImageURL   `json:"image_url,omitempty"`
+	InputAudio *InputAudio `json:"input_audio,omitempty"`
+}
+
+// HasInputAudio reports whether any of the messages carries audio
+func HasInputAudio(messages []Message) bool {
+	for _, message := range messages {
+		contentList, ok := message.Content.([]any)
+		if !ok {
+			continue
+		}
+		for _, contentItem := range contentList {
+			if contentMap, ok := contentItem.(map[string]any); ok && contentMap["type"] == ContentTypeInputAudio {
+				return true
+			}
+		}
+	}
+	return false
 }
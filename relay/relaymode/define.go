@@ -11,4 +11,26 @@ const (
 	AudioSpeech
 	AudioTranscription
 	AudioTranslation
+	Assistants
 )
+
+// names are the names of the relay modes in the configuration, a name may stand for several modes
+var names = map[string][]int{
+	"chat_completions":     {ChatCompletions},
+	"completions":          {Completions},
+	"embeddings":           {Embeddings},
+	"moderations":          {Moderations},
+	"images_generations":   {ImagesGenerations},
+	"images":               {ImagesGenerations},
+	"edits":                {Edits},
+	"audio_speech":         {AudioSpeech},
+	"audio_transcriptions": {AudioTranscription},
+	"audio_translations":   {AudioTranslation},
+	"audio":                {AudioSpeech, AudioTranscription, AudioTranslation},
+	"assistants":           {Assistants},
+}
+
+// GetByName returns the relay modes the name stands for, nil if the name is unknown
+func GetByName(name string) []int {
+	return names[name]
+}
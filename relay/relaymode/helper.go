@@ -2,28 +2,58 @@ package relaymode
 
 import "strings"
 
+// rule maps the paths matching its pattern to a relay mode, a segment of the pattern starting with : matches
+// any single segment, and a prefix rule matches the sub paths of its pattern as well
+type rule struct {
+	pattern string
+	mode    int
+	prefix  bool
+}
+
+// the rules are tried in order, the first matching one wins, so the more specific ones must come first,
+// matching whole segments only, so that e.g. /v1/chat/completions_foo is not taken for a chat completion
+var rules = []rule{
+	{pattern: "/v1/chat/completions", mode: ChatCompletions},
+	{pattern: "/v1/completions", mode: Completions},
+	{pattern: "/v1/embeddings", mode: Embeddings},
+	{pattern: "/v1/engines/:model/embeddings", mode: Embeddings},
+	{pattern: "/v1/moderations", mode: Moderations},
+	{pattern: "/v1/images/generations", mode: ImagesGenerations},
+	{pattern: "/v1/edits", mode: Edits},
+	{pattern: "/v1/audio/speech", mode: AudioSpeech},
+	{pattern: "/v1/audio/transcriptions", mode: AudioTranscription},
+	{pattern: "/v1/audio/translations", mode: AudioTranslation},
+	{pattern: "/v1/assistants", mode: Assistants, prefix: true},
+	{pattern: "/v1/threads", mode: Assistants, prefix: true},
+}
+
+func (r rule) match(segments []string) bool {
+	patternSegments := strings.Split(strings.Trim(r.pattern, "/"), "/")
+	if len(segments) < len(patternSegments) || (!r.prefix && len(segments) != len(patternSegments)) {
+		return false
+	}
+	for i, patternSegment := range patternSegments {
+		if strings.HasPrefix(patternSegment, ":") {
+			if segments[i] == "" {
+				return false
+			}
+			continue
+		}
+		if segments[i] != patternSegment {
+			return false
+		}
+	}
+	return true
+}
+
+// GetByPath returns the relay mode of the path by the first matching rule, Unknown if none matches
 func GetByPath(path string) int {
-	relayMode := Unknown
-	if strings.HasPrefix(path, "/v1/chat/completions") {
-		relayMode = ChatCompletions
-	} else if strings.HasPrefix(path, "/v1/completions") {
-		relayMode = Completions
-	} else if strings.HasPrefix(path, "/v1/embeddings") {
-		relayMode = Embeddings
-	} else if strings.HasSuffix(path, "embeddings") {
-		relayMode = Embeddings
-	} else if strings.HasPrefix(path, "/v1/moderations") {
-		relayMode = Moderations
-	} else if strings.HasPrefix(path, "/v1/images/generations") {
-		relayMode = ImagesGenerations
-	} else if strings.HasPrefix(path, "/v1/edits") {
-		relayMode = Edits
-	} else if strings.HasPrefix(path, "/v1/audio/speech") {
-		relayMode = AudioSpeech
-	} else if strings.HasPrefix(path, "/v1/audio/transcriptions") {
-		relayMode = AudioTranscription
-	} else if strings.HasPrefix(path, "/v1/audio/translations") {
-		relayMode = AudioTranslation
+	// a trailing slash is tolerated, as some clients append it to the base url
+	segments := strings.Split(strings.Trim(path, "/"), "/")
+	for _, r := range rules {
+		if r.match(segments) {
+			return r.mode
+		}
 	}
-	return relayMode
+	return Unknown
 }
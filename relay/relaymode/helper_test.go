@@ -0,0 +1,39 @@
+package relaymode
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetByPath(t *testing.T) {
+	cases := map[string]int{
+		"/v1/chat/completions":                    ChatCompletions,
+		"/v1/chat/completions/":                   ChatCompletions,
+		"/v1/completions":                         Completions,
+		"/v1/embeddings":                          Embeddings,
+		"/v1/engines/text-embedding-3/embeddings": Embeddings,
+		"/v1/moderations":                         Moderations,
+		"/v1/images/generations":                  ImagesGenerations,
+		"/v1/edits":                               Edits,
+		"/v1/audio/speech":                        AudioSpeech,
+		"/v1/audio/transcriptions":                AudioTranscription,
+		"/v1/audio/translations":                  AudioTranslation,
+		"/v1/assistants":                          Assistants,
+		"/v1/assistants/asst_1/files":             Assistants,
+		"/v1/threads/thread_1/runs/run_1":         Assistants,
+		// the ambiguous ones, which used to be matched by prefix or suffix
+		"/v1/chat/completions_legacy":        Unknown,
+		"/v1/chat/completions/extra":         Unknown,
+		"/v1/custom/embeddings":              Unknown,
+		"/v1/engines//embeddings":            Unknown,
+		"/v1/engines/model/extra/embeddings": Unknown,
+		"/v1/threadsafe":                     Unknown,
+		"/v2/chat/completions":               Unknown,
+		"/v1/images/edits":                   Unknown,
+		"":                                   Unknown,
+	}
+	for path, mode := range cases {
+		assert.Equal(t, mode, GetByPath(path), path)
+	}
+}
@@ -0,0 +1,156 @@
+package transform
+
+import "errors"
+
+type node interface {
+	eval(e *evaluator, input any) (any, error)
+}
+
+type identityNode struct{}
+
+func (identityNode) eval(_ *evaluator, input any) (any, error) {
+	return input, nil
+}
+
+type literalNode struct {
+	value any
+}
+
+func (n literalNode) eval(*evaluator, any) (any, error) {
+	return n.value, nil
+}
+
+type indexNode struct {
+	target  node
+	element pathElement
+}
+
+func (n indexNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	v, err := n.target.eval(e, input)
+	if err != nil {
+		return nil, err
+	}
+	return index(v, n.element)
+}
+
+// toPath returns the path of a node made of indexes on the identity, such as .a.b[0]
+func toPath(n node) ([]pathElement, bool) {
+	switch n := n.(type) {
+	case identityNode:
+		return nil, true
+	case indexNode:
+		path, ok := toPath(n.target)
+		if !ok {
+			return nil, false
+		}
+		return append(path, n.element), true
+	}
+	return nil, false
+}
+
+type objectNode struct {
+	keys   []string
+	values []node
+}
+
+func (n objectNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	object := make(map[string]any, len(n.keys))
+	for i, key := range n.keys {
+		v, err := n.values[i].eval(e, input)
+		if err != nil {
+			return nil, err
+		}
+		object[key] = v
+	}
+	return object, nil
+}
+
+type arrayNode struct {
+	items []node
+}
+
+func (n arrayNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	array := make([]any, 0, len(n.items))
+	for _, item := range n.items {
+		v, err := item.eval(e, input)
+		if err != nil {
+			return nil, err
+		}
+		array = append(array, v)
+	}
+	return array, nil
+}
+
+// pipeNode feeds the output of the left node to the right one
+type pipeNode struct {
+	left  node
+	right node
+}
+
+func (n pipeNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	v, err := n.left.eval(e, input)
+	if err != nil {
+		return nil, err
+	}
+	return n.right.eval(e, v)
+}
+
+// alternativeNode returns the right value if the left one is null or false
+type alternativeNode struct {
+	left  node
+	right node
+}
+
+func (n alternativeNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	v, err := n.left.eval(e, input)
+	if errors.Is(err, ErrTimeout) {
+		return nil, err
+	}
+	if err == nil && v != nil && v != false {
+		return v, nil
+	}
+	return n.right.eval(e, input)
+}
+
+// assignNode sets the path of the input to the value of an expression of the input
+type assignNode struct {
+	path  []pathElement
+	value node
+}
+
+func (n assignNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	v, err := n.value.eval(e, input)
+	if err != nil {
+		return nil, err
+	}
+	return setPath(input, n.path, v)
+}
+
+type deleteNode struct {
+	path []pathElement
+}
+
+func (n deleteNode) eval(e *evaluator, input any) (any, error) {
+	if err := e.step(); err != nil {
+		return nil, err
+	}
+	return deletePath(input, n.path)
+}
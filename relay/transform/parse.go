@@ -0,0 +1,372 @@
+package transform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenDot
+	// a field after a dot, such as .a or ."a b"
+	tokenField
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenPunct
+)
+
+type token struct {
+	kind   tokenKind
+	text   string
+	offset int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of expression"
+	}
+	if t.kind == tokenField {
+		return strconv.Quote("." + t.text)
+	}
+	return strconv.Quote(t.text)
+}
+
+func isIdentStart(ch byte) bool {
+	return ch == '_' || (ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')
+}
+
+func isIdentChar(ch byte) bool {
+	return isIdentStart(ch) || (ch >= '0' && ch <= '9')
+}
+
+func isDigit(ch byte) bool {
+	return ch >= '0' && ch <= '9'
+}
+
+// lexString returns the json string starting at i and the offset after it
+func lexString(s string, i int) (string, int, error) {
+	for j := i + 1; j < len(s); j++ {
+		switch s[j] {
+		case '\\':
+			j++
+		case '"':
+			var value string
+			if err := json.Unmarshal([]byte(s[i:j+1]), &value); err != nil {
+				return "", 0, fmt.Errorf("invalid string at offset %d", i)
+			}
+			return value, j + 1, nil
+		}
+	}
+	return "", 0, fmt.Errorf("unterminated string at offset %d", i)
+}
+
+func lex(s string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t' || ch == '\n' || ch == '\r':
+			i++
+		case ch == '.':
+			if i+1 < len(s) && isIdentStart(s[i+1]) {
+				j := i + 1
+				for j < len(s) && isIdentChar(s[j]) {
+					j++
+				}
+				tokens = append(tokens, token{kind: tokenField, text: s[i+1 : j], offset: i})
+				i = j
+			} else if i+1 < len(s) && s[i+1] == '"' {
+				value, j, err := lexString(s, i+1)
+				if err != nil {
+					return nil, err
+				}
+				tokens = append(tokens, token{kind: tokenField, text: value, offset: i})
+				i = j
+			} else {
+				tokens = append(tokens, token{kind: tokenDot, text: ".", offset: i})
+				i++
+			}
+		case isIdentStart(ch):
+			j := i
+			for j < len(s) && isIdentChar(s[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: s[i:j], offset: i})
+			i = j
+		case ch == '"':
+			value, j, err := lexString(s, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{kind: tokenString, text: value, offset: i})
+			i = j
+		case isDigit(ch) || (ch == '-' && i+1 < len(s) && isDigit(s[i+1])):
+			j := i + 1
+			for j < len(s) && (isDigit(s[j]) || s[j] == '.' || s[j] == 'e' || s[j] == 'E' ||
+				((s[j] == '+' || s[j] == '-') && (s[j-1] == 'e' || s[j-1] == 'E'))) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: s[i:j], offset: i})
+			i = j
+		case ch == '/' && i+1 < len(s) && s[i+1] == '/':
+			tokens = append(tokens, token{kind: tokenPunct, text: "//", offset: i})
+			i += 2
+		case strings.IndexByte("|=()[]{},:", ch) >= 0:
+			tokens = append(tokens, token{kind: tokenPunct, text: string(ch), offset: i})
+			i++
+		default:
+			return nil, fmt.Errorf("unexpected character %q at offset %d", ch, i)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, offset: len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isPunct(text string) bool {
+	t := p.peek()
+	return t.kind == tokenPunct && t.text == text
+}
+
+func (p *parser) expect(text string) error {
+	if !p.isPunct(text) {
+		return fmt.Errorf("expected %q but got %s at offset %d", text, p.peek(), p.peek().offset)
+	}
+	p.next()
+	return nil
+}
+
+// pipe := assign ("|" assign)*
+func (p *parser) parsePipe() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxDepth)
+	}
+	left, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("|") {
+		p.next()
+		right, err := p.parseAssign()
+		if err != nil {
+			return nil, err
+		}
+		left = pipeNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// assign := alternative ("=" alternative)?
+func (p *parser) parseAssign() (node, error) {
+	offset := p.peek().offset
+	left, err := p.parseAlternative()
+	if err != nil {
+		return nil, err
+	}
+	if !p.isPunct("=") {
+		return left, nil
+	}
+	p.next()
+	path, ok := toPath(left)
+	if !ok {
+		return nil, fmt.Errorf("the left side of the assignment at offset %d is not a path", offset)
+	}
+	value, err := p.parseAlternative()
+	if err != nil {
+		return nil, err
+	}
+	return assignNode{path: path, value: value}, nil
+}
+
+// alternative := postfix ("//" postfix)*
+func (p *parser) parseAlternative() (node, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	for p.isPunct("//") {
+		p.next()
+		right, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		left = alternativeNode{left: left, right: right}
+	}
+	return left, nil
+}
+
+// postfix := primary (field | "[" index "]")*
+func (p *parser) parsePostfix() (node, error) {
+	n, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.peek().kind == tokenField:
+			n = indexNode{target: n, element: pathElement{key: p.next().text}}
+		case p.isPunct("["):
+			p.next()
+			element, err := p.parseIndex()
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect("]"); err != nil {
+				return nil, err
+			}
+			n = indexNode{target: n, element: element}
+		default:
+			return n, nil
+		}
+	}
+}
+
+func (p *parser) parseIndex() (pathElement, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenString:
+		return pathElement{key: t.text}, nil
+	case tokenNumber:
+		i, err := strconv.Atoi(t.text)
+		if err != nil {
+			return pathElement{}, fmt.Errorf("invalid array index %s at offset %d", t.text, t.offset)
+		}
+		return pathElement{index: i, isIndex: true}, nil
+	}
+	return pathElement{}, fmt.Errorf("expected a string or an integer index but got %s at offset %d", t, t.offset)
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.kind {
+	case tokenDot:
+		return identityNode{}, nil
+	case tokenField:
+		return indexNode{target: identityNode{}, element: pathElement{key: t.text}}, nil
+	case tokenString:
+		return literalNode{value: t.text}, nil
+	case tokenNumber:
+		return literalNode{value: json.Number(t.text)}, nil
+	case tokenIdent:
+		switch t.text {
+		case "true":
+			return literalNode{value: true}, nil
+		case "false":
+			return literalNode{value: false}, nil
+		case "null":
+			return literalNode{value: nil}, nil
+		case "del":
+			return p.parseDelete()
+		}
+		return nil, fmt.Errorf("unknown function %s at offset %d", t.text, t.offset)
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			n, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expect(")")
+		case "[":
+			return p.parseArray()
+		case "{":
+			return p.parseObject()
+		}
+	}
+	return nil, fmt.Errorf("unexpected %s at offset %d", t, t.offset)
+}
+
+// del := "del" "(" path ")"
+func (p *parser) parseDelete() (node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	offset := p.peek().offset
+	n, err := p.parsePostfix()
+	if err != nil {
+		return nil, err
+	}
+	path, ok := toPath(n)
+	if !ok {
+		return nil, fmt.Errorf("the argument of del at offset %d is not a path", offset)
+	}
+	return deleteNode{path: path}, p.expect(")")
+}
+
+// array := "[" (pipe ("," pipe)*)? "]"
+func (p *parser) parseArray() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	var items []node
+	for !p.isPunct("]") {
+		if len(items) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		item, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	p.next()
+	return arrayNode{items: items}, nil
+}
+
+// object := "{" (key (":" alternative)? ("," key (":" alternative)?)*)? "}", a key without a value is
+// the field of the input of the same name
+func (p *parser) parseObject() (node, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	if p.depth > maxDepth {
+		return nil, fmt.Errorf("expression is nested deeper than %d levels", maxDepth)
+	}
+	n := objectNode{}
+	for !p.isPunct("}") {
+		if len(n.keys) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+		t := p.next()
+		if t.kind != tokenIdent && t.kind != tokenString {
+			return nil, fmt.Errorf("expected an object key but got %s at offset %d", t, t.offset)
+		}
+		var value node = indexNode{target: identityNode{}, element: pathElement{key: t.text}}
+		if p.isPunct(":") {
+			p.next()
+			var err error
+			if value, err = p.parseAlternative(); err != nil {
+				return nil, err
+			}
+		}
+		n.keys = append(n.keys, t.text)
+		n.values = append(n.values, value)
+	}
+	p.next()
+	return n, nil
+}
@@ -0,0 +1,325 @@
+// Package transform implements a small subset of jq, which the channels use to adapt their requests and responses
+// to backends whose fields are named or nested otherwise, e.g.
+//
+//	.max_new_tokens = .max_tokens | del(.max_tokens)
+//	{model, prompt: .messages[-1].content, stream: false}
+//	.choices[0].message.content = (.output // "")
+//
+// it supports paths, the identity, literals, object and array construction, pipes, the alternative operator //,
+// assignments and del, there are no loops, functions or recursion, but as the constructed objects and arrays
+// share their values, the output of a short expression may still be huge, so the evaluation and the encoding
+// of the output are given a deadline
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTimeout is returned when an expression runs past its deadline
+var ErrTimeout = errors.New("transform timed out")
+
+const (
+	maxExpressionLength = 4096
+	maxDepth            = 32
+	// the most elements an assignment may append to an array
+	maxArrayGrowth = 1024
+)
+
+// Expression is a compiled expression
+type Expression struct {
+	root node
+}
+
+// Compile parses an expression, it fails on the expressions out of the supported subset
+func Compile(expression string) (*Expression, error) {
+	if len(expression) > maxExpressionLength {
+		return nil, fmt.Errorf("expression is longer than %d characters", maxExpressionLength)
+	}
+	tokens, err := lex(expression)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	root, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %s at offset %d", p.peek(), p.peek().offset)
+	}
+	return &Expression{root: root}, nil
+}
+
+// the steps between two checks of the deadline, as reading the clock costs more than a step
+const stepsPerDeadlineCheck = 256
+
+// evaluator counts the steps of an evaluation, and fails it once its deadline has passed
+type evaluator struct {
+	deadline time.Time
+	steps    int
+}
+
+func (e *evaluator) step() error {
+	e.steps++
+	if e.steps%stepsPerDeadlineCheck == 0 && time.Now().After(e.deadline) {
+		return ErrTimeout
+	}
+	return nil
+}
+
+// walk visits every value of the output, which the encoding does as well, so that the encoding of a huge output
+// fails on the deadline rather than running on
+func (e *evaluator) walk(v any) error {
+	if err := e.step(); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case map[string]any:
+		for _, item := range value {
+			if err := e.walk(item); err != nil {
+				return err
+			}
+		}
+	case []any:
+		for _, item := range value {
+			if err := e.walk(item); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Eval applies the expression to a value decoded from json, it fails with ErrTimeout if it runs longer than timeout
+func (e *Expression) Eval(input any, timeout time.Duration) (any, error) {
+	ev := &evaluator{deadline: time.Now().Add(timeout)}
+	output, err := e.root.eval(ev, input)
+	if err != nil {
+		return nil, err
+	}
+	if err = ev.walk(output); err != nil {
+		return nil, err
+	}
+	return output, nil
+}
+
+// the channels share few expressions, so they are compiled once
+var compiledExpressions sync.Map
+
+func compileCached(expression string) (*Expression, error) {
+	if expr, ok := compiledExpressions.Load(expression); ok {
+		return expr.(*Expression), nil
+	}
+	expr, err := Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	compiledExpressions.Store(expression, expr)
+	return expr, nil
+}
+
+// Validate reports whether the expression can be compiled, for checking the configurations when they are saved
+func Validate(expression string) error {
+	_, err := compileCached(expression)
+	return err
+}
+
+// Apply applies the expression to a json body, and returns the transformed body
+func Apply(expression string, body []byte, timeout time.Duration) ([]byte, error) {
+	expr, err := compileCached(expression)
+	if err != nil {
+		return nil, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	// keeps the large integers as they are
+	decoder.UseNumber()
+	var input any
+	if err = decoder.Decode(&input); err != nil {
+		return nil, fmt.Errorf("body is not valid json: %w", err)
+	}
+	output, err := expr.Eval(input, timeout)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(output)
+}
+
+type pathElement struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+func (e pathElement) String() string {
+	if e.isIndex {
+		return fmt.Sprint(e.index)
+	}
+	return fmt.Sprintf("%q", e.key)
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case json.Number, float64:
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func index(v any, element pathElement) (any, error) {
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		if element.isIndex {
+			return nil, fmt.Errorf("cannot index an object with %s", element)
+		}
+		return value[element.key], nil
+	case []any:
+		if !element.isIndex {
+			return nil, fmt.Errorf("cannot index an array with %s", element)
+		}
+		i := element.index
+		if i < 0 {
+			i += len(value)
+		}
+		if i < 0 || i >= len(value) {
+			return nil, nil
+		}
+		return value[i], nil
+	}
+	return nil, fmt.Errorf("cannot index a %s with %s", typeName(v), element)
+}
+
+// setPath returns a copy of v with the value at the path replaced, the objects and arrays on the path are created if missing
+func setPath(v any, path []pathElement, newValue any) (any, error) {
+	if len(path) == 0 {
+		return newValue, nil
+	}
+	element := path[0]
+	if v == nil {
+		if element.isIndex {
+			v = []any{}
+		} else {
+			v = map[string]any{}
+		}
+	}
+	switch value := v.(type) {
+	case map[string]any:
+		if element.isIndex {
+			return nil, fmt.Errorf("cannot index an object with %s", element)
+		}
+		child, err := setPath(value[element.key], path[1:], newValue)
+		if err != nil {
+			return nil, err
+		}
+		result := make(map[string]any, len(value)+1)
+		for k, item := range value {
+			result[k] = item
+		}
+		result[element.key] = child
+		return result, nil
+	case []any:
+		if !element.isIndex {
+			return nil, fmt.Errorf("cannot index an array with %s", element)
+		}
+		i := element.index
+		if i < 0 {
+			i += len(value)
+		}
+		if i < 0 {
+			return nil, errors.New("array index is out of range")
+		}
+		if i-len(value) >= maxArrayGrowth {
+			return nil, fmt.Errorf("cannot append more than %d elements to an array", maxArrayGrowth)
+		}
+		result := append([]any(nil), value...)
+		for len(result) <= i {
+			result = append(result, nil)
+		}
+		var current any
+		if i < len(value) {
+			current = value[i]
+		}
+		child, err := setPath(current, path[1:], newValue)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = child
+		return result, nil
+	}
+	return nil, fmt.Errorf("cannot index a %s with %s", typeName(v), element)
+}
+
+// deletePath returns a copy of v without the value at the path, a missing path is left as is
+func deletePath(v any, path []pathElement) (any, error) {
+	if len(path) == 0 {
+		return nil, nil
+	}
+	element := path[0]
+	switch value := v.(type) {
+	case nil:
+		return nil, nil
+	case map[string]any:
+		if element.isIndex {
+			return nil, fmt.Errorf("cannot index an object with %s", element)
+		}
+		child, ok := value[element.key]
+		if !ok {
+			return value, nil
+		}
+		result := make(map[string]any, len(value))
+		for k, item := range value {
+			result[k] = item
+		}
+		if len(path) == 1 {
+			delete(result, element.key)
+			return result, nil
+		}
+		newChild, err := deletePath(child, path[1:])
+		if err != nil {
+			return nil, err
+		}
+		result[element.key] = newChild
+		return result, nil
+	case []any:
+		if !element.isIndex {
+			return nil, fmt.Errorf("cannot index an array with %s", element)
+		}
+		i := element.index
+		if i < 0 {
+			i += len(value)
+		}
+		if i < 0 || i >= len(value) {
+			return value, nil
+		}
+		if len(path) == 1 {
+			result := make([]any, 0, len(value)-1)
+			result = append(result, value[:i]...)
+			return append(result, value[i+1:]...), nil
+		}
+		newChild, err := deletePath(value[i], path[1:])
+		if err != nil {
+			return nil, err
+		}
+		result := make([]any, len(value))
+		copy(result, value)
+		result[i] = newChild
+		return result, nil
+	}
+	return nil, fmt.Errorf("cannot index a %s with %s", typeName(v), element)
+}
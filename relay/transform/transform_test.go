@@ -0,0 +1,66 @@
+package transform
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApply(t *testing.T) {
+	body := `{"model":"llama","max_tokens":100,"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}],"seed":12345678901234567890}`
+	for expression, expected := range map[string]string{
+		`.`: body,
+		`.max_new_tokens = .max_tokens | del(.max_tokens)`:                                                `{"model":"llama","max_new_tokens":100,"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}],"seed":12345678901234567890}`,
+		`{model, inputs: .messages[-1].content, parameters: {max_new_tokens: .max_tokens, stop: ["\n"]}}`: `{"model":"llama","inputs":"hi","parameters":{"max_new_tokens":100,"stop":["\n"]}}`,
+		`.temperature // 0.7`:                                            `0.7`,
+		`.messages[0]."content"`:                                         `"be brief"`,
+		`del(.messages[0]) | .messages[5] // "none"`:                     `"none"`,
+		`.options.num_predict = (.max_tokens // 128) | {model, options}`: `{"model":"llama","options":{"num_predict":100}}`,
+	} {
+		output, err := Apply(expression, []byte(body), time.Second)
+		assert.NoError(t, err, expression)
+		assert.JSONEq(t, expected, string(output), expression)
+	}
+
+	response := `{"data":{"output":"hello","tokens":{"in":3,"out":1}}}`
+	output, err := Apply(`.data | {choices: [{index: 0, message: {role: "assistant", content: .output}, finish_reason: "stop"}], usage: {prompt_tokens: .tokens.in, completion_tokens: .tokens.out}}`, []byte(response), time.Second)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"choices":[{"index":0,"message":{"role":"assistant","content":"hello"},"finish_reason":"stop"}],"usage":{"prompt_tokens":3,"completion_tokens":1}}`, string(output))
+
+	_, err = Apply(`.model[0]`, []byte(body), time.Second)
+	assert.Error(t, err)
+}
+
+func TestApplyTimeout(t *testing.T) {
+	// each level copies the output of the last one 8 times, so the output has 8^20 values
+	expression := strings.Repeat("[., ., ., ., ., ., ., .] | ", 20) + "."
+	start := time.Now()
+	_, err := Apply(expression, []byte(`{"a":1}`), 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+	assert.Less(t, time.Since(start), time.Second)
+
+	_, err = Apply(`(`+expression+`) // 1`, []byte(`{"a":1}`), 50*time.Millisecond)
+	assert.ErrorIs(t, err, ErrTimeout)
+}
+
+func TestCompileInvalid(t *testing.T) {
+	for _, expression := range []string{
+		``,
+		`.a |`,
+		`.a = `,
+		`"a" = 1`,
+		`del(1)`,
+		`length`,
+		`.a[`,
+		`{"a" 1}`,
+		`.a; .b`,
+		`"unterminated`,
+	} {
+		_, err := Compile(expression)
+		assert.Error(t, err, expression)
+	}
+	_, err := Compile(strings.Repeat("(", 40) + "." + strings.Repeat(")", 40))
+	assert.ErrorContains(t, err, "nested deeper")
+}
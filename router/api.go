@@ -58,6 +58,7 @@ func SetApiRouter(router *gin.Engine) {
 				adminRoute.POST("/", controller.CreateUser)
 				adminRoute.POST("/manage", controller.ManageUser)
 				adminRoute.PUT("/", controller.UpdateUser)
+				adminRoute.PUT("/group_override", controller.UpdateUserGroupOverride)
 				adminRoute.DELETE("/:id", controller.DeleteUser)
 			}
 		}
@@ -66,6 +67,7 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
+			optionRoute.POST("/ratio/reload", controller.ReloadRatios)
 		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
@@ -103,11 +105,25 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		quotaPoolRoute := apiRouter.Group("/quota_pool")
+		quotaPoolRoute.Use(middleware.AdminAuth())
+		{
+			quotaPoolRoute.GET("/", controller.GetAllQuotaPools)
+			quotaPoolRoute.GET("/:id", controller.GetQuotaPool)
+			quotaPoolRoute.POST("/", controller.AddQuotaPool)
+			quotaPoolRoute.PUT("/", controller.UpdateQuotaPool)
+			quotaPoolRoute.DELETE("/:id", controller.DeleteQuotaPool)
+			quotaPoolRoute.PUT("/token", controller.SetTokenQuotaPool)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.DELETE("/", middleware.AdminAuth(), controller.DeleteHistoryLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
+		logRoute.GET("/model_usage", middleware.AdminAuth(), controller.GetModelUsage)
+		logRoute.GET("/self/model_usage", middleware.UserAuth(), controller.GetSelfModelUsage)
+		logRoute.GET("/thread_usage/:id", middleware.AdminAuth(), controller.GetThreadUsage)
+		logRoute.GET("/self/thread_usage/:id", middleware.UserAuth(), controller.GetSelfThreadUsage)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
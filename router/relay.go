@@ -17,7 +17,7 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
 	relayV1Router := router.Group("/v1")
-	relayV1Router.Use(middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute())
+	relayV1Router.Use(middleware.EndpointGate(), middleware.RelayPanicRecover(), middleware.TokenAuth(), middleware.Distribute(), middleware.AudioLimit(), middleware.RelayRateLimit(), middleware.StreamLimit())
 	{
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
@@ -42,31 +42,31 @@ func SetRelayRouter(router *gin.Engine) {
 		relayV1Router.GET("/fine_tuning/jobs/:id/events", controller.RelayNotImplemented)
 		relayV1Router.DELETE("/models/:model", controller.RelayNotImplemented)
 		relayV1Router.POST("/moderations", controller.Relay)
-		relayV1Router.POST("/assistants", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/assistants/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants", controller.RelayNotImplemented)
-		relayV1Router.POST("/assistants/:id/files", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id/files/:fileId", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/assistants/:id/files/:fileId", controller.RelayNotImplemented)
-		relayV1Router.GET("/assistants/:id/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/threads/:id", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/messages", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/messages/:messageId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId/files/:filesId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/messages/:messageId/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId/submit_tool_outputs", controller.RelayNotImplemented)
-		relayV1Router.POST("/threads/:id/runs/:runsId/cancel", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.RelayNotImplemented)
-		relayV1Router.GET("/threads/:id/runs/:runsId/steps", controller.RelayNotImplemented)
+		relayV1Router.POST("/assistants", controller.Relay)
+		relayV1Router.GET("/assistants/:id", controller.Relay)
+		relayV1Router.POST("/assistants/:id", controller.Relay)
+		relayV1Router.DELETE("/assistants/:id", controller.Relay)
+		relayV1Router.GET("/assistants", controller.Relay)
+		relayV1Router.POST("/assistants/:id/files", controller.Relay)
+		relayV1Router.GET("/assistants/:id/files/:fileId", controller.Relay)
+		relayV1Router.DELETE("/assistants/:id/files/:fileId", controller.Relay)
+		relayV1Router.GET("/assistants/:id/files", controller.Relay)
+		relayV1Router.POST("/threads", controller.Relay)
+		relayV1Router.GET("/threads/:id", controller.Relay)
+		relayV1Router.POST("/threads/:id", controller.Relay)
+		relayV1Router.DELETE("/threads/:id", controller.Relay)
+		relayV1Router.POST("/threads/:id/messages", controller.Relay)
+		relayV1Router.GET("/threads/:id/messages/:messageId", controller.Relay)
+		relayV1Router.POST("/threads/:id/messages/:messageId", controller.Relay)
+		relayV1Router.GET("/threads/:id/messages/:messageId/files/:filesId", controller.Relay)
+		relayV1Router.GET("/threads/:id/messages/:messageId/files", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs/:runsId", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs/:runsId", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs/:runsId/submit_tool_outputs", controller.Relay)
+		relayV1Router.POST("/threads/:id/runs/:runsId/cancel", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs/:runsId/steps/:stepId", controller.Relay)
+		relayV1Router.GET("/threads/:id/runs/:runsId/steps", controller.Relay)
 	}
 }
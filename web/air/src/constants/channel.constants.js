@@ -4,6 +4,7 @@ export const CHANNEL_OPTIONS = [
   { key: 3, text: 'Azure OpenAI', value: 3, color: 'olive' },
   { key: 11, text: 'Google PaLM2', value: 11, color: 'orange' },
   { key: 24, text: 'Google Gemini', value: 24, color: 'orange' },
+  { key: 37, text: 'Google Vertex AI', value: 37, color: 'orange' },
   { key: 28, text: 'Mistral AI', value: 28, color: 'orange' },
   { key: 15, text: '百度文心千帆', value: 15, color: 'blue' },
   { key: 17, text: '阿里通义千问', value: 17, color: 'orange' },
@@ -137,6 +137,18 @@ export const CHANNEL_OPTIONS = {
     value: 36,
     color: 'primary'
   },
+  37: {
+    key: 37,
+    text: 'Google Vertex AI',
+    value: 37,
+    color: 'warning'
+  },
+  38: {
+    key: 38,
+    text: 'Perplexity',
+    value: 38,
+    color: 'primary'
+  },
   8: {
     key: 8,
     text: '自定义渠道',
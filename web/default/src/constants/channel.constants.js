@@ -5,6 +5,7 @@ export const CHANNEL_OPTIONS = [
   { key: 3, text: 'Azure OpenAI', value: 3, color: 'olive' },
   { key: 11, text: 'Google PaLM2', value: 11, color: 'orange' },
   { key: 24, text: 'Google Gemini', value: 24, color: 'orange' },
+  { key: 37, text: 'Google Vertex AI', value: 37, color: 'orange' },
   { key: 28, text: 'Mistral AI', value: 28, color: 'orange' },
   { key: 15, text: '百度文心千帆', value: 15, color: 'blue' },
   { key: 17, text: '阿里通义千问', value: 17, color: 'orange' },
@@ -22,6 +23,7 @@ export const CHANNEL_OPTIONS = [
   { key: 34, text: 'Coze', value: 34, color: 'blue' },
   { key: 35, text: 'Cohere', value: 35, color: 'blue' },
   { key: 36, text: 'DeepSeek', value: 36, color: 'black' },
+  { key: 38, text: 'Perplexity', value: 38, color: 'teal' },
   { key: 8, text: '自定义渠道', value: 8, color: 'pink' },
   { key: 22, text: '知识库：FastGPT', value: 22, color: 'blue' },
   { key: 21, text: '知识库：AI Proxy', value: 21, color: 'purple' },
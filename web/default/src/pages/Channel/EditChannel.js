@@ -21,6 +21,8 @@ function type2secretPrompt(type) {
       return '按照如下格式输入：APIKey-AppId，例如：fastgpt-0sp2gtvfdgyi4k30jwlgwf1i-64f335d84283f05518e9e041';
     case 23:
       return '按照如下格式输入：AppId|SecretId|SecretKey';
+    case 37:
+      return '请输入服务账号的 JSON 密钥';
     default:
       return '请输入渠道对应的鉴权密钥';
   }
@@ -58,7 +60,16 @@ const EditChannel = () => {
     region: '',
     sk: '',
     ak: '',
-    user_id: ''
+    user_id: '',
+    project_id: '',
+    rpm: '',
+    tpm: '',
+    request_transform: '',
+    response_transform: '',
+    stream_buffer_size: '',
+    markup: '',
+    secondary_base_url: '',
+    default_params: ''
   });
   const handleInputChange = (e, { name, value }) => {
     setInputs((inputs) => ({ ...inputs, [name]: value }));
@@ -355,6 +366,28 @@ const EditChannel = () => {
               </Form.Field>
             )
           }
+          {
+            inputs.type === 37 && (
+              <Form.Field>
+                <Form.Input
+                  label='Region'
+                  name='region'
+                  placeholder={'region，e.g. us-central1，默认为 us-central1'}
+                  onChange={handleConfigChange}
+                  value={config.region}
+                  autoComplete=''
+                />
+                <Form.Input
+                  label='Project ID'
+                  name='project_id'
+                  placeholder={'Google Cloud 项目 ID，默认使用服务账号密钥中的 project_id'}
+                  onChange={handleConfigChange}
+                  value={config.project_id}
+                  autoComplete=''
+                />
+              </Form.Field>
+            )
+          }
           {
             inputs.type === 34 && (
               <Message>
@@ -419,6 +452,88 @@ const EditChannel = () => {
               autoComplete='new-password'
             />
           </Form.Field>
+          <Form.Group widths='equal'>
+            <Form.Input
+              label='RPM 限制'
+              name='rpm'
+              type='number'
+              placeholder={'此项可选，每分钟最大请求数，达到后请求将转发至其他渠道'}
+              onChange={handleConfigChange}
+              value={config.rpm}
+              autoComplete=''
+            />
+            <Form.Input
+              label='TPM 限制'
+              name='tpm'
+              type='number'
+              placeholder={'此项可选，每分钟最大 token 数，达到后请求将转发至其他渠道'}
+              onChange={handleConfigChange}
+              value={config.tpm}
+              autoComplete=''
+            />
+            <Form.Input
+              label='流式缓冲区大小'
+              name='stream_buffer_size'
+              type='number'
+              placeholder={'此项可选，读取流式响应的缓冲区初始大小，单位为字节，超大的事件会自动扩大缓冲区'}
+              onChange={handleConfigChange}
+              value={config.stream_buffer_size}
+              autoComplete=''
+            />
+            <Form.Input
+              label='加价倍率'
+              name='markup'
+              type='number'
+              step='0.01'
+              placeholder={'此项可选，转发至该渠道的请求额度将额外乘以该倍率，例如 1.2，默认为 1'}
+              onChange={handleConfigChange}
+              value={config.markup}
+              autoComplete=''
+            />
+          </Form.Group>
+          <Form.Field>
+            <Form.Input
+              label='备用地址'
+              name='secondary_base_url'
+              placeholder={'此项可选，无法连接到该渠道的地址（如连接被拒绝或超时）时将改为请求该地址，之后才会转发至其他渠道，格式为：https://domain.com'}
+              onChange={handleConfigChange}
+              value={config.secondary_base_url}
+              autoComplete=''
+            />
+          </Form.Field>
+          <Form.Field>
+            <Form.TextArea
+              label='默认参数'
+              placeholder={'此项可选，用于为客户端未设置的请求参数指定默认值，为一个 JSON 对象，例如：\n{"temperature": 0.7, "max_tokens": 1024}'}
+              name='default_params'
+              onChange={handleConfigChange}
+              value={config.default_params}
+              style={{ minHeight: 80, fontFamily: 'JetBrains Mono, Consolas' }}
+              autoComplete='new-password'
+            />
+          </Form.Field>
+          <Form.Field>
+            <Form.TextArea
+              label='请求转换'
+              placeholder={'此项可选，发往上游的请求体将经过该表达式转换，语法为 jq 的子集，例如：\n.max_new_tokens = .max_tokens | del(.max_tokens)'}
+              name='request_transform'
+              onChange={handleConfigChange}
+              value={config.request_transform}
+              style={{ minHeight: 80, fontFamily: 'JetBrains Mono, Consolas' }}
+              autoComplete='new-password'
+            />
+          </Form.Field>
+          <Form.Field>
+            <Form.TextArea
+              label='响应转换'
+              placeholder={'此项可选，上游的非流式响应体将经过该表达式转换后再处理，语法为 jq 的子集，例如：\n.data'}
+              name='response_transform'
+              onChange={handleConfigChange}
+              value={config.response_transform}
+              style={{ minHeight: 80, fontFamily: 'JetBrains Mono, Consolas' }}
+              autoComplete='new-password'
+            />
+          </Form.Field>
           {
             inputs.type === 33 && (
               <Form.Field>
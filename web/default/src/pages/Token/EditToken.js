@@ -17,6 +17,7 @@ const EditToken = () => {
     unlimited_quota: false,
     models: [],
     subnet: "",
+    signing_secret: "",
   };
   const [inputs, setInputs] = useState(originInputs);
   const { name, remain_quota, expired_time, unlimited_quota } = inputs;
@@ -164,6 +165,16 @@ const EditToken = () => {
               autoComplete='new-password'
             />
           </Form.Field>
+          <Form.Field>
+            <Form.Input
+              label='签名密钥'
+              name='signing_secret'
+              placeholder={'设置后请求须携带 HMAC-SHA256 签名，留空表示不校验签名'}
+              onChange={handleInputChange}
+              value={inputs.signing_secret}
+              autoComplete='new-password'
+            />
+          </Form.Field>
           <Form.Field>
             <Form.Input
               label='过期时间'
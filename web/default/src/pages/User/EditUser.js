@@ -1,7 +1,7 @@
 import React, { useEffect, useState } from 'react';
 import { Button, Form, Header, Segment } from 'semantic-ui-react';
 import { useParams, useNavigate } from 'react-router-dom';
-import { API, showError, showSuccess } from '../../helpers';
+import { API, showError, showSuccess, timestamp2string } from '../../helpers';
 import { renderQuota, renderQuotaWithPrompt } from '../../helpers/render';
 
 const EditUser = () => {
@@ -16,7 +16,9 @@ const EditUser = () => {
     wechat_id: '',
     email: '',
     quota: 0,
-    group: 'default'
+    group: 'default',
+    group_override: '',
+    group_override_expires_at: ''
   });
   const [groupOptions, setGroupOptions] = useState([]);
   const { username, display_name, password, github_id, wechat_id, email, quota, group } =
@@ -50,6 +52,7 @@ const EditUser = () => {
     const { success, message, data } = res.data;
     if (success) {
       data.password = '';
+      data.group_override_expires_at = data.group_override_expires_at ? timestamp2string(data.group_override_expires_at) : '';
       setInputs(data);
     } else {
       showError(message);
@@ -71,6 +74,21 @@ const EditUser = () => {
         data.quota = parseInt(data.quota);
       }
       res = await API.put(`/api/user/`, data);
+      if (res.data.success) {
+        let expiresAt = 0;
+        if (inputs.group_override && inputs.group_override_expires_at) {
+          expiresAt = Math.ceil(Date.parse(inputs.group_override_expires_at) / 1000);
+          if (isNaN(expiresAt)) {
+            showError('临时分组过期时间格式错误！');
+            return;
+          }
+        }
+        res = await API.put(`/api/user/group_override`, {
+          id: parseInt(userId),
+          group: inputs.group_override,
+          expires_at: expiresAt
+        });
+      }
     } else {
       res = await API.put(`/api/user/self`, inputs);
     }
@@ -136,6 +154,29 @@ const EditUser = () => {
                   options={groupOptions}
                 />
               </Form.Field>
+              <Form.Group widths='equal'>
+                <Form.Dropdown
+                  label='临时分组'
+                  placeholder={'此项可选，设置后该用户的请求将按该分组选择渠道与计费'}
+                  name='group_override'
+                  fluid
+                  search
+                  selection
+                  clearable
+                  onChange={handleInputChange}
+                  value={inputs.group_override}
+                  autoComplete='new-password'
+                  options={groupOptions}
+                />
+                <Form.Input
+                  label='临时分组过期时间'
+                  name='group_override_expires_at'
+                  placeholder={'此项可选，格式为 yyyy-MM-dd HH:mm:ss，留空则永不过期'}
+                  onChange={handleInputChange}
+                  value={inputs.group_override_expires_at}
+                  autoComplete='new-password'
+                />
+              </Form.Group>
               <Form.Field>
                 <Form.Input
                   label={`剩余额度${renderQuotaWithPrompt(quota)}`}